@@ -14,11 +14,14 @@ import (
 	"github.com/anthropics/anthropic-sdk-go/option"
 
 	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/tokenizer"
 )
 
 const (
 	DefaultMaxTokens       = 8192
 	MaxToolResolutionDepth = 10
+	// MaxImageSize is the largest image the API accepts
+	MaxImageSize = 5 * 1024 * 1024 // 5 MB
 )
 
 type messageState struct {
@@ -37,12 +40,15 @@ type Anthropic struct {
 	defaultModel     string
 	inputTokenLimit  int
 	outputTokenLimit int
+	tokenizer        llm.Tokenizer
 }
 
 func New(llmService llm.ServiceConfig, httpClient *http.Client) *Anthropic {
 	client := anthropicSDK.NewClient(
 		option.WithAPIKey(llmService.APIKey),
 		option.WithHTTPClient(httpClient),
+		// Retries are handled by llm.RetryWrapper
+		option.WithMaxRetries(0),
 	)
 
 	return &Anthropic{
@@ -50,6 +56,7 @@ func New(llmService llm.ServiceConfig, httpClient *http.Client) *Anthropic {
 		defaultModel:     llmService.DefaultModel,
 		inputTokenLimit:  llmService.InputTokenLimit,
 		outputTokenLimit: llmService.OutputTokenLimit,
+		tokenizer:        tokenizer.Anthropic,
 	}
 }
 
@@ -101,6 +108,17 @@ func conversationToMessages(posts []llm.Post) (string, []anthropicSDK.MessagePar
 			continue
 		}
 
+		// The signed thinking blocks must come first in a message with tool use when thinking is enabled
+		if len(post.ToolUse) > 0 {
+			for _, reasoning := range post.Reasoning {
+				if reasoning.Data != "" {
+					currentBlocks = append(currentBlocks, anthropicSDK.ContentBlockParamOfRequestRedactedThinkingBlock(reasoning.Data))
+				} else if reasoning.Signature != "" {
+					currentBlocks = append(currentBlocks, anthropicSDK.ContentBlockParamOfRequestThinkingBlock(reasoning.Signature, reasoning.Text))
+				}
+			}
+		}
+
 		if post.Message != "" {
 			textBlock := anthropicSDK.NewTextBlock(post.Message)
 			currentBlocks = append(currentBlocks, textBlock)
@@ -175,7 +193,7 @@ func (a *Anthropic) createConfig(opts []llm.LanguageModelOption) llm.LanguageMod
 	return cfg
 }
 
-func (a *Anthropic) streamChatWithTools(state messageState) {
+func (a *Anthropic) streamChatWithTools(ctx context.Context, state messageState) {
 	if state.depth >= MaxToolResolutionDepth {
 		state.output <- llm.TextStreamEvent{
 			Type:  llm.EventTypeError,
@@ -194,7 +212,34 @@ func (a *Anthropic) streamChatWithTools(state messageState) {
 		}},
 		Tools: convertTools(state.tools),
 	}
-	stream := a.client.Messages.NewStreaming(context.Background(), params)
+
+	// Anthropic has no JSON mode, force the use of a tool taking the output schema as its input instead
+	if state.config.JSONOutputFormat != nil {
+		params.Tools = append(params.Tools, jsonOutputTool(state.config.JSONOutputFormat))
+		params.ToolChoice = anthropicSDK.ToolChoiceUnionParam{
+			OfToolChoiceTool: &anthropicSDK.ToolChoiceToolParam{Name: jsonOutputToolName},
+		}
+	}
+
+	// Thinking can't be used when forcing a tool and needs room for the answer on top of its budget. The
+	// sampling can't be changed when thinking.
+	thinkingBudget := state.config.ThinkingBudget
+	if thinkingBudget > 0 {
+		thinkingBudget = max(thinkingBudget, llm.MinThinkingBudget)
+	}
+	if thinkingBudget > 0 && state.config.JSONOutputFormat == nil && state.config.MaxGeneratedTokens > thinkingBudget {
+		params.Thinking = anthropicSDK.ThinkingConfigParamOfThinkingConfigEnabled(int64(thinkingBudget))
+	} else {
+		if state.config.Temperature != nil {
+			params.Temperature = anthropicSDK.Float(*state.config.Temperature)
+		}
+		if state.config.TopP != nil {
+			params.TopP = anthropicSDK.Float(*state.config.TopP)
+		}
+	}
+	params.StopSequences = state.config.StopSequences
+
+	stream := a.client.Messages.NewStreaming(ctx, params)
 
 	message := anthropicSDK.Message{}
 	for stream.Next() {
@@ -216,6 +261,11 @@ func (a *Anthropic) streamChatWithTools(state messageState) {
 					Type:  llm.EventTypeText,
 					Value: deltaVariant.Text,
 				}
+			case anthropicSDK.ThinkingDelta:
+				state.output <- llm.TextStreamEvent{
+					Type:  llm.EventTypeReasoning,
+					Value: deltaVariant.Thinking,
+				}
 			}
 		}
 	}
@@ -228,19 +278,56 @@ func (a *Anthropic) streamChatWithTools(state messageState) {
 		return
 	}
 
+	// The JSON output is the input of the forced tool call
+	for _, block := range message.Content {
+		if block.Type == "tool_use" && block.Name == jsonOutputToolName {
+			state.output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeText,
+				Value: string(block.Input),
+			}
+		}
+	}
+
 	// Check for tool usage in the message
 	pendingToolCalls := make([]llm.ToolCall, 0, len(message.Content))
+	reasoning := []llm.ReasoningBlock{}
 	for _, block := range message.Content {
-		if block.Type == "tool_use" {
-			pendingToolCalls = append(pendingToolCalls, llm.ToolCall{
-				ID:          block.ID,
-				Name:        block.Name,
-				Description: "",
-				Arguments:   block.Input,
-			})
+		switch block.Type {
+		case "tool_use":
+			if block.Name != jsonOutputToolName {
+				pendingToolCalls = append(pendingToolCalls, llm.ToolCall{
+					ID:          block.ID,
+					Name:        block.Name,
+					Description: "",
+					Arguments:   block.Input,
+				})
+			}
+		case "thinking":
+			reasoning = append(reasoning, llm.ReasoningBlock{Text: block.Thinking, Signature: block.Signature})
+		case "redacted_thinking":
+			reasoning = append(reasoning, llm.ReasoningBlock{Data: block.Data})
 		}
 	}
 
+	// The thinking blocks have to be sent back unchanged along with the tool results
+	if len(pendingToolCalls) > 0 && len(reasoning) > 0 {
+		state.output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeReasoningBlocks,
+			Value: reasoning,
+		}
+	}
+
+	// Report the token usage accumulated from message_start and message_delta
+	state.output <- llm.TextStreamEvent{
+		Type: llm.EventTypeUsage,
+		Value: llm.Usage{
+			InputTokens:  message.Usage.InputTokens + message.Usage.CacheReadInputTokens + message.Usage.CacheCreationInputTokens,
+			OutputTokens: message.Usage.OutputTokens,
+			CachedTokens: message.Usage.CacheReadInputTokens,
+			FinishReason: string(message.StopReason),
+		},
+	}
+
 	// If tools were used, send tool calls event
 	if len(pendingToolCalls) > 0 {
 		state.output <- llm.TextStreamEvent{
@@ -256,7 +343,7 @@ func (a *Anthropic) streamChatWithTools(state messageState) {
 	}
 }
 
-func (a *Anthropic) ChatCompletion(request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
+func (a *Anthropic) ChatCompletion(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
 	eventStream := make(chan llm.TextStreamEvent)
 
 	cfg := a.createConfig(opts)
@@ -279,15 +366,15 @@ func (a *Anthropic) ChatCompletion(request llm.CompletionRequest, opts ...llm.La
 
 	go func() {
 		defer close(eventStream)
-		a.streamChatWithTools(initialState)
+		a.streamChatWithTools(ctx, initialState)
 	}()
 
 	return &llm.TextStreamResult{Stream: eventStream}, nil
 }
 
-func (a *Anthropic) ChatCompletionNoStream(request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
+func (a *Anthropic) ChatCompletionNoStream(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
 	// This could perform better if we didn't use the streaming API here, but the complexity is not worth it.
-	result, err := a.ChatCompletion(request, opts...)
+	result, err := a.ChatCompletion(ctx, request, opts...)
 	if err != nil {
 		return "", err
 	}
@@ -295,7 +382,25 @@ func (a *Anthropic) ChatCompletionNoStream(request llm.CompletionRequest, opts .
 }
 
 func (a *Anthropic) CountTokens(text string) int {
-	return 0
+	return a.tokenizer.CountTokens(text)
+}
+
+const jsonOutputToolName = "json_output"
+
+// jsonOutputTool is the tool used to get JSON output matching the schema of format.
+func jsonOutputTool(format any) anthropicSDK.ToolUnionParam {
+	schema := llm.NewJSONSchemaFromStruct(format)
+	inputSchema := anthropicSDK.ToolInputSchemaParam{Properties: schema.Properties}
+	if len(schema.Required) > 0 {
+		inputSchema.WithExtraFields(map[string]any{"required": schema.Required})
+	}
+	return anthropicSDK.ToolUnionParam{
+		OfTool: &anthropicSDK.ToolParam{
+			Name:        jsonOutputToolName,
+			Description: anthropicSDK.String("Respond with JSON matching the input schema"),
+			InputSchema: inputSchema,
+		},
+	}
 }
 
 // convertTools converts from llm.Tool to anthropicSDK.ToolUnionParam format
@@ -319,3 +424,34 @@ func (a *Anthropic) InputTokenLimit() int {
 	}
 	return 100000
 }
+
+// ListModels returns the models available to the API key. The models API doesn't report context windows.
+func (a *Anthropic) ListModels(ctx context.Context) ([]llm.ModelInfo, error) {
+	var result []llm.ModelInfo
+	pager := a.client.Models.ListAutoPaging(ctx, anthropicSDK.ModelListParams{Limit: anthropicSDK.Int(1000)})
+	for pager.Next() {
+		model := pager.Current()
+		result = append(result, llm.ModelInfo{
+			ID:          model.ID,
+			DisplayName: model.DisplayName,
+		})
+	}
+	if err := pager.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	return result, nil
+}
+
+func (a *Anthropic) Capabilities() llm.Capabilities {
+	return llm.Capabilities{
+		Vision:        true,
+		Tools:         true,
+		JSONOutput:    true,
+		Streaming:     true,
+		MaxImageSize:  MaxImageSize,
+		ContextWindow: a.InputTokenLimit(),
+		Thinking:      true,
+		// Requests with a thinking budget need room for the answer within this limit
+		OutputTokenLimit: a.GetDefaultConfig().MaxGeneratedTokens,
+	}
+}
@@ -5,10 +5,17 @@ package anthropic
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	anthropicSDK "github.com/anthropics/anthropic-sdk-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/mattermost/mattermost-plugin-ai/llm"
 )
@@ -239,3 +246,145 @@ func TestConversationToMessages(t *testing.T) {
 		})
 	}
 }
+
+// redirectTransport sends every request to the test server.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestClient returns a client answering every request with the server-sent events, the body of the last
+// request is decoded into body.
+func newTestClient(t *testing.T, serviceConfig llm.ServiceConfig, events []string, body *map[string]any) *Anthropic {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(body))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			var parsed struct{ Type string }
+			require.NoError(t, json.Unmarshal([]byte(event), &parsed))
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", parsed.Type, event)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return New(serviceConfig, &http.Client{Transport: redirectTransport{target: target}})
+}
+
+func TestJSONOutput(t *testing.T) {
+	var body map[string]any
+	client := newTestClient(t, llm.ServiceConfig{APIKey: "key", DefaultModel: "claude"}, []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_1","name":"json_output","input":{}}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"emoji\":\"tada\"}"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":5}}`,
+		`{"type":"message_stop"}`,
+	}, &body)
+
+	type emojiOutput struct {
+		Emoji string `json:"emoji"`
+	}
+	result, err := client.ChatCompletionNoStream(context.Background(), llm.CompletionRequest{
+		Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Great job!"}},
+		Context: llm.NewContext(),
+	}, llm.WithJSONOutput(&emojiOutput{}), llm.WithReasoningParams(llm.ReasoningParams{ThinkingBudget: 2000}))
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"emoji":"tada"}`, result)
+	assert.Equal(t, map[string]any{"type": "tool", "name": "json_output"}, body["tool_choice"])
+	// Thinking is not compatible with forcing a tool
+	assert.NotContains(t, body, "thinking")
+	tools, ok := body["tools"].([]any)
+	require.True(t, ok)
+	require.Len(t, tools, 1)
+	inputSchema, ok := tools[0].(map[string]any)["input_schema"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, "object", inputSchema["type"])
+	assert.Equal(t, map[string]any{"emoji": map[string]any{"type": "string"}}, inputSchema["properties"])
+	assert.Equal(t, []any{"emoji"}, inputSchema["required"])
+}
+
+func TestThinking(t *testing.T) {
+	var body map[string]any
+	client := newTestClient(t, llm.ServiceConfig{APIKey: "key", DefaultModel: "claude"}, []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":"","signature":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"The user wants "}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"the weather."}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"redacted_thinking","data":"encrypted"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"weather","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{}"}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+		`{"type":"message_stop"}`,
+	}, &body)
+
+	result, err := client.ChatCompletion(context.Background(), llm.CompletionRequest{
+		Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "What's the weather?"}},
+		Context: llm.NewContext(),
+	}, llm.WithReasoningParams(llm.ReasoningParams{ThinkingBudget: 500}))
+	require.NoError(t, err)
+
+	reasoning := ""
+	var blocks []llm.ReasoningBlock
+	var toolCalls []llm.ToolCall
+	for event := range result.Stream {
+		switch event.Type {
+		case llm.EventTypeReasoning:
+			reasoning += event.Value.(string)
+		case llm.EventTypeReasoningBlocks:
+			blocks = event.Value.([]llm.ReasoningBlock)
+		case llm.EventTypeToolCalls:
+			toolCalls = event.Value.([]llm.ToolCall)
+		case llm.EventTypeError:
+			require.NoError(t, event.Value.(error))
+		}
+	}
+
+	// The budget is raised to the minimum accepted by the API
+	assert.Equal(t, map[string]any{"type": "enabled", "budget_tokens": float64(llm.MinThinkingBudget)}, body["thinking"])
+	assert.Equal(t, "The user wants the weather.", reasoning)
+	assert.Equal(t, []llm.ReasoningBlock{
+		{Text: "The user wants the weather.", Signature: "sig"},
+		{Data: "encrypted"},
+	}, blocks)
+	require.Len(t, toolCalls, 1)
+	assert.Equal(t, "weather", toolCalls[0].Name)
+}
+
+func TestConversationToMessagesWithReasoning(t *testing.T) {
+	_, messages := conversationToMessages([]llm.Post{
+		{Role: llm.PostRoleUser, Message: "What's the weather?"},
+		{
+			Role:    llm.PostRoleBot,
+			Message: "Let me check.",
+			ToolUse: []llm.ToolCall{{ID: "toolu_1", Name: "weather", Arguments: json.RawMessage(`{}`), Result: "Sunny", Status: llm.ToolCallStatusSuccess}},
+			Reasoning: []llm.ReasoningBlock{
+				{Text: "The user wants the weather.", Signature: "sig"},
+				{Data: "encrypted"},
+			},
+		},
+	})
+
+	require.Len(t, messages, 3)
+	content := messages[1].Content
+	require.Len(t, content, 4)
+	require.NotNil(t, content[0].OfRequestThinkingBlock)
+	assert.Equal(t, "The user wants the weather.", content[0].OfRequestThinkingBlock.Thinking)
+	assert.Equal(t, "sig", content[0].OfRequestThinkingBlock.Signature)
+	require.NotNil(t, content[1].OfRequestRedactedThinkingBlock)
+	assert.Equal(t, "encrypted", content[1].OfRequestRedactedThinkingBlock.Data)
+	assert.NotNil(t, content[2].OfRequestTextBlock)
+	assert.NotNil(t, content[3].OfRequestToolUseBlock)
+}
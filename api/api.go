@@ -18,6 +18,8 @@ import (
 	"github.com/mattermost/mattermost-plugin-ai/indexer"
 	"github.com/mattermost/mattermost-plugin-ai/llm"
 	"github.com/mattermost/mattermost-plugin-ai/llmcontext"
+	"github.com/mattermost/mattermost-plugin-ai/mcp"
+	"github.com/mattermost/mattermost-plugin-ai/mcpserver"
 	"github.com/mattermost/mattermost-plugin-ai/meetings"
 	"github.com/mattermost/mattermost-plugin-ai/metrics"
 	"github.com/mattermost/mattermost-plugin-ai/mmapi"
@@ -56,6 +58,8 @@ type API struct {
 	licenseChecker       *enterprise.LicenseChecker
 	streamingService     streaming.Service
 	i18nBundle           *i18n.Bundle
+	mcpClientManager     *mcp.ClientManager
+	mcpServer            *mcpserver.Server
 }
 
 // New creates a new API instance
@@ -75,6 +79,8 @@ func New(
 	licenseChecker *enterprise.LicenseChecker,
 	streamingService streaming.Service,
 	i18nBundle *i18n.Bundle,
+	mcpClientManager *mcp.ClientManager,
+	mcpServer *mcpserver.Server,
 ) *API {
 	return &API{
 		bots:                 bots,
@@ -93,6 +99,8 @@ func New(
 		licenseChecker:       licenseChecker,
 		streamingService:     streamingService,
 		i18nBundle:           i18nBundle,
+		mcpClientManager:     mcpClientManager,
+		mcpServer:            mcpServer,
 	}
 }
 
@@ -110,6 +118,15 @@ func (a *API) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Reques
 
 	router.GET("/ai_threads", a.handleGetAIThreads)
 	router.GET("/ai_bots", a.handleGetAIBots)
+	router.GET(mcp.OAuthCallbackPath, a.handleMCPOAuthCallback)
+	router.GET("/mcp/resources", a.handleGetMCPResources)
+	router.GET("/mcp/prompts", a.handleGetMCPPrompts)
+
+	mcpServerRouter := router.Group(mcpserver.Path)
+	mcpServerRouter.Use(a.mcpServerAuthorizationRequired(c))
+	mcpServerRouter.POST("", a.handleMCPServerMessage)
+	mcpServerRouter.GET("", a.handleMCPServerMethodNotAllowed)
+	mcpServerRouter.DELETE("", a.handleMCPServerMethodNotAllowed)
 
 	botRequiredRouter := router.Group("")
 	botRequiredRouter.Use(a.aiBotRequired)
@@ -123,8 +140,11 @@ func (a *API) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Reques
 	postRouter.POST("/stop", a.handleStop)
 	postRouter.POST("/regenerate", a.handleRegenerate)
 	postRouter.POST("/tool_call", a.handleToolCall)
+	postRouter.POST("/tool_approval", a.handleToolApproval)
 	postRouter.POST("/postback_summary", a.handlePostbackSummary)
 
+	botRequiredRouter.POST("/mcp/conversation", a.handleStartMCPConversation)
+
 	channelRouter := botRequiredRouter.Group("/channel/:channelid")
 	channelRouter.Use(a.channelAuthorizationRequired)
 	channelRouter.POST("/interval", a.handleInterval)
@@ -134,6 +154,11 @@ func (a *API) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Reques
 	adminRouter.POST("/reindex", a.handleReindexPosts)
 	adminRouter.GET("/reindex/status", a.handleGetJobStatus)
 	adminRouter.POST("/reindex/cancel", a.handleCancelJob)
+	adminRouter.GET("/usage", a.handleGetUsage)
+	adminRouter.GET("/mcp/status", a.handleGetMCPStatus)
+	adminRouter.POST("/services/models", a.handleListModels)
+	adminRouter.POST("/services/test", a.handleTestConnection)
+	adminRouter.POST("/bots/capabilities", a.handleCheckBotCapabilities)
 
 	searchRouter := botRequiredRouter.Group("/search")
 	// Only returns search results
@@ -4,12 +4,19 @@
 package api
 
 import (
+	"context"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	"errors"
 	"fmt"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/mcp"
+	"github.com/mattermost/mattermost-plugin-ai/quota"
 	"github.com/mattermost/mattermost/server/public/model"
 )
 
@@ -107,3 +114,152 @@ func (a *API) mattermostAdminAuthorizationRequired(c *gin.Context) {
 		return
 	}
 }
+
+// handleGetUsage reports token usage between the since and until days (YYYY-MM-DD, UTC, inclusive),
+// grouped by any combination of bot, team, user and day. Defaults to the current month grouped by bot.
+func (a *API) handleGetUsage(c *gin.Context) {
+	now := time.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	until := now
+
+	if sinceParam := c.Query("since"); sinceParam != "" {
+		parsed, err := time.Parse(time.DateOnly, sinceParam)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid since date: %w", err))
+			return
+		}
+		since = parsed
+	}
+	if untilParam := c.Query("until"); untilParam != "" {
+		parsed, err := time.Parse(time.DateOnly, untilParam)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid until date: %w", err))
+			return
+		}
+		until = parsed
+	}
+
+	groupBy := []quota.ReportGroup{quota.GroupByBot}
+	if groupByParam := c.Query("group_by"); groupByParam != "" {
+		groupBy = nil
+		for _, group := range strings.Split(groupByParam, ",") {
+			reportGroup := quota.ReportGroup(strings.TrimSpace(group))
+			switch reportGroup {
+			case quota.GroupByBot, quota.GroupByTeam, quota.GroupByUser, quota.GroupByDay:
+			default:
+				c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid group_by value: %s", group))
+				return
+			}
+			if !slices.Contains(groupBy, reportGroup) {
+				groupBy = append(groupBy, reportGroup)
+			}
+		}
+	}
+
+	rows, err := quota.NewDBStore(a.dbClient).Report(groupBy, since, until)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rows)
+}
+
+// MCPUserStatus is the state of the MCP server connections of a user
+type MCPUserStatus struct {
+	mcp.UserStatus
+	Username string `json:"username,omitempty"`
+}
+
+// handleGetMCPStatus reports the state of the connections of the users with an open MCP client: the state
+// of each server, its last error and the number of tools it offers.
+func (a *API) handleGetMCPStatus(c *gin.Context) {
+	statuses := []MCPUserStatus{}
+	if a.mcpClientManager == nil {
+		c.JSON(http.StatusOK, statuses)
+		return
+	}
+
+	for _, userStatus := range a.mcpClientManager.Status() {
+		status := MCPUserStatus{UserStatus: userStatus}
+		if user, err := a.pluginAPI.User.Get(userStatus.UserID); err == nil {
+			status.Username = user.Username
+		}
+		statuses = append(statuses, status)
+	}
+
+	c.JSON(http.StatusOK, statuses)
+}
+
+// serviceCheckTimeout bounds the requests made to a provider to check a service configuration.
+const serviceCheckTimeout = 30 * time.Second
+
+// bindServiceConfig reads the service configuration to check from the request body.
+func bindServiceConfig(c *gin.Context) (llm.ServiceConfig, bool) {
+	var serviceConfig llm.ServiceConfig
+	if err := c.ShouldBindJSON(&serviceConfig); err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid service configuration: %w", err))
+		return llm.ServiceConfig{}, false
+	}
+	if !serviceConfig.IsValid() {
+		c.AbortWithError(http.StatusBadRequest, errors.New("service configuration is missing required settings"))
+		return llm.ServiceConfig{}, false
+	}
+	return serviceConfig, true
+}
+
+// handleListModels returns the models available to the service configuration in the body, which
+// doesn't have to be saved yet.
+func (a *API) handleListModels(c *gin.Context) {
+	serviceConfig, ok := bindServiceConfig(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), serviceCheckTimeout)
+	defer cancel()
+
+	models, err := a.bots.ListModels(ctx, serviceConfig)
+	if errors.Is(err, llm.ErrModelListingNotSupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models)
+}
+
+// handleTestConnection sends a minimal completion with the service configuration in the body and
+// reports the latency or the error returned by the provider.
+func (a *API) handleTestConnection(c *gin.Context) {
+	serviceConfig, ok := bindServiceConfig(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), serviceCheckTimeout)
+	defer cancel()
+
+	c.JSON(http.StatusOK, a.bots.TestConnection(ctx, serviceConfig))
+}
+
+// handleCheckBotCapabilities lists the features enabled for the bot configuration in the body that its
+// services don't support, so the System Console can show them before the configuration is saved.
+func (a *API) handleCheckBotCapabilities(c *gin.Context) {
+	var botConfig llm.BotConfig
+	if err := c.ShouldBindJSON(&botConfig); err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid bot configuration: %w", err))
+		return
+	}
+
+	problems, err := a.bots.CheckCapabilities(botConfig)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"problems": problems})
+}
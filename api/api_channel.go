@@ -125,9 +125,12 @@ func (a *API) handleInterval(c *gin.Context) {
 		return
 	}
 
+	ctx, cancel := streaming.NewRequestContext(stdcontext.Background())
+
 	// Call channels interval processing
-	resultStream, err := channels.New(bot.LLM(), a.prompts, a.mmClient, a.dbClient).Interval(context, channel.Id, data.StartTime, data.EndTime, promptPreset)
+	resultStream, err := channels.New(bot.LLM(), a.prompts, a.mmClient, a.dbClient).Interval(ctx, context, channel.Id, data.StartTime, data.EndTime, promptPreset)
 	if err != nil {
+		cancel()
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -137,7 +140,7 @@ func (a *API) handleInterval(c *gin.Context) {
 	post.AddProp(streaming.NoRegen, "true")
 
 	// Stream result to new DM
-	if err := a.streamingService.StreamToNewDM(stdcontext.Background(), bot.GetMMBot().UserId, resultStream, user.Id, post, ""); err != nil {
+	if err := a.streamingService.StreamToNewDM(ctx, cancel, bot.GetMMBot().UserId, resultStream, user.Id, post, ""); err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
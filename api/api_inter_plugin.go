@@ -4,11 +4,13 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/quota"
 )
 
 type SimpleCompletionRequest struct {
@@ -51,6 +53,16 @@ func (a *API) handleInterPluginSimpleCompletion(c *gin.Context) {
 		return
 	}
 
+	if quotaErr := a.bots.CheckQuota(bot, userID, nil); quotaErr != nil {
+		var exceeded *quota.ExceededError
+		if errors.As(quotaErr, &exceeded) {
+			c.AbortWithError(http.StatusTooManyRequests, quotaErr)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to check quota: %w", quotaErr))
+		return
+	}
+
 	// Create a proper context for the LLM
 	context := a.contextBuilder.BuildLLMContextUserRequest(
 		bot,
@@ -93,7 +105,7 @@ func (a *API) handleInterPluginSimpleCompletion(c *gin.Context) {
 	}
 
 	// Execute the completion
-	response, err := bot.LLM().ChatCompletionNoStream(completionRequest)
+	response, err := bot.LLM().ChatCompletionNoStream(c.Request.Context(), completionRequest)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to execute chat completion: %v", err))
 		return
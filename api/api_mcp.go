@@ -0,0 +1,256 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-ai/bots"
+	"github.com/mattermost/mattermost-plugin-ai/conversations"
+	"github.com/mattermost/mattermost-plugin-ai/mcp"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// maxMCPServerMessageSize limits the size of the JSON-RPC messages sent to the MCP server endpoint
+const maxMCPServerMessageSize = 1 << 20
+
+// MCPConversationRequest starts a conversation with a bot from an MCP prompt or a message with MCP resources
+type MCPConversationRequest struct {
+	Message   string                  `json:"message"`
+	Resources []mcp.ResourceReference `json:"resources"`
+	Prompt    *MCPPromptRequest       `json:"prompt"`
+}
+
+// MCPPromptRequest selects an MCP prompt and the values of its arguments
+type MCPPromptRequest struct {
+	ServerID  string            `json:"serverID"`
+	Name      string            `json:"name"`
+	Arguments map[string]string `json:"arguments"`
+}
+
+// handleMCPOAuthCallback completes the authorization of an MCP server when the authorization server redirects
+// the user back. The user sees a page telling them whether it worked, the details of failures are logged.
+func (a *API) handleMCPOAuthCallback(c *gin.Context) {
+	userID := c.GetHeader("Mattermost-User-Id")
+
+	if a.mcpClientManager == nil {
+		a.renderMCPOAuthResult(c, http.StatusNotFound, "MCP is not available.")
+		return
+	}
+
+	if errorCode := c.Query("error"); errorCode != "" {
+		_ = c.Error(fmt.Errorf("MCP server authorization failed: %s: %s", errorCode, c.Query("error_description")))
+		a.renderMCPOAuthResult(c, http.StatusBadRequest, "The authorization was denied or failed. Ask the agent again to get a new link.")
+		return
+	}
+
+	state := c.Query("state")
+	code := c.Query("code")
+	if state == "" || code == "" {
+		_ = c.Error(errors.New("MCP server authorization callback without state or code"))
+		a.renderMCPOAuthResult(c, http.StatusBadRequest, "The authorization response is invalid.")
+		return
+	}
+
+	serverID, err := a.mcpClientManager.HandleOAuthCallback(c.Request.Context(), userID, state, code)
+	if err != nil {
+		_ = c.Error(fmt.Errorf("failed to complete MCP server authorization: %w", err))
+		a.renderMCPOAuthResult(c, http.StatusBadRequest, "The authorization could not be completed. Ask the agent again to get a new link.")
+		return
+	}
+
+	a.renderMCPOAuthResult(c, http.StatusOK, fmt.Sprintf("Your account is connected to %s. You can close this page and go back to Mattermost.", serverID))
+}
+
+func (a *API) renderMCPOAuthResult(c *gin.Context, status int, message string) {
+	page := fmt.Sprintf("<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Mattermost Agents</title></head><body><p>%s</p></body></html>", html.EscapeString(message))
+	c.Data(status, "text/html; charset=utf-8", []byte(page))
+}
+
+func (a *API) handleGetMCPResources(c *gin.Context) {
+	userID := c.GetHeader("Mattermost-User-Id")
+
+	if a.mcpClientManager == nil {
+		c.JSON(http.StatusOK, []mcp.Resource{})
+		return
+	}
+
+	resources, err := a.mcpClientManager.ListResourcesForUser(userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resources)
+}
+
+func (a *API) handleGetMCPPrompts(c *gin.Context) {
+	userID := c.GetHeader("Mattermost-User-Id")
+
+	if a.mcpClientManager == nil {
+		c.JSON(http.StatusOK, []mcp.Prompt{})
+		return
+	}
+
+	prompts, err := a.mcpClientManager.ListPromptsForUser(userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prompts)
+}
+
+// handleStartMCPConversation posts the first message of a conversation in the DM of the user with the bot,
+// which then answers it like any other message. A prompt is filled in and becomes the message, resources are
+// attached to it and read again each time the bot answers.
+func (a *API) handleStartMCPConversation(c *gin.Context) {
+	userID := c.GetHeader("Mattermost-User-Id")
+	bot := c.MustGet(ContextBotKey).(*bots.Bot)
+
+	if a.mcpClientManager == nil {
+		c.AbortWithError(http.StatusBadRequest, errors.New("MCP is not available"))
+		return
+	}
+
+	var req MCPConversationRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	if err := a.bots.CheckUsageRestrictionsForUser(bot, userID); err != nil {
+		c.AbortWithError(http.StatusForbidden, err)
+		return
+	}
+
+	message := strings.TrimSpace(req.Message)
+	if req.Prompt != nil {
+		promptText, err := a.mcpClientManager.GetPromptForUser(userID, req.Prompt.ServerID, req.Prompt.Name, req.Prompt.Arguments)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		message = strings.TrimSpace(promptText + "\n\n" + message)
+	}
+	if message == "" {
+		c.AbortWithError(http.StatusBadRequest, errors.New("message cannot be empty"))
+		return
+	}
+	if utf8.RuneCountInString(message) > model.PostMessageMaxRunesV2 {
+		c.AbortWithError(http.StatusBadRequest, errors.New("message is too long"))
+		return
+	}
+
+	post := &model.Post{
+		UserId:  userID,
+		Message: message,
+	}
+	// The post is created by the plugin, which the bot only answers when asked to
+	post.AddProp(conversations.ActivateAIProp, "true")
+	if len(req.Resources) > 0 {
+		resourcesJSON, err := json.Marshal(req.Resources)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		post.AddProp(conversations.MCPResourcesProp, string(resourcesJSON))
+	}
+
+	if err := a.mmClient.DM(userID, bot.GetMMBot().UserId, post); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create post: %w", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]string{
+		"postID":    post.Id,
+		"channelID": post.ChannelId,
+	})
+}
+
+// mcpServerAuthorizationRequired only lets through requests authenticated with a personal access token
+// while the MCP server is enabled. MCP clients are configured with a token, never with a browser session.
+func (a *API) mcpServerAuthorizationRequired(pluginContext *plugin.Context) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.mcpServer == nil || !a.mcpServer.Enabled() {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		if pluginContext == nil || pluginContext.SessionId == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		session, err := a.pluginAPI.Session.Get(pluginContext.SessionId)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to get session: %w", err))
+			return
+		}
+
+		if !session.IsUserAccessToken() || session.IsBotUser() {
+			c.AbortWithError(http.StatusForbidden, errors.New("the MCP server requires a personal access token"))
+			return
+		}
+	}
+}
+
+// handleMCPServerMessage handles the JSON-RPC messages of the streamable HTTP transport. Every request is
+// answered with JSON, the server doesn't keep sessions or streams.
+func (a *API) handleMCPServerMessage(c *gin.Context) {
+	userID := c.GetHeader("Mattermost-User-Id")
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxMCPServerMessageSize))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to read MCP message: %w", err))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid MCP message batch: %w", err))
+			return
+		}
+
+		responses := []any{}
+		for _, message := range batch {
+			if response := a.mcpServer.HandleMessage(c.Request.Context(), userID, message); response != nil {
+				responses = append(responses, response)
+			}
+		}
+		if len(responses) == 0 {
+			c.Status(http.StatusAccepted)
+			return
+		}
+		c.JSON(http.StatusOK, responses)
+		return
+	}
+
+	response := a.mcpServer.HandleMessage(c.Request.Context(), userID, body)
+	if response == nil {
+		// Notifications and responses from the client are only acknowledged
+		c.Status(http.StatusAccepted)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleMCPServerMethodNotAllowed answers the requests opening a stream or closing a session, neither of
+// which the MCP server supports.
+func (a *API) handleMCPServerMethodNotAllowed(c *gin.Context) {
+	c.Header("Allow", http.MethodPost)
+	c.AbortWithStatus(http.StatusMethodNotAllowed)
+}
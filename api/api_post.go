@@ -17,6 +17,7 @@ import (
 	"github.com/mattermost/mattermost-plugin-ai/i18n"
 	"github.com/mattermost/mattermost-plugin-ai/llm"
 	"github.com/mattermost/mattermost-plugin-ai/mmapi"
+	"github.com/mattermost/mattermost-plugin-ai/quota"
 	"github.com/mattermost/mattermost-plugin-ai/react"
 	"github.com/mattermost/mattermost-plugin-ai/streaming"
 	"github.com/mattermost/mattermost-plugin-ai/threads"
@@ -79,7 +80,7 @@ func (a *API) handleReact(c *gin.Context) {
 	emojiName, err := react.New(
 		bot.LLM(),
 		a.prompts,
-	).Resolve(post.Message, context)
+	).Resolve(c.Request.Context(), post.Message, context)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -143,6 +144,8 @@ func (a *API) handleThreadAnalysis(c *gin.Context) {
 		a.contextBuilder.WithLLMContextDefaultTools(bot, mmapi.IsDMWith(bot.GetMMBot().UserId, channel)),
 	)
 
+	ctx, cancel := streaming.NewRequestContext(stdcontext.Background())
+
 	// Create thread analyzer
 	analyzer := threads.New(bot.LLM(), a.prompts, a.mmClient)
 	var analysisStream *llm.TextStreamResult
@@ -150,15 +153,16 @@ func (a *API) handleThreadAnalysis(c *gin.Context) {
 	switch data.AnalysisType {
 	case "summarize_thread":
 		title = TitleThreadSummary
-		analysisStream, err = analyzer.Summarize(post.Id, llmContext)
+		analysisStream, err = analyzer.Summarize(ctx, post.Id, llmContext)
 	case "action_items":
 		title = TitleFindActionItems
-		analysisStream, err = analyzer.FindActionItems(post.Id, llmContext)
+		analysisStream, err = analyzer.FindActionItems(ctx, post.Id, llmContext)
 	case "open_questions":
 		title = TitleFindOpenQuestions
-		analysisStream, err = analyzer.FindOpenQuestions(post.Id, llmContext)
+		analysisStream, err = analyzer.FindOpenQuestions(ctx, post.Id, llmContext)
 	}
 	if err != nil {
+		cancel()
 		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to analyze thread: %w", err))
 		return
 	}
@@ -166,7 +170,7 @@ func (a *API) handleThreadAnalysis(c *gin.Context) {
 	// Create analysis post
 	siteURL := a.pluginAPI.Configuration.GetConfig().ServiceSettings.SiteURL
 	analysisPost := a.makeAnalysisPost(user.Locale, post.Id, data.AnalysisType, *siteURL)
-	if err := a.streamingService.StreamToNewDM(stdcontext.Background(), bot.GetMMBot().UserId, analysisStream, user.Id, analysisPost, post.Id); err != nil {
+	if err := a.streamingService.StreamToNewDM(ctx, cancel, bot.GetMMBot().UserId, analysisStream, user.Id, analysisPost, post.Id); err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -260,6 +264,11 @@ func (a *API) handleRegenerate(c *gin.Context) {
 
 	err := a.conversationsService.HandleRegenerate(userID, post, channel)
 	if err != nil {
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			c.AbortWithError(http.StatusTooManyRequests, err)
+			return
+		}
 		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("unable to regenerate post: %w", err))
 		return
 	}
@@ -294,7 +303,54 @@ func (a *API) handleToolCall(c *gin.Context) {
 
 	err := a.conversationsService.HandleToolCall(userID, post, channel, data.AcceptedToolIDs)
 	if err != nil {
-		if err.Error() == "post missing pending tool calls" || err.Error() == "post pending tool calls not valid JSON" {
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			c.AbortWithError(http.StatusTooManyRequests, err)
+		} else if err.Error() == "post missing pending tool calls" || err.Error() == "post pending tool calls not valid JSON" {
+			c.AbortWithError(http.StatusBadRequest, err)
+		} else {
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// handleToolApproval sends the requester the approval request of the private tool calls of a post again
+func (a *API) handleToolApproval(c *gin.Context) {
+	userID := c.GetHeader("Mattermost-User-Id")
+	post := c.MustGet(ContextPostKey).(*model.Post)
+
+	if err := a.enforceEmptyBody(c); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if !a.licenseChecker.IsBasicsLicensed() {
+		c.AbortWithError(http.StatusForbidden, errors.New("feature not licensed"))
+		return
+	}
+
+	// Only the original requester can approve/reject tool calls
+	if post.GetProp(streaming.LLMRequesterUserID) != userID {
+		c.AbortWithError(http.StatusForbidden, errors.New("only the original requester can approve/reject tool calls"))
+		return
+	}
+
+	if post.GetProp(streaming.PrivateToolCallProp) == nil {
+		c.AbortWithError(http.StatusBadRequest, errors.New("post missing pending tool calls"))
+		return
+	}
+
+	user, err := a.pluginAPI.User.Get(userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := a.streamingService.ResendToolApproval(post, user.Locale); err != nil {
+		if err.Error() == "post missing pending tool calls" {
 			c.AbortWithError(http.StatusBadRequest, err)
 		} else {
 			c.AbortWithError(http.StatusInternalServerError, err)
@@ -4,6 +4,7 @@
 package api
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -46,7 +47,7 @@ func (e *TestEnvironment) Cleanup(t *testing.T) {
 // createTestBots creates a test MMBots instance for testing
 func createTestBots(mockAPI *plugintest.API, client *pluginapi.Client) *bots.MMBots {
 	licenseChecker := enterprise.NewLicenseChecker(client)
-	testBots := bots.New(mockAPI, client, licenseChecker, nil, &http.Client{})
+	testBots := bots.New(mockAPI, client, licenseChecker, nil, &http.Client{}, nil)
 	return testBots
 }
 
@@ -78,7 +79,7 @@ func SetupTestEnvironment(t *testing.T) *TestEnvironment {
 	// Create minimal conversations service for testing
 	conversationsService := &conversations.Conversations{}
 
-	api := New(testBots, conversationsService, nil, nil, nil, client, noopMetrics, nil, &testConfigImpl{}, nil, nil, nil, nil, nil, nil)
+	api := New(testBots, conversationsService, nil, nil, nil, client, noopMetrics, nil, &testConfigImpl{}, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	return &TestEnvironment{
 		api:     api,
@@ -167,19 +168,29 @@ func TestAdminRouter(t *testing.T) {
 	gin.SetMode(gin.ReleaseMode)
 	gin.DefaultWriter = io.Discard
 
-	for urlName, url := range map[string]string{} {
+	for urlName, url := range map[string]string{
+		"list models":     "/admin/services/models",
+		"test connection": "/admin/services/test",
+	} {
 		for name, test := range map[string]struct {
 			request        *http.Request
 			expectedStatus int
 			envSetup       func(e *TestEnvironment)
 		}{
 			"only admins": {
-				request:        httptest.NewRequest(http.MethodGet, url, nil),
+				request:        httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"type":"openai","apiKey":"sk-xyz"}`)),
 				expectedStatus: http.StatusForbidden,
 				envSetup: func(e *TestEnvironment) {
 					e.mockAPI.On("HasPermissionTo", "userid", model.PermissionManageSystem).Return(false)
 				},
 			},
+			"invalid service configuration": {
+				request:        httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"type":"openai"}`)),
+				expectedStatus: http.StatusBadRequest,
+				envSetup: func(e *TestEnvironment) {
+					e.mockAPI.On("HasPermissionTo", "userid", model.PermissionManageSystem).Return(true)
+				},
+			},
 		} {
 			t.Run(urlName+" "+name, func(t *testing.T) {
 				e := SetupTestEnvironment(t)
@@ -199,6 +210,56 @@ func TestAdminRouter(t *testing.T) {
 	}
 }
 
+func TestCheckBotCapabilities(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	gin.DefaultWriter = io.Discard
+
+	for name, test := range map[string]struct {
+		body             string
+		expectedStatus   int
+		expectedProblems []string
+	}{
+		"supported features": {
+			body:             `{"service":{"type":"openaicompatible","apiURL":"http://localhost","modelSupportsVision":true},"enableVision":true}`,
+			expectedStatus:   http.StatusOK,
+			expectedProblems: []string{},
+		},
+		"vision the model does not support": {
+			body:             `{"service":{"type":"openaicompatible","apiURL":"http://localhost"},"enableVision":true}`,
+			expectedStatus:   http.StatusOK,
+			expectedProblems: []string{"vision is enabled but the model does not accept images, attached images are not sent to it"},
+		},
+		"unknown service type": {
+			body:           `{"service":{"type":"unknown"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := SetupTestEnvironment(t)
+			defer e.Cleanup(t)
+
+			e.mockAPI.On("LogError", mock.Anything).Maybe()
+			e.mockAPI.On("HasPermissionTo", "userid", model.PermissionManageSystem).Return(true)
+
+			request := httptest.NewRequest(http.MethodPost, "/admin/bots/capabilities", strings.NewReader(test.body))
+			request.Header.Add("Mattermost-User-ID", "userid")
+			recorder := httptest.NewRecorder()
+			e.api.ServeHTTP(&plugin.Context{}, recorder, request)
+			resp := recorder.Result()
+			require.Equal(t, test.expectedStatus, resp.StatusCode)
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var result struct {
+				Problems []string `json:"problems"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			require.Equal(t, test.expectedProblems, result.Problems)
+		})
+	}
+}
+
 func TestEnforceEmptyBody(t *testing.T) {
 	// This just makes gin not output a whole bunch of debug stuff.
 	gin.SetMode(gin.ReleaseMode)
@@ -6,10 +6,12 @@
 package asage
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
 	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/tokenizer"
 )
 
 type Provider struct {
@@ -17,6 +19,7 @@ type Provider struct {
 	defaultModel     string
 	inputTokenLimit  int
 	outputTokenLimit int
+	tokenizer        llm.Tokenizer
 }
 
 func New(llmService llm.ServiceConfig, httpClient *http.Client) *Provider {
@@ -26,7 +29,7 @@ func New(llmService llm.ServiceConfig, httpClient *http.Client) *Provider {
 		return nil
 	}
 
-	if err := client.Login(GetTokenParams{
+	if err := client.Login(context.Background(), GetTokenParams{
 		Email:    result[0],
 		Password: result[1],
 	}); err != nil {
@@ -38,6 +41,7 @@ func New(llmService llm.ServiceConfig, httpClient *http.Client) *Provider {
 		defaultModel:     llmService.DefaultModel,
 		inputTokenLimit:  llmService.InputTokenLimit,
 		outputTokenLimit: llmService.OutputTokenLimit,
+		tokenizer:        tokenizer.ForModel(llmService.DefaultModel),
 	}
 }
 
@@ -76,27 +80,30 @@ func (s *Provider) createConfig(opts []llm.LanguageModelOption) llm.LanguageMode
 }
 
 func (s *Provider) queryParamsFromConfig(cfg llm.LanguageModelConfig) QueryParams {
-	return QueryParams{
+	params := QueryParams{
 		Model: cfg.Model,
 	}
+	// ASage has no other sampling parameters
+	params.Temperature = cfg.Temperature
+	return params
 }
 
-func (s *Provider) ChatCompletion(request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
+func (s *Provider) ChatCompletion(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
 	// ASage does not support streaming.
-	result, err := s.ChatCompletionNoStream(request, opts...)
+	result, err := s.ChatCompletionNoStream(ctx, request, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return llm.NewStreamFromString(result), nil
 }
 
-func (s *Provider) ChatCompletionNoStream(request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
+func (s *Provider) ChatCompletionNoStream(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
 	params := s.queryParamsFromConfig(s.createConfig(opts))
 	params.Message = conversationToMessagesList(request.Posts)
 	params.SystemPrompt = request.ExtractSystemMessage()
 	params.Persona = "default"
 
-	response, err := s.client.Query(params)
+	response, err := s.client.Query(ctx, params)
 	if err != nil {
 		return "", err
 	}
@@ -105,14 +112,17 @@ func (s *Provider) ChatCompletionNoStream(request llm.CompletionRequest, opts ..
 
 // TODO: Implement actual token counting. For now just estimated based off OpenAI estimations
 func (s *Provider) CountTokens(text string) int {
-	charCount := float64(len(text)) / 4.0
-	wordCount := float64(len(strings.Fields(text))) / 0.75
-
-	// Average the two and add a buffer
-	return int((charCount+wordCount)/2.0) + 100
+	// Add a buffer since the models behind ASage are not known
+	return s.tokenizer.CountTokens(text) + 100
 }
 
 // TODO: Figure out what the actual token limit is. For now just be conservative.
 func (s *Provider) InputTokenLimit() int {
 	return 200000
 }
+
+func (s *Provider) Capabilities() llm.Capabilities {
+	return llm.Capabilities{
+		ContextWindow: s.InputTokenLimit(),
+	}
+}
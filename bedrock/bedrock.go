@@ -0,0 +1,578 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+// Package bedrock implements the LanguageModel interface on top of the Amazon Bedrock Converse API.
+// Requests are signed with SigV4 directly so we don't need to pull in the AWS SDK.
+package bedrock
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/tokenizer"
+)
+
+const (
+	DefaultMaxTokens       = 8192
+	DefaultInputTokenLimit = 100000
+	// MaxImageSize is the largest image the Converse API accepts
+	MaxImageSize = 3750 * 1024 // 3.75 MB
+
+	signingService = "bedrock"
+)
+
+type Bedrock struct {
+	httpClient       *http.Client
+	apiURL           string
+	signer           signer
+	defaultModel     string
+	inputTokenLimit  int
+	outputTokenLimit int
+	tokenizer        llm.Tokenizer
+}
+
+func New(llmService llm.ServiceConfig, httpClient *http.Client) *Bedrock {
+	apiURL := strings.TrimSuffix(llmService.APIURL, "/")
+	if apiURL == "" {
+		apiURL = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", llmService.AWSRegion)
+	}
+
+	return &Bedrock{
+		httpClient: httpClient,
+		apiURL:     apiURL,
+		signer: signer{
+			accessKeyID:     llmService.AWSAccessKeyID,
+			secretAccessKey: llmService.AWSSecretAccessKey,
+			region:          llmService.AWSRegion,
+			service:         signingService,
+		},
+		defaultModel:     llmService.DefaultModel,
+		inputTokenLimit:  llmService.InputTokenLimit,
+		outputTokenLimit: llmService.OutputTokenLimit,
+		tokenizer:        tokenizer.ForModel(llmService.DefaultModel),
+	}
+}
+
+type message struct {
+	Role    string         `json:"role"`
+	Content []contentBlock `json:"content"`
+}
+
+type contentBlock struct {
+	Text       string      `json:"text,omitempty"`
+	Image      *imageBlock `json:"image,omitempty"`
+	ToolUse    *toolUse    `json:"toolUse,omitempty"`
+	ToolResult *toolResult `json:"toolResult,omitempty"`
+}
+
+type imageBlock struct {
+	Format string      `json:"format"`
+	Source imageSource `json:"source"`
+}
+
+type imageSource struct {
+	Bytes string `json:"bytes"`
+}
+
+type toolUse struct {
+	ToolUseID string          `json:"toolUseId"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+}
+
+type toolResult struct {
+	ToolUseID string              `json:"toolUseId"`
+	Content   []toolResultContent `json:"content"`
+	Status    string              `json:"status"`
+}
+
+type toolResultContent struct {
+	Text string `json:"text"`
+}
+
+type systemBlock struct {
+	Text string `json:"text"`
+}
+
+type inferenceConfig struct {
+	MaxTokens     int      `json:"maxTokens,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"topP,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+}
+
+type toolConfig struct {
+	Tools []toolDefinition `json:"tools"`
+}
+
+type toolDefinition struct {
+	ToolSpec toolSpec `json:"toolSpec"`
+}
+
+type toolSpec struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	InputSchema inputSchema `json:"inputSchema"`
+}
+
+type inputSchema struct {
+	JSON map[string]any `json:"json"`
+}
+
+type converseRequest struct {
+	Messages        []message        `json:"messages"`
+	System          []systemBlock    `json:"system,omitempty"`
+	InferenceConfig *inferenceConfig `json:"inferenceConfig,omitempty"`
+	ToolConfig      *toolConfig      `json:"toolConfig,omitempty"`
+}
+
+type converseResponse struct {
+	Output struct {
+		Message message `json:"message"`
+	} `json:"output"`
+	StopReason string `json:"stopReason"`
+}
+
+type contentBlockStartEvent struct {
+	ContentBlockIndex int `json:"contentBlockIndex"`
+	Start             struct {
+		ToolUse *struct {
+			ToolUseID string `json:"toolUseId"`
+			Name      string `json:"name"`
+		} `json:"toolUse"`
+	} `json:"start"`
+}
+
+type contentBlockDeltaEvent struct {
+	ContentBlockIndex int `json:"contentBlockIndex"`
+	Delta             struct {
+		Text    string `json:"text"`
+		ToolUse *struct {
+			Input string `json:"input"`
+		} `json:"toolUse"`
+	} `json:"delta"`
+}
+
+type messageStopEvent struct {
+	StopReason string `json:"stopReason"`
+}
+
+type exceptionEvent struct {
+	Message string `json:"message"`
+}
+
+// imageFormats maps the supported MIME types to Bedrock image formats
+var imageFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// conversationToMessages creates the system blocks and messages from conversation posts.
+// Bedrock requires user and assistant turns to alternate so consecutive posts with the same role are merged.
+func conversationToMessages(posts []llm.Post) ([]systemBlock, []message) {
+	var system []systemBlock
+	messages := make([]message, 0, len(posts))
+
+	var currentBlocks []contentBlock
+	currentRole := ""
+
+	flushCurrentMessage := func() {
+		if len(currentBlocks) > 0 {
+			messages = append(messages, message{
+				Role:    currentRole,
+				Content: currentBlocks,
+			})
+			currentBlocks = nil
+		}
+	}
+
+	for _, post := range posts {
+		role := ""
+		switch post.Role {
+		case llm.PostRoleSystem:
+			if post.Message != "" {
+				system = append(system, systemBlock{Text: post.Message})
+			}
+			continue
+		case llm.PostRoleBot:
+			role = "assistant"
+		case llm.PostRoleUser:
+			role = "user"
+		default:
+			continue
+		}
+
+		if role != currentRole {
+			flushCurrentMessage()
+			currentRole = role
+		}
+
+		if post.Message != "" {
+			currentBlocks = append(currentBlocks, contentBlock{Text: post.Message})
+		}
+
+		for _, file := range post.Files {
+			format, ok := imageFormats[file.MimeType]
+			if !ok {
+				currentBlocks = append(currentBlocks, contentBlock{Text: fmt.Sprintf("[Unsupported image type: %s]", file.MimeType)})
+				continue
+			}
+
+			data, err := io.ReadAll(file.Reader)
+			if err != nil {
+				currentBlocks = append(currentBlocks, contentBlock{Text: "[Error reading image data]"})
+				continue
+			}
+
+			currentBlocks = append(currentBlocks, contentBlock{Image: &imageBlock{
+				Format: format,
+				Source: imageSource{Bytes: base64.StdEncoding.EncodeToString(data)},
+			}})
+		}
+
+		if len(post.ToolUse) > 0 {
+			for _, tc := range post.ToolUse {
+				input := tc.Arguments
+				if len(input) == 0 {
+					input = json.RawMessage("{}")
+				}
+				currentBlocks = append(currentBlocks, contentBlock{ToolUse: &toolUse{
+					ToolUseID: tc.ID,
+					Name:      tc.Name,
+					Input:     input,
+				}})
+			}
+
+			flushCurrentMessage()
+			currentRole = "user"
+			for _, tc := range post.ToolUse {
+				status := "success"
+				if tc.Status != llm.ToolCallStatusSuccess {
+					status = "error"
+				}
+				currentBlocks = append(currentBlocks, contentBlock{ToolResult: &toolResult{
+					ToolUseID: tc.ID,
+					Content:   []toolResultContent{{Text: tc.Result}},
+					Status:    status,
+				}})
+			}
+			flushCurrentMessage()
+		}
+	}
+
+	flushCurrentMessage()
+	return system, messages
+}
+
+func convertTools(tools []llm.Tool) *toolConfig {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	definitions := make([]toolDefinition, 0, len(tools))
+	for _, tool := range tools {
+		schema := map[string]any{
+			"type":       "object",
+			"properties": map[string]any{},
+		}
+		if tool.Schema != nil {
+			if tool.Schema.Properties != nil {
+				schema["properties"] = tool.Schema.Properties
+			}
+			if len(tool.Schema.Required) > 0 {
+				schema["required"] = tool.Schema.Required
+			}
+		}
+
+		definitions = append(definitions, toolDefinition{ToolSpec: toolSpec{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: inputSchema{JSON: schema},
+		}})
+	}
+
+	return &toolConfig{Tools: definitions}
+}
+
+func (b *Bedrock) GetDefaultConfig() llm.LanguageModelConfig {
+	config := llm.LanguageModelConfig{
+		Model: b.defaultModel,
+	}
+	if b.outputTokenLimit == 0 {
+		config.MaxGeneratedTokens = DefaultMaxTokens
+	} else {
+		config.MaxGeneratedTokens = b.outputTokenLimit
+	}
+	return config
+}
+
+func (b *Bedrock) createConfig(opts []llm.LanguageModelOption) llm.LanguageModelConfig {
+	cfg := b.GetDefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func (b *Bedrock) createRequest(request llm.CompletionRequest, cfg llm.LanguageModelConfig) converseRequest {
+	system, messages := conversationToMessages(request.Posts)
+	converseReq := converseRequest{
+		Messages: messages,
+		System:   system,
+	}
+	if cfg.MaxGeneratedTokens > 0 || cfg.Temperature != nil || cfg.TopP != nil || len(cfg.StopSequences) > 0 {
+		converseReq.InferenceConfig = &inferenceConfig{
+			MaxTokens:     cfg.MaxGeneratedTokens,
+			Temperature:   cfg.Temperature,
+			TopP:          cfg.TopP,
+			StopSequences: cfg.StopSequences,
+		}
+	}
+	if request.Context != nil && request.Context.Tools != nil {
+		converseReq.ToolConfig = convertTools(request.Context.Tools.GetTools())
+	}
+	return converseReq
+}
+
+// do sends a signed request to the given operation of the model and returns the response on success.
+func (b *Bedrock) do(ctx context.Context, model, operation string, body any) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/model/%s/%s", b.apiURL, uriEncode(model), operation)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	b.signer.sign(req, payload, time.Now())
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		message := strings.TrimSpace(string(respBody))
+		var errResp exceptionEvent
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Message != "" {
+			message = fmt.Sprintf("%s: %s", resp.Header.Get("X-Amzn-Errortype"), errResp.Message)
+		}
+		return nil, fmt.Errorf("bedrock request failed: %w", &llm.UpstreamError{StatusCode: resp.StatusCode, Header: resp.Header, Body: message})
+	}
+
+	return resp, nil
+}
+
+type pendingToolUse struct {
+	id    string
+	name  string
+	input strings.Builder
+}
+
+func (b *Bedrock) streamChat(ctx context.Context, model string, request converseRequest, output chan<- llm.TextStreamEvent) {
+	resp, err := b.do(ctx, model, "converse-stream", request)
+	if err != nil {
+		output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeError,
+			Value: err,
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	toolUses := map[int]*pendingToolUse{}
+	toolUseOrder := []int{}
+	stopped := false
+	for {
+		msg, err := readEventStreamMessage(resp.Body)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeError,
+				Value: fmt.Errorf("error reading bedrock stream: %w", err),
+			}
+			return
+		}
+
+		if msg.headers[":message-type"] != "event" {
+			var exception exceptionEvent
+			_ = json.Unmarshal(msg.payload, &exception)
+			errorType := msg.headers[":exception-type"]
+			if errorType == "" {
+				errorType = msg.headers[":error-code"]
+			}
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeError,
+				Value: fmt.Errorf("error from bedrock stream (%s): %s", errorType, exception.Message),
+			}
+			return
+		}
+
+		switch msg.headers[":event-type"] {
+		case "contentBlockStart":
+			var event contentBlockStartEvent
+			if err := json.Unmarshal(msg.payload, &event); err != nil {
+				output <- llm.TextStreamEvent{
+					Type:  llm.EventTypeError,
+					Value: fmt.Errorf("failed to parse bedrock event: %w", err),
+				}
+				return
+			}
+			if event.Start.ToolUse != nil {
+				toolUses[event.ContentBlockIndex] = &pendingToolUse{
+					id:   event.Start.ToolUse.ToolUseID,
+					name: event.Start.ToolUse.Name,
+				}
+				toolUseOrder = append(toolUseOrder, event.ContentBlockIndex)
+			}
+		case "contentBlockDelta":
+			var event contentBlockDeltaEvent
+			if err := json.Unmarshal(msg.payload, &event); err != nil {
+				output <- llm.TextStreamEvent{
+					Type:  llm.EventTypeError,
+					Value: fmt.Errorf("failed to parse bedrock event: %w", err),
+				}
+				return
+			}
+			if event.Delta.Text != "" {
+				output <- llm.TextStreamEvent{
+					Type:  llm.EventTypeText,
+					Value: event.Delta.Text,
+				}
+			}
+			if event.Delta.ToolUse != nil {
+				if pending, ok := toolUses[event.ContentBlockIndex]; ok {
+					pending.input.WriteString(event.Delta.ToolUse.Input)
+				}
+			}
+		case "messageStop":
+			stopped = true
+			var event messageStopEvent
+			if err := json.Unmarshal(msg.payload, &event); err == nil {
+				switch event.StopReason {
+				case "guardrail_intervened", "content_filtered":
+					output <- llm.TextStreamEvent{
+						Type:  llm.EventTypeError,
+						Value: fmt.Errorf("bedrock stopped generating: %s", event.StopReason),
+					}
+					return
+				}
+			}
+		}
+	}
+
+	// The connection was closed before the response was complete, the request can be sent again
+	if !stopped {
+		output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeError,
+			Value: fmt.Errorf("bedrock stream ended without a messageStop event: %w", io.ErrUnexpectedEOF),
+		}
+		return
+	}
+
+	if len(toolUseOrder) > 0 {
+		pendingToolCalls := make([]llm.ToolCall, 0, len(toolUseOrder))
+		for _, index := range toolUseOrder {
+			pending := toolUses[index]
+			arguments := pending.input.String()
+			if arguments == "" {
+				arguments = "{}"
+			}
+			pendingToolCalls = append(pendingToolCalls, llm.ToolCall{
+				ID:        pending.id,
+				Name:      pending.name,
+				Arguments: json.RawMessage(arguments),
+			})
+		}
+		output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeToolCalls,
+			Value: pendingToolCalls,
+		}
+		return
+	}
+
+	output <- llm.TextStreamEvent{
+		Type:  llm.EventTypeEnd,
+		Value: nil,
+	}
+}
+
+func (b *Bedrock) ChatCompletion(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
+	cfg := b.createConfig(opts)
+	converseReq := b.createRequest(request, cfg)
+
+	eventStream := make(chan llm.TextStreamEvent)
+	go func() {
+		defer close(eventStream)
+		b.streamChat(ctx, cfg.Model, converseReq, eventStream)
+	}()
+
+	return &llm.TextStreamResult{Stream: eventStream}, nil
+}
+
+func (b *Bedrock) ChatCompletionNoStream(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
+	cfg := b.createConfig(opts)
+	converseReq := b.createRequest(request, cfg)
+
+	resp, err := b.do(ctx, cfg.Model, "converse", converseReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var converseResp converseResponse
+	if err := json.NewDecoder(resp.Body).Decode(&converseResp); err != nil {
+		return "", fmt.Errorf("failed to decode bedrock response: %w", err)
+	}
+
+	var result strings.Builder
+	for _, block := range converseResp.Output.Message.Content {
+		if block.ToolUse != nil {
+			return "", errors.New("tool calls are not supported without streaming")
+		}
+		result.WriteString(block.Text)
+	}
+
+	return result.String(), nil
+}
+
+func (b *Bedrock) CountTokens(text string) int {
+	return b.tokenizer.CountTokens(text)
+}
+
+func (b *Bedrock) InputTokenLimit() int {
+	if b.inputTokenLimit > 0 {
+		return b.inputTokenLimit
+	}
+	return DefaultInputTokenLimit
+}
+
+// Capabilities reports what the Claude models on Bedrock support, JSON output is only asked for in the prompt.
+func (b *Bedrock) Capabilities() llm.Capabilities {
+	return llm.Capabilities{
+		Vision:        true,
+		Tools:         true,
+		JSONOutput:    false,
+		Streaming:     true,
+		MaxImageSize:  MaxImageSize,
+		ContextWindow: b.InputTokenLimit(),
+	}
+}
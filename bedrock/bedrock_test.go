@@ -0,0 +1,365 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package bedrock
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+)
+
+const (
+	testAccessKeyID     = "AKIDEXAMPLE"
+	testSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion          = "us-west-2"
+	testModel           = "anthropic.claude-3-5-sonnet-20240620-v1:0"
+)
+
+// encodeFrame builds an event stream frame with string headers.
+func encodeFrame(headers map[string]string, payload string) []byte {
+	var headerBytes bytes.Buffer
+	for name, value := range headers {
+		headerBytes.WriteByte(byte(len(name)))
+		headerBytes.WriteString(name)
+		headerBytes.WriteByte(7)
+		_ = binary.Write(&headerBytes, binary.BigEndian, uint16(len(value)))
+		headerBytes.WriteString(value)
+	}
+
+	totalLength := uint32(eventStreamPreludeLength + headerBytes.Len() + len(payload) + eventStreamCRCLength)
+	var frame bytes.Buffer
+	_ = binary.Write(&frame, binary.BigEndian, totalLength)
+	_ = binary.Write(&frame, binary.BigEndian, uint32(headerBytes.Len()))
+	_ = binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	frame.Write(headerBytes.Bytes())
+	frame.WriteString(payload)
+	_ = binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	return frame.Bytes()
+}
+
+func eventFrame(eventType, payload string) []byte {
+	return encodeFrame(map[string]string{
+		":message-type": "event",
+		":event-type":   eventType,
+		":content-type": "application/json",
+	}, payload)
+}
+
+var authorizationPattern = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/(\d{8})/([^/]+)/([^/]+)/aws4_request, SignedHeaders=([^,]+), Signature=([0-9a-f]{64})$`)
+
+// fakeBedrock stands in for the Bedrock runtime endpoint. It checks the SigV4 signature of every request
+// before replaying the configured frames.
+type fakeBedrock struct {
+	t           *testing.T
+	frames      [][]byte
+	response    string
+	lastPath    string
+	lastRequest converseRequest
+}
+
+func (f *fakeBedrock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(f.t, err)
+
+	if !f.verifySignature(r, body) {
+		w.Header().Set("X-Amzn-Errortype", "InvalidSignatureException")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"The request signature we calculated does not match the signature you provided."}`))
+		return
+	}
+
+	f.lastPath = r.URL.EscapedPath()
+	require.NoError(f.t, json.Unmarshal(body, &f.lastRequest))
+
+	if strings.HasSuffix(r.URL.Path, "/converse") {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(f.response))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+	for _, frame := range f.frames {
+		_, _ = w.Write(frame)
+	}
+}
+
+// verifySignature re-signs the received request, restricted to the headers the client claims to have signed.
+func (f *fakeBedrock) verifySignature(r *http.Request, body []byte) bool {
+	matches := authorizationPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	if matches == nil || matches[1] != testAccessKeyID || matches[3] != testRegion || matches[4] != signingService {
+		return false
+	}
+
+	signedAt, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	check, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return false
+	}
+	for _, name := range strings.Split(matches[5], ";") {
+		if name == "host" || name == "x-amz-date" {
+			continue
+		}
+		check.Header.Set(name, r.Header.Get(name))
+	}
+
+	s := signer{
+		accessKeyID:     testAccessKeyID,
+		secretAccessKey: testSecretAccessKey,
+		region:          testRegion,
+		service:         signingService,
+	}
+	s.sign(check, body, signedAt)
+
+	return check.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+type lookupArgs struct {
+	Username string
+}
+
+func newTestBedrock(t *testing.T, fake *fakeBedrock, secret string) *Bedrock {
+	fake.t = t
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return New(llm.ServiceConfig{
+		APIURL:             server.URL,
+		DefaultModel:       testModel,
+		AWSAccessKeyID:     testAccessKeyID,
+		AWSSecretAccessKey: secret,
+		AWSRegion:          testRegion,
+	}, server.Client())
+}
+
+func TestChatCompletionStreamsText(t *testing.T) {
+	fake := &fakeBedrock{
+		frames: [][]byte{
+			eventFrame("messageStart", `{"role":"assistant"}`),
+			eventFrame("contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Hello"}}`),
+			eventFrame("contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":" world"}}`),
+			eventFrame("contentBlockStop", `{"contentBlockIndex":0}`),
+			eventFrame("messageStop", `{"stopReason":"end_turn"}`),
+			eventFrame("metadata", `{"usage":{"inputTokens":10,"outputTokens":2,"totalTokens":12},"metrics":{"latencyMs":100}}`),
+		},
+	}
+	b := newTestBedrock(t, fake, testSecretAccessKey)
+
+	result, err := b.ChatCompletion(context.Background(), llm.CompletionRequest{
+		Posts: []llm.Post{
+			{Role: llm.PostRoleSystem, Message: "You are helpful"},
+			{Role: llm.PostRoleUser, Message: "Hi"},
+		},
+		Context: llm.NewContext(),
+	})
+	require.NoError(t, err)
+
+	text, err := result.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello world", text)
+
+	assert.Equal(t, "/model/anthropic.claude-3-5-sonnet-20240620-v1%3A0/converse-stream", fake.lastPath)
+	assert.Equal(t, []systemBlock{{Text: "You are helpful"}}, fake.lastRequest.System)
+	require.Len(t, fake.lastRequest.Messages, 1)
+	assert.Equal(t, "user", fake.lastRequest.Messages[0].Role)
+	require.NotNil(t, fake.lastRequest.InferenceConfig)
+	assert.Equal(t, DefaultMaxTokens, fake.lastRequest.InferenceConfig.MaxTokens)
+}
+
+func TestChatCompletionToolCalls(t *testing.T) {
+	fake := &fakeBedrock{
+		frames: [][]byte{
+			eventFrame("messageStart", `{"role":"assistant"}`),
+			eventFrame("contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Let me check."}}`),
+			eventFrame("contentBlockStop", `{"contentBlockIndex":0}`),
+			eventFrame("contentBlockStart", `{"contentBlockIndex":1,"start":{"toolUse":{"toolUseId":"tooluse_1","name":"LookupMattermostUser"}}}`),
+			eventFrame("contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":"{\"Username\":"}}}`),
+			eventFrame("contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":"\"alice\"}"}}}`),
+			eventFrame("contentBlockStop", `{"contentBlockIndex":1}`),
+			eventFrame("messageStop", `{"stopReason":"tool_use"}`),
+		},
+	}
+	b := newTestBedrock(t, fake, testSecretAccessKey)
+
+	tools := llm.NewToolStore(nil, false)
+	tools.AddTools([]llm.Tool{{
+		Name:        "LookupMattermostUser",
+		Description: "Lookup a user",
+		Schema:      llm.NewJSONSchemaFromStruct(lookupArgs{}),
+	}})
+
+	result, err := b.ChatCompletion(context.Background(), llm.CompletionRequest{
+		Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Who is alice?"}},
+		Context: llm.NewContext(func(c *llm.Context) { c.Tools = tools }),
+	})
+	require.NoError(t, err)
+
+	text := ""
+	var toolCalls []llm.ToolCall
+	for event := range result.Stream {
+		require.NotEqual(t, llm.EventTypeError, event.Type, "unexpected error: %v", event.Value)
+		switch event.Type {
+		case llm.EventTypeText:
+			text += event.Value.(string)
+		case llm.EventTypeToolCalls:
+			toolCalls = event.Value.([]llm.ToolCall)
+		}
+	}
+
+	assert.Equal(t, "Let me check.", text)
+	require.Len(t, toolCalls, 1)
+	assert.Equal(t, "tooluse_1", toolCalls[0].ID)
+	assert.Equal(t, "LookupMattermostUser", toolCalls[0].Name)
+	assert.JSONEq(t, `{"Username":"alice"}`, string(toolCalls[0].Arguments))
+
+	require.NotNil(t, fake.lastRequest.ToolConfig)
+	require.Len(t, fake.lastRequest.ToolConfig.Tools, 1)
+	spec := fake.lastRequest.ToolConfig.Tools[0].ToolSpec
+	assert.Equal(t, "LookupMattermostUser", spec.Name)
+	assert.Equal(t, "object", spec.InputSchema.JSON["type"])
+	assert.Contains(t, spec.InputSchema.JSON["properties"], "Username")
+}
+
+func TestChatCompletionErrors(t *testing.T) {
+	t.Run("bad signature is rejected", func(t *testing.T) {
+		fake := &fakeBedrock{}
+		b := newTestBedrock(t, fake, "wrong-secret")
+
+		result, err := b.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "InvalidSignatureException")
+	})
+
+	t.Run("exception frame", func(t *testing.T) {
+		fake := &fakeBedrock{
+			frames: [][]byte{
+				eventFrame("contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"partial"}}`),
+				encodeFrame(map[string]string{
+					":message-type":   "exception",
+					":exception-type": "throttlingException",
+				}, `{"message":"Too many requests"}`),
+			},
+		}
+		b := newTestBedrock(t, fake, testSecretAccessKey)
+
+		result, err := b.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "throttlingException")
+		assert.Contains(t, err.Error(), "Too many requests")
+	})
+
+	t.Run("corrupt frame", func(t *testing.T) {
+		frame := eventFrame("contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Hello"}}`)
+		frame[len(frame)-5] ^= 0xff
+		fake := &fakeBedrock{frames: [][]byte{frame}}
+		b := newTestBedrock(t, fake, testSecretAccessKey)
+
+		result, err := b.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "checksum")
+	})
+
+	t.Run("stream ending without messageStop", func(t *testing.T) {
+		fake := &fakeBedrock{frames: [][]byte{
+			eventFrame("contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"partial"}}`),
+		}}
+		b := newTestBedrock(t, fake, testSecretAccessKey)
+
+		result, err := b.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.Error(t, err)
+		assert.True(t, llm.IsRetryableError(err))
+	})
+}
+
+func TestChatCompletionNoStream(t *testing.T) {
+	fake := &fakeBedrock{
+		response: `{"output":{"message":{"role":"assistant","content":[{"text":"A short title"}]}},"stopReason":"end_turn"}`,
+	}
+	b := newTestBedrock(t, fake, testSecretAccessKey)
+
+	text, err := b.ChatCompletionNoStream(context.Background(), llm.CompletionRequest{
+		Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Title this"}},
+		Context: llm.NewContext(),
+	}, llm.WithMaxGeneratedTokens(25))
+	require.NoError(t, err)
+	assert.Equal(t, "A short title", text)
+	assert.Equal(t, "/model/anthropic.claude-3-5-sonnet-20240620-v1%3A0/converse", fake.lastPath)
+	assert.Equal(t, 25, fake.lastRequest.InferenceConfig.MaxTokens)
+}
+
+func TestConversationToMessages(t *testing.T) {
+	posts := []llm.Post{
+		{Role: llm.PostRoleSystem, Message: "system"},
+		{
+			Role:    llm.PostRoleUser,
+			Message: "Look at this",
+			Files: []llm.File{
+				{MimeType: "image/png", Reader: bytes.NewReader([]byte("image-1"))},
+				{MimeType: "image/tiff", Reader: bytes.NewReader([]byte("image-2"))},
+			},
+		},
+		{
+			Role: llm.PostRoleBot,
+			ToolUse: []llm.ToolCall{
+				{ID: "1", Name: "SearchServer", Arguments: json.RawMessage(`{"term":"roadmap"}`), Result: "found it", Status: llm.ToolCallStatusSuccess},
+				{ID: "2", Name: "SearchServer", Arguments: json.RawMessage(`{"term":"secret"}`), Result: "rejected", Status: llm.ToolCallStatusRejected},
+			},
+		},
+	}
+
+	system, messages := conversationToMessages(posts)
+	assert.Equal(t, []systemBlock{{Text: "system"}}, system)
+	require.Len(t, messages, 3)
+
+	assert.Equal(t, message{Role: "user", Content: []contentBlock{
+		{Text: "Look at this"},
+		{Image: &imageBlock{Format: "png", Source: imageSource{Bytes: "aW1hZ2UtMQ=="}}},
+		{Text: "[Unsupported image type: image/tiff]"},
+	}}, messages[0])
+
+	assert.Equal(t, "assistant", messages[1].Role)
+	require.Len(t, messages[1].Content, 2)
+	assert.Equal(t, "SearchServer", messages[1].Content[0].ToolUse.Name)
+
+	assert.Equal(t, "user", messages[2].Role)
+	require.Len(t, messages[2].Content, 2)
+	assert.Equal(t, "success", messages[2].Content[0].ToolResult.Status)
+	assert.Equal(t, "error", messages[2].Content[1].ToolResult.Status)
+}
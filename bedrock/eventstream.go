@@ -0,0 +1,119 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package bedrock
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	eventStreamPreludeLength = 12
+	eventStreamCRCLength     = 4
+	// maxEventStreamMessageLength guards against allocating huge buffers for corrupt frames.
+	maxEventStreamMessageLength = 16 * 1024 * 1024
+)
+
+// eventStreamMessage is a single frame of the application/vnd.amazon.eventstream encoding.
+type eventStreamMessage struct {
+	headers map[string]string
+	payload []byte
+}
+
+// readEventStreamMessage reads and validates the next frame from the stream.
+// Only string header values are kept, which is all Bedrock uses.
+// See https://docs.aws.amazon.com/transcribe/latest/dg/event-stream.html for the format.
+func readEventStreamMessage(r io.Reader) (*eventStreamMessage, error) {
+	prelude := make([]byte, eventStreamPreludeLength)
+	if _, err := io.ReadFull(r, prelude); err != nil {
+		return nil, err
+	}
+
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[0:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, errors.New("event stream prelude checksum mismatch")
+	}
+
+	if totalLength < eventStreamPreludeLength+eventStreamCRCLength || totalLength > maxEventStreamMessageLength {
+		return nil, fmt.Errorf("invalid event stream message length %d", totalLength)
+	}
+	if headersLength > totalLength-eventStreamPreludeLength-eventStreamCRCLength {
+		return nil, fmt.Errorf("invalid event stream headers length %d", headersLength)
+	}
+
+	message := make([]byte, totalLength)
+	copy(message, prelude)
+	if _, err := io.ReadFull(r, message[eventStreamPreludeLength:]); err != nil {
+		return nil, fmt.Errorf("failed to read event stream message: %w", io.ErrUnexpectedEOF)
+	}
+
+	crcOffset := totalLength - eventStreamCRCLength
+	if crc32.ChecksumIEEE(message[:crcOffset]) != binary.BigEndian.Uint32(message[crcOffset:]) {
+		return nil, errors.New("event stream message checksum mismatch")
+	}
+
+	headersEnd := eventStreamPreludeLength + headersLength
+	headers, err := parseEventStreamHeaders(message[eventStreamPreludeLength:headersEnd])
+	if err != nil {
+		return nil, err
+	}
+
+	return &eventStreamMessage{
+		headers: headers,
+		payload: message[headersEnd:crcOffset],
+	}, nil
+}
+
+func parseEventStreamHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	errTruncated := errors.New("truncated event stream header")
+
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 1+nameLength+1 {
+			return nil, errTruncated
+		}
+		name := string(data[1 : 1+nameLength])
+		valueType := data[1+nameLength]
+		data = data[1+nameLength+1:]
+
+		var valueLength int
+		switch valueType {
+		case 0, 1: // boolean true and false carry no value
+			valueLength = 0
+		case 2: // byte
+			valueLength = 1
+		case 3: // short
+			valueLength = 2
+		case 4: // integer
+			valueLength = 4
+		case 5, 8: // long and timestamp
+			valueLength = 8
+		case 9: // uuid
+			valueLength = 16
+		case 6, 7: // byte array and string are prefixed by their length
+			if len(data) < 2 {
+				return nil, errTruncated
+			}
+			valueLength = int(binary.BigEndian.Uint16(data[0:2]))
+			data = data[2:]
+		default:
+			return nil, fmt.Errorf("unknown event stream header type %d", valueType)
+		}
+
+		if len(data) < valueLength {
+			return nil, errTruncated
+		}
+		if valueType == 7 {
+			headers[name] = string(data[:valueLength])
+		}
+		data = data[valueLength:]
+	}
+
+	return headers, nil
+}
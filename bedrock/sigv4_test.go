@@ -0,0 +1,39 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package bedrock
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignMatchesAWSTestSuite(t *testing.T) {
+	// get-vanilla from the AWS SigV4 test suite
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+
+	s := signer{
+		accessKeyID:     "AKIDEXAMPLE",
+		secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		region:          "us-east-1",
+		service:         "service",
+	}
+	s.sign(req, nil, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t,
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"),
+	)
+}
+
+func TestCanonicalURI(t *testing.T) {
+	assert.Equal(t, "/", canonicalURI(""))
+	assert.Equal(t, "/", canonicalURI("/"))
+	assert.Equal(t, "/model/anthropic.claude-v2%253A1/converse", canonicalURI("/model/anthropic.claude-v2%3A1/converse"))
+}
@@ -11,11 +11,15 @@ import (
 
 	"github.com/mattermost/mattermost-plugin-ai/anthropic"
 	"github.com/mattermost/mattermost-plugin-ai/asage"
+	"github.com/mattermost/mattermost-plugin-ai/bedrock"
 	"github.com/mattermost/mattermost-plugin-ai/config"
 	"github.com/mattermost/mattermost-plugin-ai/enterprise"
+	"github.com/mattermost/mattermost-plugin-ai/gemini"
 	"github.com/mattermost/mattermost-plugin-ai/llm"
 	"github.com/mattermost/mattermost-plugin-ai/mmapi"
+	"github.com/mattermost/mattermost-plugin-ai/ollama"
 	"github.com/mattermost/mattermost-plugin-ai/openai"
+	"github.com/mattermost/mattermost-plugin-ai/quota"
 	"github.com/mattermost/mattermost-plugin-ai/subtitles"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
@@ -39,18 +43,20 @@ type MMBots struct {
 	licenseChecker         *enterprise.LicenseChecker
 	config                 Config
 	llmUpstreamHTTPClient  *http.Client
+	quotas                 *quota.Service
 
 	botsLock sync.RWMutex
 	bots     []*Bot
 }
 
-func New(mutexPluginAPI cluster.MutexPluginAPI, pluginAPI *pluginapi.Client, licenseChecker *enterprise.LicenseChecker, config Config, llmUpstreamHTTPClient *http.Client) *MMBots {
+func New(mutexPluginAPI cluster.MutexPluginAPI, pluginAPI *pluginapi.Client, licenseChecker *enterprise.LicenseChecker, config Config, llmUpstreamHTTPClient *http.Client, quotas *quota.Service) *MMBots {
 	return &MMBots{
 		ensureBotsClusterMutex: mutexPluginAPI,
 		pluginAPI:              pluginAPI,
 		licenseChecker:         licenseChecker,
 		config:                 config,
 		llmUpstreamHTTPClient:  llmUpstreamHTTPClient,
+		quotas:                 quotas,
 	}
 }
 
@@ -159,30 +165,56 @@ func (b *MMBots) UpdateBotsCache(cfgBots []llm.BotConfig) error {
 	}
 
 	for _, bot := range b.bots {
-		bot.llm = b.getLLM(bot.cfg.Service)
+		bot.llm = b.getLLM(bot)
+		if err := bot.cfg.CheckCapabilities(bot.llm.Capabilities()); err != nil {
+			b.pluginAPI.Log.Error("Bot configuration uses features its LLM does not support", "bot_name", bot.cfg.Name, "error", err.Error())
+		}
 	}
 
 	return nil
 }
 
-func (b *MMBots) getLLM(serviceConfig llm.ServiceConfig) llm.LanguageModel {
-	// Create the correct model
-	var result llm.LanguageModel
-	switch serviceConfig.Type {
-	case llm.ServiceTypeOpenAI:
-		result = openai.New(config.OpenAIConfigFromServiceConfig(serviceConfig), b.llmUpstreamHTTPClient)
-	case llm.ServiceTypeOpenAICompatible:
-		result = openai.NewCompatible(config.OpenAIConfigFromServiceConfig(serviceConfig), b.llmUpstreamHTTPClient)
-	case llm.ServiceTypeAzure:
-		result = openai.NewAzure(config.OpenAIConfigFromServiceConfig(serviceConfig), b.llmUpstreamHTTPClient)
-	case llm.ServiceTypeAnthropic:
-		result = anthropic.New(serviceConfig, b.llmUpstreamHTTPClient)
-	case llm.ServiceTypeASage:
-		result = asage.New(serviceConfig, b.llmUpstreamHTTPClient)
+func (b *MMBots) getLLM(bot *Bot) llm.LanguageModel {
+	botConfig := bot.cfg
+	// Only the last service of the chain waits out rate limits and outages, the others quickly fail over
+	retryConfig := llm.DefaultRetryConfig()
+	if len(botConfig.FallbackServices) > 0 {
+		retryConfig = llm.FailoverRetryConfig()
+	}
+	result := b.getServiceLLM(bot, botConfig.Service, retryConfig)
+
+	// Failover to the fallback services
+	if len(botConfig.FallbackServices) > 0 {
+		fallbacks := make([]llm.FailoverModel, 0, len(botConfig.FallbackServices))
+		for i, fallback := range botConfig.FallbackServices {
+			retryConfig = llm.FailoverRetryConfig()
+			if i == len(botConfig.FallbackServices)-1 {
+				retryConfig = llm.DefaultRetryConfig()
+			}
+			fallbacks = append(fallbacks, llm.FailoverModel{
+				Name:  serviceDisplayName(fallback),
+				Model: b.getServiceLLM(bot, fallback, retryConfig),
+			})
+		}
+		result = llm.NewFailoverWrapper(serviceDisplayName(botConfig.Service), fallbacks, &b.pluginAPI.Log)(result)
 	}
 
-	// Truncation Support
-	result = llm.NewLLMTruncationWrapper(result)
+	// Quota accounting
+	if b.quotas != nil {
+		result = quota.NewUsageWrapper(b.quotas, bot.mmBot.UserId, &b.pluginAPI.Log)(result)
+	}
+
+	// Response caching for requests that opt in, cache hits are not accounted
+	result = llm.NewCacheWrapper(mmapi.NewKVResponseCache(&b.pluginAPI.KV, llm.DefaultCacheMaxEntries), cacheNamespace(botConfig.Service), llm.DefaultCacheConfig(), &b.pluginAPI.Log)(result)
+
+	// Default sampling and reasoning parameters of the bot, applied before caching so they are part of the cache key
+	result = llm.NewSamplingWrapper(botConfig.Sampling, botConfig.Reasoning)(result)
+
+	// Run the tools the policies allow without approval, each step goes through the wrappers above
+	result = llm.NewToolLoopWrapper(llm.ToolLoopLimits{
+		MaxSteps:    botConfig.MaxToolSteps,
+		TokenBudget: botConfig.ToolTokenBudget,
+	})(result)
 
 	// Logging
 	if b.config.EnableLLMLogging() {
@@ -192,6 +224,61 @@ func (b *MMBots) getLLM(serviceConfig llm.ServiceConfig) llm.LanguageModel {
 	return result
 }
 
+// serviceDisplayName returns the name identifying a service in logs and post props.
+func serviceDisplayName(serviceConfig llm.ServiceConfig) string {
+	if serviceConfig.Name != "" {
+		return serviceConfig.Name
+	}
+	return serviceConfig.Type
+}
+
+// cacheNamespace identifies the model answering a service's requests so cached responses are not shared
+// between different models.
+func cacheNamespace(serviceConfig llm.ServiceConfig) string {
+	return fmt.Sprintf("%s|%s|%s|%d", serviceConfig.Type, serviceConfig.APIURL, serviceConfig.DefaultModel, serviceConfig.OutputTokenLimit)
+}
+
+// getServiceLLM creates the language model for a single service of the bot, retrying transient failures with
+// retryConfig.
+func (b *MMBots) getServiceLLM(bot *Bot, serviceConfig llm.ServiceConfig, retryConfig llm.RetryConfig) llm.LanguageModel {
+	result := b.newProvider(serviceConfig)
+
+	// Retry transient failures before giving up or failing over
+	result = llm.NewRetryWrapper(retryConfig)(result)
+
+	// Fit requests in the context window, summarizing the older turns of long conversations. The summaries
+	// are accounted to the user like the requests, cache hits are not.
+	summarizer := result
+	if b.quotas != nil {
+		summarizer = quota.NewUsageWrapper(b.quotas, bot.mmBot.UserId, &b.pluginAPI.Log)(summarizer)
+	}
+	summarizer = llm.NewCacheWrapper(mmapi.NewKVResponseCache(&b.pluginAPI.KV, llm.DefaultCacheMaxEntries), cacheNamespace(serviceConfig), llm.DefaultCacheConfig(), &b.pluginAPI.Log)(summarizer)
+	return llm.NewCompactionWrapper(summarizer, serviceConfig.OutputTokenLimit, &b.pluginAPI.Log)(result)
+}
+
+// newProvider creates the client of the service's provider, without any wrapper.
+func (b *MMBots) newProvider(serviceConfig llm.ServiceConfig) llm.LanguageModel {
+	switch serviceConfig.Type {
+	case llm.ServiceTypeOpenAI:
+		return openai.New(config.OpenAIConfigFromServiceConfig(serviceConfig), b.llmUpstreamHTTPClient)
+	case llm.ServiceTypeOpenAICompatible:
+		return openai.NewCompatible(config.OpenAIConfigFromServiceConfig(serviceConfig), b.llmUpstreamHTTPClient)
+	case llm.ServiceTypeAzure:
+		return openai.NewAzure(config.OpenAIConfigFromServiceConfig(serviceConfig), b.llmUpstreamHTTPClient)
+	case llm.ServiceTypeAnthropic:
+		return anthropic.New(serviceConfig, b.llmUpstreamHTTPClient)
+	case llm.ServiceTypeASage:
+		return asage.New(serviceConfig, b.llmUpstreamHTTPClient)
+	case llm.ServiceTypeOllama:
+		return ollama.New(serviceConfig, b.llmUpstreamHTTPClient)
+	case llm.ServiceTypeGemini:
+		return gemini.New(serviceConfig, b.llmUpstreamHTTPClient)
+	case llm.ServiceTypeBedrock:
+		return bedrock.New(serviceConfig, b.llmUpstreamHTTPClient)
+	}
+	return nil
+}
+
 // TODO: This really doesn't belong here. Figure out where to put this.
 func (b *MMBots) GetTranscribe() Transcriber {
 	// Get the configured transcript generator bot
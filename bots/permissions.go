@@ -10,6 +10,7 @@ import (
 	"errors"
 
 	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/quota"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 )
@@ -102,3 +103,22 @@ func (m *MMBots) CheckUsageRestrictionsForUser(bot *Bot, requestingUserID string
 
 	return fmt.Errorf("unknown user assistance level")
 }
+
+// CheckQuota verifies the user, their team and the bot have budget left and counts the request.
+// channel may be nil for requests made outside of a channel. Returns a *quota.ExceededError
+// when a budget has been used up.
+func (m *MMBots) CheckQuota(bot *Bot, requestingUserID string, channel *model.Channel) error {
+	if m.quotas == nil {
+		return nil
+	}
+
+	subject := quota.Subject{
+		UserID: requestingUserID,
+		BotID:  bot.GetMMBot().UserId,
+	}
+	if channel != nil {
+		subject.TeamID = channel.TeamId
+	}
+
+	return m.quotas.Check(subject)
+}
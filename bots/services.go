@@ -0,0 +1,103 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package bots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+)
+
+const connectionTestPrompt = "Reply with the word OK."
+
+// connectionTestMaxTokens keeps the connection test cheap, the answer doesn't matter as long as there is one.
+const connectionTestMaxTokens = 16
+
+// ConnectionTestResult is the outcome of a minimal completion sent to a service.
+type ConnectionTestResult struct {
+	Success   bool   `json:"success"`
+	LatencyMS int64  `json:"latencyMs"`
+	Response  string `json:"response,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ListModels returns the models available to a service that isn't necessarily used by a bot yet, so
+// administrators can check the model name before saving the configuration.
+func (b *MMBots) ListModels(ctx context.Context, serviceConfig llm.ServiceConfig) ([]llm.ModelInfo, error) {
+	provider := b.newProvider(serviceConfig)
+	if provider == nil {
+		return nil, fmt.Errorf("unknown service type %q", serviceConfig.Type)
+	}
+
+	lister, ok := provider.(llm.ModelLister)
+	if !ok {
+		return nil, llm.ErrModelListingNotSupported
+	}
+
+	return lister.ListModels(ctx)
+}
+
+// TestConnection sends a minimal completion to a service and reports how long it took to answer. The
+// request isn't retried so the result reflects a single attempt.
+func (b *MMBots) TestConnection(ctx context.Context, serviceConfig llm.ServiceConfig) ConnectionTestResult {
+	provider := b.newProvider(serviceConfig)
+	if provider == nil {
+		return ConnectionTestResult{Error: fmt.Sprintf("unknown service type %q", serviceConfig.Type)}
+	}
+
+	request := llm.CompletionRequest{
+		Posts: []llm.Post{
+			{Role: llm.PostRoleUser, Message: connectionTestPrompt},
+		},
+		Context: llm.NewContext(),
+	}
+
+	start := time.Now()
+	response, err := provider.ChatCompletionNoStream(ctx, request, llm.WithMaxGeneratedTokens(connectionTestMaxTokens))
+	result := ConnectionTestResult{
+		LatencyMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Success = true
+	result.Response = strings.TrimSpace(response)
+	return result
+}
+
+// CheckCapabilities lists the features enabled for a bot configuration, which doesn't have to be saved yet,
+// that its services don't support. See llm.BotConfig.CheckCapabilities.
+func (b *MMBots) CheckCapabilities(botConfig llm.BotConfig) ([]string, error) {
+	model := b.newProvider(botConfig.Service)
+	if model == nil {
+		return nil, fmt.Errorf("unknown service type %q", botConfig.Service.Type)
+	}
+
+	if len(botConfig.FallbackServices) > 0 {
+		fallbacks := make([]llm.FailoverModel, 0, len(botConfig.FallbackServices))
+		for _, fallback := range botConfig.FallbackServices {
+			provider := b.newProvider(fallback)
+			if provider == nil {
+				return nil, fmt.Errorf("unknown service type %q", fallback.Type)
+			}
+			fallbacks = append(fallbacks, llm.FailoverModel{Name: serviceDisplayName(fallback), Model: provider})
+		}
+		model = llm.NewFailoverWrapper(serviceDisplayName(botConfig.Service), fallbacks, &b.pluginAPI.Log)(model)
+	}
+
+	problems := []string{}
+	var joined interface{ Unwrap() []error }
+	if err := botConfig.CheckCapabilities(model.Capabilities()); errors.As(err, &joined) {
+		for _, problem := range joined.Unwrap() {
+			problems = append(problems, problem.Error())
+		}
+	}
+	return problems, nil
+}
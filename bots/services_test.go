@@ -0,0 +1,39 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package bots
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestConnection(t *testing.T) {
+	var maxTokens int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			MaxTokens int `json:"max_tokens"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		maxTokens = body.MaxTokens
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\" OK\\n\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	b := &MMBots{llmUpstreamHTTPClient: server.Client()}
+	result := b.TestConnection(context.Background(), llm.ServiceConfig{Type: llm.ServiceTypeOpenAICompatible, APIURL: server.URL, DefaultModel: "test", OutputTokenLimit: 4096})
+
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, "OK", result.Response)
+	assert.Equal(t, connectionTestMaxTokens, maxTokens)
+}
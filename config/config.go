@@ -13,6 +13,7 @@ import (
 	"github.com/mattermost/mattermost-plugin-ai/llm"
 	"github.com/mattermost/mattermost-plugin-ai/mcp"
 	"github.com/mattermost/mattermost-plugin-ai/openai"
+	"github.com/mattermost/mattermost-plugin-ai/quota"
 )
 
 type Config struct {
@@ -24,6 +25,7 @@ type Config struct {
 	AllowedUpstreamHostnames string                           `json:"allowedUpstreamHostnames"`
 	EmbeddingSearchConfig    embeddings.EmbeddingSearchConfig `json:"embeddingSearchConfig"`
 	MCP                      mcp.Config                       `json:"mcp"`
+	Quotas                   quota.Config                     `json:"quotas"`
 }
 
 func (c *Config) Clone() *Config {
@@ -72,6 +74,10 @@ func (c *Container) MCP() mcp.Config {
 	return c.cfg.Load().MCP
 }
 
+func (c *Container) Quotas() quota.Config {
+	return c.cfg.Load().Quotas
+}
+
 func (c *Container) RegisterUpdateListener(listener UpdateListener) {
 	c.listeners = append(c.listeners, listener)
 }
@@ -121,13 +127,14 @@ func OpenAIConfigFromServiceConfig(serviceConfig llm.ServiceConfig) openai.Confi
 	}
 
 	return openai.Config{
-		APIKey:           serviceConfig.APIKey,
-		APIURL:           serviceConfig.APIURL,
-		OrgID:            serviceConfig.OrgID,
-		DefaultModel:     serviceConfig.DefaultModel,
-		InputTokenLimit:  serviceConfig.InputTokenLimit,
-		OutputTokenLimit: serviceConfig.OutputTokenLimit,
-		StreamingTimeout: streamingTimeout,
-		SendUserID:       serviceConfig.SendUserID,
+		APIKey:              serviceConfig.APIKey,
+		APIURL:              serviceConfig.APIURL,
+		OrgID:               serviceConfig.OrgID,
+		DefaultModel:        serviceConfig.DefaultModel,
+		InputTokenLimit:     serviceConfig.InputTokenLimit,
+		OutputTokenLimit:    serviceConfig.OutputTokenLimit,
+		StreamingTimeout:    streamingTimeout,
+		SendUserID:          serviceConfig.SendUserID,
+		ModelSupportsVision: serviceConfig.ModelSupportsVision,
 	}
 }
@@ -4,11 +4,13 @@
 package conversations
 
 import (
+	stdcontext "context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/mattermost/mattermost-plugin-ai/bots"
 	"github.com/mattermost/mattermost-plugin-ai/enterprise"
@@ -16,6 +18,7 @@ import (
 	"github.com/mattermost/mattermost-plugin-ai/i18n"
 	"github.com/mattermost/mattermost-plugin-ai/llm"
 	"github.com/mattermost/mattermost-plugin-ai/llmcontext"
+	"github.com/mattermost/mattermost-plugin-ai/mcp"
 	"github.com/mattermost/mattermost-plugin-ai/mmapi"
 	"github.com/mattermost/mattermost-plugin-ai/prompts"
 	"github.com/mattermost/mattermost-plugin-ai/streaming"
@@ -28,6 +31,9 @@ import (
 const ThreadIDProp = "referenced_thread"
 const AnalysisTypeProp = "prompt_type"
 
+// MCPResourcesProp holds the MCP resources attached to a post as a JSON list of mcp.ResourceReference
+const MCPResourcesProp = "mcp_resources"
+
 // AIThread represents a user's conversation with an AI
 type AIThread struct {
 	ID         string `json:"id"`
@@ -53,7 +59,7 @@ type Conversations struct {
 // MeetingsService defines the interface for meetings functionality needed by conversations
 type MeetingsService interface {
 	GetCaptionsFileIDFromProps(post *model.Post) (fileID string, err error)
-	SummarizeTranscription(bot *bots.Bot, transcription *subtitles.Subtitles, context *llm.Context) (*llm.TextStreamResult, error)
+	SummarizeTranscription(ctx stdcontext.Context, bot *bots.Bot, transcription *subtitles.Subtitles, context *llm.Context) (*llm.TextStreamResult, error)
 }
 
 func New(
@@ -86,7 +92,7 @@ func (c *Conversations) SetMeetingsService(meetingsService MeetingsService) {
 }
 
 // ProcessUserRequestWithContext is an internal helper that uses an existing context to process a message
-func (c *Conversations) ProcessUserRequestWithContext(bot *bots.Bot, postingUser *model.User, channel *model.Channel, post *model.Post, context *llm.Context) (*llm.TextStreamResult, error) {
+func (c *Conversations) ProcessUserRequestWithContext(ctx stdcontext.Context, bot *bots.Bot, postingUser *model.User, channel *model.Channel, post *model.Post, context *llm.Context) (*llm.TextStreamResult, error) {
 	var posts []llm.Post
 	if post.RootId == "" {
 		// A new conversation
@@ -117,21 +123,21 @@ func (c *Conversations) ProcessUserRequestWithContext(bot *bots.Bot, postingUser
 
 	posts = append(posts, llm.Post{
 		Role:    llm.PostRoleUser,
-		Message: post.Message,
+		Message: post.Message + c.mcpResourcesMessage(bot, post, context),
 	})
 
 	completionRequest := llm.CompletionRequest{
 		Posts:   posts,
 		Context: context,
 	}
-	result, err := bot.LLM().ChatCompletion(completionRequest)
+	result, err := bot.LLM().ChatCompletion(ctx, completionRequest)
 	if err != nil {
 		return nil, err
 	}
 
 	go func() {
 		request := "Write a short title for the following request. Include only the title and nothing else, no quotations. Request:\n" + post.Message
-		if err := c.GenerateTitle(bot, request, post.Id, context); err != nil {
+		if err := c.GenerateTitle(stdcontext.WithoutCancel(ctx), bot, request, post.Id, context); err != nil {
 			c.mmClient.LogError("Failed to generate title", "error", err.Error())
 			return
 		}
@@ -141,7 +147,7 @@ func (c *Conversations) ProcessUserRequestWithContext(bot *bots.Bot, postingUser
 }
 
 // ProcessUserRequest processes a user request to a bot
-func (c *Conversations) ProcessUserRequest(bot *bots.Bot, postingUser *model.User, channel *model.Channel, post *model.Post) (*llm.TextStreamResult, error) {
+func (c *Conversations) ProcessUserRequest(ctx stdcontext.Context, bot *bots.Bot, postingUser *model.User, channel *model.Channel, post *model.Post) (*llm.TextStreamResult, error) {
 	// Create a context with default tools
 	context := c.contextBuilder.BuildLLMContextUserRequest(
 		bot,
@@ -150,16 +156,16 @@ func (c *Conversations) ProcessUserRequest(bot *bots.Bot, postingUser *model.Use
 		c.contextBuilder.WithLLMContextDefaultTools(bot, mmapi.IsDMWith(bot.GetMMBot().UserId, channel)),
 	)
 
-	return c.ProcessUserRequestWithContext(bot, postingUser, channel, post, context)
+	return c.ProcessUserRequestWithContext(ctx, bot, postingUser, channel, post, context)
 }
 
-func (c *Conversations) GenerateTitle(bot *bots.Bot, request string, postID string, context *llm.Context) error {
+func (c *Conversations) GenerateTitle(ctx stdcontext.Context, bot *bots.Bot, request string, postID string, context *llm.Context) error {
 	titleRequest := llm.CompletionRequest{
 		Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: request}},
 		Context: context,
 	}
 
-	conversationTitle, err := bot.LLM().ChatCompletionNoStream(titleRequest, llm.WithMaxGeneratedTokens(25))
+	conversationTitle, err := bot.LLM().ChatCompletionNoStream(ctx, titleRequest, llm.WithMaxGeneratedTokens(25), llm.WithCache())
 	if err != nil {
 		return fmt.Errorf("failed to get title: %w", err)
 	}
@@ -210,7 +216,7 @@ func (c *Conversations) existingConversationToLLMPosts(bot *bots.Bot, conversati
 		if err != nil {
 			return nil, err
 		}
-		posts = append(posts, c.ThreadToLLMPosts(bot, conversation)...)
+		posts = append(posts, c.ThreadToLLMPosts(bot, conversation, context)...)
 		return posts, nil
 	}
 
@@ -225,7 +231,7 @@ func (c *Conversations) existingConversationToLLMPosts(bot *bots.Bot, conversati
 			Message: prompt,
 		},
 	}
-	posts = append(posts, c.ThreadToLLMPosts(bot, conversation)...)
+	posts = append(posts, c.ThreadToLLMPosts(bot, conversation, context)...)
 
 	return posts, nil
 }
@@ -276,7 +282,9 @@ func isImageMimeType(mimeType string) bool {
 	return strings.HasPrefix(mimeType, "image/")
 }
 
-func (c *Conversations) PostToAIPost(bot *bots.Bot, post *model.Post) llm.Post {
+// PostToAIPost converts a post to the LLM format. context is the context of the request the post is sent
+// with, it decides whether the MCP resources attached to the post are read.
+func (c *Conversations) PostToAIPost(bot *bots.Bot, post *model.Post, context *llm.Context) llm.Post {
 	var filesForUpstream []llm.File
 	message := format.PostBody(post)
 	var extractedFileContents []string
@@ -286,6 +294,10 @@ func (c *Conversations) PostToAIPost(bot *bots.Bot, post *model.Post) llm.Post {
 		maxFileSize = bot.GetConfig().MaxFileSize
 	}
 
+	// Images are only sent to models that accept them, the configuration is checked against the LLM when bots are loaded
+	capabilities := bot.LLM().Capabilities()
+	vision := bot.GetConfig().EnableVision && capabilities.Vision
+
 	for _, fileID := range post.FileIds {
 		fileInfo, err := c.mmClient.GetFileInfo(fileID)
 		if err != nil {
@@ -319,7 +331,11 @@ func (c *Conversations) PostToAIPost(bot *bots.Bot, post *model.Post) llm.Post {
 			extractedFileContents = append(extractedFileContents, fileContent)
 		}
 
-		if bot.GetConfig().EnableVision && isImageMimeType(fileInfo.MimeType) {
+		if vision && isImageMimeType(fileInfo.MimeType) {
+			if capabilities.MaxImageSize > 0 && fileInfo.Size > capabilities.MaxImageSize {
+				extractedFileContents = append(extractedFileContents, fmt.Sprintf("File Name: %s\nContent: The image is larger than the %d MB the model accepts. Tell the user this.", fileInfo.Name, capabilities.MaxImageSize/(1024*1024)))
+				continue
+			}
 			file, err := c.mmClient.GetFile(fileID)
 			if err != nil {
 				c.mmClient.LogError("Error getting file", "error", err)
@@ -338,6 +354,8 @@ func (c *Conversations) PostToAIPost(bot *bots.Bot, post *model.Post) llm.Post {
 		message += "\nAttached File Contents:\n" + strings.Join(extractedFileContents, "\n\n")
 	}
 
+	message += c.mcpResourcesMessage(bot, post, context)
+
 	role := llm.PostRoleUser
 	if c.bots.IsAnyBot(post.UserId) {
 		role = llm.PostRoleBot
@@ -356,19 +374,95 @@ func (c *Conversations) PostToAIPost(bot *bots.Bot, post *model.Post) llm.Post {
 		}
 	}
 
+	// Check for the reasoning behind the tool calls
+	reasoning := []llm.ReasoningBlock{}
+	if reasoningBlocks, ok := post.GetProp(streaming.ReasoningBlocksProp).(string); ok && len(tools) > 0 {
+		if err := json.Unmarshal([]byte(reasoningBlocks), &reasoning); err != nil {
+			c.mmClient.LogError("Error unmarshalling reasoning blocks", "error", err)
+		}
+	}
+
 	return llm.Post{
-		Role:    role,
-		Message: message,
-		Files:   filesForUpstream,
-		ToolUse: tools,
+		Role:      role,
+		Message:   message,
+		Files:     filesForUpstream,
+		ToolUse:   tools,
+		Reasoning: reasoning,
 	}
 }
 
-func (c *Conversations) ThreadToLLMPosts(bot *bots.Bot, threadData *mmapi.ThreadData) []llm.Post {
+// mcpResourcesMessage reads the MCP resources attached to a post as its author and returns their contents to
+// add to the message. They are read again for each request, like files, so the model sees their current
+// contents. The resources are only read for the requesting user's own posts in their DM with the bot, posts
+// of other users or in other channels would otherwise read them with the OAuth access of their author.
+func (c *Conversations) mcpResourcesMessage(bot *bots.Bot, post *model.Post, context *llm.Context) string {
+	resourcesJSON, ok := post.GetProp(MCPResourcesProp).(string)
+	if !ok || c.contextBuilder == nil {
+		return ""
+	}
+	if context == nil || context.RequestingUser == nil || post.UserId != context.RequestingUser.Id ||
+		context.Channel == nil || post.ChannelId != context.Channel.Id ||
+		!mmapi.IsDMWith(bot.GetMMBot().UserId, context.Channel) {
+		return ""
+	}
+	var resources []mcp.ResourceReference
+	if err := json.Unmarshal([]byte(resourcesJSON), &resources); err != nil {
+		c.mmClient.LogError("Error unmarshalling MCP resources", "error", err)
+		return ""
+	}
+
+	maxSize := defaultMaxFileSize
+	if bot.GetConfig().MaxFileSize > 0 {
+		maxSize = bot.GetConfig().MaxFileSize
+	}
+
+	contents := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		content, err := c.contextBuilder.ReadMCPResource(post.UserId, resource.ServerID, resource.URI)
+		if err != nil {
+			c.mmClient.LogError("Error reading MCP resource", "serverID", resource.ServerID, "uri", resource.URI, "error", err)
+			content = "The resource could not be read. Tell the user this."
+		} else if int64(len(content)) > maxSize {
+			cut := int(maxSize)
+			for cut > 0 && !utf8.RuneStart(content[cut]) {
+				cut--
+			}
+			content = content[:cut] + "\n... (content truncated due to size limit)"
+		}
+		contents = append(contents, fmt.Sprintf("Resource Name: %s\nURI: %s\nContent: %s", resource.Name, resource.URI, content))
+	}
+	if len(contents) == 0 {
+		return ""
+	}
+	return "\nAttached Resource Contents:\n" + strings.Join(contents, "\n\n")
+}
+
+func (c *Conversations) ThreadToLLMPosts(bot *bots.Bot, threadData *mmapi.ThreadData, context *llm.Context) []llm.Post {
 	result := make([]llm.Post, 0, len(threadData.Posts))
 
+	// The steps of responses outside of the DM with the bot are only replayed for their requester
+	requestingUserID := ""
+	if context.RequestingUser != nil {
+		requestingUserID = context.RequestingUser.Id
+	}
+
 	for _, post := range threadData.Posts {
-		aiPost := c.PostToAIPost(bot, post)
+		aiPost := c.PostToAIPost(bot, post, context)
+
+		// Responses that ran tools are replayed step by step
+		if steps := streaming.GetToolSteps(c.mmClient, post, requestingUserID); aiPost.Role == llm.PostRoleBot && len(steps) > 0 {
+			stepPosts := llm.ToolStepPosts(steps, aiPost.Message)
+			last := stepPosts[len(stepPosts)-1]
+			if strings.TrimSpace(last.Message) == "" && len(aiPost.ToolUse) == 0 {
+				// The response is being continued
+				stepPosts = stepPosts[:len(stepPosts)-1]
+			} else {
+				aiPost.Message = last.Message
+				stepPosts[len(stepPosts)-1] = aiPost
+			}
+			result = append(result, stepPosts...)
+			continue
+		}
 
 		// Add username prefix for user messages in multi-user threads
 		if aiPost.Role == llm.PostRoleUser {
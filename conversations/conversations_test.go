@@ -5,10 +5,12 @@ package conversations_test
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"path/filepath"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/mattermost/mattermost-plugin-ai/bots"
 	"github.com/mattermost/mattermost-plugin-ai/conversations"
@@ -16,6 +18,7 @@ import (
 	"github.com/mattermost/mattermost-plugin-ai/evals"
 	"github.com/mattermost/mattermost-plugin-ai/i18n"
 	"github.com/mattermost/mattermost-plugin-ai/llm"
+	llmmocks "github.com/mattermost/mattermost-plugin-ai/llm/mocks"
 	"github.com/mattermost/mattermost-plugin-ai/llmcontext"
 	"github.com/mattermost/mattermost-plugin-ai/mmapi/mocks"
 	"github.com/mattermost/mattermost-plugin-ai/mmtools"
@@ -51,6 +54,10 @@ func (m *mockMCPClientManager) GetToolsForUser(userID string) ([]llm.Tool, error
 	return []llm.Tool{}, nil
 }
 
+func (m *mockMCPClientManager) ReadResourceForUser(userID, serverID, uri string) (string, error) {
+	return "", nil
+}
+
 type mockConfigProvider struct{}
 
 func (m *mockConfigProvider) GetEnableLLMTrace() bool {
@@ -88,7 +95,7 @@ func TestConversationMentionHandling(t *testing.T) {
 			client := pluginapi.NewClient(mockAPI, nil)
 			mmClient := mocks.NewMockClient(t)
 			licenseChecker := enterprise.NewLicenseChecker(client)
-			botService := bots.New(mockAPI, client, licenseChecker, nil, &http.Client{})
+			botService := bots.New(mockAPI, client, licenseChecker, nil, &http.Client{}, nil)
 			prompts, err := llm.NewPrompts(prompts.PromptsFolder)
 			require.NoError(t, err, "Failed to load prompts")
 
@@ -116,6 +123,7 @@ func TestConversationMentionHandling(t *testing.T) {
 				toolProvider,
 				mcpClientManager,
 				configProvider,
+				i18n.Init(),
 			)
 
 			conv := conversations.New(
@@ -147,7 +155,7 @@ func TestConversationMentionHandling(t *testing.T) {
 
 			bot.SetLLMForTest(llm.NewLanguageModelTestLogWrapper(t.T, t.LLM))
 
-			textStream, err := conv.ProcessUserRequest(bot, threadData.RequestingUser(), threadData.Channel, threadData.LatestPost())
+			textStream, err := conv.ProcessUserRequest(context.Background(), bot, threadData.RequestingUser(), threadData.Channel, threadData.LatestPost())
 			require.NoError(t, err, "Failed to process user request")
 			require.NotNil(t, textStream, "Expected a non-nil text stream")
 
@@ -163,3 +171,80 @@ func TestConversationMentionHandling(t *testing.T) {
 		})
 	}
 }
+
+// resourceMCPClientManager returns the same content for every resource and records who read them
+type resourceMCPClientManager struct {
+	mockMCPClientManager
+	content string
+	readers []string
+}
+
+func (m *resourceMCPClientManager) ReadResourceForUser(userID, serverID, uri string) (string, error) {
+	m.readers = append(m.readers, userID)
+	return m.content, nil
+}
+
+func TestMCPResources(t *testing.T) {
+	const resources = `[{"serverID":"server","uri":"file:///notes.md","name":"notes"}]`
+	dm := &model.Channel{Id: "dm", Type: model.ChannelTypeDirect, Name: model.GetDMNameFromIds("user", "botid")}
+	channel := &model.Channel{Id: "channel", Type: model.ChannelTypeOpen, Name: "town-square"}
+
+	setup := func(t *testing.T, content string, maxFileSize int64) (*conversations.Conversations, *resourceMCPClientManager, *bots.Bot) {
+		mockAPI := &plugintest.API{}
+		client := pluginapi.NewClient(mockAPI, nil)
+		licenseChecker := enterprise.NewLicenseChecker(client)
+		mcpClientManager := &resourceMCPClientManager{content: content}
+		contextBuilder := llmcontext.NewLLMContextBuilder(client, &mockToolProvider{}, mcpClientManager, &mockConfigProvider{}, i18n.Init())
+		conv := conversations.New(nil, mocks.NewMockClient(t), nil, contextBuilder, bots.New(mockAPI, client, licenseChecker, nil, &http.Client{}, nil), nil, licenseChecker, i18n.Init(), nil)
+
+		bot := bots.NewBot(llm.BotConfig{ID: "botid", Name: "matty", MaxFileSize: maxFileSize}, &model.Bot{UserId: "botid"})
+		mockLLM := llmmocks.NewMockLanguageModel(t)
+		mockLLM.EXPECT().Capabilities().Return(llm.Capabilities{}).Maybe()
+		bot.SetLLMForTest(mockLLM)
+		return conv, mcpClientManager, bot
+	}
+	newPost := func(userID, channelID string) *model.Post {
+		post := &model.Post{UserId: userID, ChannelId: channelID, Message: "Summarize this"}
+		post.AddProp(conversations.MCPResourcesProp, resources)
+		return post
+	}
+	requestIn := func(userID string, channel *model.Channel) *llm.Context {
+		return &llm.Context{RequestingUser: &model.User{Id: userID}, Channel: channel}
+	}
+
+	t.Run("resources of the requester's posts in their DM with the bot are read", func(t *testing.T) {
+		conv, mcpClientManager, bot := setup(t, "the notes", 0)
+
+		aiPost := conv.PostToAIPost(bot, newPost("user", "dm"), requestIn("user", dm))
+		assert.Contains(t, aiPost.Message, "Content: the notes")
+		assert.Equal(t, []string{"user"}, mcpClientManager.readers)
+	})
+
+	t.Run("resources of the posts of other users are not read", func(t *testing.T) {
+		conv, mcpClientManager, bot := setup(t, "the notes", 0)
+
+		aiPost := conv.PostToAIPost(bot, newPost("other", "dm"), requestIn("user", dm))
+		assert.Equal(t, "Summarize this", aiPost.Message)
+		assert.Empty(t, mcpClientManager.readers)
+	})
+
+	t.Run("resources of posts outside of the DM with the bot are not read", func(t *testing.T) {
+		conv, mcpClientManager, bot := setup(t, "the notes", 0)
+
+		aiPost := conv.PostToAIPost(bot, newPost("user", "channel"), requestIn("user", channel))
+		assert.Equal(t, "Summarize this", aiPost.Message)
+		aiPost = conv.PostToAIPost(bot, newPost("user", "channel"), requestIn("user", dm))
+		assert.Equal(t, "Summarize this", aiPost.Message)
+		aiPost = conv.PostToAIPost(bot, newPost("user", "dm"), nil)
+		assert.Equal(t, "Summarize this", aiPost.Message)
+		assert.Empty(t, mcpClientManager.readers)
+	})
+
+	t.Run("long resources are cut between runes", func(t *testing.T) {
+		conv, _, bot := setup(t, "ééé", 5)
+
+		aiPost := conv.PostToAIPost(bot, newPost("user", "dm"), requestIn("user", dm))
+		assert.True(t, utf8.ValidString(aiPost.Message))
+		assert.Contains(t, aiPost.Message, "Content: éé\n... (content truncated due to size limit)")
+	})
+}
@@ -5,6 +5,7 @@ package conversations_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -85,7 +86,7 @@ func TestDirectMessageConversations(t *testing.T) {
 			client := pluginapi.NewClient(mockAPI, nil)
 			mmClient := mocks.NewMockClient(t)
 			licenseChecker := enterprise.NewLicenseChecker(client)
-			botService := bots.New(mockAPI, client, licenseChecker, nil, &http.Client{})
+			botService := bots.New(mockAPI, client, licenseChecker, nil, &http.Client{}, nil)
 			prompts, err := llm.NewPrompts(prompts.PromptsFolder)
 			require.NoError(t, err, "Failed to load prompts")
 
@@ -117,6 +118,7 @@ func TestDirectMessageConversations(t *testing.T) {
 				toolProvider,
 				mcpClientManager,
 				configProvider,
+				i18n.Init(),
 			)
 
 			conv := conversations.New(
@@ -152,7 +154,7 @@ func TestDirectMessageConversations(t *testing.T) {
 			bot.SetLLMForTest(llm.NewLanguageModelTestLogWrapper(t.T, t.LLM))
 
 			// Process the DM request
-			textStream, err := conv.ProcessUserRequest(bot, threadData.RequestingUser(), threadData.Channel, threadData.LatestPost())
+			textStream, err := conv.ProcessUserRequest(context.Background(), bot, threadData.RequestingUser(), threadData.Channel, threadData.LatestPost())
 			require.NoError(t, err, "Failed to process DM request")
 			require.NotNil(t, textStream, "Expected a non-nil text stream")
 
@@ -9,6 +9,8 @@ import (
 	"fmt"
 
 	"github.com/mattermost/mattermost-plugin-ai/bots"
+	"github.com/mattermost/mattermost-plugin-ai/quota"
+	"github.com/mattermost/mattermost-plugin-ai/streaming"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
@@ -95,8 +97,14 @@ func (c *Conversations) handleMentions(bot *bots.Bot, post *model.Post, postingU
 		return err
 	}
 
-	stream, err := c.ProcessUserRequest(bot, postingUser, channel, post)
+	if err := c.bots.CheckQuota(bot, postingUser.Id, channel); err != nil {
+		return c.handleQuotaError(bot, postingUser, post, err)
+	}
+
+	ctx, cancel := streaming.NewRequestContext(context.Background())
+	stream, err := c.ProcessUserRequest(ctx, bot, postingUser, channel, post)
 	if err != nil {
+		cancel()
 		return fmt.Errorf("unable to process bot mention: %w", err)
 	}
 
@@ -109,7 +117,7 @@ func (c *Conversations) handleMentions(bot *bots.Bot, post *model.Post, postingU
 		ChannelId: channel.Id,
 		RootId:    responseRootID,
 	}
-	if err := c.streamingService.StreamToNewPost(context.Background(), bot.GetMMBot().UserId, postingUser.Id, stream, responsePost, post.Id); err != nil {
+	if err := c.streamingService.StreamToNewPost(ctx, cancel, bot.GetMMBot().UserId, postingUser.Id, stream, responsePost, post.Id); err != nil {
 		return fmt.Errorf("unable to stream response: %w", err)
 	}
 
@@ -121,8 +129,14 @@ func (c *Conversations) handleDMs(bot *bots.Bot, channel *model.Channel, posting
 		return err
 	}
 
-	stream, err := c.ProcessUserRequest(bot, postingUser, channel, post)
+	if err := c.bots.CheckQuota(bot, postingUser.Id, channel); err != nil {
+		return c.handleQuotaError(bot, postingUser, post, err)
+	}
+
+	ctx, cancel := streaming.NewRequestContext(context.Background())
+	stream, err := c.ProcessUserRequest(ctx, bot, postingUser, channel, post)
 	if err != nil {
+		cancel()
 		return fmt.Errorf("unable to process bot mention: %w", err)
 	}
 
@@ -135,9 +149,32 @@ func (c *Conversations) handleDMs(bot *bots.Bot, channel *model.Channel, posting
 		ChannelId: channel.Id,
 		RootId:    responseRootID,
 	}
-	if err := c.streamingService.StreamToNewPost(context.Background(), bot.GetMMBot().UserId, postingUser.Id, stream, responsePost, post.Id); err != nil {
+	if err := c.streamingService.StreamToNewPost(ctx, cancel, bot.GetMMBot().UserId, postingUser.Id, stream, responsePost, post.Id); err != nil {
 		return fmt.Errorf("unable to stream response: %w", err)
 	}
 
 	return nil
 }
+
+// handleQuotaError lets the user know why the bot isn't answering when they are over a quota. The message is
+// ephemeral so the rest of the channel doesn't see it.
+func (c *Conversations) handleQuotaError(bot *bots.Bot, postingUser *model.User, post *model.Post, err error) error {
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		return fmt.Errorf("unable to check quota: %w", err)
+	}
+
+	responseRootID := post.Id
+	if post.RootId != "" {
+		responseRootID = post.RootId
+	}
+
+	c.mmClient.SendEphemeralPost(postingUser.Id, &model.Post{
+		UserId:    bot.GetMMBot().UserId,
+		ChannelId: post.ChannelId,
+		RootId:    responseRootID,
+		Message:   quota.ExceededMessage(c.i18n, postingUser, exceeded),
+	})
+
+	return fmt.Errorf("%w: %w", err, ErrNoResponse)
+}
@@ -34,7 +34,7 @@ func SetupTestEnvironment(t *testing.T) *TestEnvironment {
 	mmClient := mocks.NewMockClient(t)
 
 	licenseChecker := enterprise.NewLicenseChecker(client)
-	botsService := bots.New(mockAPI, client, licenseChecker, nil, &http.Client{})
+	botsService := bots.New(mockAPI, client, licenseChecker, nil, &http.Client{}, nil)
 
 	conversations := &Conversations{
 		mmClient: mmClient,
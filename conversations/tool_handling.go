@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"strings"
 
 	"github.com/mattermost/mattermost-plugin-ai/llm"
 	"github.com/mattermost/mattermost-plugin-ai/mmapi"
@@ -28,15 +29,31 @@ func (c *Conversations) HandleToolCall(userID string, post *model.Post, channel
 		return err
 	}
 
-	toolsJSON := post.GetProp(streaming.ToolCallProp)
-	if toolsJSON == nil {
-		return errors.New("post missing pending tool calls")
+	// The response continues with a new request to the LLM
+	if err = c.bots.CheckQuota(bot, userID, channel); err != nil {
+		return c.handleQuotaError(bot, user, post, err)
 	}
 
 	var tools []llm.ToolCall
-	unmarshalErr := json.Unmarshal([]byte(toolsJSON.(string)), &tools)
-	if unmarshalErr != nil {
-		return errors.New("post pending tool calls not valid JSON")
+	private := post.GetProp(streaming.PrivateToolCallProp) != nil
+	if private {
+		// Outside of DMs the tool calls are kept away from the other members of the channel
+		if err := c.mmClient.KVGet(streaming.PrivateToolCallKey(post.Id), &tools); err != nil {
+			return fmt.Errorf("failed to get private tool calls: %w", err)
+		}
+		if len(tools) == 0 {
+			return errors.New("post missing pending tool calls")
+		}
+	} else {
+		toolsJSON := post.GetProp(streaming.ToolCallProp)
+		if toolsJSON == nil {
+			return errors.New("post missing pending tool calls")
+		}
+
+		unmarshalErr := json.Unmarshal([]byte(toolsJSON.(string)), &tools)
+		if unmarshalErr != nil {
+			return errors.New("post pending tool calls not valid JSON")
+		}
 	}
 
 	llmContext := c.contextBuilder.BuildLLMContextUserRequest(
@@ -47,45 +64,94 @@ func (c *Conversations) HandleToolCall(userID string, post *model.Post, channel
 	)
 
 	for i := range tools {
-		if slices.Contains(acceptedToolIDs, tools[i].ID) {
-			result, resolveErr := llmContext.Tools.ResolveTool(tools[i].Name, func(args any) error {
-				return json.Unmarshal(tools[i].Arguments, args)
-			}, llmContext)
-			if resolveErr != nil {
-				// Maybe in the future we can return this to the user and have a retry. For now just tell the LLM it failed.
-				tools[i].Result = "Tool call failed"
-				tools[i].Status = llm.ToolCallStatusError
-				continue
+		// The policies are checked again, the statuses in the post can't be trusted
+		switch llmContext.Tools.Policy(tools[i].Name) {
+		case llm.ToolPolicyAuto:
+			// Calls held back by the tool loop limits wait for the user like the others
+			if tools[i].Status != llm.ToolCallStatusPending || slices.Contains(acceptedToolIDs, tools[i].ID) {
+				tools[i].Status = llm.ToolCallStatusAccepted
+			} else {
+				tools[i].Result = "Tool call rejected by user"
+				tools[i].Status = llm.ToolCallStatusRejected
 			}
-			tools[i].Result = result
-			tools[i].Status = llm.ToolCallStatusSuccess
-		} else {
-			tools[i].Result = "Tool call rejected by user"
+		case llm.ToolPolicyDeny:
+			tools[i].Result = llm.ToolCallDeniedResult
 			tools[i].Status = llm.ToolCallStatusRejected
+		default:
+			if slices.Contains(acceptedToolIDs, tools[i].ID) {
+				tools[i].Status = llm.ToolCallStatusAccepted
+			} else {
+				tools[i].Result = "Tool call rejected by user"
+				tools[i].Status = llm.ToolCallStatusRejected
+			}
 		}
 	}
 
-	responseRootID := post.Id
-	if post.RootId != "" {
-		responseRootID = post.RootId
+	steps := streaming.GetToolSteps(c.mmClient, post, userID)
+	progress := llm.NewToolLoopProgress(steps, postTokenUsage(post))
+	llm.ResolveToolCalls(llmContext, tools, &progress)
+
+	if private {
+		if err := c.mmClient.KVSet(streaming.PrivateToolCallKey(post.Id), nil); err != nil {
+			c.mmClient.LogError("Failed to delete private tool calls", "error", err)
+		}
+		post.DelProp(streaming.PrivateToolCallProp)
 	}
 
-	// Update post with the tool call results
-	resolvedToolsJSON, err := json.Marshal(tools)
-	if err != nil {
-		return fmt.Errorf("failed to marshal tool call results: %w", err)
+	// Only continue if at lest one tool call was successful or the model can correct its arguments
+	if !slices.ContainsFunc(tools, func(tc llm.ToolCall) bool {
+		return tc.Status == llm.ToolCallStatusSuccess || llm.IsToolArgumentsResult(tc.Result)
+	}) {
+		if private {
+			if updateErr := c.mmClient.UpdatePost(post); updateErr != nil {
+				return fmt.Errorf("failed to update post after tool calls were rejected: %w", updateErr)
+			}
+			return nil
+		}
+
+		// Update post with the tool call results
+		resolvedToolsJSON, err := json.Marshal(tools)
+		if err != nil {
+			return fmt.Errorf("failed to marshal tool call results: %w", err)
+		}
+		post.AddProp(streaming.ToolCallProp, string(resolvedToolsJSON))
+
+		if updateErr := c.mmClient.UpdatePost(post); updateErr != nil {
+			return fmt.Errorf("failed to update post with tool call results: %w", updateErr)
+		}
+		return nil
+	}
+
+	// The resolved tool calls become a step of the response, which continues in the same post
+	stepPosts := llm.ToolStepPosts(steps, post.Message)
+	step := llm.ToolStep{
+		Message:   stepPosts[len(stepPosts)-1].Message,
+		ToolCalls: tools,
+	}
+	if reasoningBlocks, ok := post.GetProp(streaming.ReasoningBlocksProp).(string); ok {
+		if err := json.Unmarshal([]byte(reasoningBlocks), &step.Reasoning); err != nil {
+			c.mmClient.LogError("Error unmarshalling reasoning blocks", "error", err)
+		}
+	}
+	steps = append(steps, step)
+	progress.Steps = len(steps)
+	// Outside of the DM with the bot the results are kept away from the other members of the channel
+	if _, err := streaming.SetToolSteps(c.mmClient, post, steps, !mmapi.IsDMWith(bot.GetMMBot().UserId, channel)); err != nil {
+		return err
+	}
+	post.DelProp(streaming.ToolCallProp)
+	post.DelProp(streaming.ReasoningBlocksProp)
+	if strings.TrimSpace(step.Message) != "" {
+		post.Message += llm.ToolStepSeparator
 	}
-	post.AddProp(streaming.ToolCallProp, string(resolvedToolsJSON))
 
 	if updateErr := c.mmClient.UpdatePost(post); updateErr != nil {
 		return fmt.Errorf("failed to update post with tool call results: %w", updateErr)
 	}
 
-	// Only continue if at lest one tool call was successful
-	if !slices.ContainsFunc(tools, func(tc llm.ToolCall) bool {
-		return tc.Status == llm.ToolCallStatusSuccess
-	}) {
-		return nil
+	responseRootID := post.Id
+	if post.RootId != "" {
+		responseRootID = post.RootId
 	}
 
 	previousConversation, err := mmapi.GetThreadData(c.mmClient, responseRootID)
@@ -101,21 +167,37 @@ func (c *Conversations) HandleToolCall(userID string, post *model.Post, channel
 	}
 
 	completionRequest := llm.CompletionRequest{
-		Posts:   posts,
-		Context: llmContext,
+		Posts:    posts,
+		Context:  llmContext,
+		ToolLoop: progress,
 	}
-	result, err := bot.LLM().ChatCompletion(completionRequest)
+	ctx, err := c.streamingService.GetStreamingContext(context.Background(), post.Id)
 	if err != nil {
+		return fmt.Errorf("failed to get post streaming context: %w", err)
+	}
+	result, err := bot.LLM().ChatCompletion(ctx, completionRequest)
+	if err != nil {
+		c.streamingService.FinishStreaming(post.Id)
 		return fmt.Errorf("failed to get chat completion: %w", err)
 	}
 
-	responsePost := &model.Post{
-		ChannelId: channel.Id,
-		RootId:    responseRootID,
-	}
-	if err := c.streamingService.StreamToNewPost(context.Background(), bot.GetMMBot().UserId, user.Id, result, responsePost, post.Id); err != nil {
-		return fmt.Errorf("failed to stream result to new post: %w", err)
-	}
+	go func() {
+		defer c.streamingService.FinishStreaming(post.Id)
+		c.streamingService.StreamToPost(ctx, result, post, user.Locale)
+	}()
 
 	return nil
 }
+
+// postTokenUsage returns the input and output tokens used so far by the response in the post.
+func postTokenUsage(post *model.Post) int64 {
+	usageJSON, ok := post.GetProp(streaming.UsageProp).(string)
+	if !ok {
+		return 0
+	}
+	var usage llm.Usage
+	if err := json.Unmarshal([]byte(usageJSON), &usage); err != nil {
+		return 0
+	}
+	return usage.InputTokens + usage.OutputTokens
+}
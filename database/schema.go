@@ -15,6 +15,10 @@ func SetupTables(db *sqlx.DB) error {
 		return fmt.Errorf("failed to create tables: %w", err)
 	}
 
+	if err := createLLMUsageTable(db); err != nil {
+		return fmt.Errorf("failed to create tables: %w", err)
+	}
+
 	if err := migrateOldTables(db); err != nil {
 		return fmt.Errorf("failed to migrate old tables: %w", err)
 	}
@@ -36,6 +40,30 @@ func createLLMPostMetaTable(db *sqlx.DB) error {
 	return nil
 }
 
+// createLLMUsageTable creates the LLM_Usage table holding request and token counts per day, user, team and bot
+func createLLMUsageTable(db *sqlx.DB) error {
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS LLM_Usage (
+			Day DATE NOT NULL,
+			UserID TEXT NOT NULL,
+			TeamID TEXT NOT NULL,
+			BotID TEXT NOT NULL,
+			Requests BIGINT NOT NULL DEFAULT 0,
+			InputTokens BIGINT NOT NULL DEFAULT 0,
+			OutputTokens BIGINT NOT NULL DEFAULT 0,
+			CachedTokens BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (Day, UserID, TeamID, BotID)
+		);
+		CREATE INDEX IF NOT EXISTS idx_llm_usage_userid_day ON LLM_Usage (UserID, Day);
+		CREATE INDEX IF NOT EXISTS idx_llm_usage_teamid_day ON LLM_Usage (TeamID, Day);
+		CREATE INDEX IF NOT EXISTS idx_llm_usage_botid_day ON LLM_Usage (BotID, Day);
+	`); err != nil {
+		return fmt.Errorf("can't create llm usage table: %w", err)
+	}
+
+	return nil
+}
+
 // migrateOldTables handles migration from older table structures
 func migrateOldTables(db *sqlx.DB) error {
 	// This fixes data retention issues when a post is deleted for an older version of the postmeta table.
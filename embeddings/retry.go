@@ -0,0 +1,63 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package embeddings
+
+import (
+	"context"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+)
+
+// RetryingProvider retries embedding requests that fail with a transient upstream error, so a
+// single rate limited batch doesn't abort a whole indexing job.
+type RetryingProvider struct {
+	wrapped EmbeddingProvider
+	config  llm.RetryConfig
+	wait    func(ctx context.Context, d time.Duration) error
+}
+
+func NewRetryingProvider(wrapped EmbeddingProvider, config llm.RetryConfig) *RetryingProvider {
+	return &RetryingProvider{
+		wrapped: wrapped,
+		config:  config,
+		wait:    llm.SleepContext,
+	}
+}
+
+func (p *RetryingProvider) CreateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	for attempt := 0; ; attempt++ {
+		result, err := p.wrapped.CreateEmbedding(ctx, text)
+		if err == nil {
+			return result, nil
+		}
+		delay, retry := p.config.Delay(err, attempt)
+		if !retry || ctx.Err() != nil {
+			return nil, err
+		}
+		if waitErr := p.wait(ctx, delay); waitErr != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *RetryingProvider) BatchCreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	for attempt := 0; ; attempt++ {
+		result, err := p.wrapped.BatchCreateEmbeddings(ctx, texts)
+		if err == nil {
+			return result, nil
+		}
+		delay, retry := p.config.Delay(err, attempt)
+		if !retry || ctx.Err() != nil {
+			return nil, err
+		}
+		if waitErr := p.wait(ctx, delay); waitErr != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *RetryingProvider) Dimensions() int {
+	return p.wrapped.Dimensions()
+}
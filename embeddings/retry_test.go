@@ -0,0 +1,82 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package embeddings
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type flakyProvider struct {
+	failures []error
+	calls    int
+}
+
+func (p *flakyProvider) next() error {
+	p.calls++
+	if p.calls <= len(p.failures) {
+		return p.failures[p.calls-1]
+	}
+	return nil
+}
+
+func (p *flakyProvider) CreateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return []float32{1}, nil
+}
+
+func (p *flakyProvider) BatchCreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return [][]float32{{1}}, nil
+}
+
+func (p *flakyProvider) Dimensions() int { return 1 }
+
+func TestRetryingProvider(t *testing.T) {
+	rateLimited := &llm.UpstreamError{StatusCode: http.StatusTooManyRequests}
+	noWait := func(context.Context, time.Duration) error { return nil }
+
+	t.Run("retries a rate limited batch", func(t *testing.T) {
+		wrapped := &flakyProvider{failures: []error{rateLimited}}
+		provider := NewRetryingProvider(wrapped, llm.DefaultRetryConfig())
+		provider.wait = noWait
+
+		result, err := provider.BatchCreateEmbeddings(context.Background(), []string{"text"})
+		require.NoError(t, err)
+		assert.Len(t, result, 1)
+		assert.Equal(t, 2, wrapped.calls)
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		wrapped := &flakyProvider{failures: []error{errors.New("bad request")}}
+		provider := NewRetryingProvider(wrapped, llm.DefaultRetryConfig())
+		provider.wait = noWait
+
+		_, err := provider.CreateEmbedding(context.Background(), "text")
+		assert.EqualError(t, err, "bad request")
+		assert.Equal(t, 1, wrapped.calls)
+	})
+
+	t.Run("stops waiting when the context is canceled", func(t *testing.T) {
+		wrapped := &flakyProvider{failures: []error{rateLimited}}
+		provider := NewRetryingProvider(wrapped, llm.DefaultRetryConfig())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := provider.BatchCreateEmbeddings(ctx, []string{"text"})
+		assert.ErrorIs(t, err, rateLimited)
+		assert.Equal(t, 1, wrapped.calls)
+	})
+}
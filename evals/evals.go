@@ -5,6 +5,7 @@ package evals
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"strconv"
@@ -48,10 +49,25 @@ func NewEval() (*Eval, error) {
 		return nil, errors.New("failed to create LLM provider")
 	}
 
+	// Seeded runs are reproducible as far as the provider allows, the grader always uses the lowest temperature
+	sampling := llm.SamplingParams{}
+	if seedValue := os.Getenv("GOEVALS_SEED"); seedValue != "" {
+		seed, err := strconv.ParseInt(seedValue, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GOEVALS_SEED: %w", err)
+		}
+		sampling.Seed = &seed
+	}
+	graderSampling := sampling
+	graderTemperature := 0.0
+	graderSampling.Temperature = &graderTemperature
+
+	grader := llm.NewCacheWrapper(llm.NewMemoryResponseCache(llm.DefaultCacheMaxEntries), "grader", llm.DefaultCacheConfig(), nil)(provider) // TODO: use a different LLM for grading
+
 	return &Eval{
 		Prompts:   prompts,
-		LLM:       provider,
-		GraderLLM: provider, // TODO: use a different LLM for grading
+		LLM:       llm.NewSamplingWrapper(sampling, llm.ReasoningParams{})(provider),
+		GraderLLM: llm.NewSamplingWrapper(graderSampling, llm.ReasoningParams{})(grader),
 	}, nil
 }
 
@@ -0,0 +1,614 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+// Package gemini implements the LanguageModel interface on top of the Google Gemini API.
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/tokenizer"
+)
+
+const (
+	DefaultAPIURL = "https://generativelanguage.googleapis.com"
+
+	// DefaultInputTokenLimit is deliberately lower than the context window of current Gemini models
+	// to keep the cost of long conversations reasonable. Admins can raise it in the bot configuration.
+	DefaultInputTokenLimit = 128000
+
+	// MaxImageSize is the limit of inline data in a request
+	MaxImageSize = 20 * 1024 * 1024 // 20 MB
+)
+
+type Gemini struct {
+	httpClient       *http.Client
+	apiURL           string
+	apiKey           string
+	defaultModel     string
+	inputTokenLimit  int
+	outputTokenLimit int
+	tokenizer        llm.Tokenizer
+}
+
+func New(llmService llm.ServiceConfig, httpClient *http.Client) *Gemini {
+	apiURL := strings.TrimSuffix(llmService.APIURL, "/")
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+
+	return &Gemini{
+		httpClient:       httpClient,
+		apiURL:           apiURL,
+		apiKey:           llmService.APIKey,
+		defaultModel:     llmService.DefaultModel,
+		inputTokenLimit:  llmService.InputTokenLimit,
+		outputTokenLimit: llmService.OutputTokenLimit,
+		tokenizer:        tokenizer.ForModel(llmService.DefaultModel),
+	}
+}
+
+type content struct {
+	Role  string `json:"role,omitempty"`
+	Parts []part `json:"parts"`
+}
+
+type part struct {
+	Text             string            `json:"text,omitempty"`
+	InlineData       *inlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *functionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *functionResponse `json:"functionResponse,omitempty"`
+}
+
+type inlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type functionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type functionResponse struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type tool struct {
+	FunctionDeclarations []functionDeclaration `json:"functionDeclarations"`
+}
+
+type functionDeclaration struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type generationConfig struct {
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	ResponseMIMEType string   `json:"responseMimeType,omitempty"`
+	ResponseSchema   any      `json:"responseSchema,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequencyPenalty,omitempty"`
+}
+
+type generateContentRequest struct {
+	Contents          []content         `json:"contents"`
+	SystemInstruction *content          `json:"systemInstruction,omitempty"`
+	Tools             []tool            `json:"tools,omitempty"`
+	GenerationConfig  *generationConfig `json:"generationConfig,omitempty"`
+}
+
+type candidate struct {
+	Content      content `json:"content"`
+	FinishReason string  `json:"finishReason"`
+}
+
+type generateContentResponse struct {
+	Candidates     []candidate `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	Error *apiError `json:"error"`
+}
+
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// isValidImageType checks if the MIME type is supported by the Gemini API
+func isValidImageType(mimeType string) bool {
+	validTypes := map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/webp": true,
+		"image/heic": true,
+		"image/heif": true,
+	}
+	return validTypes[mimeType]
+}
+
+// conversationToContents creates the system instruction and the contents from conversation posts.
+// Gemini expects user and model turns to alternate so consecutive posts with the same role are merged.
+func conversationToContents(posts []llm.Post) (*content, []content) {
+	var systemParts []part
+	contents := make([]content, 0, len(posts))
+
+	var currentParts []part
+	currentRole := ""
+
+	flushCurrent := func() {
+		if len(currentParts) > 0 {
+			contents = append(contents, content{
+				Role:  currentRole,
+				Parts: currentParts,
+			})
+			currentParts = nil
+		}
+	}
+
+	for _, post := range posts {
+		role := ""
+		switch post.Role {
+		case llm.PostRoleSystem:
+			systemParts = append(systemParts, part{Text: post.Message})
+			continue
+		case llm.PostRoleBot:
+			role = "model"
+		case llm.PostRoleUser:
+			role = "user"
+		default:
+			continue
+		}
+
+		if role != currentRole {
+			flushCurrent()
+			currentRole = role
+		}
+
+		if post.Message != "" {
+			currentParts = append(currentParts, part{Text: post.Message})
+		}
+
+		for _, file := range post.Files {
+			if !isValidImageType(file.MimeType) {
+				currentParts = append(currentParts, part{Text: fmt.Sprintf("[Unsupported image type: %s]", file.MimeType)})
+				continue
+			}
+
+			data, err := io.ReadAll(file.Reader)
+			if err != nil {
+				currentParts = append(currentParts, part{Text: "[Error reading image data]"})
+				continue
+			}
+
+			currentParts = append(currentParts, part{InlineData: &inlineData{
+				MimeType: file.MimeType,
+				Data:     base64.StdEncoding.EncodeToString(data),
+			}})
+		}
+
+		if len(post.ToolUse) > 0 {
+			for _, tc := range post.ToolUse {
+				currentParts = append(currentParts, part{FunctionCall: &functionCall{
+					ID:   tc.ID,
+					Name: tc.Name,
+					Args: tc.Arguments,
+				}})
+			}
+
+			// Function responses are sent back in a user turn following the model's request
+			flushCurrent()
+			currentRole = "user"
+			for _, tc := range post.ToolUse {
+				response := map[string]any{"result": tc.Result}
+				if tc.Status != llm.ToolCallStatusSuccess {
+					response = map[string]any{"error": tc.Result}
+				}
+				currentParts = append(currentParts, part{FunctionResponse: &functionResponse{
+					ID:       tc.ID,
+					Name:     tc.Name,
+					Response: response,
+				}})
+			}
+			flushCurrent()
+		}
+	}
+
+	flushCurrent()
+
+	if len(systemParts) == 0 {
+		return nil, contents
+	}
+	return &content{Parts: systemParts}, contents
+}
+
+// supportedSchemaKeys are the JSON schema keywords understood by Gemini's OpenAPI based schema subset.
+var supportedSchemaKeys = map[string]bool{
+	"type":        true,
+	"format":      true,
+	"title":       true,
+	"description": true,
+	"nullable":    true,
+	"enum":        true,
+	"properties":  true,
+	"required":    true,
+	"items":       true,
+	"minItems":    true,
+	"maxItems":    true,
+	"minimum":     true,
+	"maximum":     true,
+	"anyOf":       true,
+}
+
+// sanitizeSchema converts a JSON schema into the subset accepted by Gemini.
+// Gemini rejects requests containing keywords such as $schema or additionalProperties.
+func sanitizeSchema(schema any) any {
+	if schema == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(schema)
+	if err != nil {
+		return nil
+	}
+	var generic any
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil
+	}
+
+	return sanitizeSchemaNode(generic)
+}
+
+func sanitizeSchemaNode(node any) any {
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return node
+	}
+
+	result := make(map[string]any, len(obj))
+	for key, value := range obj {
+		if !supportedSchemaKeys[key] {
+			continue
+		}
+		switch key {
+		case "properties":
+			props, ok := value.(map[string]any)
+			if !ok {
+				continue
+			}
+			sanitizedProps := make(map[string]any, len(props))
+			for name, prop := range props {
+				sanitizedProps[name] = sanitizeSchemaNode(prop)
+			}
+			result[key] = sanitizedProps
+		case "items":
+			result[key] = sanitizeSchemaNode(value)
+		case "anyOf":
+			options, ok := value.([]any)
+			if !ok {
+				continue
+			}
+			sanitizedOptions := make([]any, 0, len(options))
+			for _, option := range options {
+				sanitizedOptions = append(sanitizedOptions, sanitizeSchemaNode(option))
+			}
+			result[key] = sanitizedOptions
+		default:
+			result[key] = value
+		}
+	}
+
+	// Gemini rejects object schemas without any properties
+	if result["type"] == "object" {
+		if props, ok := result["properties"].(map[string]any); !ok || len(props) == 0 {
+			return nil
+		}
+	}
+
+	return result
+}
+
+func convertTools(tools []llm.Tool) []tool {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	declarations := make([]functionDeclaration, 0, len(tools))
+	for _, t := range tools {
+		declaration := functionDeclaration{
+			Name:        t.Name,
+			Description: t.Description,
+		}
+		if t.Schema != nil {
+			if parameters := sanitizeSchema(t.Schema); parameters != nil {
+				declaration.Parameters = parameters
+			}
+		}
+		declarations = append(declarations, declaration)
+	}
+
+	return []tool{{FunctionDeclarations: declarations}}
+}
+
+func (g *Gemini) GetDefaultConfig() llm.LanguageModelConfig {
+	return llm.LanguageModelConfig{
+		Model:              g.defaultModel,
+		MaxGeneratedTokens: g.outputTokenLimit,
+	}
+}
+
+func (g *Gemini) createConfig(opts []llm.LanguageModelOption) llm.LanguageModelConfig {
+	cfg := g.GetDefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func generationConfigFromConfig(cfg llm.LanguageModelConfig) *generationConfig {
+	genConfig := &generationConfig{}
+	empty := true
+
+	if cfg.MaxGeneratedTokens > 0 {
+		genConfig.MaxOutputTokens = cfg.MaxGeneratedTokens
+		empty = false
+	}
+
+	if cfg.JSONOutputFormat != nil {
+		genConfig.ResponseMIMEType = "application/json"
+		genConfig.ResponseSchema = sanitizeSchema(llm.NewJSONSchemaFromStruct(cfg.JSONOutputFormat))
+		empty = false
+	}
+
+	if cfg.Temperature != nil || cfg.TopP != nil || len(cfg.StopSequences) > 0 ||
+		cfg.Seed != nil || cfg.PresencePenalty != nil || cfg.FrequencyPenalty != nil {
+		genConfig.Temperature = cfg.Temperature
+		genConfig.TopP = cfg.TopP
+		genConfig.StopSequences = cfg.StopSequences
+		genConfig.Seed = cfg.Seed
+		genConfig.PresencePenalty = cfg.PresencePenalty
+		genConfig.FrequencyPenalty = cfg.FrequencyPenalty
+		empty = false
+	}
+
+	if empty {
+		return nil
+	}
+	return genConfig
+}
+
+func (g *Gemini) streamGenerateContent(ctx context.Context, model string, request generateContentRequest) (*http.Response, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v1beta/models/%s:streamGenerateContent?alt=sse", g.apiURL, url.PathEscape(model))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", g.apiKey)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		message := strings.TrimSpace(string(respBody))
+		var errResp generateContentResponse
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != nil {
+			message = errResp.Error.Message
+		}
+		return nil, fmt.Errorf("gemini request failed: %w", &llm.UpstreamError{StatusCode: resp.StatusCode, Header: resp.Header, Body: message})
+	}
+
+	return resp, nil
+}
+
+func (g *Gemini) streamChat(ctx context.Context, model string, request generateContentRequest, output chan<- llm.TextStreamEvent) {
+	resp, err := g.streamGenerateContent(ctx, model, request)
+	if err != nil {
+		output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeError,
+			Value: err,
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	pendingToolCalls := []llm.ToolCall{}
+	finished := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		data, found := strings.CutPrefix(line, "data:")
+		if !found {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "" {
+			continue
+		}
+
+		var chunk generateContentResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeError,
+				Value: fmt.Errorf("failed to parse gemini response: %w", err),
+			}
+			return
+		}
+
+		if chunk.Error != nil {
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeError,
+				Value: fmt.Errorf("error from gemini stream: %s", chunk.Error.Message),
+			}
+			return
+		}
+
+		if chunk.PromptFeedback != nil && chunk.PromptFeedback.BlockReason != "" {
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeError,
+				Value: fmt.Errorf("gemini blocked the prompt: %s", chunk.PromptFeedback.BlockReason),
+			}
+			return
+		}
+
+		if len(chunk.Candidates) == 0 {
+			continue
+		}
+
+		for _, p := range chunk.Candidates[0].Content.Parts {
+			if p.Text != "" {
+				output <- llm.TextStreamEvent{
+					Type:  llm.EventTypeText,
+					Value: p.Text,
+				}
+			}
+
+			if p.FunctionCall != nil {
+				// Gemini doesn't always assign IDs to function calls so we make our own
+				id := p.FunctionCall.ID
+				if id == "" {
+					id = "call_" + strconv.Itoa(len(pendingToolCalls))
+				}
+				arguments := p.FunctionCall.Args
+				if len(arguments) == 0 {
+					arguments = json.RawMessage("{}")
+				}
+				pendingToolCalls = append(pendingToolCalls, llm.ToolCall{
+					ID:        id,
+					Name:      p.FunctionCall.Name,
+					Arguments: arguments,
+				})
+			}
+		}
+
+		switch chunk.Candidates[0].FinishReason {
+		case "":
+		case "STOP", "MAX_TOKENS":
+			finished = true
+		default:
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeError,
+				Value: fmt.Errorf("gemini stopped generating: %s", chunk.Candidates[0].FinishReason),
+			}
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeError,
+			Value: fmt.Errorf("error reading gemini stream: %w", err),
+		}
+		return
+	}
+
+	// The connection was closed before the response was complete, the request can be sent again
+	if !finished {
+		output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeError,
+			Value: fmt.Errorf("gemini stream ended without a finish reason: %w", io.ErrUnexpectedEOF),
+		}
+		return
+	}
+
+	if len(pendingToolCalls) > 0 {
+		output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeToolCalls,
+			Value: pendingToolCalls,
+		}
+		return
+	}
+
+	output <- llm.TextStreamEvent{
+		Type:  llm.EventTypeEnd,
+		Value: nil,
+	}
+}
+
+func (g *Gemini) ChatCompletion(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
+	cfg := g.createConfig(opts)
+	if cfg.Model == "" {
+		return nil, errors.New("no model configured for gemini")
+	}
+
+	systemInstruction, contents := conversationToContents(request.Posts)
+	geminiRequest := generateContentRequest{
+		Contents:          contents,
+		SystemInstruction: systemInstruction,
+		GenerationConfig:  generationConfigFromConfig(cfg),
+	}
+	if request.Context != nil && request.Context.Tools != nil {
+		geminiRequest.Tools = convertTools(request.Context.Tools.GetTools())
+	}
+
+	eventStream := make(chan llm.TextStreamEvent)
+	go func() {
+		defer close(eventStream)
+		g.streamChat(ctx, cfg.Model, geminiRequest, eventStream)
+	}()
+
+	return &llm.TextStreamResult{Stream: eventStream}, nil
+}
+
+func (g *Gemini) ChatCompletionNoStream(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
+	// This could perform better if we didn't use the streaming API here, but the complexity is not worth it.
+	result, err := g.ChatCompletion(ctx, request, opts...)
+	if err != nil {
+		return "", err
+	}
+	return result.ReadAll()
+}
+
+func (g *Gemini) CountTokens(text string) int {
+	return g.tokenizer.CountTokens(text)
+}
+
+func (g *Gemini) InputTokenLimit() int {
+	if g.inputTokenLimit > 0 {
+		return g.inputTokenLimit
+	}
+	return DefaultInputTokenLimit
+}
+
+func (g *Gemini) Capabilities() llm.Capabilities {
+	return llm.Capabilities{
+		Vision:        true,
+		Tools:         true,
+		JSONOutput:    true,
+		Streaming:     true,
+		MaxImageSize:  MaxImageSize,
+		ContextWindow: g.InputTokenLimit(),
+	}
+}
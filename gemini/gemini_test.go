@@ -0,0 +1,245 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gemini
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+)
+
+type fakeGemini struct {
+	status      int
+	body        string
+	events      []string
+	lastPath    string
+	lastAPIKey  string
+	lastRequest generateContentRequest
+}
+
+func (f *fakeGemini) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lastPath = r.URL.Path
+	f.lastAPIKey = r.Header.Get("x-goog-api-key")
+	if err := json.NewDecoder(r.Body).Decode(&f.lastRequest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		_, _ = w.Write([]byte(f.body))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range f.events {
+		_, _ = w.Write([]byte("data: " + event + "\r\n\r\n"))
+	}
+}
+
+type lookupArgs struct {
+	Username string `jsonschema_description:"The username to look up"`
+}
+
+func newTestGemini(t *testing.T, fake *fakeGemini) *Gemini {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return New(llm.ServiceConfig{
+		APIKey:           "test-key",
+		APIURL:           server.URL,
+		DefaultModel:     "gemini-2.0-flash",
+		OutputTokenLimit: 512,
+	}, server.Client())
+}
+
+func TestChatCompletionStreamsText(t *testing.T) {
+	fake := &fakeGemini{
+		events: []string{
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"}]}}]}`,
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":" world"}]},"finishReason":"STOP"}]}`,
+		},
+	}
+	g := newTestGemini(t, fake)
+
+	result, err := g.ChatCompletion(context.Background(), llm.CompletionRequest{
+		Posts: []llm.Post{
+			{Role: llm.PostRoleSystem, Message: "You are helpful"},
+			{Role: llm.PostRoleUser, Message: "Hi"},
+		},
+		Context: llm.NewContext(),
+	})
+	require.NoError(t, err)
+
+	text, err := result.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello world", text)
+
+	assert.Equal(t, "/v1beta/models/gemini-2.0-flash:streamGenerateContent", fake.lastPath)
+	assert.Equal(t, "test-key", fake.lastAPIKey)
+	require.NotNil(t, fake.lastRequest.SystemInstruction)
+	assert.Equal(t, "You are helpful", fake.lastRequest.SystemInstruction.Parts[0].Text)
+	require.Len(t, fake.lastRequest.Contents, 1)
+	assert.Equal(t, "user", fake.lastRequest.Contents[0].Role)
+	require.NotNil(t, fake.lastRequest.GenerationConfig)
+	assert.Equal(t, 512, fake.lastRequest.GenerationConfig.MaxOutputTokens)
+}
+
+func TestChatCompletionToolCalls(t *testing.T) {
+	fake := &fakeGemini{
+		events: []string{
+			`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"LookupMattermostUser","args":{"username":"alice"}}}]},"finishReason":"STOP"}]}`,
+		},
+	}
+	g := newTestGemini(t, fake)
+
+	tools := llm.NewToolStore(nil, false)
+	tools.AddTools([]llm.Tool{{
+		Name:        "LookupMattermostUser",
+		Description: "Lookup a user",
+		Schema:      llm.NewJSONSchemaFromStruct(lookupArgs{}),
+	}})
+
+	result, err := g.ChatCompletion(context.Background(), llm.CompletionRequest{
+		Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Who is alice?"}},
+		Context: llm.NewContext(func(c *llm.Context) { c.Tools = tools }),
+	})
+	require.NoError(t, err)
+
+	var toolCalls []llm.ToolCall
+	for event := range result.Stream {
+		require.NotEqual(t, llm.EventTypeError, event.Type, "unexpected error: %v", event.Value)
+		if event.Type == llm.EventTypeToolCalls {
+			toolCalls = event.Value.([]llm.ToolCall)
+		}
+	}
+
+	require.Len(t, toolCalls, 1)
+	assert.Equal(t, "LookupMattermostUser", toolCalls[0].Name)
+	assert.NotEmpty(t, toolCalls[0].ID)
+	assert.JSONEq(t, `{"username":"alice"}`, string(toolCalls[0].Arguments))
+
+	require.Len(t, fake.lastRequest.Tools, 1)
+	require.Len(t, fake.lastRequest.Tools[0].FunctionDeclarations, 1)
+	declaration := fake.lastRequest.Tools[0].FunctionDeclarations[0]
+	assert.Equal(t, "LookupMattermostUser", declaration.Name)
+	parameters, err := json.Marshal(declaration.Parameters)
+	require.NoError(t, err)
+	assert.NotContains(t, string(parameters), "$schema")
+	assert.NotContains(t, string(parameters), "additionalProperties")
+	assert.Contains(t, string(parameters), "Username")
+}
+
+func TestChatCompletionErrors(t *testing.T) {
+	t.Run("http error", func(t *testing.T) {
+		fake := &fakeGemini{
+			status: http.StatusBadRequest,
+			body:   `{"error":{"code":400,"message":"API key not valid","status":"INVALID_ARGUMENT"}}`,
+		}
+		g := newTestGemini(t, fake)
+
+		result, err := g.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "API key not valid")
+	})
+
+	t.Run("safety stop", func(t *testing.T) {
+		fake := &fakeGemini{
+			events: []string{
+				`{"candidates":[{"content":{"role":"model","parts":[{"text":"partial"}]},"finishReason":"SAFETY"}]}`,
+			},
+		}
+		g := newTestGemini(t, fake)
+
+		result, err := g.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "SAFETY")
+	})
+
+	t.Run("stream ending without a finish reason", func(t *testing.T) {
+		fake := &fakeGemini{
+			events: []string{
+				`{"candidates":[{"content":{"role":"model","parts":[{"text":"partial"}]}}]}`,
+			},
+		}
+		g := newTestGemini(t, fake)
+
+		result, err := g.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.Error(t, err)
+		assert.True(t, llm.IsRetryableError(err))
+	})
+}
+
+func TestConversationToContents(t *testing.T) {
+	posts := []llm.Post{
+		{Role: llm.PostRoleSystem, Message: "system"},
+		{
+			Role:    llm.PostRoleUser,
+			Message: "Look at this",
+			Files: []llm.File{
+				{MimeType: "image/png", Reader: bytes.NewReader([]byte("image-1"))},
+				{MimeType: "image/tiff", Reader: bytes.NewReader([]byte("image-2"))},
+			},
+		},
+		{Role: llm.PostRoleUser, Message: "and this"},
+		{
+			Role:    llm.PostRoleBot,
+			Message: "Searching",
+			ToolUse: []llm.ToolCall{
+				{ID: "1", Name: "SearchServer", Arguments: json.RawMessage(`{"term":"roadmap"}`), Result: "found it", Status: llm.ToolCallStatusSuccess},
+				{ID: "2", Name: "SearchServer", Arguments: json.RawMessage(`{"term":"secret"}`), Result: "rejected", Status: llm.ToolCallStatusRejected},
+			},
+		},
+		{Role: llm.PostRoleBot, Message: "Here you go"},
+	}
+
+	system, contents := conversationToContents(posts)
+	require.NotNil(t, system)
+	assert.Equal(t, []part{{Text: "system"}}, system.Parts)
+
+	require.Len(t, contents, 4)
+
+	assert.Equal(t, "user", contents[0].Role)
+	assert.Equal(t, []part{
+		{Text: "Look at this"},
+		{InlineData: &inlineData{MimeType: "image/png", Data: "aW1hZ2UtMQ=="}},
+		{Text: "[Unsupported image type: image/tiff]"},
+		{Text: "and this"},
+	}, contents[0].Parts)
+
+	assert.Equal(t, "model", contents[1].Role)
+	require.Len(t, contents[1].Parts, 3)
+	assert.Equal(t, "Searching", contents[1].Parts[0].Text)
+	assert.Equal(t, "SearchServer", contents[1].Parts[1].FunctionCall.Name)
+	assert.Equal(t, "1", contents[1].Parts[1].FunctionCall.ID)
+
+	assert.Equal(t, "user", contents[2].Role)
+	require.Len(t, contents[2].Parts, 2)
+	assert.Equal(t, map[string]any{"result": "found it"}, contents[2].Parts[0].FunctionResponse.Response)
+	assert.Equal(t, map[string]any{"error": "rejected"}, contents[2].Parts[1].FunctionResponse.Response)
+
+	assert.Equal(t, content{Role: "model", Parts: []part{{Text: "Here you go"}}}, contents[3])
+}
@@ -20,7 +20,7 @@ type Bundle i18n.Bundle
 
 func Init() *Bundle {
 	bundle := i18n.NewBundle(language.English)
-	_, _ = bundle.LoadMessageFileFS(i18nFiles, "i18n/es.json")
+	_, _ = bundle.LoadMessageFileFS(i18nFiles, "es.json")
 
 	return (*Bundle)(bundle)
 }
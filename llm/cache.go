@@ -0,0 +1,268 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/invopop/jsonschema"
+)
+
+// ResponseCache stores completions by key. Get reports whether the key was found.
+type ResponseCache interface {
+	Get(key string) (string, bool, error)
+	Set(key, value string, ttl time.Duration) error
+}
+
+// CacheConfig controls how long and how large cached responses can be.
+type CacheConfig struct {
+	TTL time.Duration
+	// MaxEntrySize is the largest response, in bytes, that is cached.
+	MaxEntrySize int
+}
+
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		TTL:          24 * time.Hour,
+		MaxEntrySize: 16 * 1024,
+	}
+}
+
+const cacheKeyPrefix = "llm_cache_"
+
+// DefaultCacheMaxEntries is the number of responses kept by the caches, the oldest are evicted first
+const DefaultCacheMaxEntries = 5000
+
+type cacheLogger interface {
+	Warn(message string, keyValuePairs ...any)
+}
+
+// CacheWrapper serves non-streaming completions from the cache for requests made with WithCache.
+// Streaming completions and requests with files are never cached.
+type CacheWrapper struct {
+	wrapped   LanguageModel
+	cache     ResponseCache
+	namespace string
+	config    CacheConfig
+	log       cacheLogger
+}
+
+// NewCacheWrapper returns a LanguageModelWrapper that caches responses in the given cache. The namespace
+// separates the entries of different models and services. log may be nil.
+func NewCacheWrapper(cache ResponseCache, namespace string, config CacheConfig, log cacheLogger) LanguageModelWrapper {
+	return func(wrapped LanguageModel) LanguageModel {
+		return &CacheWrapper{
+			wrapped:   wrapped,
+			cache:     cache,
+			namespace: namespace,
+			config:    config,
+			log:       log,
+		}
+	}
+}
+
+func (w *CacheWrapper) logWarn(message string, err error) {
+	if w.log != nil {
+		w.log.Warn(message, "error", err)
+	}
+}
+
+func (w *CacheWrapper) ChatCompletion(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error) {
+	return w.wrapped.ChatCompletion(ctx, request, opts...)
+}
+
+func (w *CacheWrapper) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	cfg := LanguageModelConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if !cfg.UseCache {
+		return w.wrapped.ChatCompletionNoStream(ctx, request, opts...)
+	}
+
+	key, ok := w.cacheKey(request, cfg)
+	if !ok {
+		return w.wrapped.ChatCompletionNoStream(ctx, request, opts...)
+	}
+
+	cached, found, err := w.cache.Get(key)
+	if err != nil {
+		w.logWarn("failed to read cached LLM response", err)
+	} else if found {
+		return cached, nil
+	}
+
+	result, err := w.wrapped.ChatCompletionNoStream(ctx, request, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	if result != "" && len(result) <= w.config.MaxEntrySize {
+		if err := w.cache.Set(key, result, w.config.TTL); err != nil {
+			w.logWarn("failed to cache LLM response", err)
+		}
+	}
+
+	return result, nil
+}
+
+func (w *CacheWrapper) CountTokens(text string) int {
+	return w.wrapped.CountTokens(text)
+}
+
+func (w *CacheWrapper) InputTokenLimit() int {
+	return w.wrapped.InputTokenLimit()
+}
+
+func (w *CacheWrapper) Capabilities() Capabilities {
+	return w.wrapped.Capabilities()
+}
+
+type cacheKeyPost struct {
+	Role    PostRole
+	Message string
+	ToolUse []ToolCall
+}
+
+type cacheKeyTool struct {
+	Name        string
+	Description string
+	Schema      *jsonschema.Schema
+}
+
+type cacheKeyContent struct {
+	Namespace          string
+	Model              string
+	MaxGeneratedTokens int
+	EnableVision       bool
+	JSONOutputFormat   *jsonschema.Schema
+	Sampling           SamplingParams
+	Reasoning          ReasoningParams
+	Posts              []cacheKeyPost
+	Tools              []cacheKeyTool
+}
+
+// cacheKey hashes everything that is sent to the model. Requests that can't be hashed, such as
+// ones with files, are not cached.
+func (w *CacheWrapper) cacheKey(request CompletionRequest, cfg LanguageModelConfig) (string, bool) {
+	content := cacheKeyContent{
+		Namespace:          w.namespace,
+		Model:              cfg.Model,
+		MaxGeneratedTokens: cfg.MaxGeneratedTokens,
+		EnableVision:       cfg.EnableVision,
+		Sampling:           cfg.SamplingParams,
+		Reasoning:          cfg.ReasoningParams,
+		Posts:              make([]cacheKeyPost, 0, len(request.Posts)),
+	}
+	if cfg.JSONOutputFormat != nil {
+		content.JSONOutputFormat = NewJSONSchemaFromStruct(cfg.JSONOutputFormat)
+	}
+
+	for _, post := range request.Posts {
+		if len(post.Files) > 0 {
+			return "", false
+		}
+		content.Posts = append(content.Posts, cacheKeyPost{
+			Role:    post.Role,
+			Message: strings.TrimSpace(post.Message),
+			ToolUse: post.ToolUse,
+		})
+	}
+
+	if request.Context != nil && request.Context.Tools != nil {
+		for _, tool := range request.Context.Tools.GetTools() {
+			content.Tools = append(content.Tools, cacheKeyTool{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Schema:      tool.Schema,
+			})
+		}
+		slices.SortFunc(content.Tools, func(a, b cacheKeyTool) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		w.logWarn("failed to build LLM cache key", fmt.Errorf("failed to marshal request: %w", err))
+		return "", false
+	}
+
+	hash := sha256.Sum256(data)
+	return cacheKeyPrefix + hex.EncodeToString(hash[:]), true
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+// MemoryResponseCache is a ResponseCache kept in memory, for use outside of the plugin such as in evals.
+// It keeps up to maxEntries responses and evicts the oldest first.
+type MemoryResponseCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	maxEntries int
+	now        func() time.Time
+}
+
+func NewMemoryResponseCache(maxEntries int) *MemoryResponseCache {
+	return &MemoryResponseCache{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+func (c *MemoryResponseCache) Get(key string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false, nil
+	}
+	entry := element.Value.(memoryCacheEntry)
+	if c.now().After(entry.expiresAt) {
+		c.remove(element)
+		return "", false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (c *MemoryResponseCache) Set(key, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.order.PushBack(memoryCacheEntry{
+		key:       key,
+		value:     value,
+		expiresAt: c.now().Add(ttl),
+	})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Front())
+	}
+	return nil
+}
+
+// remove deletes an entry, c.mu must be held.
+func (c *MemoryResponseCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(memoryCacheEntry).key)
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cacheTestOutput struct {
+	Name string
+}
+
+func cacheTestRequest(message string) CompletionRequest {
+	return CompletionRequest{
+		Posts: []Post{
+			{Role: PostRoleSystem, Message: "Pick an emoji"},
+			{Role: PostRoleUser, Message: message},
+		},
+		Context: NewContext(),
+	}
+}
+
+func TestCacheWrapper(t *testing.T) {
+	newWrapper := func(wrapped LanguageModel, cache ResponseCache) LanguageModel {
+		return NewCacheWrapper(cache, "test", DefaultCacheConfig(), nil)(wrapped)
+	}
+
+	t.Run("identical requests are served from the cache", func(t *testing.T) {
+		wrapped := &scriptedModel{}
+		w := newWrapper(wrapped, NewMemoryResponseCache(DefaultCacheMaxEntries))
+
+		for range 3 {
+			result, err := w.ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), WithMaxGeneratedTokens(25), WithCache())
+			require.NoError(t, err)
+			assert.Equal(t, "ok", result)
+		}
+		assert.Equal(t, 1, wrapped.calls)
+	})
+
+	t.Run("surrounding whitespace does not change the key", func(t *testing.T) {
+		wrapped := &scriptedModel{}
+		w := newWrapper(wrapped, NewMemoryResponseCache(DefaultCacheMaxEntries))
+
+		_, err := w.ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), WithCache())
+		require.NoError(t, err)
+		_, err = w.ChatCompletionNoStream(context.Background(), cacheTestRequest("  hello\n"), WithCache())
+		require.NoError(t, err)
+		assert.Equal(t, 1, wrapped.calls)
+	})
+
+	t.Run("different requests and options are cached separately", func(t *testing.T) {
+		wrapped := &scriptedModel{}
+		w := newWrapper(wrapped, NewMemoryResponseCache(DefaultCacheMaxEntries))
+
+		calls := [][]LanguageModelOption{
+			{WithCache()},
+			{WithCache(), WithMaxGeneratedTokens(25)},
+			{WithCache(), WithModel("other")},
+			{WithCache(), WithJSONOutput(&cacheTestOutput{})},
+		}
+		for _, opts := range calls {
+			_, err := w.ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), opts...)
+			require.NoError(t, err)
+		}
+		_, err := w.ChatCompletionNoStream(context.Background(), cacheTestRequest("goodbye"), WithCache())
+		require.NoError(t, err)
+
+		assert.Equal(t, len(calls)+1, wrapped.calls)
+	})
+
+	t.Run("namespaces are cached separately", func(t *testing.T) {
+		wrapped := &scriptedModel{}
+		cache := NewMemoryResponseCache(DefaultCacheMaxEntries)
+
+		_, err := NewCacheWrapper(cache, "a", DefaultCacheConfig(), nil)(wrapped).ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), WithCache())
+		require.NoError(t, err)
+		_, err = NewCacheWrapper(cache, "b", DefaultCacheConfig(), nil)(wrapped).ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), WithCache())
+		require.NoError(t, err)
+		assert.Equal(t, 2, wrapped.calls)
+	})
+
+	t.Run("requests without the option are not cached", func(t *testing.T) {
+		wrapped := &scriptedModel{}
+		w := newWrapper(wrapped, NewMemoryResponseCache(DefaultCacheMaxEntries))
+
+		for range 2 {
+			_, err := w.ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, wrapped.calls)
+	})
+
+	t.Run("requests with files are not cached", func(t *testing.T) {
+		wrapped := &scriptedModel{}
+		w := newWrapper(wrapped, NewMemoryResponseCache(DefaultCacheMaxEntries))
+
+		for range 2 {
+			request := cacheTestRequest("hello")
+			request.Posts[1].Files = []File{{MimeType: "image/png", Reader: bytes.NewReader([]byte("image"))}}
+			_, err := w.ChatCompletionNoStream(context.Background(), request, WithCache())
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, wrapped.calls)
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		wrapped := &scriptedModel{noStreamErr: errors.New("upstream failed")}
+		w := newWrapper(wrapped, NewMemoryResponseCache(DefaultCacheMaxEntries))
+
+		_, err := w.ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), WithCache())
+		require.Error(t, err)
+
+		wrapped.noStreamErr = nil
+		result, err := w.ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), WithCache())
+		require.NoError(t, err)
+		assert.Equal(t, "ok", result)
+		assert.Equal(t, 2, wrapped.calls)
+	})
+
+	t.Run("responses over the size limit are not cached", func(t *testing.T) {
+		wrapped := &scriptedModel{}
+		w := NewCacheWrapper(NewMemoryResponseCache(DefaultCacheMaxEntries), "test", CacheConfig{TTL: time.Hour, MaxEntrySize: 1}, nil)(wrapped)
+
+		for range 2 {
+			_, err := w.ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), WithCache())
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, wrapped.calls)
+	})
+
+	t.Run("cache entries are prefixed and expire", func(t *testing.T) {
+		now := time.Now()
+		cache := NewMemoryResponseCache(DefaultCacheMaxEntries)
+		cache.now = func() time.Time { return now }
+		wrapped := &scriptedModel{}
+		w := newWrapper(wrapped, cache)
+
+		_, err := w.ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), WithCache())
+		require.NoError(t, err)
+		require.Len(t, cache.entries, 1)
+		for key := range cache.entries {
+			assert.True(t, strings.HasPrefix(key, cacheKeyPrefix))
+		}
+
+		now = now.Add(DefaultCacheConfig().TTL + time.Second)
+		_, err = w.ChatCompletionNoStream(context.Background(), cacheTestRequest("hello"), WithCache())
+		require.NoError(t, err)
+		assert.Equal(t, 2, wrapped.calls)
+	})
+
+	t.Run("oldest entries are evicted over the limit", func(t *testing.T) {
+		cache := NewMemoryResponseCache(2)
+		require.NoError(t, cache.Set("a", "1", time.Hour))
+		require.NoError(t, cache.Set("b", "2", time.Hour))
+		require.NoError(t, cache.Set("a", "3", time.Hour))
+		require.NoError(t, cache.Set("c", "4", time.Hour))
+
+		_, found, err := cache.Get("b")
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		value, found, err := cache.Get("a")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "3", value)
+
+		_, found, err = cache.Get("c")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Len(t, cache.entries, 2)
+	})
+}
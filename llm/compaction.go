@@ -0,0 +1,204 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+const TokenLimitBufferSize = 0.9
+const MinTokens = 100
+
+// MaxSummaryTokens caps the size of the summary replacing the turns that don't fit in the context window.
+const MaxSummaryTokens = 1000
+
+// summaryBlockPosts is how many posts are folded into the summary at a time. Older turns are only
+// dropped in whole blocks so the summaries of previous blocks stay the same from one request to the next
+// and can be served from the cache.
+const summaryBlockPosts = 10
+
+const compactionSummarySystem = `You maintain a running summary of a conversation between a user and an AI assistant. The summary replaces the earlier part of the conversation, which the assistant can no longer see. Update the current summary with the new messages. Keep facts, decisions, open questions, the preferences of the user and any instructions they gave. Only reply with the updated summary, no other text.`
+
+const compactionSummaryHeader = "Summary of the earlier conversation:\n"
+
+type compactionLogger interface {
+	Warn(message string, keyValuePairs ...any)
+}
+
+// CompactionWrapper fits requests in the context window of the model. The system prompt and tool
+// definitions are always kept and the output tokens are reserved. Turns that don't fit are replaced by a
+// rolling summary generated by the summarizer, or dropped if there is no summarizer or it fails.
+type CompactionWrapper struct {
+	wrapped      LanguageModel
+	summarizer   LanguageModel
+	outputTokens int
+	log          compactionLogger
+}
+
+// NewCompactionWrapper returns a LanguageModelWrapper that compacts requests. outputTokens is reserved for
+// the response unless the request sets its own maximum. summarizer and log may be nil.
+func NewCompactionWrapper(summarizer LanguageModel, outputTokens int, log compactionLogger) LanguageModelWrapper {
+	return func(wrapped LanguageModel) LanguageModel {
+		return &CompactionWrapper{
+			wrapped:      wrapped,
+			summarizer:   summarizer,
+			outputTokens: outputTokens,
+			log:          log,
+		}
+	}
+}
+
+func (w *CompactionWrapper) ChatCompletion(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error) {
+	return w.wrapped.ChatCompletion(ctx, w.compact(ctx, request, opts), opts...)
+}
+
+func (w *CompactionWrapper) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	return w.wrapped.ChatCompletionNoStream(ctx, w.compact(ctx, request, opts), opts...)
+}
+
+func (w *CompactionWrapper) CountTokens(text string) int {
+	return w.wrapped.CountTokens(text)
+}
+
+func (w *CompactionWrapper) InputTokenLimit() int {
+	return w.wrapped.InputTokenLimit()
+}
+
+func (w *CompactionWrapper) Capabilities() Capabilities {
+	return w.wrapped.Capabilities()
+}
+
+// inputBudget is the number of tokens left for the request once the output is reserved.
+func inputBudget(inputTokenLimit, outputTokens int) int {
+	return int(math.Max(math.Floor(float64(inputTokenLimit-outputTokens)*TokenLimitBufferSize), MinTokens))
+}
+
+func (w *CompactionWrapper) compact(ctx context.Context, request CompletionRequest, opts []LanguageModelOption) CompletionRequest {
+	cfg := LanguageModelConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	outputTokens := w.outputTokens
+	if cfg.MaxGeneratedTokens > 0 {
+		outputTokens = cfg.MaxGeneratedTokens
+	}
+
+	budget := inputBudget(w.wrapped.InputTokenLimit(), outputTokens)
+	if request.Tokens(w.wrapped.CountTokens) <= budget {
+		return request
+	}
+
+	if w.summarizer == nil {
+		request.FitToBudget(budget, w.wrapped.CountTokens)
+		return request
+	}
+
+	summaryTokens := min(MaxSummaryTokens, budget/4)
+	dropped := request.FitToBudget(budget-summaryTokens, w.wrapped.CountTokens)
+	if len(dropped) == 0 {
+		return request
+	}
+	dropped = dropWholeBlocks(&request, dropped)
+
+	summary, err := w.summarize(ctx, request.Context, dropped, summaryTokens)
+	if err != nil {
+		if w.log != nil {
+			w.log.Warn("failed to summarize the earlier conversation, dropping it", "error", err)
+		}
+		return request
+	}
+	AppendToSystemPrompt(&request, compactionSummaryHeader+summary)
+
+	return request
+}
+
+// dropWholeBlocks drops more of the oldest turns until the dropped posts fill whole blocks. The latest
+// post is always kept.
+func dropWholeBlocks(request *CompletionRequest, dropped []Post) []Post {
+	extra := (summaryBlockPosts - len(dropped)%summaryBlockPosts) % summaryBlockPosts
+
+	remaining := 0
+	for _, post := range request.Posts {
+		if post.Role != PostRoleSystem {
+			remaining++
+		}
+	}
+
+	posts := make([]Post, 0, len(request.Posts))
+	for _, post := range request.Posts {
+		if extra > 0 && remaining > 1 && post.Role != PostRoleSystem {
+			dropped = append(dropped, post)
+			extra--
+			remaining--
+			continue
+		}
+		posts = append(posts, post)
+	}
+	request.Posts = posts
+
+	return dropped
+}
+
+// summarize folds the posts into the summary a block at a time, oldest first. The summaries are requested
+// for the user of the request so they are accounted to them.
+func (w *CompactionWrapper) summarize(ctx context.Context, requestContext *Context, posts []Post, maxTokens int) (string, error) {
+	llmContext := NewContext()
+	if requestContext != nil {
+		llmContext.RequestingUser = requestContext.RequestingUser
+		llmContext.Channel = requestContext.Channel
+		llmContext.Team = requestContext.Team
+	}
+
+	summary := ""
+	for block := range slices.Chunk(posts, summaryBlockPosts) {
+		var err error
+		summary, err = w.summarizeBlock(ctx, llmContext, summary, block, maxTokens)
+		if err != nil {
+			return "", err
+		}
+	}
+	return summary, nil
+}
+
+func (w *CompactionWrapper) summarizeBlock(ctx context.Context, llmContext *Context, summary string, block []Post, maxTokens int) (string, error) {
+	system := compactionSummarySystem
+	if summary != "" {
+		system += "\n\nCurrent summary:\n" + summary
+	}
+
+	var transcript strings.Builder
+	for _, post := range block {
+		switch post.Role {
+		case PostRoleUser:
+			transcript.WriteString("User: ")
+		case PostRoleBot:
+			transcript.WriteString("Assistant: ")
+		}
+		transcript.WriteString(post.Message)
+		for _, toolCall := range post.ToolUse {
+			transcript.WriteString(fmt.Sprintf("\nTool call: %s %s\nTool result: %s", toolCall.Name, toolCall.Arguments, toolCall.Result))
+		}
+		transcript.WriteString("\n\n")
+	}
+
+	request := CompletionRequest{
+		Posts: []Post{
+			{Role: PostRoleSystem, Message: system},
+			{Role: PostRoleUser, Message: transcript.String()},
+		},
+		Context: llmContext,
+	}
+	request.FitToBudget(inputBudget(w.summarizer.InputTokenLimit(), maxTokens), w.summarizer.CountTokens)
+
+	result, err := w.summarizer.ChatCompletionNoStream(ctx, request, WithMaxGeneratedTokens(maxTokens), WithCache())
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+
+	return strings.TrimSpace(result), nil
+}
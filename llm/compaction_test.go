@@ -0,0 +1,205 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// summarizerModel answers with a numbered summary and records the requests it receives.
+type summarizerModel struct {
+	scriptedModel
+	requests []CompletionRequest
+	configs  []LanguageModelConfig
+	err      error
+}
+
+func (m *summarizerModel) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	m.calls++
+	m.requests = append(m.requests, request)
+	cfg := LanguageModelConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	m.configs = append(m.configs, cfg)
+	if m.err != nil {
+		return "", m.err
+	}
+	return fmt.Sprintf("summary %d", m.calls), nil
+}
+
+func (m *summarizerModel) InputTokenLimit() int { return 100000 }
+
+// requestRecorder records the last request it receives.
+type requestRecorder struct {
+	scriptedModel
+	last CompletionRequest
+}
+
+func (m *requestRecorder) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	m.last = request
+	return m.scriptedModel.ChatCompletionNoStream(ctx, request, opts...)
+}
+
+// conversation returns a system prompt followed by turns of 100 tokens each.
+func conversation(turns int) CompletionRequest {
+	posts := []Post{{Role: PostRoleSystem, Message: strings.Repeat("s", 50)}}
+	for i := range turns {
+		role := PostRoleUser
+		if i%2 == 1 {
+			role = PostRoleBot
+		}
+		posts = append(posts, Post{Role: role, Message: fmt.Sprintf("%03d", i) + strings.Repeat("m", 97)})
+	}
+	return CompletionRequest{Posts: posts, Context: NewContext()}
+}
+
+func TestCompactionWrapper(t *testing.T) {
+	t.Run("requests that fit are sent as is", func(t *testing.T) {
+		wrapped := &requestRecorder{}
+		summarizer := &summarizerModel{}
+		request := conversation(4)
+
+		_, err := NewCompactionWrapper(summarizer, 0, nil)(wrapped).ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+		assert.Equal(t, request.Posts, wrapped.last.Posts)
+		assert.Equal(t, 0, summarizer.calls)
+	})
+
+	t.Run("older turns are replaced by a summary in the system prompt", func(t *testing.T) {
+		wrapped := &requestRecorder{}
+		summarizer := &summarizerModel{}
+		request := conversation(25)
+
+		_, err := NewCompactionWrapper(summarizer, 0, nil)(wrapped).ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+
+		// 20 turns are dropped in two blocks, each folded into the summary in turn
+		require.Equal(t, 2, summarizer.calls)
+		assert.Contains(t, summarizer.requests[0].Posts[1].Message, "User: 000")
+		assert.Contains(t, summarizer.requests[0].Posts[1].Message, "Assistant: 009")
+		assert.NotContains(t, summarizer.requests[0].Posts[0].Message, "Current summary")
+		assert.Contains(t, summarizer.requests[1].Posts[0].Message, "Current summary:\nsummary 1")
+		assert.Contains(t, summarizer.requests[1].Posts[1].Message, "User: 010")
+		for _, cfg := range summarizer.configs {
+			assert.True(t, cfg.UseCache)
+			assert.Equal(t, 225, cfg.MaxGeneratedTokens)
+		}
+
+		sent := wrapped.last.Posts
+		require.Len(t, sent, 6)
+		assert.Equal(t, strings.Repeat("s", 50)+"\n\n"+compactionSummaryHeader+"summary 2", sent[0].Message)
+		assert.Equal(t, request.Posts[21:], sent[1:])
+		assert.LessOrEqual(t, wrapped.last.Tokens(wrapped.CountTokens), inputBudget(1000, 0))
+	})
+
+	t.Run("tool calls and their results are summarized", func(t *testing.T) {
+		wrapped := &requestRecorder{}
+		summarizer := &summarizerModel{}
+		request := conversation(25)
+		request.Posts[2].ToolUse = []ToolCall{{Name: "search", Arguments: []byte(`{"query":"roadmap"}`), Result: "Q3 roadmap"}}
+
+		_, err := NewCompactionWrapper(summarizer, 0, nil)(wrapped).ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+
+		require.NotEmpty(t, summarizer.requests)
+		assert.Contains(t, summarizer.requests[0].Posts[1].Message, "Tool call: search {\"query\":\"roadmap\"}\nTool result: Q3 roadmap")
+	})
+
+	t.Run("summaries are requested for the user of the request without its tools", func(t *testing.T) {
+		summarizer := &summarizerModel{}
+		request := conversation(25)
+		request.Context.RequestingUser = &model.User{Id: "user1"}
+		request.Context.Channel = &model.Channel{Id: "channel1", TeamId: "team1"}
+		request.Context.Tools = NewToolStore(nil, false)
+		request.Context.Tools.AddTools([]Tool{{Name: "search"}})
+
+		_, err := NewCompactionWrapper(summarizer, 0, nil)(&requestRecorder{}).ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+
+		require.NotEmpty(t, summarizer.requests)
+		for _, summaryRequest := range summarizer.requests {
+			assert.Equal(t, request.Context.RequestingUser, summaryRequest.Context.RequestingUser)
+			assert.Equal(t, request.Context.Channel, summaryRequest.Context.Channel)
+			assert.Nil(t, summaryRequest.Context.Tools)
+		}
+	})
+
+	t.Run("the summary of previous blocks is reused as the conversation grows", func(t *testing.T) {
+		summarizer := &summarizerModel{}
+		w := NewCompactionWrapper(NewCacheWrapper(NewMemoryResponseCache(DefaultCacheMaxEntries), "test", DefaultCacheConfig(), nil)(summarizer), 0, nil)(&requestRecorder{})
+
+		_, err := w.ChatCompletionNoStream(context.Background(), conversation(25))
+		require.NoError(t, err)
+		assert.Equal(t, 2, summarizer.calls)
+
+		_, err = w.ChatCompletionNoStream(context.Background(), conversation(27))
+		require.NoError(t, err)
+		assert.Equal(t, 3, summarizer.calls, "only the new block should be summarized")
+	})
+
+	t.Run("older turns are dropped when summarizing fails", func(t *testing.T) {
+		wrapped := &requestRecorder{}
+		summarizer := &summarizerModel{err: errors.New("unavailable")}
+		request := conversation(25)
+
+		_, err := NewCompactionWrapper(summarizer, 0, nil)(wrapped).ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+
+		sent := wrapped.last.Posts
+		require.NotEmpty(t, sent)
+		assert.Equal(t, request.Posts[0], sent[0], "the system prompt should be kept as is")
+		assert.Equal(t, request.Posts[len(request.Posts)-1], sent[len(sent)-1])
+	})
+
+	t.Run("older turns are dropped without a summarizer", func(t *testing.T) {
+		wrapped := &requestRecorder{}
+		request := conversation(25)
+
+		_, err := NewCompactionWrapper(nil, 0, nil)(wrapped).ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+
+		sent := wrapped.last.Posts
+		require.Len(t, sent, 9)
+		assert.Equal(t, request.Posts[0], sent[0])
+		assert.Equal(t, request.Posts[18:], sent[1:])
+	})
+
+	t.Run("a system prompt is added for the summary if there is none", func(t *testing.T) {
+		wrapped := &requestRecorder{}
+		request := conversation(25)
+		request.Posts = request.Posts[1:]
+
+		_, err := NewCompactionWrapper(&summarizerModel{}, 0, nil)(wrapped).ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+
+		require.NotEmpty(t, wrapped.last.Posts)
+		assert.Equal(t, Post{Role: PostRoleSystem, Message: compactionSummaryHeader + "summary 2"}, wrapped.last.Posts[0])
+	})
+
+	t.Run("the output tokens are reserved", func(t *testing.T) {
+		wrapped := &requestRecorder{}
+		request := conversation(6)
+
+		_, err := NewCompactionWrapper(nil, 0, nil)(wrapped).ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+		assert.Len(t, wrapped.last.Posts, 7)
+
+		_, err = NewCompactionWrapper(nil, 500, nil)(wrapped).ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+		assert.Len(t, wrapped.last.Posts, 5)
+
+		_, err = NewCompactionWrapper(nil, 500, nil)(wrapped).ChatCompletionNoStream(context.Background(), request, WithMaxGeneratedTokens(100))
+		require.NoError(t, err)
+		assert.Len(t, wrapped.last.Posts, 7, "the request maximum should take precedence")
+	})
+}
@@ -4,12 +4,16 @@
 package llm
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
-	"slices"
 	"strings"
+	"unicode/utf8"
 )
 
+// trimMargin is the share of the estimated bytes that TrimToTokens keeps, so one cut is usually enough
+const trimMargin = 0.95
+
 type File struct {
 	MimeType string
 	Size     int64
@@ -29,37 +33,140 @@ type Post struct {
 	Message string
 	Files   []File
 	ToolUse []ToolCall
+	// Reasoning is the reasoning that led to ToolUse, for the providers requiring it with the tool results
+	Reasoning []ReasoningBlock
 }
 
 type CompletionRequest struct {
 	Posts   []Post
 	Context *Context
+	// ToolLoop is what the response already used of the tool loop limits when the request continues it
+	ToolLoop ToolLoopProgress
+}
+
+// ToolTokens is the number of tokens used by the definitions of the tools available to the request.
+func (b CompletionRequest) ToolTokens(countTokens func(string) int) int {
+	if b.Context == nil || b.Context.Tools == nil {
+		return 0
+	}
+
+	total := 0
+	for _, tool := range b.Context.Tools.GetTools() {
+		total += countTokens(tool.Name) + countTokens(tool.Description)
+		if tool.Schema != nil {
+			if schema, err := json.Marshal(tool.Schema); err == nil {
+				total += countTokens(string(schema))
+			}
+		}
+	}
+	return total
+}
+
+// Tokens is the number of tokens of the posts and tool definitions of the request.
+func (b CompletionRequest) Tokens(countTokens func(string) int) int {
+	total := b.ToolTokens(countTokens)
+	for _, post := range b.Posts {
+		total += post.Tokens(countTokens)
+	}
+	return total
+}
+
+// Tokens is the number of tokens of the message of the post and of its tool calls and their results.
+func (p Post) Tokens(countTokens func(string) int) int {
+	total := countTokens(p.Message)
+	for _, toolCall := range p.ToolUse {
+		total += countTokens(toolCall.Name) + countTokens(string(toolCall.Arguments)) + countTokens(toolCall.Result)
+	}
+	return total
 }
 
-func (b *CompletionRequest) Truncate(maxTokens int, countTokens func(string) int) bool {
-	oldPosts := b.Posts
-	b.Posts = make([]Post, 0, len(oldPosts))
-	var totalTokens int
-	for i := len(oldPosts) - 1; i >= 0; i-- {
-		post := oldPosts[i]
-		if totalTokens >= maxTokens {
-			slices.Reverse(b.Posts)
-			return true
+// FitToBudget drops the oldest turns of the conversation until the request fits in maxTokens. System posts
+// and tool definitions are always kept. The latest post is always kept too: if it doesn't fit on its own, the
+// beginning of its message is cut, to MinTokens when the system posts and tool definitions leave no room.
+// The dropped posts are returned oldest first.
+func (b *CompletionRequest) FitToBudget(maxTokens int, countTokens func(string) int) []Post {
+	available := maxTokens - b.ToolTokens(countTokens)
+	for _, post := range b.Posts {
+		if post.Role == PostRoleSystem {
+			available -= post.Tokens(countTokens)
+		}
+	}
+
+	// Walk the conversation from the newest post, keeping posts while they fit
+	keep := make([]bool, len(b.Posts))
+	latest := true
+	cutIndex, cutTokens := -1, 0
+	for i := len(b.Posts) - 1; i >= 0; i-- {
+		post := b.Posts[i]
+		if post.Role == PostRoleSystem {
+			keep[i] = true
+			continue
+		}
+		postTokens := post.Tokens(countTokens)
+
+		if latest {
+			latest = false
+			keep[i] = true
+			if postTokens > available {
+				cutIndex = i
+				cutTokens = available - (postTokens - countTokens(post.Message))
+				if cutTokens <= 0 {
+					cutTokens = MinTokens
+				}
+				available = 0
+				continue
+			}
+			available -= postTokens
+			continue
+		}
+
+		// Older posts that don't fit are dropped whole, along with everything before them
+		if available <= 0 || postTokens > available {
+			available = 0
+			continue
+		}
+		available -= postTokens
+		keep[i] = true
+	}
+
+	var dropped []Post
+	posts := make([]Post, 0, len(b.Posts))
+	for i, post := range b.Posts {
+		if !keep[i] {
+			dropped = append(dropped, post)
+			continue
+		}
+		if i == cutIndex {
+			post.Message = TrimToTokens(post.Message, cutTokens, countTokens)
+		}
+		posts = append(posts, post)
+	}
+	b.Posts = posts
+
+	return dropped
+}
+
+// TrimToTokens cuts the beginning of text until it fits in maxTokens, keeping the most recent content.
+// Text is only cut between runes. The cut is sized from the bytes per token of the text, with a margin so
+// that the text is usually only counted twice whatever its length.
+func TrimToTokens(text string, maxTokens int, countTokens func(string) int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+
+	for tokens := countTokens(text); tokens > maxTokens && text != ""; tokens = countTokens(text) {
+		keep := int(float64(len(text)) * float64(maxTokens) / float64(tokens) * trimMargin)
+		cut := max(len(text)-keep, 1)
+		if cut >= len(text) {
+			return ""
 		}
-		postTokens := countTokens(post.Message)
-		if (totalTokens + postTokens) > maxTokens {
-			charactersToCut := (postTokens - (maxTokens - totalTokens)) * 4
-			post.Message = strings.TrimSpace(post.Message[charactersToCut:])
-			b.Posts = append(b.Posts, post)
-			slices.Reverse(b.Posts)
-			return true
+		for cut < len(text) && !utf8.RuneStart(text[cut]) {
+			cut++
 		}
-		totalTokens += postTokens
-		b.Posts = append(b.Posts, post)
+		text = text[cut:]
 	}
 
-	slices.Reverse(b.Posts)
-	return false
+	return strings.TrimSpace(text)
 }
 
 // ExtractSystemMessage extracts the system message from the conversation.
@@ -6,11 +6,13 @@ package llm
 import (
 	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestCompletionRequestTruncate(t *testing.T) {
+func TestCompletionRequestFitToBudget(t *testing.T) {
 	// Mock token counting function that simply counts characters divided by 4
 	// This is a simplified approximation of how token counting works
 	mockTokenCounter := func(text string) int {
@@ -28,14 +30,14 @@ func TestCompletionRequestTruncate(t *testing.T) {
 		}
 
 		// Token count: ~24 tokens, limit is 50
-		wasTruncated := req.Truncate(50, mockTokenCounter)
+		dropped := req.FitToBudget(50, mockTokenCounter)
 
-		assert.False(t, wasTruncated, "Should not need truncation")
+		assert.Empty(t, dropped, "Should not need truncation")
 		assert.Equal(t, 3, len(req.Posts), "Should have same number of posts")
 		assert.Equal(t, "You are a helpful assistant.", req.Posts[0].Message, "First message should be unchanged")
 	})
 
-	t.Run("Remove oldest messages", func(t *testing.T) {
+	t.Run("Remove oldest messages and keep the system prompt", func(t *testing.T) {
 		// Create a request with more messages than the token limit allows
 		req := CompletionRequest{
 			Posts: []Post{
@@ -48,24 +50,17 @@ func TestCompletionRequestTruncate(t *testing.T) {
 		}
 
 		// Token count: ~72 tokens, limit is 40
-		wasTruncated := req.Truncate(40, mockTokenCounter)
-
-		assert.True(t, wasTruncated, "Should truncate messages")
-		assert.Less(t, len(req.Posts), 5, "Should have fewer posts")
-
-		// The system message and earliest conversation should be dropped
-		lastUserMsg := "What is the population of Paris?"
-		lastBotMsg := "The population of Paris is approximately 2.2 million in the city proper."
-
-		// Check that the most recent messages are preserved
-		found := false
-		for _, post := range req.Posts {
-			if post.Message == lastUserMsg || post.Message == lastBotMsg {
-				found = true
-				break
-			}
-		}
-		assert.True(t, found, "Recent messages should be preserved")
+		dropped := req.FitToBudget(40, mockTokenCounter)
+
+		assert.Equal(t, []Post{
+			{Role: PostRoleUser, Message: "What is the capital of France?"},
+			{Role: PostRoleBot, Message: "The capital of France is Paris."},
+		}, dropped, "The oldest turns should be dropped, oldest first")
+		assert.Equal(t, []Post{
+			{Role: PostRoleSystem, Message: "You are a helpful assistant that provides concise answers."},
+			{Role: PostRoleUser, Message: "What is the population of Paris?"},
+			{Role: PostRoleBot, Message: "The population of Paris is approximately 2.2 million in the city proper."},
+		}, req.Posts, "The system prompt and the most recent messages should be kept")
 	})
 
 	t.Run("Truncate single message", func(t *testing.T) {
@@ -78,15 +73,124 @@ func TestCompletionRequestTruncate(t *testing.T) {
 		}
 
 		// Limit is much smaller than the message
-		wasTruncated := req.Truncate(20, mockTokenCounter)
+		dropped := req.FitToBudget(20, mockTokenCounter)
 
-		assert.True(t, wasTruncated, "Should truncate message")
+		assert.Empty(t, dropped)
 		assert.Equal(t, 1, len(req.Posts), "Should still have one post")
 		assert.NotEqual(t, longMessage, req.Posts[0].Message, "Message should be truncated")
-		assert.Less(t, len(req.Posts[0].Message), len(longMessage), "Truncated message should be shorter")
+		assert.True(t, strings.HasSuffix(strings.TrimSpace(longMessage), req.Posts[0].Message), "The end of the message should be kept")
 
 		// Verify token count is within limit
 		tokenCount := mockTokenCounter(req.Posts[0].Message)
 		assert.LessOrEqual(t, tokenCount, 20, "Truncated message should be within token limit")
 	})
+
+	t.Run("Truncation does not split runes", func(t *testing.T) {
+		longMessage := strings.Repeat("日本語のメッセージ", 20)
+		req := CompletionRequest{
+			Posts: []Post{
+				{Role: PostRoleSystem, Message: "Be brief."},
+				{Role: PostRoleUser, Message: longMessage},
+			},
+		}
+
+		req.FitToBudget(30, mockTokenCounter)
+
+		require.Len(t, req.Posts, 2)
+		assert.Equal(t, "Be brief.", req.Posts[0].Message)
+		assert.True(t, utf8.ValidString(req.Posts[1].Message), "Truncated message should be valid UTF-8")
+		assert.NotEmpty(t, req.Posts[1].Message)
+		assert.LessOrEqual(t, mockTokenCounter(req.Posts[1].Message), 28)
+	})
+
+	t.Run("Tool definitions use part of the budget", func(t *testing.T) {
+		tools := NewNoTools()
+		tools.AddTools([]Tool{{
+			Name:        "lookup",
+			Description: strings.Repeat("Looks things up. ", 6),
+		}})
+		req := CompletionRequest{
+			Posts: []Post{
+				{Role: PostRoleUser, Message: "What is the capital of France?"},
+				{Role: PostRoleBot, Message: "The capital of France is Paris."},
+				{Role: PostRoleUser, Message: "Thanks!"},
+			},
+			Context: &Context{Tools: tools},
+		}
+
+		dropped := req.FitToBudget(30, mockTokenCounter)
+
+		assert.Len(t, dropped, 2)
+		assert.Equal(t, []Post{{Role: PostRoleUser, Message: "Thanks!"}}, req.Posts)
+	})
+
+	t.Run("The latest post is kept when the system prompt fills the budget", func(t *testing.T) {
+		req := CompletionRequest{
+			Posts: []Post{
+				{Role: PostRoleSystem, Message: strings.Repeat("s", 40)},
+				{Role: PostRoleUser, Message: "What is the capital of France?"},
+				{Role: PostRoleUser, Message: "And of Germany?"},
+			},
+		}
+
+		dropped := req.FitToBudget(10, mockTokenCounter)
+
+		assert.Equal(t, []Post{{Role: PostRoleUser, Message: "What is the capital of France?"}}, dropped)
+		assert.Equal(t, []Post{
+			{Role: PostRoleSystem, Message: strings.Repeat("s", 40)},
+			{Role: PostRoleUser, Message: "And of Germany?"},
+		}, req.Posts)
+	})
+
+	t.Run("The latest post is cut to the minimum when nothing is left for it", func(t *testing.T) {
+		longMessage := strings.Repeat("m", 1000)
+		req := CompletionRequest{
+			Posts: []Post{
+				{Role: PostRoleSystem, Message: strings.Repeat("s", 40)},
+				{Role: PostRoleUser, Message: longMessage},
+			},
+		}
+
+		req.FitToBudget(10, mockTokenCounter)
+
+		require.Len(t, req.Posts, 2)
+		assert.LessOrEqual(t, mockTokenCounter(req.Posts[1].Message), MinTokens)
+		assert.Greater(t, mockTokenCounter(req.Posts[1].Message), MinTokens*9/10)
+	})
+
+	t.Run("Tool calls and results use part of the budget", func(t *testing.T) {
+		req := CompletionRequest{
+			Posts: []Post{
+				{Role: PostRoleUser, Message: "What is the capital of France?"},
+				{Role: PostRoleBot, ToolUse: []ToolCall{{Name: "search", Arguments: []byte(`{"query":"capital of France"}`), Result: strings.Repeat("r", 120)}}},
+				{Role: PostRoleUser, Message: "Thanks!"},
+			},
+		}
+
+		dropped := req.FitToBudget(30, mockTokenCounter)
+
+		assert.Len(t, dropped, 2)
+		assert.Equal(t, []Post{{Role: PostRoleUser, Message: "Thanks!"}}, req.Posts)
+	})
+}
+
+func TestTrimToTokens(t *testing.T) {
+	t.Run("Long texts are only counted a few times", func(t *testing.T) {
+		counts := 0
+		countTokens := func(text string) int {
+			counts++
+			return len(strings.Fields(text))
+		}
+
+		text := strings.Repeat("word        ", 100000)
+		trimmed := TrimToTokens(text, 1000, countTokens)
+
+		assert.LessOrEqual(t, countTokens(trimmed), 1000)
+		assert.Greater(t, len(strings.Fields(trimmed)), 900)
+		assert.LessOrEqual(t, counts, 4)
+	})
+
+	t.Run("Nothing is kept without tokens", func(t *testing.T) {
+		assert.Empty(t, TrimToTokens("hello", 0, func(text string) int { return len(text) }))
+	})
 }
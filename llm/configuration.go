@@ -3,6 +3,11 @@
 
 package llm
 
+import (
+	"errors"
+	"fmt"
+)
+
 type ServiceConfig struct {
 	Name         string `json:"name"`
 	Type         string `json:"type"`
@@ -18,6 +23,14 @@ type ServiceConfig struct {
 
 	// Otherwise known as maxTokens
 	OutputTokenLimit int `json:"outputTokenLimit"`
+
+	// ModelSupportsVision declares that the model of an OpenAI compatible service accepts images
+	ModelSupportsVision bool `json:"modelSupportsVision"`
+
+	// Credentials used to sign requests to AWS Bedrock
+	AWSAccessKeyID     string `json:"awsAccessKeyID"`
+	AWSSecretAccessKey string `json:"awsSecretAccessKey"`
+	AWSRegion          string `json:"awsRegion"`
 }
 
 type ChannelAccessLevel int
@@ -52,6 +65,26 @@ type BotConfig struct {
 	UserIDs            []string           `json:"userIDs"`
 	TeamIDs            []string           `json:"teamIDs"`
 	MaxFileSize        int64              `json:"maxFileSize"`
+
+	// FallbackServices are tried in order when the main service is unavailable
+	FallbackServices []ServiceConfig `json:"fallbackServices"`
+
+	// Sampling holds the default sampling parameters of the bot's requests
+	Sampling SamplingParams `json:"sampling"`
+
+	// Reasoning holds the default reasoning parameters of the bot's requests
+	Reasoning ReasoningParams `json:"reasoning"`
+
+	// ToolPolicies decide which tools run automatically, need approval or are denied, the first matching rule applies
+	ToolPolicies []ToolPolicyRule `json:"toolPolicies"`
+
+	// ChannelTools are the names of the tools the bot can use when it's mentioned outside of a direct message
+	ChannelTools []string `json:"channelTools"`
+
+	// MaxToolSteps is the number of completions a response can chain running tools on its own, 0 for the default
+	MaxToolSteps int `json:"maxToolSteps"`
+	// ToolTokenBudget is the number of tokens a response can use running tools on its own, 0 for no limit
+	ToolTokenBudget int64 `json:"toolTokenBudget"`
 }
 
 func (c *BotConfig) IsValid() bool {
@@ -68,18 +101,70 @@ func (c *BotConfig) IsValid() bool {
 		return false
 	}
 
-	// Service-specific validation
-	switch c.Service.Type {
+	if !c.Service.IsValid() {
+		return false
+	}
+
+	for _, fallback := range c.FallbackServices {
+		if !fallback.IsValid() {
+			return false
+		}
+	}
+
+	for _, rule := range c.ToolPolicies {
+		if !rule.IsValid() {
+			return false
+		}
+	}
+
+	if c.MaxToolSteps < 0 || c.ToolTokenBudget < 0 || c.Reasoning.ThinkingBudget < 0 {
+		return false
+	}
+
+	return true
+}
+
+// CheckCapabilities returns an error for each feature enabled for the bot that its LLM does not support. These
+// features are not used, the error tells the administrator why.
+func (c *BotConfig) CheckCapabilities(capabilities Capabilities) error {
+	var errs []error
+	if c.EnableVision && !capabilities.Vision {
+		errs = append(errs, errors.New("vision is enabled but the model does not accept images, attached images are not sent to it"))
+	}
+	if !c.DisableTools && !capabilities.Tools {
+		errs = append(errs, errors.New("tools are enabled but the model does not support tool calling, no tools are offered to it"))
+	}
+	if c.Reasoning.ThinkingBudget > 0 {
+		budget := max(c.Reasoning.ThinkingBudget, MinThinkingBudget)
+		switch {
+		case !capabilities.Thinking:
+			errs = append(errs, errors.New("a thinking budget is set but the model does not support extended thinking, it answers without thinking"))
+		case capabilities.OutputTokenLimit > 0 && capabilities.OutputTokenLimit <= budget:
+			errs = append(errs, fmt.Errorf("the thinking budget of %d tokens leaves no room for the answer within the output token limit of %d tokens, the model answers without thinking", budget, capabilities.OutputTokenLimit))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsValid checks that the service has the settings required by its type
+func (s *ServiceConfig) IsValid() bool {
+	switch s.Type {
 	case ServiceTypeOpenAI:
-		return c.Service.APIKey != ""
+		return s.APIKey != ""
 	case ServiceTypeOpenAICompatible:
-		return c.Service.APIURL != ""
+		return s.APIURL != ""
 	case ServiceTypeAzure:
-		return c.Service.APIKey != "" && c.Service.APIURL != ""
+		return s.APIKey != "" && s.APIURL != ""
 	case ServiceTypeAnthropic:
-		return c.Service.APIKey != ""
+		return s.APIKey != ""
 	case ServiceTypeASage:
-		return c.Service.APIKey != ""
+		return s.APIKey != ""
+	case ServiceTypeOllama:
+		return s.APIURL != ""
+	case ServiceTypeGemini:
+		return s.APIKey != ""
+	case ServiceTypeBedrock:
+		return s.AWSAccessKeyID != "" && s.AWSSecretAccessKey != "" && s.AWSRegion != ""
 	default:
 		return false
 	}
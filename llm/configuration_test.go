@@ -24,6 +24,7 @@ func TestBotConfig_IsValid(t *testing.T) {
 		UserIDs            []string
 		TeamIDs            []string
 		MaxFileSize        int64
+		FallbackServices   []ServiceConfig
 	}
 	tests := []struct {
 		name   string
@@ -263,6 +264,186 @@ func TestBotConfig_IsValid(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "Ollama service requires API URL to be set",
+			fields: fields{
+				ID:          "xxx",
+				Name:        "xxx",
+				DisplayName: "xxx",
+				Service: ServiceConfig{
+					Name:         "Agents",
+					Type:         "ollama",
+					APIURL:       "", // bad
+					DefaultModel: "llama3.1",
+				},
+				ChannelAccessLevel: ChannelAccessLevelAll,
+				UserAccessLevel:    UserAccessLevelAll,
+			},
+			want: false,
+		},
+		{
+			name: "Ollama service does not require API Key to be set",
+			fields: fields{
+				ID:          "xxx",
+				Name:        "xxx",
+				DisplayName: "xxx",
+				Service: ServiceConfig{
+					Name:         "Agents",
+					Type:         "ollama",
+					APIKey:       "", // not bad
+					APIURL:       "http://localhost:11434",
+					DefaultModel: "llama3.1",
+				},
+				ChannelAccessLevel: ChannelAccessLevelAll,
+				UserAccessLevel:    UserAccessLevelAll,
+			},
+			want: true,
+		},
+		{
+			name: "Gemini service requires API Key to be set",
+			fields: fields{
+				ID:          "xxx",
+				Name:        "xxx",
+				DisplayName: "xxx",
+				Service: ServiceConfig{
+					Name:         "Agents",
+					Type:         "gemini",
+					APIKey:       "", // bad
+					DefaultModel: "gemini-2.0-flash",
+				},
+				ChannelAccessLevel: ChannelAccessLevelAll,
+				UserAccessLevel:    UserAccessLevelAll,
+			},
+			want: false,
+		},
+		{
+			name: "Gemini service does not require API URL to be set",
+			fields: fields{
+				ID:          "xxx",
+				Name:        "xxx",
+				DisplayName: "xxx",
+				Service: ServiceConfig{
+					Name:         "Agents",
+					Type:         "gemini",
+					APIKey:       "thisisfake",
+					APIURL:       "", // not bad
+					DefaultModel: "gemini-2.0-flash",
+				},
+				ChannelAccessLevel: ChannelAccessLevelAll,
+				UserAccessLevel:    UserAccessLevelAll,
+			},
+			want: true,
+		},
+		{
+			name: "Bedrock service requires AWS region to be set",
+			fields: fields{
+				ID:          "xxx",
+				Name:        "xxx",
+				DisplayName: "xxx",
+				Service: ServiceConfig{
+					Name:               "Agents",
+					Type:               "bedrock",
+					AWSAccessKeyID:     "AKIDEXAMPLE",
+					AWSSecretAccessKey: "thisisfake",
+					AWSRegion:          "", // bad
+					DefaultModel:       "anthropic.claude-3-5-sonnet-20240620-v1:0",
+				},
+				ChannelAccessLevel: ChannelAccessLevelAll,
+				UserAccessLevel:    UserAccessLevelAll,
+			},
+			want: false,
+		},
+		{
+			name: "Bedrock service requires AWS credentials to be set",
+			fields: fields{
+				ID:          "xxx",
+				Name:        "xxx",
+				DisplayName: "xxx",
+				Service: ServiceConfig{
+					Name:               "Agents",
+					Type:               "bedrock",
+					AWSAccessKeyID:     "AKIDEXAMPLE",
+					AWSSecretAccessKey: "", // bad
+					AWSRegion:          "us-east-1",
+					DefaultModel:       "anthropic.claude-3-5-sonnet-20240620-v1:0",
+				},
+				ChannelAccessLevel: ChannelAccessLevelAll,
+				UserAccessLevel:    UserAccessLevelAll,
+			},
+			want: false,
+		},
+		{
+			name: "Bedrock service does not require API Key to be set",
+			fields: fields{
+				ID:          "xxx",
+				Name:        "xxx",
+				DisplayName: "xxx",
+				Service: ServiceConfig{
+					Name:               "Agents",
+					Type:               "bedrock",
+					APIKey:             "", // not bad
+					AWSAccessKeyID:     "AKIDEXAMPLE",
+					AWSSecretAccessKey: "thisisfake",
+					AWSRegion:          "us-east-1",
+					DefaultModel:       "anthropic.claude-3-5-sonnet-20240620-v1:0",
+				},
+				ChannelAccessLevel: ChannelAccessLevelAll,
+				UserAccessLevel:    UserAccessLevelAll,
+			},
+			want: true,
+		},
+		{
+			name: "Fallback services must be valid",
+			fields: fields{
+				ID:          "xxx",
+				Name:        "xxx",
+				DisplayName: "xxx",
+				Service: ServiceConfig{
+					Name:         "OpenAI",
+					Type:         "openai",
+					APIKey:       "thisisfake",
+					DefaultModel: "gpt-4o",
+				},
+				FallbackServices: []ServiceConfig{
+					{
+						Name:         "Azure",
+						Type:         "azure",
+						APIKey:       "thisisfake",
+						APIURL:       "", // bad
+						DefaultModel: "gpt-4o",
+					},
+				},
+				ChannelAccessLevel: ChannelAccessLevelAll,
+				UserAccessLevel:    UserAccessLevelAll,
+			},
+			want: false,
+		},
+		{
+			name: "Valid fallback services",
+			fields: fields{
+				ID:          "xxx",
+				Name:        "xxx",
+				DisplayName: "xxx",
+				Service: ServiceConfig{
+					Name:         "OpenAI",
+					Type:         "openai",
+					APIKey:       "thisisfake",
+					DefaultModel: "gpt-4o",
+				},
+				FallbackServices: []ServiceConfig{
+					{
+						Name:         "Azure",
+						Type:         "azure",
+						APIKey:       "thisisfake",
+						APIURL:       "https://example.openai.azure.com",
+						DefaultModel: "gpt-4o",
+					},
+				},
+				ChannelAccessLevel: ChannelAccessLevelAll,
+				UserAccessLevel:    UserAccessLevelAll,
+			},
+			want: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -280,8 +461,70 @@ func TestBotConfig_IsValid(t *testing.T) {
 				UserIDs:            tt.fields.UserIDs,
 				TeamIDs:            tt.fields.TeamIDs,
 				MaxFileSize:        tt.fields.MaxFileSize,
+				FallbackServices:   tt.fields.FallbackServices,
 			}
 			assert.Equalf(t, tt.want, c.IsValid(), "IsValid() for test case %q", tt.name)
 		})
 	}
 }
+
+func TestBotConfig_CheckCapabilities(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       BotConfig
+		capabilities Capabilities
+		wantErrors   []string
+	}{
+		{
+			name:         "supported features",
+			config:       BotConfig{EnableVision: true},
+			capabilities: Capabilities{Vision: true, Tools: true},
+		},
+		{
+			name:         "disabled features are not checked",
+			config:       BotConfig{DisableTools: true},
+			capabilities: Capabilities{},
+		},
+		{
+			name:         "vision without image support",
+			config:       BotConfig{EnableVision: true},
+			capabilities: Capabilities{Tools: true},
+			wantErrors:   []string{"vision is enabled"},
+		},
+		{
+			name:         "every unsupported feature is reported",
+			config:       BotConfig{EnableVision: true},
+			capabilities: Capabilities{},
+			wantErrors:   []string{"vision is enabled", "tools are enabled"},
+		},
+		{
+			name:         "thinking budget within the output token limit",
+			config:       BotConfig{Reasoning: ReasoningParams{ThinkingBudget: 2000}},
+			capabilities: Capabilities{Tools: true, Thinking: true, OutputTokenLimit: 8192},
+		},
+		{
+			name:         "thinking budget without thinking support",
+			config:       BotConfig{Reasoning: ReasoningParams{ThinkingBudget: 2000}},
+			capabilities: Capabilities{Tools: true},
+			wantErrors:   []string{"does not support extended thinking"},
+		},
+		{
+			name:         "thinking budget leaving no room for the answer",
+			config:       BotConfig{Reasoning: ReasoningParams{ThinkingBudget: 500}},
+			capabilities: Capabilities{Tools: true, Thinking: true, OutputTokenLimit: 1000},
+			wantErrors:   []string{"thinking budget of 1024 tokens", "output token limit of 1000 tokens"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.CheckCapabilities(tt.capabilities)
+			if len(tt.wantErrors) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			for _, want := range tt.wantErrors {
+				assert.ErrorContains(t, err, want)
+			}
+		})
+	}
+}
@@ -28,6 +28,7 @@ type Context struct {
 	RequestingUser *model.User
 
 	// Bot Specific
+	BotUserID          string
 	BotName            string
 	BotUsername        string
 	BotModel           string
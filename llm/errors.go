@@ -0,0 +1,88 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/sashabaranov/go-openai"
+)
+
+// UpstreamError is returned by the providers that call their API directly, rather than through an SDK, when
+// the API responds with an error status.
+type UpstreamError struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("upstream returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("upstream returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// upstreamStatus returns the status code and headers of the error response behind err, whether it was
+// returned by one of the provider SDKs or as an UpstreamError. ok is false when err isn't an error response.
+func upstreamStatus(err error) (statusCode int, header http.Header, ok bool) {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode, upstreamErr.Header, true
+	}
+
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		if anthropicErr.Response != nil {
+			header = anthropicErr.Response.Header
+		}
+		return anthropicErr.StatusCode, header, true
+	}
+
+	// The OpenAI SDK doesn't keep the headers of the response
+	var openaiAPIErr *openai.APIError
+	if errors.As(err, &openaiAPIErr) && openaiAPIErr.HTTPStatusCode != 0 {
+		return openaiAPIErr.HTTPStatusCode, nil, true
+	}
+	var openaiRequestErr *openai.RequestError
+	if errors.As(err, &openaiRequestErr) && openaiRequestErr.HTTPStatusCode != 0 {
+		return openaiRequestErr.HTTPStatusCode, nil, true
+	}
+
+	return 0, nil, false
+}
+
+// IsRetryableError reports whether a request that failed with err could succeed if sent again,
+// either to the same provider later or to a different provider.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// The caller gave up, trying again would not help.
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if statusCode, _, ok := upstreamStatus(err); ok {
+		return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "rate limited", err: &UpstreamError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "server error", err: fmt.Errorf("wrapped: %w", &UpstreamError{StatusCode: http.StatusBadGateway}), want: true},
+		{name: "connection refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), want: true},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, want: true},
+		{name: "canceled", err: fmt.Errorf("request: %w", context.Canceled), want: false},
+		{name: "other error", err: errors.New("invalid api key"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryableError(tt.err))
+		})
+	}
+}
+
+func TestIsRetryableErrorFromSDKs(t *testing.T) {
+	anthropicErr := func(status int, header http.Header) error {
+		return &anthropic.Error{StatusCode: status, Response: &http.Response{StatusCode: status, Header: header}}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "openai rate limited", err: &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "openai bad request", err: fmt.Errorf("wrapped: %w", &openai.APIError{HTTPStatusCode: http.StatusBadRequest}), want: false},
+		{name: "openai error in stream", err: &openai.APIError{Message: "server_error"}, want: false},
+		{name: "openai unparsable server error", err: &openai.RequestError{HTTPStatusCode: http.StatusBadGateway}, want: true},
+		{name: "anthropic overloaded", err: anthropicErr(529, nil), want: true},
+		{name: "anthropic unauthorized", err: anthropicErr(http.StatusUnauthorized, nil), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryableError(tt.err))
+		})
+	}
+
+	t.Run("anthropic retry after", func(t *testing.T) {
+		err := anthropicErr(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"2"}})
+		delay, retry := DefaultRetryConfig().Delay(err, 0)
+		require.True(t, retry)
+		assert.GreaterOrEqual(t, delay, 2*time.Second)
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FailoverModel is a language model in a failover chain along with the name reported when it answers.
+type FailoverModel struct {
+	Name  string
+	Model LanguageModel
+}
+
+type failoverLogger interface {
+	Warn(message string, keyValuePairs ...any)
+}
+
+// FailoverWrapper sends requests to the first model of the chain and moves on to the next one when a
+// request fails with a retryable error before anything has been streamed back.
+type FailoverWrapper struct {
+	models []FailoverModel
+	log    failoverLogger
+}
+
+// NewFailoverWrapper returns a LanguageModelWrapper that falls back to the given models, in order,
+// when the wrapped model is unavailable. log may be nil.
+func NewFailoverWrapper(primaryName string, fallbacks []FailoverModel, log failoverLogger) LanguageModelWrapper {
+	return func(primary LanguageModel) LanguageModel {
+		models := make([]FailoverModel, 0, len(fallbacks)+1)
+		models = append(models, FailoverModel{Name: primaryName, Model: primary})
+		models = append(models, fallbacks...)
+		return &FailoverWrapper{
+			models: models,
+			log:    log,
+		}
+	}
+}
+
+func (w *FailoverWrapper) logFailover(from FailoverModel, err error) {
+	if w.log != nil {
+		w.log.Warn("LLM provider failed, falling back to next provider", "provider", from.Name, "error", err)
+	}
+}
+
+func (w *FailoverWrapper) ChatCompletion(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error) {
+	newRequest := replayableRequest(request)
+	output := make(chan TextStreamEvent)
+
+	go func() {
+		defer close(output)
+
+		var lastErr error
+		for i, m := range w.models {
+			isLast := i == len(w.models)-1
+
+			result, first, err := startStream(ctx, m.Model, newRequest(), opts...)
+			if err != nil {
+				lastErr = err
+				if !isLast && IsRetryableError(err) {
+					w.logFailover(m, err)
+					continue
+				}
+				break
+			}
+
+			output <- TextStreamEvent{
+				Type:  EventTypeProvider,
+				Value: m.Name,
+			}
+			output <- first
+			for event := range result.Stream {
+				output <- event
+			}
+			return
+		}
+
+		output <- TextStreamEvent{
+			Type:  EventTypeError,
+			Value: lastErr,
+		}
+	}()
+
+	return &TextStreamResult{Stream: output}, nil
+}
+
+func (w *FailoverWrapper) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	newRequest := replayableRequest(request)
+
+	var lastErr error
+	for i, m := range w.models {
+		result, err := m.Model.ChatCompletionNoStream(ctx, newRequest(), opts...)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+		if i == len(w.models)-1 || !IsRetryableError(err) {
+			break
+		}
+		w.logFailover(m, err)
+	}
+
+	return "", lastErr
+}
+
+func (w *FailoverWrapper) CountTokens(text string) int {
+	return w.models[0].Model.CountTokens(text)
+}
+
+// InputTokenLimit returns the limit of the primary model. Each model in the chain is expected to
+// do its own truncation.
+func (w *FailoverWrapper) InputTokenLimit() int {
+	return w.models[0].Model.InputTokenLimit()
+}
+
+// Capabilities returns the features supported by every model in the chain since any of them may answer.
+// The context window is the one of the primary model, like InputTokenLimit.
+func (w *FailoverWrapper) Capabilities() Capabilities {
+	result := w.models[0].Model.Capabilities()
+	for _, m := range w.models[1:] {
+		capabilities := m.Model.Capabilities()
+		result.Vision = result.Vision && capabilities.Vision
+		result.Tools = result.Tools && capabilities.Tools
+		result.JSONOutput = result.JSONOutput && capabilities.JSONOutput
+		result.Streaming = result.Streaming && capabilities.Streaming
+		result.Thinking = result.Thinking && capabilities.Thinking
+		if capabilities.OutputTokenLimit > 0 && (result.OutputTokenLimit == 0 || capabilities.OutputTokenLimit < result.OutputTokenLimit) {
+			result.OutputTokenLimit = capabilities.OutputTokenLimit
+		}
+		if capabilities.MaxImageSize > 0 && (result.MaxImageSize == 0 || capabilities.MaxImageSize < result.MaxImageSize) {
+			result.MaxImageSize = capabilities.MaxImageSize
+		}
+	}
+	return result
+}
+
+// startStream starts a completion and waits for its first event to find out whether the model is
+// answering. A failure to start and an error as the first event are both returned as err.
+func startStream(ctx context.Context, model LanguageModel, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, TextStreamEvent, error) {
+	result, err := model.ChatCompletion(ctx, request, opts...)
+	if err != nil {
+		return nil, TextStreamEvent{}, err
+	}
+
+	first, ok := <-result.Stream
+	if !ok {
+		return nil, TextStreamEvent{}, errors.New("stream closed without a result")
+	}
+	if first.Type == EventTypeError {
+		// Let the provider finish up in case it sends anything after the error.
+		go drain(result.Stream)
+		streamErr, _ := first.Value.(error)
+		if streamErr == nil {
+			streamErr = fmt.Errorf("unknown error from LLM")
+		}
+		return nil, TextStreamEvent{}, streamErr
+	}
+
+	return result, first, nil
+}
+
+// drain discards the remaining events of a stream so the goroutine producing them can exit.
+func drain(stream <-chan TextStreamEvent) {
+	for range stream {
+	}
+}
+
+// replayableRequest buffers the files attached to the request so it can be sent more than once.
+// It returns a function producing a fresh copy of the request for each attempt.
+func replayableRequest(request CompletionRequest) func() CompletionRequest {
+	type bufferedFile struct {
+		file File
+		data []byte
+		err  error
+	}
+
+	buffered := make([][]bufferedFile, len(request.Posts))
+	for i, post := range request.Posts {
+		for _, file := range post.Files {
+			bf := bufferedFile{file: file}
+			if file.Reader != nil {
+				bf.data, bf.err = io.ReadAll(file.Reader)
+			}
+			buffered[i] = append(buffered[i], bf)
+		}
+	}
+
+	return func() CompletionRequest {
+		posts := make([]Post, len(request.Posts))
+		for i, post := range request.Posts {
+			posts[i] = post
+			if len(post.Files) == 0 {
+				continue
+			}
+			posts[i].Files = make([]File, 0, len(post.Files))
+			for _, bf := range buffered[i] {
+				file := bf.file
+				switch {
+				case bf.err != nil:
+					file.Reader = &errorReader{err: bf.err}
+				case file.Reader != nil:
+					file.Reader = bytes.NewReader(bf.data)
+				}
+				posts[i].Files = append(posts[i].Files, file)
+			}
+		}
+		replay := request
+		replay.Posts = posts
+		return replay
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
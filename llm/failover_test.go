@@ -0,0 +1,208 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedModel replays a fixed list of events and records the requests it receives.
+type scriptedModel struct {
+	events       []TextStreamEvent
+	noStreamErr  error
+	calls        int
+	files        [][]byte
+	toolLoop     ToolLoopProgress
+	capabilities Capabilities
+}
+
+func (m *scriptedModel) record(request CompletionRequest) {
+	m.calls++
+	m.toolLoop = request.ToolLoop
+	for _, post := range request.Posts {
+		for _, file := range post.Files {
+			data, _ := io.ReadAll(file.Reader)
+			m.files = append(m.files, data)
+		}
+	}
+}
+
+func (m *scriptedModel) ChatCompletion(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error) {
+	m.record(request)
+	stream := make(chan TextStreamEvent)
+	go func() {
+		defer close(stream)
+		for _, event := range m.events {
+			stream <- event
+		}
+	}()
+	return &TextStreamResult{Stream: stream}, nil
+}
+
+func (m *scriptedModel) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	m.record(request)
+	if m.noStreamErr != nil {
+		return "", m.noStreamErr
+	}
+	return "ok", nil
+}
+
+func (m *scriptedModel) CountTokens(text string) int { return len(text) }
+func (m *scriptedModel) InputTokenLimit() int        { return 1000 }
+func (m *scriptedModel) Capabilities() Capabilities  { return m.capabilities }
+
+func textEvents(text string) []TextStreamEvent {
+	return []TextStreamEvent{
+		{Type: EventTypeText, Value: text},
+		{Type: EventTypeEnd},
+	}
+}
+
+func collect(t *testing.T, result *TextStreamResult) (string, string, error) {
+	t.Helper()
+	provider := ""
+	text := ""
+	for event := range result.Stream {
+		switch event.Type {
+		case EventTypeProvider:
+			provider = event.Value.(string)
+		case EventTypeText:
+			text += event.Value.(string)
+		case EventTypeError:
+			return provider, text, event.Value.(error)
+		}
+	}
+	return provider, text, nil
+}
+
+func TestFailoverWrapper(t *testing.T) {
+	unavailable := &UpstreamError{StatusCode: 503}
+
+	t.Run("falls back on retryable error before streaming", func(t *testing.T) {
+		primary := &scriptedModel{events: []TextStreamEvent{{Type: EventTypeError, Value: unavailable}}}
+		fallback := &scriptedModel{events: textEvents("from fallback")}
+		model := NewFailoverWrapper("openai", []FailoverModel{{Name: "azure", Model: fallback}}, nil)(primary)
+
+		result, err := model.ChatCompletion(context.Background(), CompletionRequest{Posts: []Post{{Role: PostRoleUser, Message: "hi"}}})
+		require.NoError(t, err)
+		provider, text, err := collect(t, result)
+		require.NoError(t, err)
+		assert.Equal(t, "azure", provider)
+		assert.Equal(t, "from fallback", text)
+		assert.Equal(t, 1, primary.calls)
+		assert.Equal(t, 1, fallback.calls)
+	})
+
+	t.Run("reports primary when it answers", func(t *testing.T) {
+		primary := &scriptedModel{events: textEvents("from primary")}
+		fallback := &scriptedModel{events: textEvents("from fallback")}
+		model := NewFailoverWrapper("openai", []FailoverModel{{Name: "azure", Model: fallback}}, nil)(primary)
+
+		result, err := model.ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		provider, text, err := collect(t, result)
+		require.NoError(t, err)
+		assert.Equal(t, "openai", provider)
+		assert.Equal(t, "from primary", text)
+		assert.Equal(t, 0, fallback.calls)
+	})
+
+	t.Run("does not fall back on non retryable error", func(t *testing.T) {
+		primary := &scriptedModel{events: []TextStreamEvent{{Type: EventTypeError, Value: errors.New("invalid api key")}}}
+		fallback := &scriptedModel{events: textEvents("from fallback")}
+		model := NewFailoverWrapper("openai", []FailoverModel{{Name: "azure", Model: fallback}}, nil)(primary)
+
+		result, err := model.ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		_, _, err = collect(t, result)
+		assert.EqualError(t, err, "invalid api key")
+		assert.Equal(t, 0, fallback.calls)
+	})
+
+	t.Run("does not fall back once tokens have been streamed", func(t *testing.T) {
+		primary := &scriptedModel{events: []TextStreamEvent{
+			{Type: EventTypeText, Value: "partial"},
+			{Type: EventTypeError, Value: unavailable},
+		}}
+		fallback := &scriptedModel{events: textEvents("from fallback")}
+		model := NewFailoverWrapper("openai", []FailoverModel{{Name: "azure", Model: fallback}}, nil)(primary)
+
+		result, err := model.ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		_, text, err := collect(t, result)
+		assert.ErrorIs(t, err, unavailable)
+		assert.Equal(t, "partial", text)
+		assert.Equal(t, 0, fallback.calls)
+	})
+
+	t.Run("returns last error when every provider fails", func(t *testing.T) {
+		primary := &scriptedModel{events: []TextStreamEvent{{Type: EventTypeError, Value: unavailable}}}
+		fallback := &scriptedModel{events: []TextStreamEvent{{Type: EventTypeError, Value: &UpstreamError{StatusCode: 429}}}}
+		model := NewFailoverWrapper("openai", []FailoverModel{{Name: "azure", Model: fallback}}, nil)(primary)
+
+		result, err := model.ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		_, _, err = collect(t, result)
+		assert.EqualError(t, err, "upstream returned status 429")
+	})
+
+	t.Run("files are replayed to the fallback", func(t *testing.T) {
+		primary := &scriptedModel{events: []TextStreamEvent{{Type: EventTypeError, Value: unavailable}}}
+		fallback := &scriptedModel{events: textEvents("done")}
+		model := NewFailoverWrapper("openai", []FailoverModel{{Name: "azure", Model: fallback}}, nil)(primary)
+
+		result, err := model.ChatCompletion(context.Background(), CompletionRequest{Posts: []Post{{
+			Role:  PostRoleUser,
+			Files: []File{{MimeType: "image/png", Reader: bytes.NewReader([]byte("image"))}},
+		}}})
+		require.NoError(t, err)
+		_, _, err = collect(t, result)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("image")}, primary.files)
+		assert.Equal(t, [][]byte{[]byte("image")}, fallback.files)
+	})
+
+	t.Run("tool loop progress is replayed to the fallback", func(t *testing.T) {
+		primary := &scriptedModel{events: []TextStreamEvent{{Type: EventTypeError, Value: unavailable}}}
+		fallback := &scriptedModel{events: textEvents("done")}
+		model := NewFailoverWrapper("openai", []FailoverModel{{Name: "azure", Model: fallback}}, nil)(primary)
+
+		progress := ToolLoopProgress{Steps: 2, Tokens: 300, ArgumentErrors: 1}
+		result, err := model.ChatCompletion(context.Background(), CompletionRequest{
+			Posts:    []Post{{Role: PostRoleUser, Message: "hi"}},
+			ToolLoop: progress,
+		})
+		require.NoError(t, err)
+		_, _, err = collect(t, result)
+		require.NoError(t, err)
+		assert.Equal(t, progress, primary.toolLoop)
+		assert.Equal(t, progress, fallback.toolLoop)
+	})
+
+	t.Run("no stream falls back", func(t *testing.T) {
+		primary := &scriptedModel{noStreamErr: unavailable}
+		fallback := &scriptedModel{}
+		model := NewFailoverWrapper("openai", []FailoverModel{{Name: "azure", Model: fallback}}, nil)(primary)
+
+		text, err := model.ChatCompletionNoStream(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, "ok", text)
+		assert.Equal(t, 1, fallback.calls)
+	})
+
+	t.Run("capabilities are shared by every model", func(t *testing.T) {
+		primary := &scriptedModel{capabilities: Capabilities{Vision: true, Tools: true, Streaming: true, MaxImageSize: 20, ContextWindow: 1000}}
+		fallback := &scriptedModel{capabilities: Capabilities{Vision: true, Streaming: true, MaxImageSize: 5, ContextWindow: 500}}
+		model := NewFailoverWrapper("openai", []FailoverModel{{Name: "azure", Model: fallback}}, nil)(primary)
+
+		assert.Equal(t, Capabilities{Vision: true, Streaming: true, MaxImageSize: 5, ContextWindow: 1000}, model.Capabilities())
+	})
+}
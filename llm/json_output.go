@@ -0,0 +1,113 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// JSONOutputRetries is how many times an invalid JSON response is sent back to the model to be fixed.
+const JSONOutputRetries = 2
+
+const jsonOutputInstructions = "Respond only with a JSON object matching the following JSON schema. Do not add any other text or formatting.\n"
+
+const jsonOutputRepair = "Your response is not valid: %s. Respond again with only the corrected JSON object."
+
+// CompleteJSON asks the model for a response matching the JSON schema of the struct T and decodes it.
+// The schema is described in the system prompt, and also given through WithJSONOutput when the model
+// reports Capabilities().JSONOutput. Models that reject the native mode with a bad request error are asked
+// again with the prompt only. Invalid responses are sent back to the model along with the error, up to
+// JSONOutputRetries times.
+func CompleteJSON[T any](ctx context.Context, model LanguageModel, request CompletionRequest, opts ...LanguageModelOption) (T, error) {
+	var result T
+
+	schema := NewJSONSchemaFromStruct(&result)
+	schemaJSON, err := json.Marshal(schema)
+	if err != nil {
+		return result, fmt.Errorf("failed to marshal JSON schema: %w", err)
+	}
+
+	request.Posts = slices.Clone(request.Posts)
+	AppendToSystemPrompt(&request, jsonOutputInstructions+string(schemaJSON))
+	nativeOpts := append(slices.Clone(opts), WithJSONOutput(&result))
+	native := model.Capabilities().JSONOutput
+
+	var lastErr error
+	for attempt := 0; attempt <= JSONOutputRetries; attempt++ {
+		var response string
+		var err error
+		if native {
+			response, err = model.ChatCompletionNoStream(ctx, request, nativeOpts...)
+			if statusCode, _, ok := upstreamStatus(err); ok && statusCode == http.StatusBadRequest {
+				native = false
+			}
+		}
+		if !native {
+			response, err = model.ChatCompletionNoStream(ctx, request, opts...)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		result, lastErr = decodeJSONOutput[T](response, schema.Required)
+		if lastErr == nil {
+			return result, nil
+		}
+
+		request.Posts = append(request.Posts,
+			Post{Role: PostRoleBot, Message: response},
+			Post{Role: PostRoleUser, Message: fmt.Sprintf(jsonOutputRepair, lastErr)},
+		)
+	}
+
+	return result, fmt.Errorf("invalid JSON response after %d attempts: %w", JSONOutputRetries+1, lastErr)
+}
+
+// decodeJSONOutput decodes the JSON object in the response, ignoring surrounding text and code fences,
+// and checks that the required fields are present.
+func decodeJSONOutput[T any](response string, required []string) (T, error) {
+	var result T
+
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start == -1 || end < start {
+		return result, errors.New("no JSON object found")
+	}
+	data := []byte(response[start : end+1])
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return result, fmt.Errorf("invalid JSON: %w", err)
+	}
+	for _, field := range required {
+		if _, ok := fields[field]; !ok {
+			return result, fmt.Errorf("missing required field %q", field)
+		}
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, fmt.Errorf("JSON does not match the schema: %w", err)
+	}
+
+	return result, nil
+}
+
+// AppendToSystemPrompt adds text to the end of the system prompt, or adds a system prompt if there is
+// none. Not every provider supports several system messages.
+func AppendToSystemPrompt(request *CompletionRequest, text string) {
+	for i, post := range request.Posts {
+		if post.Role == PostRoleSystem {
+			request.Posts[i].Message = post.Message + "\n\n" + text
+			return
+		}
+	}
+
+	request.Posts = append([]Post{{Role: PostRoleSystem, Message: text}}, request.Posts...)
+}
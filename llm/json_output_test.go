@@ -0,0 +1,171 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jsonTestOutput struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// responseModel answers with the given responses in order and records the requests it receives.
+type responseModel struct {
+	scriptedModel
+	responses []string
+	requests  []CompletionRequest
+	configs   []LanguageModelConfig
+	// nativeErr is returned for requests using the native JSON output mode
+	nativeErr error
+}
+
+func (m *responseModel) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	m.requests = append(m.requests, request)
+	cfg := LanguageModelConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	m.configs = append(m.configs, cfg)
+	if m.nativeErr != nil && cfg.JSONOutputFormat != nil {
+		return "", m.nativeErr
+	}
+	if m.noStreamErr != nil {
+		return "", m.noStreamErr
+	}
+	return m.responses[min(len(m.requests), len(m.responses))-1], nil
+}
+
+func TestCompleteJSON(t *testing.T) {
+	request := func() CompletionRequest {
+		return CompletionRequest{
+			Posts: []Post{
+				{Role: PostRoleSystem, Message: "You count things."},
+				{Role: PostRoleUser, Message: "How many apples?"},
+			},
+			Context: NewContext(),
+		}
+	}
+
+	t.Run("valid response", func(t *testing.T) {
+		model := &responseModel{responses: []string{`{"name": "apples", "count": 3}`}}
+		model.capabilities.JSONOutput = true
+		req := request()
+
+		result, err := CompleteJSON[jsonTestOutput](context.Background(), model, req, WithMaxGeneratedTokens(100))
+		require.NoError(t, err)
+		assert.Equal(t, jsonTestOutput{Name: "apples", Count: 3}, result)
+
+		require.Len(t, model.requests, 1)
+		system := model.requests[0].Posts[0]
+		assert.Equal(t, PostRoleSystem, system.Role)
+		assert.True(t, strings.HasPrefix(system.Message, "You count things.\n\n"+jsonOutputInstructions))
+		assert.Contains(t, system.Message, `"count"`)
+		assert.Equal(t, 100, model.configs[0].MaxGeneratedTokens)
+		assert.NotNil(t, model.configs[0].JSONOutputFormat)
+
+		// The caller's request is left untouched
+		assert.Equal(t, request().Posts, req.Posts)
+	})
+
+	t.Run("the schema is only in the prompt without native JSON output", func(t *testing.T) {
+		model := &responseModel{responses: []string{`{"name": "apples", "count": 3}`}}
+
+		result, err := CompleteJSON[jsonTestOutput](context.Background(), model, request())
+		require.NoError(t, err)
+		assert.Equal(t, jsonTestOutput{Name: "apples", Count: 3}, result)
+
+		require.Len(t, model.configs, 1)
+		assert.Nil(t, model.configs[0].JSONOutputFormat)
+		assert.Contains(t, model.requests[0].Posts[0].Message, jsonOutputInstructions)
+	})
+
+	t.Run("falls back to the prompt when the native mode is rejected", func(t *testing.T) {
+		model := &responseModel{responses: []string{`{"name": "apples", "count": 3}`, `{"name": "apples", "count": 3}`}}
+		model.capabilities.JSONOutput = true
+		model.nativeErr = &UpstreamError{StatusCode: http.StatusBadRequest, Body: "response_format is not supported"}
+
+		result, err := CompleteJSON[jsonTestOutput](context.Background(), model, request())
+		require.NoError(t, err)
+		assert.Equal(t, jsonTestOutput{Name: "apples", Count: 3}, result)
+
+		require.Len(t, model.configs, 2)
+		assert.NotNil(t, model.configs[0].JSONOutputFormat)
+		assert.Nil(t, model.configs[1].JSONOutputFormat)
+	})
+
+	t.Run("other errors of the native mode are returned", func(t *testing.T) {
+		model := &responseModel{}
+		model.capabilities.JSONOutput = true
+		model.nativeErr = &UpstreamError{StatusCode: http.StatusTooManyRequests}
+
+		_, err := CompleteJSON[jsonTestOutput](context.Background(), model, request())
+		require.ErrorIs(t, err, model.nativeErr)
+		assert.Len(t, model.requests, 1)
+	})
+
+	t.Run("code fences and surrounding text are ignored", func(t *testing.T) {
+		model := &responseModel{responses: []string{"Here you go:\n```json\n{\"name\": \"apples\", \"count\": 3}\n```"}}
+
+		result, err := CompleteJSON[jsonTestOutput](context.Background(), model, request())
+		require.NoError(t, err)
+		assert.Equal(t, jsonTestOutput{Name: "apples", Count: 3}, result)
+	})
+
+	t.Run("invalid responses are sent back to be fixed", func(t *testing.T) {
+		model := &responseModel{responses: []string{
+			`{"name": "apples"}`,
+			`{"name": "apples", "count": 3}`,
+		}}
+
+		result, err := CompleteJSON[jsonTestOutput](context.Background(), model, request())
+		require.NoError(t, err)
+		assert.Equal(t, jsonTestOutput{Name: "apples", Count: 3}, result)
+
+		require.Len(t, model.requests, 2)
+		posts := model.requests[1].Posts
+		require.Len(t, posts, 4)
+		assert.Equal(t, Post{Role: PostRoleBot, Message: `{"name": "apples"}`}, posts[2])
+		assert.Equal(t, PostRoleUser, posts[3].Role)
+		assert.Contains(t, posts[3].Message, `missing required field "count"`)
+	})
+
+	t.Run("gives up after the retries", func(t *testing.T) {
+		model := &responseModel{responses: []string{`{"name": "apples", "count": "three"}`}}
+
+		_, err := CompleteJSON[jsonTestOutput](context.Background(), model, request())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid JSON response after 3 attempts")
+		assert.Len(t, model.requests, JSONOutputRetries+1)
+	})
+
+	t.Run("model errors are returned", func(t *testing.T) {
+		model := &responseModel{}
+		model.noStreamErr = errors.New("unavailable")
+
+		_, err := CompleteJSON[jsonTestOutput](context.Background(), model, request())
+		require.ErrorIs(t, err, model.noStreamErr)
+		assert.Len(t, model.requests, 1)
+	})
+
+	t.Run("a system prompt is added when there is none", func(t *testing.T) {
+		model := &responseModel{responses: []string{`{"name": "apples", "count": 3}`}}
+		req := CompletionRequest{Posts: []Post{{Role: PostRoleUser, Message: "How many apples?"}}}
+
+		_, err := CompleteJSON[jsonTestOutput](context.Background(), model, req)
+		require.NoError(t, err)
+		posts := model.requests[0].Posts
+		require.Len(t, posts, 2)
+		assert.Equal(t, PostRoleSystem, posts[0].Role)
+		assert.True(t, strings.HasPrefix(posts[0].Message, jsonOutputInstructions))
+	})
+}
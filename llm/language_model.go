@@ -20,12 +20,63 @@
 // provider-specific capabilities like vision, JSON output, and tool calling.
 package llm
 
+import (
+	"context"
+	"errors"
+)
+
 type LanguageModel interface {
-	ChatCompletion(conversation CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error)
-	ChatCompletionNoStream(conversation CompletionRequest, opts ...LanguageModelOption) (string, error)
+	ChatCompletion(ctx context.Context, conversation CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error)
+	ChatCompletionNoStream(ctx context.Context, conversation CompletionRequest, opts ...LanguageModelOption) (string, error)
 
+	// CountTokens counts with the tokenizer of the default model of the service. Requests made for another
+	// model with WithModel are counted the same way, which is only an estimate when that model uses another
+	// tokenizer.
 	CountTokens(text string) int
 	InputTokenLimit() int
+	Capabilities() Capabilities
+}
+
+// Capabilities describes the features supported by the model behind a LanguageModel.
+type Capabilities struct {
+	// Vision is true when the model accepts images
+	Vision bool
+	// Tools is true when the model supports tool calling
+	Tools bool
+	// JSONOutput is true when the provider can constrain the response to a JSON schema
+	JSONOutput bool
+	// Streaming is false for providers that only return the response once it is complete
+	Streaming bool
+	// MaxImageSize is the largest image in bytes the provider accepts, 0 when there is no known limit
+	MaxImageSize int64
+	// ContextWindow is the number of input tokens the model can take
+	ContextWindow int
+	// Thinking is true when the model can use a thinking budget, see ReasoningParams
+	Thinking bool
+	// OutputTokenLimit is the number of tokens the model generates when the request doesn't set a limit, 0 when
+	// it is not known
+	OutputTokenLimit int
+}
+
+// Tokenizer counts the tokens a model uses for a text.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// ErrModelListingNotSupported is returned when the provider of a service can't list its models.
+var ErrModelListingNotSupported = errors.New("listing models is not supported for this service type")
+
+// ModelLister is implemented by providers that can list the models available to the configured account.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// ModelInfo describes a model offered by a provider.
+type ModelInfo struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName,omitempty"`
+	// ContextWindow is the number of input tokens the model can take, 0 when the provider doesn't report it
+	ContextWindow int `json:"contextWindow,omitempty"`
 }
 
 type LanguageModelConfig struct {
@@ -33,10 +84,40 @@ type LanguageModelConfig struct {
 	MaxGeneratedTokens int
 	EnableVision       bool
 	JSONOutputFormat   any
+	UseCache           bool
+	SamplingParams
+	ReasoningParams
+}
+
+// SamplingParams control how the model picks the tokens of the response. Unset fields leave the provider
+// defaults, providers ignore the parameters they don't support. ASage only supports the temperature.
+type SamplingParams struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequencyPenalty,omitempty"`
+}
+
+// MinThinkingBudget is the smallest thinking budget accepted by the providers supporting one, smaller budgets
+// are raised to it.
+const MinThinkingBudget = 1024
+
+// ReasoningParams control how much reasoning models think before answering. Providers ignore the parameters
+// they don't support.
+type ReasoningParams struct {
+	// ReasoningEffort is sent to OpenAI reasoning models, one of "minimal", "low", "medium" or "high"
+	ReasoningEffort string `json:"reasoningEffort,omitempty"`
+	// ThinkingBudget is the number of tokens Anthropic models can use for extended thinking, 0 disables it.
+	// Thinking is skipped for requests that don't leave room for the answer on top of the budget.
+	ThinkingBudget int `json:"thinkingBudget,omitempty"`
 }
 
 type LanguageModelOption func(*LanguageModelConfig)
 
+// WithModel sends the request to another model of the service. Tokens are still counted for the default
+// model, see LanguageModel.CountTokens.
 func WithModel(model string) LanguageModelOption {
 	return func(cfg *LanguageModelConfig) {
 		cfg.Model = model
@@ -53,4 +134,79 @@ func WithJSONOutput(format any) LanguageModelOption {
 	}
 }
 
+func WithTemperature(temperature float64) LanguageModelOption {
+	return func(cfg *LanguageModelConfig) {
+		cfg.Temperature = &temperature
+	}
+}
+func WithTopP(topP float64) LanguageModelOption {
+	return func(cfg *LanguageModelConfig) {
+		cfg.TopP = &topP
+	}
+}
+func WithStopSequences(stopSequences ...string) LanguageModelOption {
+	return func(cfg *LanguageModelConfig) {
+		cfg.StopSequences = stopSequences
+	}
+}
+func WithSeed(seed int64) LanguageModelOption {
+	return func(cfg *LanguageModelConfig) {
+		cfg.Seed = &seed
+	}
+}
+func WithPresencePenalty(penalty float64) LanguageModelOption {
+	return func(cfg *LanguageModelConfig) {
+		cfg.PresencePenalty = &penalty
+	}
+}
+func WithFrequencyPenalty(penalty float64) LanguageModelOption {
+	return func(cfg *LanguageModelConfig) {
+		cfg.FrequencyPenalty = &penalty
+	}
+}
+
+// WithSamplingParams sets the parameters that are set in params, leaving the others as they are.
+func WithSamplingParams(params SamplingParams) LanguageModelOption {
+	return func(cfg *LanguageModelConfig) {
+		if params.Temperature != nil {
+			cfg.Temperature = params.Temperature
+		}
+		if params.TopP != nil {
+			cfg.TopP = params.TopP
+		}
+		if len(params.StopSequences) > 0 {
+			cfg.StopSequences = params.StopSequences
+		}
+		if params.Seed != nil {
+			cfg.Seed = params.Seed
+		}
+		if params.PresencePenalty != nil {
+			cfg.PresencePenalty = params.PresencePenalty
+		}
+		if params.FrequencyPenalty != nil {
+			cfg.FrequencyPenalty = params.FrequencyPenalty
+		}
+	}
+}
+
+// WithReasoningParams sets the parameters that are set in params, leaving the others as they are.
+func WithReasoningParams(params ReasoningParams) LanguageModelOption {
+	return func(cfg *LanguageModelConfig) {
+		if params.ReasoningEffort != "" {
+			cfg.ReasoningEffort = params.ReasoningEffort
+		}
+		if params.ThinkingBudget > 0 {
+			cfg.ThinkingBudget = params.ThinkingBudget
+		}
+	}
+}
+
+// WithCache allows the response to be served from, and stored in, the response cache.
+// Only use it for requests where an identical prompt can get an identical answer.
+func WithCache() LanguageModelOption {
+	return func(cfg *LanguageModelConfig) {
+		cfg.UseCache = true
+	}
+}
+
 type LanguageModelWrapper func(LanguageModel) LanguageModel
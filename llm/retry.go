@@ -0,0 +1,205 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryConfig controls how failed upstream requests are retried.
+type RetryConfig struct {
+	// MaxRetries is the number of attempts made after the first one fails
+	MaxRetries int
+	// InitialBackoff is the upper bound of the delay before the first retry, it doubles with each attempt
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponential backoff
+	MaxBackoff time.Duration
+	// MaxRetryAfter is the longest delay requested by the upstream that we are willing to wait for
+	MaxRetryAfter time.Duration
+}
+
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxRetries:     3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     8 * time.Second,
+		MaxRetryAfter:  time.Minute,
+	}
+}
+
+// FailoverRetryConfig is used for the models of a failover chain that have a fallback. Waiting on a rate
+// limited or failing provider would hold up the request when the next provider of the chain can answer
+// right away, so such a model only retries once, briefly.
+func FailoverRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxRetries:     1,
+		InitialBackoff: 250 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		MaxRetryAfter:  2 * time.Second,
+	}
+}
+
+// Delay returns how long to wait before making retry number attempt (starting at 0) after err,
+// and false if the request should not be retried.
+func (c RetryConfig) Delay(err error, attempt int) (time.Duration, bool) {
+	if attempt >= c.MaxRetries || !IsRetryableError(err) {
+		return 0, false
+	}
+
+	if _, header, ok := upstreamStatus(err); ok {
+		if hint := retryAfterFromHeader(header, time.Now()); hint > 0 {
+			if hint > c.MaxRetryAfter {
+				return 0, false
+			}
+			// A little jitter so that concurrent requests don't all come back at the same instant
+			return hint + rand.N(hint/10+1), true
+		}
+	}
+
+	// Full jitter exponential backoff
+	backoff := c.InitialBackoff << attempt
+	if backoff <= 0 || backoff > c.MaxBackoff {
+		backoff = c.MaxBackoff
+	}
+	return rand.N(backoff) + 1, true
+}
+
+// retryAfterFromHeader extracts the delay requested by the upstream.
+// Retry-After (seconds or HTTP date) and retry-after-ms take precedence, otherwise the latest of the
+// OpenAI style x-ratelimit-reset-requests and x-ratelimit-reset-tokens durations is used.
+func retryAfterFromHeader(header http.Header, now time.Time) time.Duration {
+	if header == nil {
+		return 0
+	}
+
+	if value := header.Get("Retry-After-Ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			if delay := date.Sub(now); delay > 0 {
+				return delay
+			}
+		}
+	}
+
+	var longest time.Duration
+	for name, values := range header {
+		if !strings.HasPrefix(strings.ToLower(name), "x-ratelimit-reset-") || len(values) == 0 {
+			continue
+		}
+		if reset, err := time.ParseDuration(values[0]); err == nil && reset > longest {
+			longest = reset
+		}
+	}
+
+	return longest
+}
+
+// RetryWrapper retries requests that fail with a retryable error before anything has been streamed.
+type RetryWrapper struct {
+	wrapped LanguageModel
+	config  RetryConfig
+	sleep   func(context.Context, time.Duration) error
+}
+
+// NewRetryWrapper returns a LanguageModelWrapper retrying transient upstream failures.
+func NewRetryWrapper(config RetryConfig) LanguageModelWrapper {
+	return func(wrapped LanguageModel) LanguageModel {
+		return &RetryWrapper{
+			wrapped: wrapped,
+			config:  config,
+			sleep:   SleepContext,
+		}
+	}
+}
+
+func (w *RetryWrapper) ChatCompletion(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error) {
+	newRequest := replayableRequest(request)
+	output := make(chan TextStreamEvent)
+
+	go func() {
+		defer close(output)
+
+		for attempt := 0; ; attempt++ {
+			result, first, err := startStream(ctx, w.wrapped, newRequest(), opts...)
+			if err != nil {
+				delay, retry := w.config.Delay(err, attempt)
+				if retry {
+					if err = w.sleep(ctx, delay); err == nil {
+						continue
+					}
+				}
+				output <- TextStreamEvent{
+					Type:  EventTypeError,
+					Value: err,
+				}
+				return
+			}
+
+			// Output has started, from here on errors are passed through as is.
+			output <- first
+			for event := range result.Stream {
+				output <- event
+			}
+			return
+		}
+	}()
+
+	return &TextStreamResult{Stream: output}, nil
+}
+
+func (w *RetryWrapper) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	newRequest := replayableRequest(request)
+
+	for attempt := 0; ; attempt++ {
+		result, err := w.wrapped.ChatCompletionNoStream(ctx, newRequest(), opts...)
+		if err == nil {
+			return result, nil
+		}
+		delay, retry := w.config.Delay(err, attempt)
+		if !retry {
+			return "", err
+		}
+		if err := w.sleep(ctx, delay); err != nil {
+			return "", err
+		}
+	}
+}
+
+// SleepContext waits for d, returning early with the error of ctx when it is done first.
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *RetryWrapper) CountTokens(text string) int {
+	return w.wrapped.CountTokens(text)
+}
+
+func (w *RetryWrapper) InputTokenLimit() int {
+	return w.wrapped.InputTokenLimit()
+}
+
+func (w *RetryWrapper) Capabilities() Capabilities {
+	return w.wrapped.Capabilities()
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyModel fails with the given errors, in order, before answering.
+type flakyModel struct {
+	scriptedModel
+	failures []error
+}
+
+func (m *flakyModel) ChatCompletion(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error) {
+	if m.calls < len(m.failures) {
+		m.events = []TextStreamEvent{{Type: EventTypeError, Value: m.failures[m.calls]}}
+	} else {
+		m.events = textEvents("answer")
+	}
+	return m.scriptedModel.ChatCompletion(ctx, request, opts...)
+}
+
+func (m *flakyModel) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	m.noStreamErr = nil
+	if m.calls < len(m.failures) {
+		m.noStreamErr = m.failures[m.calls]
+	}
+	return m.scriptedModel.ChatCompletionNoStream(ctx, request, opts...)
+}
+
+func newTestRetryWrapper(wrapped LanguageModel, sleeps *[]time.Duration) *RetryWrapper {
+	w := NewRetryWrapper(DefaultRetryConfig())(wrapped).(*RetryWrapper)
+	w.sleep = func(_ context.Context, d time.Duration) error {
+		*sleeps = append(*sleeps, d)
+		return nil
+	}
+	return w
+}
+
+func TestRetryWrapper(t *testing.T) {
+	unavailable := &UpstreamError{StatusCode: http.StatusServiceUnavailable}
+
+	t.Run("retries until the model answers", func(t *testing.T) {
+		var sleeps []time.Duration
+		model := &flakyModel{failures: []error{unavailable, unavailable}}
+		result, err := newTestRetryWrapper(model, &sleeps).ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		_, text, err := collect(t, result)
+		require.NoError(t, err)
+		assert.Equal(t, "answer", text)
+		assert.Equal(t, 3, model.calls)
+		assert.Len(t, sleeps, 2)
+	})
+
+	t.Run("gives up after max retries", func(t *testing.T) {
+		var sleeps []time.Duration
+		model := &flakyModel{failures: []error{unavailable, unavailable, unavailable, unavailable}}
+		result, err := newTestRetryWrapper(model, &sleeps).ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		_, _, err = collect(t, result)
+		assert.ErrorIs(t, err, unavailable)
+		assert.Equal(t, 4, model.calls)
+		assert.Len(t, sleeps, 3)
+	})
+
+	t.Run("does not retry non retryable errors", func(t *testing.T) {
+		var sleeps []time.Duration
+		model := &flakyModel{failures: []error{errors.New("invalid api key")}}
+		result, err := newTestRetryWrapper(model, &sleeps).ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		_, _, err = collect(t, result)
+		assert.EqualError(t, err, "invalid api key")
+		assert.Equal(t, 1, model.calls)
+		assert.Empty(t, sleeps)
+	})
+
+	t.Run("does not retry once output has started", func(t *testing.T) {
+		var sleeps []time.Duration
+		model := &scriptedModel{events: []TextStreamEvent{
+			{Type: EventTypeText, Value: "partial"},
+			{Type: EventTypeError, Value: unavailable},
+		}}
+		result, err := newTestRetryWrapper(model, &sleeps).ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		_, text, err := collect(t, result)
+		assert.ErrorIs(t, err, unavailable)
+		assert.Equal(t, "partial", text)
+		assert.Equal(t, 1, model.calls)
+		assert.Empty(t, sleeps)
+	})
+
+	t.Run("honors retry after", func(t *testing.T) {
+		var sleeps []time.Duration
+		rateLimited := &UpstreamError{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"2"}}}
+		model := &flakyModel{failures: []error{rateLimited}}
+		text, err := newTestRetryWrapper(model, &sleeps).ChatCompletionNoStream(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, "ok", text)
+		require.Len(t, sleeps, 1)
+		assert.GreaterOrEqual(t, sleeps[0], 2*time.Second)
+		assert.LessOrEqual(t, sleeps[0], 2200*time.Millisecond)
+	})
+
+	t.Run("does not wait longer than allowed", func(t *testing.T) {
+		var sleeps []time.Duration
+		rateLimited := &UpstreamError{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"3600"}}}
+		model := &flakyModel{failures: []error{rateLimited}}
+		_, err := newTestRetryWrapper(model, &sleeps).ChatCompletionNoStream(context.Background(), CompletionRequest{})
+		assert.ErrorIs(t, err, rateLimited)
+		assert.Empty(t, sleeps)
+	})
+
+	t.Run("stops waiting when the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		model := &flakyModel{failures: []error{unavailable}}
+		_, err := NewRetryWrapper(DefaultRetryConfig())(model).ChatCompletionNoStream(ctx, CompletionRequest{})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, model.calls)
+	})
+}
+
+func TestRetryConfigDelay(t *testing.T) {
+	config := DefaultRetryConfig()
+	unavailable := &UpstreamError{StatusCode: http.StatusServiceUnavailable}
+
+	for attempt := 0; attempt < config.MaxRetries; attempt++ {
+		delay, retry := config.Delay(unavailable, attempt)
+		require.True(t, retry)
+		assert.Greater(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, min(config.InitialBackoff<<attempt, config.MaxBackoff))
+	}
+
+	_, retry := config.Delay(unavailable, config.MaxRetries)
+	assert.False(t, retry)
+}
+
+func TestRetryAfterFromHeader(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "none", header: http.Header{}, want: 0},
+		{name: "seconds", header: http.Header{"Retry-After": []string{"7"}}, want: 7 * time.Second},
+		{name: "http date", header: http.Header{"Retry-After": []string{"Wed, 01 May 2024 12:00:30 GMT"}}, want: 30 * time.Second},
+		{name: "date in the past", header: http.Header{"Retry-After": []string{"Wed, 01 May 2024 11:00:00 GMT"}}, want: 0},
+		{name: "milliseconds", header: http.Header{"Retry-After-Ms": []string{"250"}}, want: 250 * time.Millisecond},
+		{
+			name: "rate limit reset uses the longest",
+			header: http.Header{
+				"X-Ratelimit-Reset-Requests": []string{"20ms"},
+				"X-Ratelimit-Reset-Tokens":   []string{"6m0s"},
+			},
+			want: 6 * time.Minute,
+		},
+		{
+			name: "retry after takes precedence",
+			header: http.Header{
+				"Retry-After":              []string{"1"},
+				"X-Ratelimit-Reset-Tokens": []string{"10s"},
+			},
+			want: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retryAfterFromHeader(tt.header, now))
+		})
+	}
+}
+
+func TestRetryWithFailover(t *testing.T) {
+	// The stack built for a bot with a fallback: the primary retries with FailoverRetryConfig and the last
+	// model of the chain with DefaultRetryConfig
+	newStack := func(primary, fallback LanguageModel, sleeps *[]time.Duration) LanguageModel {
+		sleep := func(_ context.Context, d time.Duration) error {
+			*sleeps = append(*sleeps, d)
+			return nil
+		}
+		primaryRetry := NewRetryWrapper(FailoverRetryConfig())(primary).(*RetryWrapper)
+		primaryRetry.sleep = sleep
+		fallbackRetry := NewRetryWrapper(DefaultRetryConfig())(fallback).(*RetryWrapper)
+		fallbackRetry.sleep = sleep
+		return NewFailoverWrapper("primary", []FailoverModel{{Name: "fallback", Model: fallbackRetry}}, nil)(primaryRetry)
+	}
+
+	t.Run("long rate limits fail over without waiting", func(t *testing.T) {
+		var sleeps []time.Duration
+		rateLimited := &UpstreamError{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"60"}}}
+		primary := &flakyModel{failures: []error{rateLimited}}
+		fallback := &flakyModel{}
+
+		result, err := newStack(primary, fallback, &sleeps).ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		provider, text, err := collect(t, result)
+		require.NoError(t, err)
+		assert.Equal(t, "fallback", provider)
+		assert.Equal(t, "answer", text)
+		assert.Equal(t, 1, primary.calls)
+		assert.Empty(t, sleeps)
+	})
+
+	t.Run("outages are retried briefly before failing over", func(t *testing.T) {
+		var sleeps []time.Duration
+		unavailable := &UpstreamError{StatusCode: http.StatusServiceUnavailable}
+		primary := &flakyModel{failures: []error{unavailable, unavailable, unavailable}}
+		fallback := &flakyModel{failures: []error{unavailable}}
+
+		result, err := newStack(primary, fallback, &sleeps).ChatCompletion(context.Background(), CompletionRequest{})
+		require.NoError(t, err)
+		provider, _, err := collect(t, result)
+		require.NoError(t, err)
+		assert.Equal(t, "fallback", provider)
+		assert.Equal(t, 2, primary.calls)
+		assert.Equal(t, 2, fallback.calls)
+
+		var total time.Duration
+		for _, sleep := range sleeps {
+			total += sleep
+		}
+		assert.Len(t, sleeps, 2)
+		assert.LessOrEqual(t, total, FailoverRetryConfig().MaxBackoff+DefaultRetryConfig().InitialBackoff)
+	})
+}
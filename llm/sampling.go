@@ -0,0 +1,52 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"slices"
+)
+
+// SamplingWrapper applies default sampling and reasoning parameters to every request. Parameters set by the
+// caller take precedence over the defaults.
+type SamplingWrapper struct {
+	wrapped   LanguageModel
+	defaults  SamplingParams
+	reasoning ReasoningParams
+}
+
+// NewSamplingWrapper returns a LanguageModelWrapper applying the default sampling and reasoning parameters.
+func NewSamplingWrapper(defaults SamplingParams, reasoning ReasoningParams) LanguageModelWrapper {
+	return func(wrapped LanguageModel) LanguageModel {
+		return &SamplingWrapper{
+			wrapped:   wrapped,
+			defaults:  defaults,
+			reasoning: reasoning,
+		}
+	}
+}
+
+func (w *SamplingWrapper) withDefaults(opts []LanguageModelOption) []LanguageModelOption {
+	return slices.Insert(slices.Clone(opts), 0, WithSamplingParams(w.defaults), WithReasoningParams(w.reasoning))
+}
+
+func (w *SamplingWrapper) ChatCompletion(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error) {
+	return w.wrapped.ChatCompletion(ctx, request, w.withDefaults(opts)...)
+}
+
+func (w *SamplingWrapper) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	return w.wrapped.ChatCompletionNoStream(ctx, request, w.withDefaults(opts)...)
+}
+
+func (w *SamplingWrapper) CountTokens(text string) int {
+	return w.wrapped.CountTokens(text)
+}
+
+func (w *SamplingWrapper) InputTokenLimit() int {
+	return w.wrapped.InputTokenLimit()
+}
+
+func (w *SamplingWrapper) Capabilities() Capabilities {
+	return w.wrapped.Capabilities()
+}
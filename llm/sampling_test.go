@@ -0,0 +1,44 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSamplingWrapper(t *testing.T) {
+	temperature := 0.2
+	seed := int64(42)
+	defaults := SamplingParams{Temperature: &temperature, Seed: &seed, StopSequences: []string{"END"}}
+	reasoning := ReasoningParams{ReasoningEffort: "low", ThinkingBudget: 2000}
+
+	t.Run("defaults are applied", func(t *testing.T) {
+		wrapped := &responseModel{responses: []string{"ok"}}
+
+		_, err := NewSamplingWrapper(defaults, reasoning)(wrapped).ChatCompletionNoStream(context.Background(), CompletionRequest{}, WithMaxGeneratedTokens(10))
+		require.NoError(t, err)
+		require.Len(t, wrapped.configs, 1)
+		assert.Equal(t, defaults, wrapped.configs[0].SamplingParams)
+		assert.Equal(t, reasoning, wrapped.configs[0].ReasoningParams)
+		assert.Equal(t, 10, wrapped.configs[0].MaxGeneratedTokens)
+	})
+
+	t.Run("options of the call take precedence", func(t *testing.T) {
+		wrapped := &responseModel{responses: []string{"ok"}}
+
+		_, err := NewSamplingWrapper(defaults, reasoning)(wrapped).ChatCompletionNoStream(context.Background(), CompletionRequest{}, WithTemperature(1), WithStopSequences("STOP"), WithReasoningParams(ReasoningParams{ReasoningEffort: "high"}))
+		require.NoError(t, err)
+		cfg := wrapped.configs[0]
+		assert.Equal(t, ReasoningParams{ReasoningEffort: "high", ThinkingBudget: 2000}, cfg.ReasoningParams)
+		require.NotNil(t, cfg.Temperature)
+		assert.Equal(t, 1.0, *cfg.Temperature)
+		assert.Equal(t, []string{"STOP"}, cfg.StopSequences)
+		assert.Equal(t, &seed, cfg.Seed)
+		assert.Nil(t, cfg.TopP)
+	})
+}
@@ -9,4 +9,7 @@ const (
 	ServiceTypeAzure            = "azure"
 	ServiceTypeASage            = "asage"
 	ServiceTypeAnthropic        = "anthropic"
+	ServiceTypeOllama           = "ollama"
+	ServiceTypeGemini           = "gemini"
+	ServiceTypeBedrock          = "bedrock"
 )
@@ -17,8 +17,38 @@ const (
 	EventTypeError
 	// EventTypeToolCalls represents a tool call event
 	EventTypeToolCalls
+	// EventTypeProvider carries the name of the service answering the request when a failover chain is used
+	EventTypeProvider
+	// EventTypeUsage carries the Usage reported by the provider, sent right before EventTypeEnd or EventTypeToolCalls
+	EventTypeUsage
+	// EventTypeReasoning carries a chunk of the reasoning of the model as a string, streamed before the answer
+	EventTypeReasoning
+	// EventTypeReasoningBlocks carries the []ReasoningBlock of a response with tool calls, for providers that need
+	// them sent back along with the tool results
+	EventTypeReasoningBlocks
+	// EventTypeToolStep carries a ToolStep whose tool calls ran automatically, sent before the response continues
+	// with the next step
+	EventTypeToolStep
 )
 
+// ReasoningBlock is a complete block of reasoning as returned by the provider. The signature lets the provider
+// verify the reasoning it is sent back, redacted blocks only have Data.
+type ReasoningBlock struct {
+	Text      string `json:"text,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+// Usage is the token usage of a single request as reported by the provider.
+type Usage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+	// CachedTokens is the part of InputTokens served from the provider's prompt cache
+	CachedTokens int64 `json:"cached_tokens"`
+	// FinishReason is the provider specific reason the generation stopped, such as "stop", "length" or "tool_calls"
+	FinishReason string `json:"finish_reason"`
+}
+
 // TextStreamEvent represents an event in the text stream
 type TextStreamEvent struct {
 	Type  EventType
@@ -0,0 +1,311 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// DefaultMaxToolSteps is the number of completions a response can chain by running tools automatically before
+// the tool calls are handed to the user.
+const DefaultMaxToolSteps = 10
+
+// Results sent to the model for the tool calls that didn't run
+const (
+	ToolCallFailedResult = "Tool call failed"
+	ToolCallDeniedResult = "Tool call denied by policy"
+)
+
+// MaxToolArgumentRetries is the number of tool calls with invalid arguments a response can make. The problems
+// are sent back to the model so it can correct the call, after that such calls fail with ToolCallFailedResult.
+const MaxToolArgumentRetries = 3
+
+// toolArgumentsResultPrefix starts the result of a tool call with invalid arguments
+const toolArgumentsResultPrefix = "Invalid arguments for tool "
+
+// ToolStepSeparator is streamed between the text of two steps of a response.
+const ToolStepSeparator = "\n\n"
+
+// ToolLoopLimits bound the steps a response makes on its own. A step is one completion, the steps made before
+// the user approved tool calls of the response count as well.
+type ToolLoopLimits struct {
+	// MaxSteps is the number of completions after which tool calls are handed to the user, DefaultMaxToolSteps when 0
+	MaxSteps int
+	// TokenBudget is the number of input and output tokens after which tool calls are handed to the user, 0 for no limit
+	TokenBudget int64
+}
+
+// ToolLoopProgress is what a response already used of its ToolLoopLimits, for requests continuing a response
+// after the user approved its tool calls.
+type ToolLoopProgress struct {
+	Steps  int
+	Tokens int64
+	// ArgumentErrors is the number of tool calls with invalid arguments sent back to the model
+	ArgumentErrors int
+}
+
+// NewToolLoopProgress returns the progress of a response that made steps and used tokens.
+func NewToolLoopProgress(steps []ToolStep, tokens int64) ToolLoopProgress {
+	progress := ToolLoopProgress{Steps: len(steps), Tokens: tokens}
+	for _, step := range steps {
+		for _, toolCall := range step.ToolCalls {
+			if IsToolArgumentsResult(toolCall.Result) {
+				progress.ArgumentErrors++
+			}
+		}
+	}
+	return progress
+}
+
+// ToolStep is a completion of a response whose tool calls ran before the response continued.
+type ToolStep struct {
+	Message   string           `json:"message"`
+	ToolCalls []ToolCall       `json:"tool_calls"`
+	Reasoning []ReasoningBlock `json:"reasoning,omitempty"`
+}
+
+// ToolStepPosts returns the posts replaying the steps of a response followed by its last step, message being
+// the whole text of the response as it was streamed.
+func ToolStepPosts(steps []ToolStep, message string) []Post {
+	posts := make([]Post, 0, len(steps)+1)
+	for _, step := range steps {
+		posts = append(posts, Post{
+			Role:      PostRoleBot,
+			Message:   step.Message,
+			ToolUse:   step.ToolCalls,
+			Reasoning: step.Reasoning,
+		})
+		if strings.TrimSpace(step.Message) != "" {
+			message = strings.TrimPrefix(message, step.Message+ToolStepSeparator)
+		}
+	}
+	return append(posts, Post{Role: PostRoleBot, Message: message})
+}
+
+// ToolLoopWrapper runs the tools called by the model when the policies of the request's tool store let them
+// run without approval, and sends the results back to the model. When a tool call needs approval, or the
+// limits are reached, the calls are passed on to the caller. Those that don't need approval are already
+// marked accepted or rejected.
+type ToolLoopWrapper struct {
+	wrapped LanguageModel
+	limits  ToolLoopLimits
+}
+
+// NewToolLoopWrapper returns a LanguageModelWrapper running the tools that don't need approval.
+func NewToolLoopWrapper(limits ToolLoopLimits) LanguageModelWrapper {
+	if limits.MaxSteps <= 0 {
+		limits.MaxSteps = DefaultMaxToolSteps
+	}
+	return func(wrapped LanguageModel) LanguageModel {
+		return &ToolLoopWrapper{
+			wrapped: wrapped,
+			limits:  limits,
+		}
+	}
+}
+
+func (w *ToolLoopWrapper) ChatCompletion(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error) {
+	result, err := w.wrapped.ChatCompletion(ctx, request, opts...)
+	if err != nil || request.Context == nil || request.Context.Tools == nil {
+		return result, err
+	}
+
+	output := make(chan TextStreamEvent)
+	go func() {
+		defer close(output)
+		w.run(ctx, request, result, output, opts)
+	}()
+
+	return &TextStreamResult{Stream: output}, nil
+}
+
+// run forwards the events of each step. The tool calls, the reasoning sent with them and the end of the
+// stream are held back until it is known whether the tools run automatically.
+func (w *ToolLoopWrapper) run(ctx context.Context, request CompletionRequest, result *TextStreamResult, output chan<- TextStreamEvent, opts []LanguageModelOption) {
+	progress := request.ToolLoop
+	for {
+		progress.Steps++
+		var text strings.Builder
+		var toolCalls []ToolCall
+		var held []TextStreamEvent
+		for event := range result.Stream {
+			switch event.Type {
+			case EventTypeText:
+				if chunk, ok := event.Value.(string); ok {
+					text.WriteString(chunk)
+				}
+			case EventTypeUsage:
+				if usage, ok := event.Value.(Usage); ok {
+					progress.Tokens += usage.InputTokens + usage.OutputTokens
+				}
+			case EventTypeToolCalls:
+				toolCalls, _ = event.Value.([]ToolCall)
+				held = append(held, event)
+				continue
+			case EventTypeReasoningBlocks:
+				held = append(held, event)
+				continue
+			case EventTypeEnd:
+				if len(toolCalls) > 0 {
+					held = append(held, event)
+					continue
+				}
+			}
+			output <- event
+		}
+
+		if len(toolCalls) == 0 {
+			return
+		}
+
+		automatic := w.applyPolicies(request.Context.Tools, toolCalls)
+		if automatic && w.limitReached(progress) {
+			// The user decides whether the response goes on
+			for i := range toolCalls {
+				if toolCalls[i].Status == ToolCallStatusAccepted {
+					toolCalls[i].Status = ToolCallStatusPending
+				}
+			}
+			automatic = false
+		}
+		if !automatic || ctx.Err() != nil {
+			for _, event := range held {
+				output <- event
+			}
+			return
+		}
+
+		var reasoning []ReasoningBlock
+		for _, event := range held {
+			if event.Type == EventTypeReasoningBlocks {
+				reasoning, _ = event.Value.([]ReasoningBlock)
+			}
+		}
+		ResolveToolCalls(request.Context, toolCalls, &progress)
+		step := ToolStep{
+			Message:   text.String(),
+			ToolCalls: toolCalls,
+			Reasoning: reasoning,
+		}
+		request.Posts = append(slices.Clip(request.Posts), Post{
+			Role:      PostRoleBot,
+			Message:   step.Message,
+			ToolUse:   step.ToolCalls,
+			Reasoning: step.Reasoning,
+		})
+		output <- TextStreamEvent{Type: EventTypeToolStep, Value: step}
+
+		if strings.TrimSpace(step.Message) != "" {
+			output <- TextStreamEvent{Type: EventTypeText, Value: ToolStepSeparator}
+		}
+
+		var err error
+		result, err = w.wrapped.ChatCompletion(ctx, request, opts...)
+		if err != nil {
+			output <- TextStreamEvent{Type: EventTypeError, Value: err}
+			return
+		}
+	}
+}
+
+// limitReached reports whether the response can't make another step on its own.
+func (w *ToolLoopWrapper) limitReached(progress ToolLoopProgress) bool {
+	if progress.Steps >= w.limits.MaxSteps {
+		return true
+	}
+	return w.limits.TokenBudget > 0 && progress.Tokens >= w.limits.TokenBudget
+}
+
+// applyPolicies marks the calls that run without approval as accepted and the denied ones as rejected. It
+// reports whether none of the calls need approval.
+func (w *ToolLoopWrapper) applyPolicies(tools *ToolStore, toolCalls []ToolCall) bool {
+	automatic := true
+	for i := range toolCalls {
+		switch tools.Policy(toolCalls[i].Name) {
+		case ToolPolicyAuto:
+			toolCalls[i].Status = ToolCallStatusAccepted
+		case ToolPolicyDeny:
+			toolCalls[i].Status = ToolCallStatusRejected
+			toolCalls[i].Result = ToolCallDeniedResult
+		default:
+			toolCalls[i].Status = ToolCallStatusPending
+			automatic = false
+		}
+	}
+	return automatic
+}
+
+// ResolveToolCalls runs the accepted tool calls. The calls with invalid arguments are sent back to the model
+// with the problems found until the response reaches MaxToolArgumentRetries.
+func ResolveToolCalls(llmContext *Context, toolCalls []ToolCall, progress *ToolLoopProgress) {
+	for i := range toolCalls {
+		if toolCalls[i].Status != ToolCallStatusAccepted {
+			continue
+		}
+		err := ResolveToolCall(llmContext, &toolCalls[i])
+		var argumentsErr *ToolArgumentsError
+		if errors.As(err, &argumentsErr) {
+			progress.ArgumentErrors++
+			if progress.ArgumentErrors > MaxToolArgumentRetries {
+				toolCalls[i].Result = ToolCallFailedResult
+			}
+		}
+	}
+}
+
+// ResolveToolCall runs a tool call with the tools of the context and records its result and status. When the
+// arguments are invalid the result describes the problems so the model can call the tool again.
+func ResolveToolCall(llmContext *Context, toolCall *ToolCall) error {
+	result, err := llmContext.Tools.ResolveTool(toolCall.Name, func(args any) error {
+		return json.Unmarshal(toolCall.Arguments, args)
+	}, llmContext)
+	var argumentsErr *ToolArgumentsError
+	switch {
+	case errors.As(err, &argumentsErr):
+		toolCall.Result = toolArgumentsResult(argumentsErr)
+		toolCall.Status = ToolCallStatusError
+	case err != nil:
+		toolCall.Result = ToolCallFailedResult
+		toolCall.Status = ToolCallStatusError
+	default:
+		toolCall.Result = result
+		toolCall.Status = ToolCallStatusSuccess
+	}
+	return err
+}
+
+func toolArgumentsResult(err *ToolArgumentsError) string {
+	var result strings.Builder
+	result.WriteString(toolArgumentsResultPrefix + err.Tool + ":\n")
+	for _, problem := range err.Problems {
+		result.WriteString("- " + problem + "\n")
+	}
+	result.WriteString("Correct the arguments and call the tool again.")
+	return result.String()
+}
+
+// IsToolArgumentsResult reports whether the result of a tool call asks the model to correct its arguments.
+func IsToolArgumentsResult(result string) bool {
+	return strings.HasPrefix(result, toolArgumentsResultPrefix)
+}
+
+func (w *ToolLoopWrapper) ChatCompletionNoStream(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (string, error) {
+	return w.wrapped.ChatCompletionNoStream(ctx, request, opts...)
+}
+
+func (w *ToolLoopWrapper) CountTokens(text string) int {
+	return w.wrapped.CountTokens(text)
+}
+
+func (w *ToolLoopWrapper) InputTokenLimit() int {
+	return w.wrapped.InputTokenLimit()
+}
+
+func (w *ToolLoopWrapper) Capabilities() Capabilities {
+	return w.wrapped.Capabilities()
+}
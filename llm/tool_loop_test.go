@@ -0,0 +1,275 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stepModel answers each completion with the next list of events and records the requests it receives.
+type stepModel struct {
+	scriptedModel
+	steps    [][]TextStreamEvent
+	requests []CompletionRequest
+}
+
+func (m *stepModel) ChatCompletion(ctx context.Context, request CompletionRequest, opts ...LanguageModelOption) (*TextStreamResult, error) {
+	m.requests = append(m.requests, request)
+	events := m.steps[min(len(m.requests), len(m.steps))-1]
+	stream := make(chan TextStreamEvent)
+	go func() {
+		defer close(stream)
+		for _, event := range events {
+			stream <- event
+		}
+	}()
+	return &TextStreamResult{Stream: stream}, nil
+}
+
+func toolCallEvents(text string, calls ...ToolCall) []TextStreamEvent {
+	return []TextStreamEvent{
+		{Type: EventTypeText, Value: text},
+		{Type: EventTypeToolCalls, Value: calls},
+		{Type: EventTypeEnd},
+	}
+}
+
+func newTestToolStore(rules []ToolPolicyRule) (*ToolStore, *[]string) {
+	var resolved []string
+	resolver := func(name string) ToolResolver {
+		return func(context *Context, argsGetter ToolArgumentGetter) (string, error) {
+			resolved = append(resolved, name)
+			return name + " result", nil
+		}
+	}
+	store := NewToolStore(nil, false)
+	store.AddTools([]Tool{
+		{Name: "LookupUser", Resolver: resolver("LookupUser"), ReadOnly: true},
+		{Name: "Search", Resolver: resolver("Search"), ReadOnly: true, ChannelSafe: true},
+		{Name: "CreateIssue", Resolver: resolver("CreateIssue")},
+		{Name: "DeleteRepo", Resolver: resolver("DeleteRepo"), Server: "github"},
+	})
+	store.SetPolicies(rules)
+	return store, &resolved
+}
+
+func TestToolPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     []ToolPolicyRule
+		inChannel bool
+		tool      string
+		want      ToolPolicy
+	}{
+		{name: "read only tools run automatically", tool: "LookupUser", want: ToolPolicyAuto},
+		{name: "read only tools need approval in channels", tool: "LookupUser", inChannel: true, want: ToolPolicyAsk},
+		{name: "channel safe tools run automatically in channels", tool: "Search", inChannel: true, want: ToolPolicyAuto},
+		{
+			name:      "rules apply in channels",
+			rules:     []ToolPolicyRule{{Tool: "LookupUser", Policy: ToolPolicyAuto}},
+			inChannel: true,
+			tool:      "LookupUser",
+			want:      ToolPolicyAuto,
+		},
+		{name: "other tools need approval", tool: "CreateIssue", want: ToolPolicyAsk},
+		{name: "unknown tools are denied", tool: "Unknown", want: ToolPolicyDeny},
+		{
+			name:  "rule for the tool",
+			rules: []ToolPolicyRule{{Tool: "CreateIssue", Policy: ToolPolicyAuto}},
+			tool:  "CreateIssue",
+			want:  ToolPolicyAuto,
+		},
+		{
+			name:  "rule for the server",
+			rules: []ToolPolicyRule{{Server: "github", Policy: ToolPolicyDeny}},
+			tool:  "DeleteRepo",
+			want:  ToolPolicyDeny,
+		},
+		{
+			name:  "first matching rule applies",
+			rules: []ToolPolicyRule{{Tool: "LookupUser", Policy: ToolPolicyAsk}, {Policy: ToolPolicyAuto}},
+			tool:  "LookupUser",
+			want:  ToolPolicyAsk,
+		},
+		{
+			name:  "rule without tool or server sets the default",
+			rules: []ToolPolicyRule{{Policy: ToolPolicyAuto}},
+			tool:  "DeleteRepo",
+			want:  ToolPolicyAuto,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newTestToolStore(tt.rules)
+			store.SetInChannel(tt.inChannel)
+			assert.Equal(t, tt.want, store.Policy(tt.tool))
+		})
+	}
+
+	t.Run("denied tools are not offered or resolved", func(t *testing.T) {
+		store, resolved := newTestToolStore([]ToolPolicyRule{{Server: "github", Policy: ToolPolicyDeny}})
+		for _, tool := range store.GetTools() {
+			assert.NotEqual(t, "DeleteRepo", tool.Name)
+		}
+		_, err := store.ResolveTool("DeleteRepo", func(any) error { return nil }, NewContext())
+		assert.Error(t, err)
+		assert.Empty(t, *resolved)
+	})
+}
+
+func TestToolLoopWrapper(t *testing.T) {
+	lookup := ToolCall{ID: "1", Name: "LookupUser", Arguments: json.RawMessage(`{}`)}
+	create := ToolCall{ID: "2", Name: "CreateIssue", Arguments: json.RawMessage(`{}`)}
+
+	var steps []ToolStep
+	collectAll := func(t *testing.T, result *TextStreamResult) (string, []ToolCall) {
+		t.Helper()
+		text := ""
+		var toolCalls []ToolCall
+		steps = nil
+		for event := range result.Stream {
+			switch event.Type {
+			case EventTypeText:
+				text += event.Value.(string)
+			case EventTypeToolCalls:
+				toolCalls = event.Value.([]ToolCall)
+			case EventTypeToolStep:
+				steps = append(steps, event.Value.(ToolStep))
+			case EventTypeError:
+				require.NoError(t, event.Value.(error))
+			}
+		}
+		return text, toolCalls
+	}
+
+	t.Run("tools that don't need approval run and the results are sent back", func(t *testing.T) {
+		store, resolved := newTestToolStore(nil)
+		model := &stepModel{steps: [][]TextStreamEvent{
+			toolCallEvents("Looking it up.", lookup),
+			textEvents("Found them."),
+		}}
+		wrapped := NewToolLoopWrapper(ToolLoopLimits{})(model)
+
+		llmContext := NewContext()
+		llmContext.Tools = store
+		result, err := wrapped.ChatCompletion(context.Background(), CompletionRequest{Posts: []Post{{Role: PostRoleUser, Message: "who is bob?"}}, Context: llmContext})
+		require.NoError(t, err)
+		text, toolCalls := collectAll(t, result)
+
+		assert.Equal(t, "Looking it up.\n\nFound them.", text)
+		assert.Empty(t, toolCalls)
+		assert.Equal(t, []string{"LookupUser"}, *resolved)
+		require.Len(t, model.requests, 2)
+		require.Len(t, model.requests[1].Posts, 2)
+		followUp := model.requests[1].Posts[1]
+		assert.Equal(t, PostRoleBot, followUp.Role)
+		assert.Equal(t, "Looking it up.", followUp.Message)
+		require.Len(t, followUp.ToolUse, 1)
+		assert.Equal(t, "LookupUser result", followUp.ToolUse[0].Result)
+		assert.Equal(t, ToolCallStatusSuccess, followUp.ToolUse[0].Status)
+		assert.Len(t, model.requests[0].Posts, 1, "the request of the caller is not modified")
+
+		require.Len(t, steps, 1)
+		assert.Equal(t, "Looking it up.", steps[0].Message)
+		assert.Equal(t, followUp.ToolUse, steps[0].ToolCalls)
+	})
+
+	t.Run("tool calls needing approval are passed on", func(t *testing.T) {
+		store, resolved := newTestToolStore([]ToolPolicyRule{{Tool: "DeleteRepo", Policy: ToolPolicyDeny}})
+		model := &stepModel{steps: [][]TextStreamEvent{
+			toolCallEvents("", lookup, create, ToolCall{ID: "3", Name: "DeleteRepo"}),
+		}}
+		wrapped := NewToolLoopWrapper(ToolLoopLimits{})(model)
+
+		llmContext := NewContext()
+		llmContext.Tools = store
+		result, err := wrapped.ChatCompletion(context.Background(), CompletionRequest{Context: llmContext})
+		require.NoError(t, err)
+		_, toolCalls := collectAll(t, result)
+
+		require.Len(t, toolCalls, 3)
+		assert.Equal(t, ToolCallStatusAccepted, toolCalls[0].Status)
+		assert.Equal(t, ToolCallStatusPending, toolCalls[1].Status)
+		assert.Equal(t, ToolCallStatusRejected, toolCalls[2].Status)
+		assert.Equal(t, ToolCallDeniedResult, toolCalls[2].Result)
+		assert.Empty(t, *resolved)
+		assert.Len(t, model.requests, 1)
+	})
+
+	t.Run("tool calls are passed on after the maximum number of steps", func(t *testing.T) {
+		store, resolved := newTestToolStore(nil)
+		model := &stepModel{steps: [][]TextStreamEvent{
+			toolCallEvents("", lookup),
+		}}
+		wrapped := NewToolLoopWrapper(ToolLoopLimits{MaxSteps: 3})(model)
+
+		llmContext := NewContext()
+		llmContext.Tools = store
+		result, err := wrapped.ChatCompletion(context.Background(), CompletionRequest{Context: llmContext})
+		require.NoError(t, err)
+		_, toolCalls := collectAll(t, result)
+
+		require.Len(t, toolCalls, 1)
+		assert.Equal(t, ToolCallStatusPending, toolCalls[0].Status, "the user decides whether the response goes on")
+		assert.Len(t, model.requests, 3)
+		assert.Len(t, *resolved, 2)
+		assert.Len(t, steps, 2)
+	})
+
+	t.Run("steps made before the request count toward the limit", func(t *testing.T) {
+		store, resolved := newTestToolStore(nil)
+		model := &stepModel{steps: [][]TextStreamEvent{
+			toolCallEvents("", lookup),
+		}}
+		wrapped := NewToolLoopWrapper(ToolLoopLimits{MaxSteps: 3})(model)
+
+		llmContext := NewContext()
+		llmContext.Tools = store
+		result, err := wrapped.ChatCompletion(context.Background(), CompletionRequest{Context: llmContext, ToolLoop: ToolLoopProgress{Steps: 2}})
+		require.NoError(t, err)
+		_, toolCalls := collectAll(t, result)
+
+		assert.Len(t, toolCalls, 1)
+		assert.Len(t, model.requests, 1)
+		assert.Empty(t, *resolved)
+	})
+
+	t.Run("tool calls are passed on once the token budget is used", func(t *testing.T) {
+		store, resolved := newTestToolStore(nil)
+		events := append([]TextStreamEvent{{Type: EventTypeUsage, Value: Usage{InputTokens: 400, OutputTokens: 100}}}, toolCallEvents("", lookup)...)
+		model := &stepModel{steps: [][]TextStreamEvent{events}}
+		wrapped := NewToolLoopWrapper(ToolLoopLimits{TokenBudget: 1200})(model)
+
+		llmContext := NewContext()
+		llmContext.Tools = store
+		result, err := wrapped.ChatCompletion(context.Background(), CompletionRequest{Context: llmContext, ToolLoop: ToolLoopProgress{Tokens: 300}})
+		require.NoError(t, err)
+		_, toolCalls := collectAll(t, result)
+
+		assert.Len(t, toolCalls, 1)
+		assert.Len(t, model.requests, 2)
+		assert.Len(t, *resolved, 1)
+	})
+}
+
+func TestToolStepPosts(t *testing.T) {
+	call := ToolCall{ID: "1", Name: "LookupUser", Result: "bob", Status: ToolCallStatusSuccess}
+	steps := []ToolStep{
+		{Message: "Looking it up.", ToolCalls: []ToolCall{call}},
+		{ToolCalls: []ToolCall{call}},
+	}
+
+	posts := ToolStepPosts(steps, "Looking it up.\n\nBob is an engineer.")
+	require.Len(t, posts, 3)
+	assert.Equal(t, "Looking it up.", posts[0].Message)
+	assert.Equal(t, []ToolCall{call}, posts[0].ToolUse)
+	assert.Equal(t, "", posts[1].Message)
+	assert.Equal(t, []ToolCall{call}, posts[1].ToolUse)
+	assert.Equal(t, Post{Role: PostRoleBot, Message: "Bob is an engineer."}, posts[2])
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+// ToolPolicy controls what happens when the model calls a tool.
+type ToolPolicy string
+
+const (
+	// ToolPolicyAsk shows the tool call to the user who has to accept it before it runs
+	ToolPolicyAsk ToolPolicy = "ask"
+	// ToolPolicyAuto runs the tool without asking the user
+	ToolPolicyAuto ToolPolicy = "auto"
+	// ToolPolicyDeny never runs the tool, it isn't offered to the model
+	ToolPolicyDeny ToolPolicy = "deny"
+)
+
+// ToolPolicyRule sets the policy of the tools matching it. An empty Tool matches every tool and an empty
+// Server matches every source, so a rule with neither sets the default of the bot.
+type ToolPolicyRule struct {
+	// Tool is the name of the tool
+	Tool string `json:"tool"`
+	// Server is the name of the MCP server providing the tool
+	Server string     `json:"server"`
+	Policy ToolPolicy `json:"policy"`
+}
+
+func (r ToolPolicyRule) matches(tool Tool) bool {
+	return (r.Tool == "" || r.Tool == tool.Name) && (r.Server == "" || r.Server == tool.Server)
+}
+
+// IsValid checks that the rule has a known policy
+func (r ToolPolicyRule) IsValid() bool {
+	switch r.Policy {
+	case ToolPolicyAsk, ToolPolicyAuto, ToolPolicyDeny:
+		return true
+	}
+	return false
+}
+
+// policyFor returns the policy of the first rule matching the tool. Read only tools run automatically and
+// the others need approval when no rule matches. In a channel only the read only tools that are ChannelSafe
+// run automatically, the others could share what only the requester can read.
+func policyFor(rules []ToolPolicyRule, tool Tool, inChannel bool) ToolPolicy {
+	for _, rule := range rules {
+		if rule.IsValid() && rule.matches(tool) {
+			return rule.Policy
+		}
+	}
+	if tool.ReadOnly && (!inChannel || tool.ChannelSafe) {
+		return ToolPolicyAuto
+	}
+	return ToolPolicyAsk
+}
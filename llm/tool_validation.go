@@ -0,0 +1,161 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+const toolSchemaURL = "tool-schema.json"
+
+var validationPrinter = message.NewPrinter(language.English)
+
+// ToolArgumentsError is returned when the arguments of a tool call don't match the tool's schema.
+type ToolArgumentsError struct {
+	Tool     string
+	Problems []string
+}
+
+func (e *ToolArgumentsError) Error() string {
+	return fmt.Sprintf("invalid arguments for tool %s: %s", e.Tool, strings.Join(e.Problems, "; "))
+}
+
+// validateToolArguments checks the raw arguments of a call to the tool against its schema. A *ToolArgumentsError
+// is returned when they don't match it, any other error means the schema itself couldn't be used.
+func validateToolArguments(tool Tool, raw json.RawMessage) error {
+	if tool.Schema == nil {
+		return nil
+	}
+
+	schemaJSON, err := json.Marshal(tool.Schema)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schema of tool %s: %w", tool.Name, err)
+	}
+	schemaDoc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schemaJSON))
+	if err != nil {
+		return fmt.Errorf("failed to read schema of tool %s: %w", tool.Name, err)
+	}
+
+	// Formats aren't asserted, like the compiler does by default for the latest drafts
+	compiler := jsonschema.NewCompiler()
+	compiler.UseRegexpEngine(compileECMARegexp)
+	if err = compiler.AddResource(toolSchemaURL, schemaDoc); err != nil {
+		return fmt.Errorf("failed to add schema of tool %s: %w", tool.Name, err)
+	}
+	schema, err := compiler.Compile(toolSchemaURL)
+	if err != nil {
+		return fmt.Errorf("failed to compile schema of tool %s: %w", tool.Name, err)
+	}
+
+	if len(bytes.TrimSpace(raw)) == 0 {
+		raw = json.RawMessage("{}")
+	}
+	args, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return &ToolArgumentsError{Tool: tool.Name, Problems: []string{"arguments are not valid JSON: " + err.Error()}}
+	}
+	matchPropertyNames(schemaDoc, args)
+
+	err = schema.Validate(args)
+	if err == nil {
+		return nil
+	}
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return fmt.Errorf("failed to validate arguments of tool %s: %w", tool.Name, err)
+	}
+	// The causes come in no particular order, sort them so the model sees the same message for the same call
+	problems := validationProblems(validationErr, nil)
+	slices.Sort(problems)
+	return &ToolArgumentsError{Tool: tool.Name, Problems: problems}
+}
+
+// matchPropertyNames renames the arguments that match a property of the schema only when ignoring case to the
+// name of the property, like encoding/json matches them when the tool reads its arguments.
+func matchPropertyNames(schemaDoc any, args any) {
+	schema, _ := schemaDoc.(map[string]any)
+	properties, _ := schema["properties"].(map[string]any)
+	object, _ := args.(map[string]any)
+	if len(properties) == 0 || len(object) == 0 {
+		return
+	}
+
+	for name, value := range object {
+		if _, ok := properties[name]; ok {
+			continue
+		}
+		for property := range properties {
+			if _, taken := object[property]; !taken && strings.EqualFold(property, name) {
+				delete(object, name)
+				object[property] = value
+				break
+			}
+		}
+	}
+}
+
+// validationProblems returns the problems of the error and its causes as the model reads them, each prefixed
+// with the path of the argument it is about. Errors that only group others are left out.
+func validationProblems(err *jsonschema.ValidationError, problems []string) []string {
+	if len(err.Causes) > 0 {
+		for _, cause := range err.Causes {
+			problems = validationProblems(cause, problems)
+		}
+		return problems
+	}
+	if _, ok := err.ErrorKind.(*kind.Group); ok {
+		return problems
+	}
+	return append(problems, argumentPath(err.InstanceLocation)+": "+err.ErrorKind.LocalizedString(validationPrinter))
+}
+
+// argumentPath formats the location of a value in the arguments, such as tags[1]
+func argumentPath(location []string) string {
+	if len(location) == 0 {
+		return "arguments"
+	}
+	var path strings.Builder
+	for _, token := range location {
+		if _, err := strconv.Atoi(token); err == nil {
+			path.WriteString("[" + token + "]")
+			continue
+		}
+		if path.Len() > 0 {
+			path.WriteString(".")
+		}
+		path.WriteString(token)
+	}
+	return path.String()
+}
+
+// ecmaRegexp matches patterns with the ECMA 262 syntax JSON schemas are written in
+type ecmaRegexp regexp2.Regexp
+
+func (re *ecmaRegexp) MatchString(s string) bool {
+	matched, err := (*regexp2.Regexp)(re).MatchString(s)
+	return err == nil && matched
+}
+
+func (re *ecmaRegexp) String() string {
+	return (*regexp2.Regexp)(re).String()
+}
+
+func compileECMARegexp(pattern string) (jsonschema.Regexp, error) {
+	re, err := regexp2.Compile(pattern, regexp2.ECMAScript)
+	if err != nil {
+		return nil, err
+	}
+	return (*ecmaRegexp)(re), nil
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/invopop/jsonschema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type validationTestArgs struct {
+	Term  string   `jsonschema:"minLength=3"`
+	Limit int      `json:"limit,omitempty" jsonschema:"minimum=1,maximum=50"`
+	Sort  string   `json:"sort,omitempty" jsonschema:"enum=newest,enum=relevance"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func TestValidateToolArguments(t *testing.T) {
+	tool := Tool{Name: "Search", Schema: NewJSONSchemaFromStruct(validationTestArgs{})}
+
+	var mcpSchema jsonschema.Schema
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {
+			"issue": {"type": "integer"},
+			"labels": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+		},
+		"required": ["issue"]
+	}`), &mcpSchema))
+	mcpTool := Tool{Name: "UpdateIssue", Schema: &mcpSchema}
+
+	tests := []struct {
+		name     string
+		tool     Tool
+		args     string
+		problems []string
+	}{
+		{name: "valid arguments", tool: tool, args: `{"Term": "roadmap", "limit": 10, "sort": "newest", "tags": ["a"]}`},
+		{name: "property names ignore case", tool: tool, args: `{"term": "roadmap"}`},
+		{name: "tools without schema accept anything", tool: Tool{Name: "Free"}, args: `{"anything": true}`},
+		{name: "missing required property", tool: tool, args: `{}`, problems: []string{"arguments: missing property 'Term'"}},
+		{name: "wrong type", tool: tool, args: `{"Term": 42}`, problems: []string{"Term: got number, want string"}},
+		{name: "string too short", tool: tool, args: `{"Term": "ab"}`, problems: []string{"Term: minLength: got 2, want 3"}},
+		{name: "number out of range", tool: tool, args: `{"Term": "roadmap", "limit": 100}`, problems: []string{"limit: maximum: got 100, want 50"}},
+		{name: "value not in enum", tool: tool, args: `{"Term": "roadmap", "sort": "oldest"}`, problems: []string{"sort: value must be one of 'newest', 'relevance'"}},
+		{name: "wrong item type", tool: tool, args: `{"Term": "roadmap", "tags": ["a", 1]}`, problems: []string{"tags[1]: got number, want string"}},
+		{
+			name:     "unknown property",
+			tool:     tool,
+			args:     `{"Term": "roadmap", "query": "x"}`,
+			problems: []string{"arguments: additional properties 'query' not allowed"},
+		},
+		{name: "not an object", tool: tool, args: `"roadmap"`, problems: []string{"arguments: got string, want object"}},
+		{name: "invalid JSON", tool: tool, args: `{"Term": `, problems: []string{"arguments are not valid JSON: unexpected EOF"}},
+		{name: "schema from an MCP server", tool: mcpTool, args: `{"issue": 12, "labels": ["bug"]}`},
+		{
+			name:     "several problems are reported together",
+			tool:     mcpTool,
+			args:     `{"issue": "12", "labels": ["a", "b", "c"]}`,
+			problems: []string{"issue: got string, want integer", "labels: maxItems: got 3, want 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateToolArguments(tt.tool, json.RawMessage(tt.args))
+			if tt.problems == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var argumentsErr *ToolArgumentsError
+			require.ErrorAs(t, err, &argumentsErr)
+			assert.Equal(t, tt.tool.Name, argumentsErr.Tool)
+			assert.Equal(t, tt.problems, argumentsErr.Problems)
+		})
+	}
+}
+
+type recordingLog struct {
+	errors []string
+}
+
+func (l *recordingLog) Info(message string, keyValuePairs ...any) {}
+
+func (l *recordingLog) Error(message string, keyValuePairs ...any) {
+	l.errors = append(l.errors, message)
+}
+
+func TestToolWithInvalidSchema(t *testing.T) {
+	var schema jsonschema.Schema
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {"name": {"type": "string", "pattern": "("}}
+	}`), &schema))
+	tool := Tool{
+		Name:   "Lookup",
+		Schema: &schema,
+		Resolver: func(*Context, ToolArgumentGetter) (string, error) {
+			return "found", nil
+		},
+		ReadOnly: true,
+	}
+
+	err := validateToolArguments(tool, json.RawMessage(`{"name": "x"}`))
+	require.Error(t, err)
+	var argumentsErr *ToolArgumentsError
+	assert.False(t, errors.As(err, &argumentsErr))
+
+	log := &recordingLog{}
+	store := NewToolStore(log, false)
+	store.AddTools([]Tool{tool})
+	result, err := store.ResolveTool("Lookup", func(args any) error {
+		return json.Unmarshal([]byte(`{"name": "x"}`), args)
+	}, NewContext())
+	require.NoError(t, err)
+	assert.Equal(t, "found", result)
+	assert.Len(t, log.errors, 1)
+}
+
+func TestToolArgumentsSelfCorrection(t *testing.T) {
+	newStore := func(resolved *[]string) *ToolStore {
+		store := NewToolStore(nil, false)
+		store.AddTools([]Tool{{
+			Name:   "Search",
+			Schema: NewJSONSchemaFromStruct(validationTestArgs{}),
+			Resolver: func(context *Context, argsGetter ToolArgumentGetter) (string, error) {
+				var args validationTestArgs
+				if err := argsGetter(&args); err != nil {
+					return "", err
+				}
+				*resolved = append(*resolved, args.Term)
+				return "found " + args.Term, nil
+			},
+			ReadOnly: true,
+		}})
+		return store
+	}
+	badCall := ToolCall{ID: "1", Name: "Search", Arguments: json.RawMessage(`{"query": "roadmap"}`)}
+	goodCall := ToolCall{ID: "2", Name: "Search", Arguments: json.RawMessage(`{"Term": "roadmap"}`)}
+
+	t.Run("the model gets the problems and calls the tool again", func(t *testing.T) {
+		var resolved []string
+		model := &stepModel{steps: [][]TextStreamEvent{
+			toolCallEvents("", badCall),
+			toolCallEvents("", goodCall),
+			textEvents("The roadmap is ready."),
+		}}
+		llmContext := NewContext()
+		llmContext.Tools = newStore(&resolved)
+
+		result, err := NewToolLoopWrapper(ToolLoopLimits{})(model).ChatCompletion(context.Background(), CompletionRequest{Context: llmContext})
+		require.NoError(t, err)
+		text, err := result.ReadAll()
+		require.NoError(t, err)
+
+		assert.Equal(t, "The roadmap is ready.", text)
+		assert.Equal(t, []string{"roadmap"}, resolved)
+		require.Len(t, model.requests, 3)
+		correction := model.requests[1].Posts[0].ToolUse[0]
+		assert.Equal(t, ToolCallStatusError, correction.Status)
+		assert.Equal(t, "Invalid arguments for tool Search:\n"+
+			"- arguments: additional properties 'query' not allowed\n"+
+			"- arguments: missing property 'Term'\n"+
+			"Correct the arguments and call the tool again.", correction.Result)
+	})
+
+	t.Run("calls fail once the retries are used", func(t *testing.T) {
+		var resolved []string
+		model := &stepModel{steps: [][]TextStreamEvent{
+			toolCallEvents("", badCall),
+			textEvents("I could not search."),
+		}}
+		llmContext := NewContext()
+		llmContext.Tools = newStore(&resolved)
+
+		request := CompletionRequest{Context: llmContext, ToolLoop: ToolLoopProgress{ArgumentErrors: MaxToolArgumentRetries}}
+		result, err := NewToolLoopWrapper(ToolLoopLimits{})(model).ChatCompletion(context.Background(), request)
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.NoError(t, err)
+
+		require.Len(t, model.requests, 2)
+		assert.Equal(t, ToolCallFailedResult, model.requests[1].Posts[0].ToolUse[0].Result)
+		assert.Empty(t, resolved)
+	})
+
+	t.Run("progress counts the corrections of earlier steps", func(t *testing.T) {
+		steps := []ToolStep{
+			{ToolCalls: []ToolCall{{Result: toolArgumentsResult(&ToolArgumentsError{Tool: "Search", Problems: []string{"Term: is required"}})}}},
+			{ToolCalls: []ToolCall{{Result: "found roadmap"}}},
+		}
+		assert.Equal(t, ToolLoopProgress{Steps: 2, Tokens: 100, ArgumentErrors: 1}, NewToolLoopProgress(steps, 100))
+	})
+}
@@ -24,6 +24,13 @@ type Tool struct {
 	Description string
 	Schema      *jsonschema.Schema
 	Resolver    ToolResolver
+	// Server is the name of the MCP server providing the tool, empty for built-in tools
+	Server string
+	// ReadOnly tools only read data the user has access to, they run without approval unless a policy says otherwise
+	ReadOnly bool
+	// ChannelSafe read only tools only return what every member of the channel can read, they also run without
+	// approval outside of direct messages
+	ChannelSafe bool
 }
 
 type ToolResolver func(context *Context, argsGetter ToolArgumentGetter) (string, error)
@@ -57,13 +64,16 @@ type ToolCall struct {
 type ToolArgumentGetter func(args any) error
 
 type ToolStore struct {
-	tools   map[string]Tool
-	log     TraceLog
-	doTrace bool
+	tools     map[string]Tool
+	policies  []ToolPolicyRule
+	inChannel bool
+	log       TraceLog
+	doTrace   bool
 }
 
 type TraceLog interface {
 	Info(message string, keyValuePairs ...any)
+	Error(message string, keyValuePairs ...any)
 }
 
 // NewJSONSchemaFromStruct creates a JSONSchema from a Go struct using reflection
@@ -99,20 +109,64 @@ func (s *ToolStore) AddTools(tools []Tool) {
 	}
 }
 
+// SetPolicies sets the rules deciding which tools run automatically, need approval or are denied. The first
+// matching rule applies.
+func (s *ToolStore) SetPolicies(rules []ToolPolicyRule) {
+	s.policies = rules
+}
+
+// SetInChannel marks the tools as used outside of a direct message, where their results can be shared with
+// every member of the channel. There only the ChannelSafe tools run without approval when no rule matches.
+func (s *ToolStore) SetInChannel(inChannel bool) {
+	s.inChannel = inChannel
+}
+
+// Policy returns the policy of a tool. Unknown tools are denied.
+func (s *ToolStore) Policy(name string) ToolPolicy {
+	tool, ok := s.tools[name]
+	if !ok {
+		return ToolPolicyDeny
+	}
+	return policyFor(s.policies, tool, s.inChannel)
+}
+
+// ResolveTool runs a tool. The arguments are checked against the tool's schema first, a *ToolArgumentsError
+// is returned when they don't match it. Tools whose schema can't be used for the check are logged and run anyway.
 func (s *ToolStore) ResolveTool(name string, argsGetter ToolArgumentGetter, context *Context) (string, error) {
 	tool, ok := s.tools[name]
 	if !ok {
 		s.TraceUnknown(name, argsGetter)
 		return "", errors.New("unknown tool " + name)
 	}
+	if policyFor(s.policies, tool, s.inChannel) == ToolPolicyDeny {
+		return "", errors.New("tool " + name + " is not allowed")
+	}
+	var raw json.RawMessage
+	if err := argsGetter(&raw); err != nil {
+		return "", &ToolArgumentsError{Tool: name, Problems: []string{"arguments are not valid JSON: " + err.Error()}}
+	}
+	if err := validateToolArguments(tool, raw); err != nil {
+		var argumentsErr *ToolArgumentsError
+		if errors.As(err, &argumentsErr) {
+			s.TraceResolved(name, argsGetter, err.Error())
+			return "", err
+		}
+		if s.log != nil {
+			s.log.Error("failed to validate tool arguments, running the tool without validation", "name", name, "error", err)
+		}
+	}
 	results, err := tool.Resolver(context, argsGetter)
 	s.TraceResolved(name, argsGetter, results)
 	return results, err
 }
 
+// GetTools returns the tools offered to the model, denied tools are left out.
 func (s *ToolStore) GetTools() []Tool {
 	result := make([]Tool, 0, len(s.tools))
 	for _, tool := range s.tools {
+		if policyFor(s.policies, tool, s.inChannel) == ToolPolicyDeny {
+			continue
+		}
 		result = append(result, tool)
 	}
 	return result
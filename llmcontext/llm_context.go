@@ -4,10 +4,16 @@
 package llmcontext
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/mattermost/mattermost-plugin-ai/bots"
+	"github.com/mattermost/mattermost-plugin-ai/i18n"
 	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/mcp"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 )
@@ -17,9 +23,10 @@ type ToolProvider interface {
 	GetTools(isDM bool, bot *bots.Bot) []llm.Tool
 }
 
-// MCPToolProvider provides MCP tools for a user
+// MCPToolProvider provides MCP tools and resources for a user
 type MCPToolProvider interface {
 	GetToolsForUser(userID string) ([]llm.Tool, error)
+	ReadResourceForUser(userID, serverID, uri string) (string, error)
 }
 
 // ConfigProvider provides configuration access
@@ -33,6 +40,7 @@ type Builder struct {
 	toolProvider    ToolProvider
 	mcpToolProvider MCPToolProvider
 	configProvider  ConfigProvider
+	i18n            *i18n.Bundle
 }
 
 // NewLLMContextBuilder creates a new LLM context builder
@@ -41,12 +49,14 @@ func NewLLMContextBuilder(
 	toolProvider ToolProvider,
 	mcpToolProvider MCPToolProvider,
 	configProvider ConfigProvider,
+	i18nBundle *i18n.Bundle,
 ) *Builder {
 	return &Builder{
 		pluginAPI:       pluginAPI,
 		toolProvider:    toolProvider,
 		mcpToolProvider: mcpToolProvider,
 		configProvider:  configProvider,
+		i18n:            i18nBundle,
 	}
 }
 
@@ -120,22 +130,34 @@ func (b *Builder) GetToolsStoreForUser(bot *bots.Bot, isDM bool, userID string)
 		return llm.NewNoTools()
 	}
 
-	// Check if tools are disabled for this bot
-	if bot.GetConfig().DisableTools {
+	// Check if tools are disabled for this bot or not supported by its LLM
+	if bot.GetConfig().DisableTools || !bot.LLM().Capabilities().Tools {
 		return llm.NewNoTools()
 	}
 
-	// Create a tool store that requires user approval for tool calls
+	// Create a tool store, the policies of the bot decide which tool calls need user approval
 	store := llm.NewToolStore(&b.pluginAPI.Log, b.configProvider.GetEnableLLMTrace())
+	store.SetPolicies(bot.GetConfig().ToolPolicies)
+	store.SetInChannel(!isDM)
 
 	// Add built-in tools
 	store.AddTools(b.toolProvider.GetTools(isDM, bot))
 
-	// Add MCP tools if available, enabled, and in a DM
-	if b.mcpToolProvider != nil && isDM {
+	// Add MCP tools if available and enabled, outside of DMs only those enabled for channels
+	if b.mcpToolProvider != nil && (isDM || len(bot.GetConfig().ChannelTools) > 0) {
 		mcpTools, err := b.mcpToolProvider.GetToolsForUser(userID)
+		var authErr *mcp.AuthorizationRequiredError
+		if errors.As(err, &authErr) {
+			// The tools of the servers the user already authorized are still available
+			b.sendAuthorizationLinks(bot, userID, authErr.Servers)
+			err = nil
+		}
 		if err != nil {
 			b.pluginAPI.Log.Error("Failed to get MCP tools for user", "userID", userID, "error", err)
+		} else if !isDM {
+			store.AddTools(slices.DeleteFunc(mcpTools, func(tool llm.Tool) bool {
+				return !slices.Contains(bot.GetConfig().ChannelTools, tool.Name)
+			}))
 		} else if len(mcpTools) > 0 {
 			store.AddTools(mcpTools)
 		}
@@ -144,6 +166,37 @@ func (b *Builder) GetToolsStoreForUser(bot *bots.Bot, isDM bool, userID string)
 	return store
 }
 
+// ReadMCPResource returns the text of an MCP resource read as the given user
+func (b *Builder) ReadMCPResource(userID, serverID, uri string) (string, error) {
+	if b.mcpToolProvider == nil {
+		return "", errors.New("MCP is not available")
+	}
+	return b.mcpToolProvider.ReadResourceForUser(userID, serverID, uri)
+}
+
+// sendAuthorizationLinks sends the user a DM from the bot with the links to authorize MCP servers. The MCP
+// client manager only reports each link once, so the DM isn't sent again with every request.
+func (b *Builder) sendAuthorizationLinks(bot *bots.Bot, userID string, servers []mcp.ServerAuthorization) {
+	user, err := b.pluginAPI.User.Get(userID)
+	if err != nil {
+		b.pluginAPI.Log.Error("Failed to get user for MCP authorization links", "userID", userID, "error", err)
+		return
+	}
+
+	T := i18n.LocalizerFunc(b.i18n, user.Locale)
+	var message strings.Builder
+	message.WriteString(T("agents.mcp_authorization_required", "Some tools need access to your account on other services. Open these links to connect your account:"))
+	message.WriteString("\n")
+	for _, server := range servers {
+		message.WriteString(fmt.Sprintf("- [%s](%s)\n", server.ServerID, server.URL))
+	}
+
+	post := &model.Post{Message: message.String()}
+	if err := b.pluginAPI.Post.DM(bot.GetMMBot().UserId, userID, post); err != nil {
+		b.pluginAPI.Log.Error("Failed to send MCP authorization links", "userID", userID, "error", err)
+	}
+}
+
 // WithLLMContextDefaultTools adds default tools to the LLM context for the requesting user
 func (b *Builder) WithLLMContextDefaultTools(bot *bots.Bot, isDM bool) llm.ContextOption {
 	return func(c *llm.Context) {
@@ -164,6 +217,9 @@ func (b *Builder) WithLLMContextParameters(params map[string]interface{}) llm.Co
 
 func (b *Builder) WithLLMContextBot(bot *bots.Bot) llm.ContextOption {
 	return func(c *llm.Context) {
+		if mmBot := bot.GetMMBot(); mmBot != nil {
+			c.BotUserID = mmBot.UserId
+		}
 		c.BotName = bot.GetConfig().DisplayName
 		c.BotUsername = bot.GetConfig().Name
 		c.BotModel = bot.GetConfig().Service.DefaultModel
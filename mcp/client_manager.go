@@ -4,7 +4,12 @@
 package mcp
 
 import (
+	"context"
 	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,6 +21,9 @@ import (
 type ClientManager struct {
 	config        Config
 	log           pluginapi.LogService
+	oauth         *OAuthManager
+	httpClient    *http.Client
+	processes     *stdioProcesses
 	clientsMu     sync.RWMutex
 	clients       map[string]*UserClient // Map of userID to UserClient
 	cleanupTicker *time.Ticker
@@ -28,34 +36,41 @@ type Config struct {
 	Enabled            bool                    `json:"enabled"`
 	Servers            map[string]ServerConfig `json:"servers"`
 	IdleTimeoutMinutes int                     `json:"idleTimeoutMinutes"`
+	// EnableServer publishes the plugin's own MCP server to the users' MCP clients
+	EnableServer bool `json:"enableServer"`
 }
 
-// NewClientManager creates a new MCP client manager
-func NewClientManager(config Config, log pluginapi.LogService) *ClientManager {
+// NewClientManager creates a new MCP client manager. oauth may be nil, users are then not asked to authorize
+// the servers that require it. httpClient is used by the streamable HTTP transport and stdioCommands are the
+// executables local servers can run, see StdioCommandsEnv.
+func NewClientManager(config Config, log pluginapi.LogService, oauth *OAuthManager, httpClient *http.Client, stdioCommands []string) *ClientManager {
 	manager := &ClientManager{
-		log: log,
+		log:        log,
+		oauth:      oauth,
+		httpClient: httpClient,
+		processes:  newStdioProcesses(stdioCommands),
 	}
 	manager.ReInit(config)
 	return manager
 }
 
-// cleanupInactiveClients periodically checks for and closes inactive client connections
-func (m *ClientManager) cleanupInactiveClients() {
+// cleanupInactiveClients periodically checks for and closes inactive client connections.
+// Closing a client also stops the local server processes started for the user.
+func (m *ClientManager) cleanupInactiveClients(ticker *time.Ticker, closeChan chan struct{}) {
 	for {
 		select {
-		case <-m.cleanupTicker.C:
+		case <-ticker.C:
 			m.clientsMu.Lock()
-			now := time.Now()
 			for userID, client := range m.clients {
-				if now.Sub(client.lastActivity) > m.clientTimeout {
-					m.log.Debug("Closing inactive MCP client", "userID", userID, "idleTime", now.Sub(client.lastActivity))
+				if idleTime := client.idleTime(); idleTime > m.clientTimeout {
+					m.log.Debug("Closing inactive MCP client", "userID", userID, "idleTime", idleTime)
 					client.Close()
 					delete(m.clients, userID)
 				}
 			}
 			m.clientsMu.Unlock()
-		case <-m.closeChan:
-			m.cleanupTicker.Stop()
+		case <-closeChan:
+			ticker.Stop()
 			return
 		}
 	}
@@ -76,7 +91,7 @@ func (m *ClientManager) ReInit(config Config) {
 
 	// Start cleanup ticker to remove inactive clients
 	m.cleanupTicker = time.NewTicker(5 * time.Minute)
-	go m.cleanupInactiveClients()
+	go m.cleanupInactiveClients(m.cleanupTicker, m.closeChan)
 }
 
 // Close closes the client manager and all managed clients
@@ -111,21 +126,16 @@ func (m *ClientManager) createAndStoreUserClient(userID string) (*UserClient, er
 	// Check again in case another goroutine created the client while we were waiting for the lock
 	client, exists := m.clients[userID]
 	if exists {
-		client.lastActivity = time.Now()
+		client.touch()
 		return client, nil
 	}
 
 	// Create a new user client
-	userClient := &UserClient{
-		log:          m.log,
-		clients:      make(map[string]*ServerConnection),
-		toolDefs:     make(map[string]ToolDefinition),
-		lastActivity: time.Now(),
-		userID:       userID,
-	}
+	userClient := newUserClient(userID, m.log, m.oauth, m.httpClient, m.processes)
 
 	// Let user client connect to all servers
 	if err := userClient.ConnectToAllServers(m.config.Servers); err != nil {
+		userClient.Close()
 		return nil, fmt.Errorf("failed to initialize MCP client for user %s: %w", userID, err)
 	}
 
@@ -136,23 +146,32 @@ func (m *ClientManager) createAndStoreUserClient(userID string) (*UserClient, er
 
 // getClientForUser gets or creates an MCP client for a specific user
 func (m *ClientManager) getClientForUser(userID string) (*UserClient, error) {
-	m.clientsMu.RLock()
+	m.clientsMu.Lock()
 	client, exists := m.clients[userID]
-	m.clientsMu.RUnlock()
+	if exists && client.authorizationsExpired() {
+		// The authorization links expired, the client is recreated to connect the servers the user authorized
+		// since and to get new links for the others
+		client.Close()
+		delete(m.clients, userID)
+		exists = false
+	}
+	m.clientsMu.Unlock()
 	if exists {
-		client.lastActivity = time.Now()
+		client.touch()
 		return client, nil
 	}
 
-	newUserClient, err := m.createAndStoreUserClient(userID)
+	userClient, err := m.createAndStoreUserClient(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return newUserClient, nil
+	return userClient, nil
 }
 
-// GetToolsForUser returns the tools available for a specific user
+// GetToolsForUser returns the tools available for a specific user. When servers need the user to authorize
+// them first, the tools of the other servers are returned with an *AuthorizationRequiredError, once for each
+// authorization link until it expires or the user completes it.
 func (m *ClientManager) GetToolsForUser(userID string) ([]llm.Tool, error) {
 	// If not enabled or no servers configured return no tools
 	if !m.config.Enabled || len(m.config.Servers) == 0 {
@@ -165,6 +184,117 @@ func (m *ClientManager) GetToolsForUser(userID string) ([]llm.Tool, error) {
 		return nil, fmt.Errorf("failed to get MCP client for user %s: %w", userID, err)
 	}
 
+	var authErr error
+	if authorizations := userClient.takeAuthorizations(); len(authorizations) > 0 && m.oauth != nil {
+		if authorizations = m.oauth.claimAuthorizationLinks(userID, authorizations); len(authorizations) > 0 {
+			authErr = &AuthorizationRequiredError{Servers: authorizations}
+		}
+	}
+
 	// Return the user's tools
-	return userClient.GetTools(), nil
+	return userClient.GetTools(), authErr
+}
+
+// HandleOAuthCallback completes the authorization of an MCP server by a user and returns the ID of the
+// server. The client of the user is closed so the server is connected on the next request.
+func (m *ClientManager) HandleOAuthCallback(ctx context.Context, userID, state, code string) (string, error) {
+	if m.oauth == nil {
+		return "", fmt.Errorf("MCP OAuth is not available")
+	}
+
+	serverID, err := m.oauth.completeAuthorization(ctx, userID, state, code)
+	if err != nil {
+		return "", err
+	}
+
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+	if client, exists := m.clients[userID]; exists {
+		client.Close()
+		delete(m.clients, userID)
+	}
+	return serverID, nil
+}
+
+// ListResourcesForUser returns the resources available for a specific user
+func (m *ClientManager) ListResourcesForUser(userID string) ([]Resource, error) {
+	userClient, err := m.enabledClientForUser(userID)
+	if err != nil || userClient == nil {
+		return []Resource{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return userClient.ListResources(ctx), nil
+}
+
+// ReadResourceForUser returns the text of a resource read as a specific user
+func (m *ClientManager) ReadResourceForUser(userID, serverID, uri string) (string, error) {
+	userClient, err := m.enabledClientForUser(userID)
+	if err != nil {
+		return "", err
+	}
+	if userClient == nil {
+		return "", fmt.Errorf("MCP is not enabled")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return userClient.ReadResource(ctx, serverID, uri)
+}
+
+// ListPromptsForUser returns the prompts available for a specific user
+func (m *ClientManager) ListPromptsForUser(userID string) ([]Prompt, error) {
+	userClient, err := m.enabledClientForUser(userID)
+	if err != nil || userClient == nil {
+		return []Prompt{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return userClient.ListPrompts(ctx), nil
+}
+
+// GetPromptForUser returns the text of a prompt filled in with the given arguments for a specific user
+func (m *ClientManager) GetPromptForUser(userID, serverID, name string, arguments map[string]string) (string, error) {
+	userClient, err := m.enabledClientForUser(userID)
+	if err != nil {
+		return "", err
+	}
+	if userClient == nil {
+		return "", fmt.Errorf("MCP is not enabled")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return userClient.GetPrompt(ctx, serverID, name, arguments)
+}
+
+// enabledClientForUser gets or creates the client of a user, nil is returned when MCP is disabled
+func (m *ClientManager) enabledClientForUser(userID string) (*UserClient, error) {
+	if !m.config.Enabled || len(m.config.Servers) == 0 {
+		return nil, nil
+	}
+
+	userClient, err := m.getClientForUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MCP client for user %s: %w", userID, err)
+	}
+	return userClient, nil
+}
+
+// Status returns the state of the connections of the users with an open MCP client, sorted by user ID
+func (m *ClientManager) Status() []UserStatus {
+	m.clientsMu.RLock()
+	clients := slices.Collect(maps.Values(m.clients))
+	m.clientsMu.RUnlock()
+
+	statuses := make([]UserStatus, 0, len(clients))
+	for _, client := range clients {
+		statuses = append(statuses, client.Status())
+	}
+	slices.SortFunc(statuses, func(a, b UserStatus) int {
+		return strings.Compare(a.UserID, b.UserID)
+	})
+	return statuses
 }
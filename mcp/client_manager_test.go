@@ -0,0 +1,76 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logAPI is a plugin API which only discards the logs
+type logAPI struct {
+	plugin.API
+}
+
+func (logAPI) LogDebug(string, ...any) {}
+func (logAPI) LogInfo(string, ...any)  {}
+func (logAPI) LogWarn(string, ...any)  {}
+func (logAPI) LogError(string, ...any) {}
+
+func testLog() pluginapi.LogService {
+	return pluginapi.NewClient(logAPI{}, nil).Log
+}
+
+func TestClientManager(t *testing.T) {
+	t.Run("no tools are returned when MCP is disabled", func(t *testing.T) {
+		manager := NewClientManager(Config{Enabled: false, Servers: map[string]ServerConfig{"server": {BaseURL: "http://localhost"}}}, testLog(), nil, nil, nil)
+		defer manager.Close()
+
+		tools, err := manager.GetToolsForUser("user")
+		require.NoError(t, err)
+		assert.Empty(t, tools)
+		assert.Empty(t, manager.Status())
+	})
+
+	t.Run("each user gets a client", func(t *testing.T) {
+		server := httptest.NewServer(&testHTTPServer{})
+		defer server.Close()
+		manager := NewClientManager(Config{Enabled: true, Servers: map[string]ServerConfig{"server": {Transport: TransportStreamableHTTP, BaseURL: server.URL}}}, testLog(), nil, server.Client(), nil)
+		defer manager.Close()
+
+		tools, err := manager.GetToolsForUser("user1")
+		require.NoError(t, err)
+		require.Len(t, tools, 1)
+		assert.Equal(t, "server", tools[0].Server)
+
+		_, err = manager.GetToolsForUser("user2")
+		require.NoError(t, err)
+
+		statuses := manager.Status()
+		require.Len(t, statuses, 2)
+		assert.Equal(t, "user1", statuses[0].UserID)
+		assert.Equal(t, "user2", statuses[1].UserID)
+	})
+
+	t.Run("local servers need their command to be allowed", func(t *testing.T) {
+		serverConfig := testStdioServerConfig(t)
+		manager := NewClientManager(Config{Enabled: true, Servers: map[string]ServerConfig{"server": serverConfig}}, testLog(), nil, nil, nil)
+		defer manager.Close()
+
+		_, err := manager.GetToolsForUser("user")
+		assert.Error(t, err)
+
+		manager = NewClientManager(Config{Enabled: true, Servers: map[string]ServerConfig{"server": serverConfig}}, testLog(), nil, nil, []string{serverConfig.Command})
+		defer manager.Close()
+
+		tools, err := manager.GetToolsForUser("user")
+		require.NoError(t, err)
+		assert.Len(t, tools, 1)
+	})
+}
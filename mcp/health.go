@@ -0,0 +1,256 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Connection states of the servers of a user
+const (
+	ServerStateConnected             = "connected"
+	ServerStateReconnecting          = "reconnecting"
+	ServerStateAuthorizationRequired = "authorization_required"
+)
+
+const (
+	notificationToolsListChanged = "notifications/tools/list_changed"
+
+	// reconnectBaseDelay is the delay before the first attempt to reconnect a server, doubled after each
+	// failed attempt up to reconnectMaxDelay
+	reconnectBaseDelay = 5 * time.Second
+	reconnectMaxDelay  = 5 * time.Minute
+	reconnectTimeout   = time.Minute
+	// healthCheckInterval is how often the connected servers are pinged to find the lost connections
+	healthCheckInterval = time.Minute
+	healthCheckTimeout  = 10 * time.Second
+)
+
+// serverState is the health of the connection of a user to a server
+type serverState struct {
+	config         ServerConfig
+	state          string
+	lastError      string
+	lastErrorAt    time.Time
+	failures       int
+	nextAttempt    time.Time
+	reconnectTimer *time.Timer
+}
+
+// ServerStatus is the state of the connection of a user to an MCP server
+type ServerStatus struct {
+	ServerID  string `json:"serverID"`
+	State     string `json:"state"`
+	ToolCount int    `json:"toolCount"`
+	LastError string `json:"lastError,omitempty"`
+	// LastErrorAt, and NextAttemptAt while reconnecting, are in milliseconds since the epoch
+	LastErrorAt       int64 `json:"lastErrorAt,omitempty"`
+	ReconnectAttempts int   `json:"reconnectAttempts,omitempty"`
+	NextAttemptAt     int64 `json:"nextAttemptAt,omitempty"`
+}
+
+// UserStatus is the state of the connections of a user to the MCP servers
+type UserStatus struct {
+	UserID string `json:"userID"`
+	// LastActivity is in milliseconds since the epoch
+	LastActivity int64          `json:"lastActivity"`
+	Servers      []ServerStatus `json:"servers"`
+}
+
+// Status returns the state of the connections of the user
+func (c *UserClient) Status() UserStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := UserStatus{
+		UserID:       c.userID,
+		LastActivity: c.lastActivity.UnixMilli(),
+		Servers:      make([]ServerStatus, 0, len(c.servers)),
+	}
+	for _, serverID := range slices.Sorted(maps.Keys(c.servers)) {
+		server := c.servers[serverID]
+		serverStatus := ServerStatus{
+			ServerID:  serverID,
+			State:     server.state,
+			LastError: server.lastError,
+		}
+		if serverClient, exists := c.clients[serverID]; exists {
+			serverStatus.ToolCount = len(serverClient.tools)
+		}
+		if !server.lastErrorAt.IsZero() {
+			serverStatus.LastErrorAt = server.lastErrorAt.UnixMilli()
+		}
+		if server.state == ServerStateReconnecting {
+			serverStatus.ReconnectAttempts = server.failures
+			serverStatus.NextAttemptAt = server.nextAttempt.UnixMilli()
+		}
+		status.Servers = append(status.Servers, serverStatus)
+	}
+	return status
+}
+
+// addConnection makes the tools of a connected server available. c.mu must be held.
+func (c *UserClient) addConnection(serverID string, serverClient *ServerConnection) {
+	c.clients[serverID] = serverClient
+	if server, exists := c.servers[serverID]; exists {
+		server.state = ServerStateConnected
+		server.failures = 0
+		server.reconnectTimer = nil
+	}
+	c.rebuildToolDefs()
+}
+
+// rebuildToolDefs indexes the tools of the connected servers by name. When servers offer tools with the same
+// name the server with the last ID in alphabetical order wins. c.mu must be held.
+func (c *UserClient) rebuildToolDefs() {
+	c.toolDefs = make(map[string]ToolDefinition)
+	for _, serverID := range slices.Sorted(maps.Keys(c.clients)) {
+		for _, tool := range c.clients[serverID].tools {
+			if existingTool, exists := c.toolDefs[tool.Name]; exists {
+				c.log.Warn("Tool name conflict detected",
+					"userID", c.userID,
+					"tool", tool.Name,
+					"server1", existingTool.serverID,
+					"server2", serverID)
+			}
+			c.toolDefs[tool.Name] = ToolDefinition{
+				tool:     tool,
+				serverID: serverID,
+			}
+		}
+	}
+}
+
+// scheduleReconnect plans the next attempt to connect a server. c.mu must be held.
+func (c *UserClient) scheduleReconnect(serverID string, err error) {
+	server, exists := c.servers[serverID]
+	if !exists || c.closed {
+		return
+	}
+
+	server.state = ServerStateReconnecting
+	server.lastError = err.Error()
+	server.lastErrorAt = time.Now()
+	server.failures++
+
+	delay := reconnectMaxDelay
+	if shift := server.failures - 1; shift < 10 {
+		delay = min(reconnectBaseDelay<<shift, reconnectMaxDelay)
+	}
+	server.nextAttempt = time.Now().Add(delay)
+	server.reconnectTimer = time.AfterFunc(delay, func() {
+		c.reconnect(serverID)
+	})
+}
+
+// reconnect attempts to connect a server again, the next attempt is scheduled when it fails
+func (c *UserClient) reconnect(serverID string) {
+	c.mu.RLock()
+	server, exists := c.servers[serverID]
+	if !exists || c.closed || server.state != ServerStateReconnecting {
+		c.mu.RUnlock()
+		return
+	}
+	serverConfig := server.config
+	c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), reconnectTimeout)
+	defer cancel()
+	serverClient, err := c.connectToServer(ctx, serverID, serverConfig)
+
+	if statusErr, ok := unauthorized(err); ok && c.oauth != nil {
+		// The user has to authorize the server again, they get a new link with their next request
+		c.requireAuthorization(serverID, serverConfig, statusErr.Challenge)
+		c.mu.Lock()
+		c.authorizationsCreated = time.Now()
+		c.authorizationsDelivered = false
+		c.mu.Unlock()
+		return
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		if serverClient != nil {
+			serverClient.client.Close()
+		}
+		return
+	}
+	if err != nil {
+		c.log.Warn("Failed to reconnect to MCP server", "userID", c.userID, "serverID", serverID, "attempt", server.failures, "error", err)
+		c.scheduleReconnect(serverID, err)
+		c.mu.Unlock()
+		return
+	}
+	c.addConnection(serverID, serverClient)
+	c.mu.Unlock()
+
+	c.log.Info("Reconnected to MCP server", "userID", c.userID, "serverID", serverID)
+}
+
+// monitorConnections pings the connected servers until the client is closed
+func (c *UserClient) monitorConnections() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			for _, serverClient := range c.connections() {
+				c.checkConnection(serverClient.serverID, serverClient)
+			}
+		}
+	}
+}
+
+// checkConnection pings a server and reconnects it when the connection was lost
+func (c *UserClient) checkConnection(serverID string, serverClient *ServerConnection) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	err := serverClient.client.Ping(ctx)
+	if err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.closed || c.clients[serverID] != serverClient {
+		// The connection was already replaced or the client closed
+		c.mu.Unlock()
+		return
+	}
+	delete(c.clients, serverID)
+	c.rebuildToolDefs()
+	c.scheduleReconnect(serverID, err)
+	c.mu.Unlock()
+
+	c.log.Warn("Lost connection to MCP server, reconnecting", "userID", c.userID, "serverID", serverID, "error", err)
+	if closeErr := serverClient.client.Close(); closeErr != nil {
+		c.log.Debug("Failed to close lost MCP client", "userID", c.userID, "serverID", serverID, "error", closeErr)
+	}
+}
+
+// refreshTools lists the tools of a server again after it said they changed
+func (c *UserClient) refreshTools(serverID string, serverClient *ServerConnection) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	tools, err := listTools(ctx, serverClient.client)
+	if err != nil {
+		c.log.Error("Failed to refresh MCP tools", "userID", c.userID, "serverID", serverID, "error", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.clients[serverID] != serverClient {
+		return
+	}
+	serverClient.tools = tools
+	c.rebuildToolDefs()
+	c.log.Debug("Refreshed MCP tools", "userID", c.userID, "serverID", serverID, "tools", len(tools))
+}
@@ -0,0 +1,693 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+// OAuthCallbackPath is the path of the plugin route the authorization servers redirect users to
+const OAuthCallbackPath = "/mcp/oauth/callback"
+
+const (
+	// oauthFlowExpiry is how long users have to complete an authorization, no other link to authorize the same
+	// server is sent to them in the meantime
+	oauthFlowExpiry = time.Hour
+	// oauthRefreshMargin is how long before their expiry access tokens are refreshed
+	oauthRefreshMargin = time.Minute
+	oauthClientName    = "Mattermost Agents"
+
+	oauthEncryptionKeyKey = "mcp_oauth_encryption_key"
+	oauthClientKeyPrefix  = "mcp_oauth_client_"
+	oauthTokenKeyPrefix   = "mcp_oauth_token_"
+	oauthFlowKeyPrefix    = "mcp_oauth_flow_"
+	oauthLinkKeyPrefix    = "mcp_oauth_link_"
+)
+
+var resourceMetadataPattern = regexp.MustCompile(`resource_metadata="([^"]+)"`)
+
+// OAuthConfig contains the optional OAuth settings of an MCP server. The client is registered dynamically
+// with the authorization server when no client ID is configured.
+type OAuthConfig struct {
+	ClientID     string `json:"clientID,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	Scopes       string `json:"scopes,omitempty"`
+}
+
+// KVStore stores the OAuth clients, flows and tokens
+type KVStore interface {
+	KVGet(key string, value any) error
+	KVSet(key string, value any) error
+	KVSetWithOptions(key string, value any, options model.PluginKVSetOptions) (bool, error)
+}
+
+// ServerAuthorization is the link a user has to open to authorize access to an MCP server
+type ServerAuthorization struct {
+	ServerID string
+	URL      string
+}
+
+// AuthorizationRequiredError is returned along with the tools of a user when MCP servers need the user to
+// authorize access to their account first
+type AuthorizationRequiredError struct {
+	Servers []ServerAuthorization
+}
+
+func (e *AuthorizationRequiredError) Error() string {
+	serverIDs := make([]string, 0, len(e.Servers))
+	for _, server := range e.Servers {
+		serverIDs = append(serverIDs, server.ServerID)
+	}
+	return "authorization required for MCP servers: " + strings.Join(serverIDs, ", ")
+}
+
+// oauthServerMetadata is the metadata of an authorization server, as defined by RFC 8414
+type oauthServerMetadata struct {
+	Issuer                string `json:"issuer,omitempty"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	RegistrationEndpoint  string `json:"registration_endpoint,omitempty"`
+}
+
+// protectedResourceMetadata is the metadata of an MCP server, as defined by RFC 9728
+type protectedResourceMetadata struct {
+	AuthorizationServers []string `json:"authorization_servers"`
+	ScopesSupported      []string `json:"scopes_supported,omitempty"`
+}
+
+// oauthClient is the registration of the plugin with the authorization server of an MCP server
+type oauthClient struct {
+	ServerURL    string              `json:"server_url"`
+	RedirectURI  string              `json:"redirect_uri"`
+	Metadata     oauthServerMetadata `json:"metadata"`
+	ClientID     string              `json:"client_id"`
+	ClientSecret string              `json:"client_secret,omitempty"`
+	Scopes       string              `json:"scopes,omitempty"`
+}
+
+type oauthToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// oauthFlow is an authorization waiting for the user to come back from the authorization server
+type oauthFlow struct {
+	UserID   string    `json:"user_id"`
+	ServerID string    `json:"server_id"`
+	Verifier string    `json:"verifier"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+// OAuthManager runs the OAuth authorization of users on MCP servers, following the authorization
+// specification of MCP: the authorization server is discovered from the MCP server, the plugin registers
+// itself as a client and users authorize it with the authorization code flow and PKCE. The tokens of each user
+// are encrypted in the KV store and refreshed when they expire.
+type OAuthManager struct {
+	kvStore     KVStore
+	log         pluginapi.LogService
+	httpClient  *http.Client
+	callbackURL func() string
+
+	keyMu sync.Mutex
+	key   []byte
+	// refreshMu avoids refreshing a token twice at once on this server, refresh tokens can be single use. The
+	// other servers of a cluster can still refresh it at the same time, see refreshToken.
+	refreshMu sync.Mutex
+}
+
+// NewOAuthManager creates an OAuth manager. callbackURL returns the full URL of the OAuth callback route.
+func NewOAuthManager(kvStore KVStore, log pluginapi.LogService, httpClient *http.Client, callbackURL func() string) *OAuthManager {
+	return &OAuthManager{
+		kvStore:     kvStore,
+		log:         log,
+		httpClient:  httpClient,
+		callbackURL: callbackURL,
+	}
+}
+
+// serverAuthorization provides the OAuth access token of a user for a server
+type serverAuthorization struct {
+	oauth    *OAuthManager
+	userID   string
+	serverID string
+}
+
+func (a *serverAuthorization) accessToken(ctx context.Context) (string, error) {
+	return a.oauth.accessToken(ctx, a.userID, a.serverID)
+}
+
+// refresh refreshes the access token after the server rejected it
+func (a *serverAuthorization) refresh(ctx context.Context) (string, error) {
+	rejected, err := a.accessToken(ctx)
+	if err != nil || rejected == "" {
+		return "", err
+	}
+	return a.oauth.refreshToken(ctx, a.userID, a.serverID, rejected)
+}
+
+// accessToken returns the access token of the user for the server, refreshed when it is about to expire. An
+// empty token is returned when the user hasn't authorized the server or the token can't be refreshed.
+func (m *OAuthManager) accessToken(ctx context.Context, userID, serverID string) (string, error) {
+	var token oauthToken
+	found, err := m.getSealed(tokenKey(serverID, userID), &token)
+	if err != nil || !found {
+		return "", err
+	}
+
+	if !token.Expiry.IsZero() && time.Until(token.Expiry) < oauthRefreshMargin {
+		refreshed, err := m.refreshToken(ctx, userID, serverID, token.AccessToken)
+		if err != nil && time.Now().Before(token.Expiry) {
+			// The token works until it expires, the refresh is tried again on the next request
+			return token.AccessToken, nil
+		}
+		return refreshed, err
+	}
+	return token.AccessToken, nil
+}
+
+// refreshToken replaces the stale access token of the user with a new one obtained with the refresh token.
+// The tokens are removed when the authorization server rejects the refresh token, so the user is asked to
+// authorize the server again. Other errors are returned and the tokens are kept for the next attempt.
+func (m *OAuthManager) refreshToken(ctx context.Context, userID, serverID string, stale string) (string, error) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	var token oauthToken
+	found, err := m.getSealed(tokenKey(serverID, userID), &token)
+	if err != nil || !found {
+		return "", err
+	}
+	// Another request may have refreshed it while waiting for the lock
+	if token.AccessToken != stale {
+		return token.AccessToken, nil
+	}
+
+	var client oauthClient
+	clientFound, err := m.getSealed(clientKey(serverID), &client)
+	if err != nil {
+		return "", err
+	}
+	if !clientFound || token.RefreshToken == "" {
+		return "", m.deleteToken(userID, serverID)
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+		"resource":      {client.ServerURL},
+	}
+	refreshed, err := m.requestToken(ctx, &client, form)
+	if err != nil {
+		m.log.Warn("Failed to refresh MCP server access token", "userID", userID, "serverID", serverID, "error", err)
+		if oauthErrorCode(err) != "invalid_grant" {
+			return "", err
+		}
+		// Another server of the cluster may have used the refresh token first
+		var current oauthToken
+		if found, getErr := m.getSealed(tokenKey(serverID, userID), &current); getErr == nil && found && current.AccessToken != stale {
+			return current.AccessToken, nil
+		}
+		return "", m.deleteToken(userID, serverID)
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	if err := m.setSealed(tokenKey(serverID, userID), refreshed); err != nil {
+		return "", err
+	}
+	return refreshed.AccessToken, nil
+}
+
+// oauthErrorCode returns the error code of an error response of the authorization server, as defined by
+// RFC 6749
+func oauthErrorCode(err error) string {
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) {
+		return ""
+	}
+	var response struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal([]byte(statusErr.Body), &response) != nil {
+		return ""
+	}
+	return response.Error
+}
+
+func (m *OAuthManager) deleteToken(userID, serverID string) error {
+	return m.kvStore.KVSet(tokenKey(serverID, userID), nil)
+}
+
+// authorizationURL starts the authorization of the server by the user and returns the URL the user has to
+// open. challenge is the WWW-Authenticate header of the response rejecting the request.
+func (m *OAuthManager) authorizationURL(ctx context.Context, userID, serverID string, serverConfig ServerConfig, challenge string) (string, error) {
+	client, err := m.getClient(ctx, serverID, serverConfig, challenge)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	flow := oauthFlow{
+		UserID:   userID,
+		ServerID: serverID,
+		Verifier: verifier,
+		Expiry:   time.Now().Add(oauthFlowExpiry),
+	}
+	if err := m.setSealed(oauthFlowKeyPrefix+state, flow); err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(client.Metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	challengeHash := sha256.Sum256([]byte(verifier))
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", client.ClientID)
+	query.Set("redirect_uri", client.RedirectURI)
+	query.Set("state", state)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challengeHash[:]))
+	query.Set("code_challenge_method", "S256")
+	query.Set("resource", client.ServerURL)
+	if client.Scopes != "" {
+		query.Set("scope", client.Scopes)
+	}
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// completeAuthorization exchanges the code the authorization server returned for the tokens of the user and
+// returns the ID of the authorized server.
+func (m *OAuthManager) completeAuthorization(ctx context.Context, userID, state, code string) (string, error) {
+	var flow oauthFlow
+	found, err := m.getSealed(oauthFlowKeyPrefix+state, &flow)
+	if err != nil {
+		return "", err
+	}
+	if !found || flow.UserID != userID {
+		return "", errors.New("unknown authorization")
+	}
+	if err := m.kvStore.KVSet(oauthFlowKeyPrefix+state, nil); err != nil {
+		return "", fmt.Errorf("failed to delete authorization: %w", err)
+	}
+	if time.Now().After(flow.Expiry) {
+		return "", errors.New("authorization expired")
+	}
+
+	var client oauthClient
+	found, err = m.getSealed(clientKey(flow.ServerID), &client)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", errors.New("OAuth client no longer registered")
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {client.RedirectURI},
+		"code_verifier": {flow.Verifier},
+		"resource":      {client.ServerURL},
+	}
+	token, err := m.requestToken(ctx, &client, form)
+	if err != nil {
+		return "", err
+	}
+	if err := m.setSealed(tokenKey(flow.ServerID, userID), token); err != nil {
+		return "", err
+	}
+
+	// The user gets a new link as soon as the server needs to be authorized again
+	if err := m.kvStore.KVSet(linkKey(flow.ServerID, userID), nil); err != nil {
+		m.log.Warn("Failed to delete MCP authorization link marker", "userID", userID, "serverID", flow.ServerID, "error", err)
+	}
+	return flow.ServerID, nil
+}
+
+// claimAuthorizationLinks returns the authorizations whose links weren't sent to the user yet. A link is only
+// sent once until its authorization expires or the user completes it, whichever server of the cluster the user
+// talks to.
+func (m *OAuthManager) claimAuthorizationLinks(userID string, authorizations []ServerAuthorization) []ServerAuthorization {
+	claimed := make([]ServerAuthorization, 0, len(authorizations))
+	for _, authorization := range authorizations {
+		ok, err := m.kvStore.KVSetWithOptions(linkKey(authorization.ServerID, userID), true, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        nil,
+			ExpireInSeconds: int64(oauthFlowExpiry / time.Second),
+		})
+		if err != nil {
+			// Sending the link again is better than leaving the user without one
+			m.log.Error("Failed to record MCP authorization link", "userID", userID, "serverID", authorization.ServerID, "error", err)
+		} else if !ok {
+			continue
+		}
+		claimed = append(claimed, authorization)
+	}
+	return claimed
+}
+
+// getClient returns the client registered with the authorization server of the MCP server, registering it
+// first when the server or the settings changed since the last registration.
+func (m *OAuthManager) getClient(ctx context.Context, serverID string, serverConfig ServerConfig, challenge string) (*oauthClient, error) {
+	redirectURI := m.callbackURL()
+
+	var client oauthClient
+	found, err := m.getSealed(clientKey(serverID), &client)
+	if err != nil {
+		return nil, err
+	}
+	if found && client.ServerURL == serverConfig.BaseURL && client.RedirectURI == redirectURI &&
+		(serverConfig.OAuth.ClientID == "" || serverConfig.OAuth.ClientID == client.ClientID) {
+		return &client, nil
+	}
+
+	metadata, scopes, err := m.discover(ctx, serverConfig.BaseURL, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	client = oauthClient{
+		ServerURL:    serverConfig.BaseURL,
+		RedirectURI:  redirectURI,
+		Metadata:     *metadata,
+		ClientID:     serverConfig.OAuth.ClientID,
+		ClientSecret: serverConfig.OAuth.ClientSecret,
+		Scopes:       serverConfig.OAuth.Scopes,
+	}
+	if client.Scopes == "" {
+		client.Scopes = scopes
+	}
+	if client.ClientID == "" {
+		if err := m.register(ctx, &client); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.setSealed(clientKey(serverID), client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// discover finds the authorization server of an MCP server. The protected resource metadata of the server
+// points to its authorization server, older servers are their own authorization server and may not publish
+// metadata, in which case the default endpoints are used.
+func (m *OAuthManager) discover(ctx context.Context, serverURL string, challenge string) (*oauthServerMetadata, string, error) {
+	parsedURL, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid server URL: %w", err)
+	}
+	origin := parsedURL.Scheme + "://" + parsedURL.Host
+
+	resourceMetadataURL := origin + "/.well-known/oauth-protected-resource"
+	if match := resourceMetadataPattern.FindStringSubmatch(challenge); match != nil {
+		resourceMetadataURL = match[1]
+	}
+
+	issuer := origin
+	scopes := ""
+	var resource protectedResourceMetadata
+	if m.getJSON(ctx, resourceMetadataURL, &resource) == nil && len(resource.AuthorizationServers) > 0 {
+		issuer = strings.TrimSuffix(resource.AuthorizationServers[0], "/")
+		scopes = strings.Join(resource.ScopesSupported, " ")
+	}
+
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid authorization server: %w", err)
+	}
+	issuerOrigin := issuerURL.Scheme + "://" + issuerURL.Host
+	issuerPath := strings.TrimSuffix(issuerURL.Path, "/")
+	metadataURLs := []string{
+		issuerOrigin + "/.well-known/oauth-authorization-server" + issuerPath,
+		issuerOrigin + "/.well-known/openid-configuration" + issuerPath,
+	}
+	if issuerPath != "" {
+		metadataURLs = append(metadataURLs, issuer+"/.well-known/openid-configuration")
+	}
+	for _, metadataURL := range metadataURLs {
+		var metadata oauthServerMetadata
+		if m.getJSON(ctx, metadataURL, &metadata) == nil && metadata.AuthorizationEndpoint != "" && metadata.TokenEndpoint != "" {
+			return &metadata, scopes, nil
+		}
+	}
+
+	return &oauthServerMetadata{
+		Issuer:                issuerOrigin,
+		AuthorizationEndpoint: issuerOrigin + "/authorize",
+		TokenEndpoint:         issuerOrigin + "/token",
+		RegistrationEndpoint:  issuerOrigin + "/register",
+	}, scopes, nil
+}
+
+// register registers the plugin as a public client with the authorization server, as defined by RFC 7591
+func (m *OAuthManager) register(ctx context.Context, client *oauthClient) error {
+	if client.Metadata.RegistrationEndpoint == "" {
+		return errors.New("the authorization server does not support dynamic client registration, configure a client ID for the server")
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"client_name":                oauthClientName,
+		"redirect_uris":              []string{client.RedirectURI},
+		"grant_types":                []string{"authorization_code", "refresh_token"},
+		"response_types":             []string{"code"},
+		"token_endpoint_auth_method": "none",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal client registration: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Metadata.RegistrationEndpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("failed to create client registration request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	var registration struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+	if err := m.doJSON(req, &registration); err != nil {
+		return fmt.Errorf("failed to register OAuth client: %w", err)
+	}
+	if registration.ClientID == "" {
+		return errors.New("failed to register OAuth client: no client ID returned")
+	}
+	client.ClientID = registration.ClientID
+	client.ClientSecret = registration.ClientSecret
+	return nil
+}
+
+func (m *OAuthManager) requestToken(ctx context.Context, client *oauthClient, form url.Values) (*oauthToken, error) {
+	form.Set("client_id", client.ClientID)
+	if client.ClientSecret != "" {
+		form.Set("client_secret", client.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var response struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		ExpiresIn    int64  `json:"expires_in,omitempty"`
+	}
+	if err := m.doJSON(req, &response); err != nil {
+		return nil, fmt.Errorf("failed to get OAuth token: %w", err)
+	}
+	if response.AccessToken == "" {
+		return nil, errors.New("failed to get OAuth token: no access token returned")
+	}
+
+	token := &oauthToken{
+		AccessToken:  response.AccessToken,
+		RefreshToken: response.RefreshToken,
+	}
+	if response.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+func (m *OAuthManager) getJSON(ctx context.Context, url string, value any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return m.doJSON(req, value)
+}
+
+func (m *OAuthManager) doJSON(req *http.Request, value any) error {
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &httpStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return json.Unmarshal(body, value)
+}
+
+// getSealed reads and decrypts a value stored by setSealed, reporting whether it was found. Values that can't
+// be decrypted, for example after the key was lost, are treated as missing.
+func (m *OAuthManager) getSealed(key string, value any) (bool, error) {
+	var sealed []byte
+	if err := m.kvStore.KVGet(key, &sealed); err != nil {
+		return false, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	if len(sealed) == 0 {
+		return false, nil
+	}
+
+	gcm, err := m.cipher()
+	if err != nil {
+		return false, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return false, nil
+	}
+	data, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(key))
+	if err != nil {
+		m.log.Warn("Ignoring MCP OAuth value that can't be decrypted", "key", key)
+		return false, nil
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+// setSealed encrypts and stores a value. The key of the value is authenticated with it, so a value can't be
+// moved to another key.
+func (m *OAuthManager) setSealed(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	gcm, err := m.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if err := m.kvStore.KVSet(key, gcm.Seal(nonce, nonce, data, []byte(key))); err != nil {
+		return fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return nil
+}
+
+// cipher returns the AES-GCM cipher of the values, the key is generated the first time it is needed.
+func (m *OAuthManager) cipher() (cipher.AEAD, error) {
+	m.keyMu.Lock()
+	defer m.keyMu.Unlock()
+
+	if m.key == nil {
+		key, err := m.encryptionKey()
+		if err != nil {
+			return nil, err
+		}
+		m.key = key
+	}
+
+	block, err := aes.NewCipher(m.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptionKey returns the stored encryption key, generating it when there is none. The servers of a cluster
+// can generate one at the same time, only the first one is stored and the key is read back so they all use it.
+func (m *OAuthManager) encryptionKey() ([]byte, error) {
+	var key []byte
+	if err := m.kvStore.KVGet(oauthEncryptionKeyKey, &key); err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	if len(key) > 0 {
+		return key, nil
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	if _, err := m.kvStore.KVSetWithOptions(oauthEncryptionKeyKey, key, model.PluginKVSetOptions{Atomic: true, OldValue: nil}); err != nil {
+		return nil, fmt.Errorf("failed to save encryption key: %w", err)
+	}
+
+	key = nil
+	if err := m.kvStore.KVGet(oauthEncryptionKeyKey, &key); err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("failed to save encryption key")
+	}
+	return key, nil
+}
+
+func randomString() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// hashKey builds a KV key of bounded length from values of any length
+func hashKey(prefix string, parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return prefix + hex.EncodeToString(hash[:16])
+}
+
+func clientKey(serverID string) string {
+	return hashKey(oauthClientKeyPrefix, serverID)
+}
+
+func tokenKey(serverID, userID string) string {
+	return hashKey(oauthTokenKeyPrefix, serverID, userID)
+}
+
+func linkKey(serverID, userID string) string {
+	return hashKey(oauthLinkKeyPrefix, serverID, userID)
+}
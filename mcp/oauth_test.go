@@ -0,0 +1,448 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKVStore stores the values like the plugin KV store, byte slices as they are and the rest as JSON
+type memoryKVStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	// onGet is called before a value is read
+	onGet func(key string)
+}
+
+func newMemoryKVStore() *memoryKVStore {
+	return &memoryKVStore{values: make(map[string][]byte)}
+}
+
+func (s *memoryKVStore) KVGet(key string, value any) error {
+	if s.onGet != nil {
+		s.onGet(key)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.values[key]
+	if !ok {
+		return nil
+	}
+	if bytes, ok := value.(*[]byte); ok {
+		*bytes = data
+		return nil
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (s *memoryKVStore) KVSet(key string, value any) error {
+	_, err := s.KVSetWithOptions(key, value, model.PluginKVSetOptions{})
+	return err
+}
+
+func (s *memoryKVStore) KVSetWithOptions(key string, value any, options model.PluginKVSetOptions) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if options.Atomic {
+		if _, exists := s.values[key]; exists != (options.OldValue != nil) {
+			return false, nil
+		}
+	}
+	if value == nil {
+		delete(s.values, key)
+		return true, nil
+	}
+	data, ok := value.([]byte)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return false, err
+		}
+	}
+	s.values[key] = data
+	return true, nil
+}
+
+// testAuthServer is an MCP server which is its own authorization server
+type testAuthServer struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	challenge     string
+	refreshTokens map[string]bool
+	refreshStatus int
+	// onRefresh is called when a refresh token is used
+	onRefresh func()
+	issued    int
+}
+
+func newTestAuthServer(t *testing.T) *testAuthServer {
+	s := &testAuthServer{refreshTokens: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-protected-resource", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"authorization_servers":[%q],"scopes_supported":["read","write"]}`, s.URL)
+	})
+	mux.HandleFunc("/.well-known/oauth-authorization-server", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"issuer":%q,"authorization_endpoint":"%[1]s/authorize","token_endpoint":"%[1]s/token","registration_endpoint":"%[1]s/register"}`, s.URL)
+	})
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"client_id":"registered"}`)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Form.Get("client_id") != "registered" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			hash := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+			if r.Form.Get("code") != "code" || base64.RawURLEncoding.EncodeToString(hash[:]) != s.challenge {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+		case "refresh_token":
+			if s.onRefresh != nil {
+				s.onRefresh()
+			}
+			if s.refreshStatus != 0 {
+				http.Error(w, `{"error":"temporarily_unavailable"}`, s.refreshStatus)
+				return
+			}
+			if !s.refreshTokens[r.Form.Get("refresh_token")] {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			delete(s.refreshTokens, r.Form.Get("refresh_token"))
+		default:
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+
+		s.issued++
+		refreshToken := fmt.Sprintf("refresh-%d", s.issued)
+		s.refreshTokens[refreshToken] = true
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access-%d","refresh_token":%q,"expires_in":3600}`, s.issued, refreshToken)
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestOAuthManager(kvStore KVStore, server *testAuthServer) *OAuthManager {
+	return NewOAuthManager(kvStore, testLog(), server.Client(), func() string {
+		return "https://mattermost.example.com/plugins/mattermost-ai" + OAuthCallbackPath
+	})
+}
+
+// authorize starts the authorization of the user and returns the query of the authorization URL
+func authorize(t *testing.T, m *OAuthManager, server *testAuthServer, userID string) url.Values {
+	authURL, err := m.authorizationURL(context.Background(), userID, "server", ServerConfig{BaseURL: server.URL + "/mcp"}, "")
+	require.NoError(t, err)
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+
+	query := parsed.Query()
+	server.mu.Lock()
+	server.challenge = query.Get("code_challenge")
+	server.mu.Unlock()
+	return query
+}
+
+func TestOAuthAuthorization(t *testing.T) {
+	t.Run("the authorization uses PKCE", func(t *testing.T) {
+		server := newTestAuthServer(t)
+		m := newTestOAuthManager(newMemoryKVStore(), server)
+
+		query := authorize(t, m, server, "user")
+		assert.Equal(t, "code", query.Get("response_type"))
+		assert.Equal(t, "registered", query.Get("client_id"))
+		assert.Equal(t, "S256", query.Get("code_challenge_method"))
+		assert.NotEmpty(t, query.Get("code_challenge"))
+		assert.NotEmpty(t, query.Get("state"))
+		assert.Equal(t, server.URL+"/mcp", query.Get("resource"))
+		assert.Equal(t, "read write", query.Get("scope"))
+		assert.Equal(t, "https://mattermost.example.com/plugins/mattermost-ai"+OAuthCallbackPath, query.Get("redirect_uri"))
+
+		serverID, err := m.completeAuthorization(context.Background(), "user", query.Get("state"), "code")
+		require.NoError(t, err)
+		assert.Equal(t, "server", serverID)
+
+		token, err := m.accessToken(context.Background(), "user", "server")
+		require.NoError(t, err)
+		assert.Equal(t, "access-1", token)
+	})
+
+	t.Run("each authorization has its own verifier", func(t *testing.T) {
+		server := newTestAuthServer(t)
+		m := newTestOAuthManager(newMemoryKVStore(), server)
+
+		first := authorize(t, m, server, "user")
+		second := authorize(t, m, server, "user")
+		assert.NotEqual(t, first.Get("state"), second.Get("state"))
+		assert.NotEqual(t, first.Get("code_challenge"), second.Get("code_challenge"))
+
+		// The server expects the challenge of the second authorization
+		_, err := m.completeAuthorization(context.Background(), "user", first.Get("state"), "code")
+		assert.Error(t, err)
+		_, err = m.completeAuthorization(context.Background(), "user", second.Get("state"), "code")
+		assert.NoError(t, err)
+	})
+
+	t.Run("the state belongs to the user who started the authorization", func(t *testing.T) {
+		server := newTestAuthServer(t)
+		m := newTestOAuthManager(newMemoryKVStore(), server)
+		query := authorize(t, m, server, "user")
+
+		_, err := m.completeAuthorization(context.Background(), "attacker", query.Get("state"), "code")
+		assert.EqualError(t, err, "unknown authorization")
+		_, err = m.completeAuthorization(context.Background(), "user", "unknown", "code")
+		assert.EqualError(t, err, "unknown authorization")
+
+		token, err := m.accessToken(context.Background(), "attacker", "server")
+		require.NoError(t, err)
+		assert.Empty(t, token)
+	})
+
+	t.Run("a state can only be used once", func(t *testing.T) {
+		server := newTestAuthServer(t)
+		m := newTestOAuthManager(newMemoryKVStore(), server)
+		query := authorize(t, m, server, "user")
+
+		_, err := m.completeAuthorization(context.Background(), "user", query.Get("state"), "code")
+		require.NoError(t, err)
+		_, err = m.completeAuthorization(context.Background(), "user", query.Get("state"), "code")
+		assert.EqualError(t, err, "unknown authorization")
+	})
+
+	t.Run("expired authorizations are rejected", func(t *testing.T) {
+		server := newTestAuthServer(t)
+		kvStore := newMemoryKVStore()
+		m := newTestOAuthManager(kvStore, server)
+		query := authorize(t, m, server, "user")
+
+		var flow oauthFlow
+		found, err := m.getSealed(oauthFlowKeyPrefix+query.Get("state"), &flow)
+		require.NoError(t, err)
+		require.True(t, found)
+		flow.Expiry = time.Now().Add(-time.Minute)
+		require.NoError(t, m.setSealed(oauthFlowKeyPrefix+query.Get("state"), flow))
+
+		_, err = m.completeAuthorization(context.Background(), "user", query.Get("state"), "code")
+		assert.EqualError(t, err, "authorization expired")
+	})
+}
+
+func TestOAuthAuthorizationLinks(t *testing.T) {
+	authorizations := []ServerAuthorization{{ServerID: "server", URL: "https://auth.example.com/authorize"}}
+
+	t.Run("a link is only sent once", func(t *testing.T) {
+		kvStore := newMemoryKVStore()
+		m := newTestOAuthManager(kvStore, newTestAuthServer(t))
+
+		assert.Equal(t, authorizations, m.claimAuthorizationLinks("user", authorizations))
+		assert.Empty(t, m.claimAuthorizationLinks("user", authorizations))
+
+		// Another server of the cluster shares the KV store
+		other := newTestOAuthManager(kvStore, newTestAuthServer(t))
+		assert.Empty(t, other.claimAuthorizationLinks("user", authorizations))
+
+		// Other users get their own links
+		assert.Equal(t, authorizations, m.claimAuthorizationLinks("other", authorizations))
+	})
+
+	t.Run("the link marker expires with the authorization", func(t *testing.T) {
+		kvStore := &optionsKVStore{memoryKVStore: newMemoryKVStore()}
+		m := newTestOAuthManager(kvStore, newTestAuthServer(t))
+
+		m.claimAuthorizationLinks("user", authorizations)
+		assert.Equal(t, int64(oauthFlowExpiry/time.Second), kvStore.options.ExpireInSeconds)
+	})
+
+	t.Run("a new link is sent once the authorization is completed", func(t *testing.T) {
+		server := newTestAuthServer(t)
+		m := newTestOAuthManager(newMemoryKVStore(), server)
+
+		query := authorize(t, m, server, "user")
+		assert.Len(t, m.claimAuthorizationLinks("user", authorizations), 1)
+		_, err := m.completeAuthorization(context.Background(), "user", query.Get("state"), "code")
+		require.NoError(t, err)
+
+		assert.Len(t, m.claimAuthorizationLinks("user", authorizations), 1)
+	})
+}
+
+// optionsKVStore records the options of the last value set with options
+type optionsKVStore struct {
+	*memoryKVStore
+	options model.PluginKVSetOptions
+}
+
+func (s *optionsKVStore) KVSetWithOptions(key string, value any, options model.PluginKVSetOptions) (bool, error) {
+	s.options = options
+	return s.memoryKVStore.KVSetWithOptions(key, value, options)
+}
+
+func TestOAuthRefresh(t *testing.T) {
+	// setup authorizes the user and makes the access token expire
+	setup := func(t *testing.T) (*OAuthManager, *testAuthServer, *memoryKVStore) {
+		server := newTestAuthServer(t)
+		kvStore := newMemoryKVStore()
+		m := newTestOAuthManager(kvStore, server)
+		query := authorize(t, m, server, "user")
+		_, err := m.completeAuthorization(context.Background(), "user", query.Get("state"), "code")
+		require.NoError(t, err)
+
+		var token oauthToken
+		_, err = m.getSealed(tokenKey("server", "user"), &token)
+		require.NoError(t, err)
+		token.Expiry = time.Now().Add(-time.Minute)
+		require.NoError(t, m.setSealed(tokenKey("server", "user"), token))
+		return m, server, kvStore
+	}
+
+	t.Run("expired tokens are refreshed", func(t *testing.T) {
+		m, _, _ := setup(t)
+
+		token, err := m.accessToken(context.Background(), "user", "server")
+		require.NoError(t, err)
+		assert.Equal(t, "access-2", token)
+
+		var stored oauthToken
+		_, err = m.getSealed(tokenKey("server", "user"), &stored)
+		require.NoError(t, err)
+		assert.Equal(t, "refresh-2", stored.RefreshToken)
+		assert.True(t, stored.Expiry.After(time.Now()))
+	})
+
+	t.Run("tokens rejected by the server are refreshed once", func(t *testing.T) {
+		m, _, _ := setup(t)
+		var token oauthToken
+		_, err := m.getSealed(tokenKey("server", "user"), &token)
+		require.NoError(t, err)
+		token.Expiry = time.Now().Add(time.Hour)
+		require.NoError(t, m.setSealed(tokenKey("server", "user"), token))
+		auth := &serverAuthorization{oauth: m, userID: "user", serverID: "server"}
+
+		accessToken, err := auth.refresh(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "access-2", accessToken)
+	})
+
+	t.Run("tokens are removed when the refresh token is rejected", func(t *testing.T) {
+		m, server, _ := setup(t)
+		server.refreshTokens = map[string]bool{}
+
+		token, err := m.accessToken(context.Background(), "user", "server")
+		require.NoError(t, err)
+		assert.Empty(t, token)
+
+		found, err := m.getSealed(tokenKey("server", "user"), &oauthToken{})
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("tokens are kept when the refresh fails for another reason", func(t *testing.T) {
+		m, server, _ := setup(t)
+		server.refreshStatus = http.StatusServiceUnavailable
+
+		_, err := m.accessToken(context.Background(), "user", "server")
+		assert.Error(t, err)
+
+		server.refreshStatus = 0
+		token, err := m.accessToken(context.Background(), "user", "server")
+		require.NoError(t, err)
+		assert.Equal(t, "access-2", token)
+	})
+
+	t.Run("tokens still valid are used when the refresh fails", func(t *testing.T) {
+		m, server, _ := setup(t)
+		var token oauthToken
+		_, err := m.getSealed(tokenKey("server", "user"), &token)
+		require.NoError(t, err)
+		token.Expiry = time.Now().Add(oauthRefreshMargin / 2)
+		require.NoError(t, m.setSealed(tokenKey("server", "user"), token))
+		server.refreshStatus = http.StatusInternalServerError
+
+		accessToken, err := m.accessToken(context.Background(), "user", "server")
+		require.NoError(t, err)
+		assert.Equal(t, "access-1", accessToken)
+	})
+
+	t.Run("tokens refreshed by another server are used", func(t *testing.T) {
+		m, server, kvStore := setup(t)
+		other := newTestOAuthManager(kvStore, server)
+		server.onRefresh = func() {
+			// The other server refreshes the token first, using up the refresh token
+			server.onRefresh = nil
+			server.issued++
+			delete(server.refreshTokens, "refresh-1")
+			require.NoError(t, other.setSealed(tokenKey("server", "user"), oauthToken{AccessToken: "access-other", RefreshToken: "refresh-other", Expiry: time.Now().Add(time.Hour)}))
+		}
+
+		token, err := m.accessToken(context.Background(), "user", "server")
+		require.NoError(t, err)
+		assert.Equal(t, "access-other", token)
+	})
+}
+
+func TestOAuthEncryptionKey(t *testing.T) {
+	t.Run("the servers of a cluster share the key", func(t *testing.T) {
+		kvStore := newMemoryKVStore()
+		first := NewOAuthManager(kvStore, testLog(), nil, nil)
+		second := NewOAuthManager(kvStore, testLog(), nil, nil)
+
+		require.NoError(t, first.setSealed("key", "value"))
+		var value string
+		found, err := second.getSealed("key", &value)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "value", value)
+	})
+
+	t.Run("the first key generated is used", func(t *testing.T) {
+		kvStore := newMemoryKVStore()
+		other := []byte("0123456789abcdef0123456789abcdef")
+		kvStore.onGet = func(key string) {
+			// Another server stores its key between the read and the write of this one
+			kvStore.onGet = nil
+			require.NoError(t, kvStore.KVSet(oauthEncryptionKeyKey, other))
+		}
+		m := NewOAuthManager(kvStore, testLog(), nil, nil)
+
+		_, err := m.cipher()
+		require.NoError(t, err)
+		assert.Equal(t, other, m.key)
+	})
+
+	t.Run("values sealed for another key are ignored", func(t *testing.T) {
+		m := NewOAuthManager(newMemoryKVStore(), testLog(), nil, nil)
+		require.NoError(t, m.setSealed("key", "value"))
+
+		sealed := m.kvStore.(*memoryKVStore).values["key"]
+		require.NoError(t, m.kvStore.KVSet("moved", sealed))
+		found, err := m.getSealed("moved", new(string))
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+}
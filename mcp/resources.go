@@ -0,0 +1,192 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// requestTimeout bounds the requests listing and reading resources and prompts, which users wait for
+const requestTimeout = 30 * time.Second
+
+// Resource is a resource offered by an MCP server
+type Resource struct {
+	ServerID    string `json:"serverID"`
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceReference identifies a resource attached to a conversation
+type ResourceReference struct {
+	ServerID string `json:"serverID"`
+	URI      string `json:"uri"`
+	Name     string `json:"name,omitempty"`
+}
+
+// Prompt is a prompt template offered by an MCP server
+type Prompt struct {
+	ServerID    string           `json:"serverID"`
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []PromptArgument `json:"arguments,omitempty"`
+}
+
+// PromptArgument is an argument filled in by the user when using a prompt
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// ListResources returns the resources of the servers offering them. Servers failing to list their resources
+// are logged and skipped.
+func (c *UserClient) ListResources(ctx context.Context) []Resource {
+	c.touch()
+
+	resources := []Resource{}
+	for _, serverClient := range c.connections() {
+		serverID := serverClient.serverID
+		if serverClient.capabilities.Resources == nil {
+			continue
+		}
+		result, err := serverClient.client.ListResources(ctx, mcp.ListResourcesRequest{})
+		if err != nil {
+			c.log.Error("Failed to list MCP resources", "userID", c.userID, "serverID", serverID, "error", err)
+			continue
+		}
+		for _, resource := range result.Resources {
+			resources = append(resources, Resource{
+				ServerID:    serverID,
+				URI:         resource.URI,
+				Name:        resource.Name,
+				Description: resource.Description,
+				MimeType:    resource.MIMEType,
+			})
+		}
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].ServerID != resources[j].ServerID {
+			return resources[i].ServerID < resources[j].ServerID
+		}
+		return resources[i].Name < resources[j].Name
+	})
+	return resources
+}
+
+// ReadResource returns the text of a resource. Binary contents are replaced by a note saying they were left out.
+func (c *UserClient) ReadResource(ctx context.Context, serverID, uri string) (string, error) {
+	c.touch()
+
+	serverClient, exists := c.connection(serverID)
+	if !exists {
+		return "", fmt.Errorf("server %s not found", serverID)
+	}
+
+	request := mcp.ReadResourceRequest{}
+	request.Params.URI = uri
+	result, err := serverClient.client.ReadResource(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("failed to read resource %s on server %s: %w", uri, serverID, err)
+	}
+
+	contents := make([]string, 0, len(result.Contents))
+	for _, content := range result.Contents {
+		contents = append(contents, resourceContentsText(content))
+	}
+	return strings.Join(contents, "\n\n"), nil
+}
+
+// ListPrompts returns the prompts of the servers offering them. Servers failing to list their prompts are
+// logged and skipped.
+func (c *UserClient) ListPrompts(ctx context.Context) []Prompt {
+	c.touch()
+
+	prompts := []Prompt{}
+	for _, serverClient := range c.connections() {
+		serverID := serverClient.serverID
+		if serverClient.capabilities.Prompts == nil {
+			continue
+		}
+		result, err := serverClient.client.ListPrompts(ctx, mcp.ListPromptsRequest{})
+		if err != nil {
+			c.log.Error("Failed to list MCP prompts", "userID", c.userID, "serverID", serverID, "error", err)
+			continue
+		}
+		for _, prompt := range result.Prompts {
+			arguments := make([]PromptArgument, 0, len(prompt.Arguments))
+			for _, argument := range prompt.Arguments {
+				arguments = append(arguments, PromptArgument{
+					Name:        argument.Name,
+					Description: argument.Description,
+					Required:    argument.Required,
+				})
+			}
+			prompts = append(prompts, Prompt{
+				ServerID:    serverID,
+				Name:        prompt.Name,
+				Description: prompt.Description,
+				Arguments:   arguments,
+			})
+		}
+	}
+
+	sort.Slice(prompts, func(i, j int) bool {
+		if prompts[i].ServerID != prompts[j].ServerID {
+			return prompts[i].ServerID < prompts[j].ServerID
+		}
+		return prompts[i].Name < prompts[j].Name
+	})
+	return prompts
+}
+
+// GetPrompt fills in a prompt with the given arguments and returns its text. The text of the messages and of
+// the resources embedded in them is joined, images are left out.
+func (c *UserClient) GetPrompt(ctx context.Context, serverID, name string, arguments map[string]string) (string, error) {
+	c.touch()
+
+	serverClient, exists := c.connection(serverID)
+	if !exists {
+		return "", fmt.Errorf("server %s not found", serverID)
+	}
+
+	request := mcp.GetPromptRequest{}
+	request.Params.Name = name
+	request.Params.Arguments = arguments
+	result, err := serverClient.client.GetPrompt(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("failed to get prompt %s on server %s: %w", name, serverID, err)
+	}
+
+	parts := make([]string, 0, len(result.Messages))
+	for _, message := range result.Messages {
+		if text, ok := mcp.AsTextContent(message.Content); ok {
+			parts = append(parts, text.Text)
+		} else if resource, ok := mcp.AsEmbeddedResource(message.Content); ok {
+			parts = append(parts, resourceContentsText(resource.Resource))
+		}
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("prompt %s on server %s has no text", name, serverID)
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+func resourceContentsText(content mcp.ResourceContents) string {
+	if text, ok := mcp.AsTextResourceContents(content); ok {
+		return text.Text
+	}
+	if blob, ok := mcp.AsBlobResourceContents(content); ok {
+		return fmt.Sprintf("(binary content of type %s left out)", blob.MIMEType)
+	}
+	return ""
+}
@@ -0,0 +1,487 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+// MMUserIDEnv is the environment variable holding the ID of the user a local MCP server runs for
+const MMUserIDEnv = "MM_USER_ID"
+
+// StdioCommandsEnv is the environment variable of the Mattermost server listing the executables local MCP
+// servers can run, separated like the PATH. Local servers run code on the host, so the executables are
+// allowed where only the operators of the host can change them instead of the System Console. Local servers
+// are disabled when it isn't set.
+const StdioCommandsEnv = "MM_AI_MCP_STDIO_COMMANDS"
+
+const (
+	// stdioMaxRestarts is the number of crashes in a row after which a local server is no longer restarted
+	stdioMaxRestarts = 5
+	// stdioRestartDelay is the delay before the first restart, doubled after each crash in a row
+	stdioRestartDelay = time.Second
+	// stdioStableRuntime is how long a process has to run for its crash not to count as one in a row
+	stdioStableRuntime = time.Minute
+	// stdioStopTimeout is how long a process is given to exit once its input is closed before it is killed
+	stdioStopTimeout  = 5 * time.Second
+	stdioStartTimeout = 30 * time.Second
+	// stdioMaxProcesses is the number of local server processes running at once for all the users
+	stdioMaxProcesses = 100
+	// stdioMaxProcessesPerUser is the number of local server processes running at once for a user
+	stdioMaxProcessesPerUser = 5
+)
+
+// inheritedEnvironment lists the variables local servers get from the environment of the plugin, the rest of
+// it stays private to the plugin.
+var inheritedEnvironment = []string{"PATH", "LANG", "LC_ALL", "TZ", "SSL_CERT_FILE", "SSL_CERT_DIR"}
+
+var (
+	errProcessExited    = errors.New("MCP server process exited")
+	errTooManyProcesses = errors.New("too many MCP server processes are running")
+)
+
+// StdioCommandsFromEnv returns the executables allowed by StdioCommandsEnv
+func StdioCommandsFromEnv() []string {
+	return slices.DeleteFunc(filepath.SplitList(os.Getenv(StdioCommandsEnv)), func(command string) bool {
+		return strings.TrimSpace(command) == ""
+	})
+}
+
+// stdioProcesses allows the executables of the local servers and limits the processes running at once, in
+// total and for each user. It is shared by the clients of all the users.
+type stdioProcesses struct {
+	commands   []string
+	maxTotal   int
+	maxPerUser int
+
+	mu      sync.Mutex
+	total   int
+	perUser map[string]int
+}
+
+func newStdioProcesses(commands []string) *stdioProcesses {
+	return &stdioProcesses{
+		commands:   commands,
+		maxTotal:   stdioMaxProcesses,
+		maxPerUser: stdioMaxProcessesPerUser,
+		perUser:    make(map[string]int),
+	}
+}
+
+// allowed reports whether local servers can run the command
+func (s *stdioProcesses) allowed(command string) bool {
+	return slices.Contains(s.commands, command)
+}
+
+// acquire reserves a process for the user, it is released once the process exits.
+func (s *stdioProcesses) acquire(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.total >= s.maxTotal {
+		return fmt.Errorf("%w: the limit is %d", errTooManyProcesses, s.maxTotal)
+	}
+	if s.perUser[userID] >= s.maxPerUser {
+		return fmt.Errorf("%w for the user: the limit is %d", errTooManyProcesses, s.maxPerUser)
+	}
+	s.total++
+	s.perUser[userID]++
+	return nil
+}
+
+func (s *stdioProcesses) release(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total--
+	s.perUser[userID]--
+	if s.perUser[userID] <= 0 {
+		delete(s.perUser, userID)
+	}
+}
+
+// stdioTransport runs a local MCP server as a subprocess and exchanges the messages on its standard input and
+// output, one JSON-RPC message per line. Each user gets their own process, with its own home and temporary
+// directory and an environment holding only the configured variables and the user ID.
+//
+// The process is supervised: when it crashes it is started again after a delay and the session is initialized
+// again. After stdioMaxRestarts crashes in a row the server is left stopped and the requests fail until the
+// client is closed.
+type stdioTransport struct {
+	command   string
+	args      []string
+	env       []string
+	dir       string
+	log       pluginapi.LogService
+	userID    string
+	serverID  string
+	processes *stdioProcesses
+
+	requestID     atomic.Int64
+	handshake     sessionHandshake
+	notifications notificationHandlers
+
+	mu           sync.Mutex
+	process      *stdioProcess
+	crashes      int
+	closed       bool
+	restartTimer *time.Timer
+}
+
+// stdioProcess is a running local server
+type stdioProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.Closer
+	stderr  io.Closer
+	writeMu sync.Mutex
+	started time.Time
+	exited  chan struct{}
+	// stopping is set when the process is stopped on purpose, its exit isn't a crash
+	stopping atomic.Bool
+
+	pendingMu sync.Mutex
+	pending   map[int64]chan rpcMessage
+}
+
+func newStdioTransport(serverID, userID string, serverConfig ServerConfig, processes *stdioProcesses, log pluginapi.LogService) (*stdioTransport, error) {
+	if !processes.allowed(serverConfig.Command) {
+		return nil, fmt.Errorf("the command %q of the MCP server is not allowed by %s", serverConfig.Command, StdioCommandsEnv)
+	}
+
+	dir, err := os.MkdirTemp("", "mattermost-mcp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directory for MCP server process: %w", err)
+	}
+
+	t := &stdioTransport{
+		command:   serverConfig.Command,
+		args:      slices.DeleteFunc(slices.Clone(serverConfig.Args), func(arg string) bool { return arg == "" }),
+		env:       stdioEnvironment(serverConfig.Env, userID, dir),
+		dir:       dir,
+		log:       log,
+		userID:    userID,
+		serverID:  serverID,
+		processes: processes,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stdioStartTimeout)
+	defer cancel()
+	if _, err := t.ensureProcess(ctx); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return t, nil
+}
+
+// stdioEnvironment builds the environment of a local server run for a user. The configured variables can
+// override the inherited ones and the directories, but not the user ID.
+func stdioEnvironment(configured map[string]string, userID, dir string) []string {
+	var env []string
+	for _, name := range inheritedEnvironment {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	env = append(env, "HOME="+dir, "TMPDIR="+dir)
+	for _, name := range slices.Sorted(maps.Keys(configured)) {
+		env = append(env, name+"="+configured[name])
+	}
+	return append(env, MMUserIDEnv+"="+userID)
+}
+
+// ensureProcess returns the running process, starting it first when needed.
+func (t *stdioTransport) ensureProcess(ctx context.Context) (*stdioProcess, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, errors.New("MCP client is closed")
+	}
+	if t.process != nil {
+		return t.process, nil
+	}
+	if t.crashes > stdioMaxRestarts {
+		return nil, fmt.Errorf("MCP server process stopped after crashing %d times in a row", t.crashes)
+	}
+
+	p, err := t.startProcess()
+	if err != nil {
+		return nil, err
+	}
+
+	// A restarted server needs to be initialized again before it accepts requests
+	if params, ok := t.handshake.initializeParams(); ok {
+		if _, err := p.roundTrip(ctx, t.requestID.Add(1), methodInitialize, params); err != nil {
+			p.stop()
+			return nil, fmt.Errorf("failed to initialize restarted MCP server: %w", err)
+		}
+		if err := p.writeNotification(notificationInitialized); err != nil {
+			p.stop()
+			return nil, fmt.Errorf("failed to initialize restarted MCP server: %w", err)
+		}
+	}
+
+	t.process = p
+	return p, nil
+}
+
+func (t *stdioTransport) startProcess() (_ *stdioProcess, err error) {
+	if err = t.processes.acquire(t.userID); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			t.processes.release(t.userID)
+		}
+	}()
+
+	cmd := exec.Command(t.command, t.args...)
+	cmd.Env = t.env
+	cmd.Dir = t.dir
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start MCP server process: %w", err)
+	}
+
+	p := &stdioProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+		started: time.Now(),
+		exited:  make(chan struct{}),
+		pending: make(map[int64]chan rpcMessage),
+	}
+
+	t.log.Debug("Started MCP server process", "userID", t.userID, "serverID", t.serverID, "pid", cmd.Process.Pid)
+
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			t.log.Debug("MCP server process output", "userID", t.userID, "serverID", t.serverID, "output", scanner.Text())
+		}
+	}()
+
+	go func() {
+		t.readMessages(p, stdout)
+		// The pipes have to be drained before waiting for the process
+		<-stderrDone
+		waitErr := cmd.Wait()
+		t.processes.release(t.userID)
+		close(p.exited)
+		t.processExited(p, waitErr)
+	}()
+
+	return p, nil
+}
+
+func (t *stdioTransport) readMessages(p *stdioProcess, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxTransportMessageLength)
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		var message rpcMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.log.Debug("Ignoring invalid message from MCP server process", "userID", t.userID, "serverID", t.serverID, "error", err)
+			continue
+		}
+
+		switch {
+		case message.isResponse():
+			var id int64
+			if err := json.Unmarshal(message.ID, &id); err != nil {
+				continue
+			}
+			p.pendingMu.Lock()
+			responseChan, ok := p.pending[id]
+			delete(p.pending, id)
+			p.pendingMu.Unlock()
+			if ok {
+				responseChan <- message
+			}
+		case message.isRequest():
+			reply, err := replyToServerRequest(&message)
+			if err == nil {
+				_ = p.write(reply)
+			}
+		default:
+			t.notifications.handle(data)
+		}
+	}
+}
+
+// processExited schedules the restart of a process which exited on its own.
+func (t *stdioTransport) processExited(p *stdioProcess, waitErr error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.process == p {
+		t.process = nil
+	}
+	if t.closed || p.stopping.Load() {
+		return
+	}
+
+	if time.Since(p.started) > stdioStableRuntime {
+		t.crashes = 0
+	}
+	t.crashes++
+	if t.crashes > stdioMaxRestarts {
+		t.log.Error("MCP server process keeps crashing, not restarting it", "userID", t.userID, "serverID", t.serverID, "crashes", t.crashes, "error", waitErr)
+		return
+	}
+
+	delay := stdioRestartDelay << (t.crashes - 1)
+	t.log.Warn("MCP server process exited, restarting it", "userID", t.userID, "serverID", t.serverID, "delay", delay, "error", waitErr)
+	t.restartTimer = time.AfterFunc(delay, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), stdioStartTimeout)
+		defer cancel()
+		if _, err := t.ensureProcess(ctx); err != nil {
+			t.log.Error("Failed to restart MCP server process", "userID", t.userID, "serverID", t.serverID, "error", err)
+		}
+	})
+}
+
+func (t *stdioTransport) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	p, err := t.ensureProcess(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := p.roundTrip(ctx, t.requestID.Add(1), method, params)
+	if err == nil {
+		t.handshake.record(method, params)
+	}
+	return result, err
+}
+
+func (t *stdioTransport) notify(ctx context.Context, method string) error {
+	p, err := t.ensureProcess(ctx)
+	if err != nil {
+		return err
+	}
+	return p.writeNotification(method)
+}
+
+func (t *stdioTransport) addNotificationHandler(handler func(mcp.JSONRPCNotification)) {
+	t.notifications.add(handler)
+}
+
+// close stops the process for good and removes its directory.
+func (t *stdioTransport) close() error {
+	t.mu.Lock()
+	t.closed = true
+	if t.restartTimer != nil {
+		t.restartTimer.Stop()
+	}
+	p := t.process
+	t.process = nil
+	t.mu.Unlock()
+
+	if p != nil {
+		p.stop()
+	}
+	if err := os.RemoveAll(t.dir); err != nil {
+		return fmt.Errorf("failed to remove directory of MCP server process: %w", err)
+	}
+	return nil
+}
+
+func (p *stdioProcess) roundTrip(ctx context.Context, id int64, method string, params any) (json.RawMessage, error) {
+	message, err := newRequestMessage(id, method, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	responseChan := make(chan rpcMessage, 1)
+	p.pendingMu.Lock()
+	p.pending[id] = responseChan
+	p.pendingMu.Unlock()
+	defer func() {
+		p.pendingMu.Lock()
+		delete(p.pending, id)
+		p.pendingMu.Unlock()
+	}()
+
+	if err := p.write(message); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-responseChan:
+		return responseResult(&response)
+	case <-p.exited:
+		// The response may have been read just before the process exited
+		select {
+		case response := <-responseChan:
+			return responseResult(&response)
+		default:
+			return nil, errProcessExited
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *stdioProcess) writeNotification(method string) error {
+	message, err := newNotificationMessage(method)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	return p.write(message)
+}
+
+func (p *stdioProcess) write(message []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if _, err := p.stdin.Write(append(message, '\n')); err != nil {
+		return fmt.Errorf("failed to write to MCP server process: %w", err)
+	}
+	return nil
+}
+
+// stop closes the input of the process, which ends well behaved servers, and kills it if it doesn't exit.
+func (p *stdioProcess) stop() {
+	p.stopping.Store(true)
+	p.stdin.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(stdioStopTimeout):
+		_ = p.cmd.Process.Kill()
+		// Children of the process can keep its output open, the reads are ended from this side
+		p.stdout.Close()
+		p.stderr.Close()
+		<-p.exited
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStdioServerEnv makes the test binary run as a local MCP server
+const testStdioServerEnv = "MCP_TEST_STDIO_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testStdioServerEnv) != "" {
+		runTestStdioServer()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runTestStdioServer serves a tool returning the user ID the process was started for
+func runTestStdioServer() {
+	s := server.NewMCPServer("test", "1", server.WithToolCapabilities(false))
+	s.AddTool(mcp.NewTool("whoami"), func(_ context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(os.Getenv(MMUserIDEnv)), nil
+	})
+	if err := server.ServeStdio(s); err != nil {
+		os.Exit(1)
+	}
+}
+
+func testStdioServerConfig(t *testing.T) ServerConfig {
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	return ServerConfig{
+		Transport: TransportStdio,
+		Command:   executable,
+		Env:       map[string]string{testStdioServerEnv: "1"},
+	}
+}
+
+func TestStdioEnvironment(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("MM_SQLSETTINGS_DATASOURCE", "postgres://secret")
+
+	env := stdioEnvironment(map[string]string{"API_KEY": "key", MMUserIDEnv: "someone-else"}, "user", "/tmp/dir")
+
+	assert.Contains(t, env, "PATH=/usr/bin")
+	assert.Contains(t, env, "HOME=/tmp/dir")
+	assert.Contains(t, env, "API_KEY=key")
+	assert.NotContains(t, env, "MM_SQLSETTINGS_DATASOURCE=postgres://secret")
+	assert.Equal(t, MMUserIDEnv+"=user", env[len(env)-1], "the user ID can't be overridden")
+}
+
+func TestStdioCommandsFromEnv(t *testing.T) {
+	t.Setenv(StdioCommandsEnv, "")
+	assert.Empty(t, StdioCommandsFromEnv())
+
+	t.Setenv(StdioCommandsEnv, filepath.Join("opt", "a")+string(os.PathListSeparator)+string(os.PathListSeparator)+filepath.Join("opt", "b"))
+	assert.Equal(t, []string{filepath.Join("opt", "a"), filepath.Join("opt", "b")}, StdioCommandsFromEnv())
+}
+
+func TestStdioProcesses(t *testing.T) {
+	processes := newStdioProcesses([]string{"/opt/mcp/server"})
+	processes.maxTotal = 3
+	processes.maxPerUser = 2
+
+	assert.True(t, processes.allowed("/opt/mcp/server"))
+	assert.False(t, processes.allowed("/bin/sh"))
+	assert.False(t, processes.allowed(""))
+
+	require.NoError(t, processes.acquire("user1"))
+	require.NoError(t, processes.acquire("user1"))
+	assert.ErrorIs(t, processes.acquire("user1"), errTooManyProcesses)
+
+	require.NoError(t, processes.acquire("user2"))
+	assert.ErrorIs(t, processes.acquire("user3"), errTooManyProcesses)
+
+	processes.release("user1")
+	require.NoError(t, processes.acquire("user3"))
+	assert.ErrorIs(t, processes.acquire("user1"), errTooManyProcesses)
+}
+
+func TestStdioTransport(t *testing.T) {
+	serverConfig := testStdioServerConfig(t)
+
+	t.Run("commands that are not allowed are not run", func(t *testing.T) {
+		_, err := newStdioTransport("server", "user", serverConfig, newStdioProcesses(nil), testLog())
+		assert.ErrorContains(t, err, StdioCommandsEnv)
+	})
+
+	t.Run("the process runs for the user", func(t *testing.T) {
+		processes := newStdioProcesses([]string{serverConfig.Command})
+		client := newUserClient("user", testLog(), nil, nil, processes)
+		require.NoError(t, client.ConnectToAllServers(map[string]ServerConfig{"server": serverConfig}))
+
+		tools := client.GetTools()
+		require.Len(t, tools, 1)
+		result, err := tools[0].Resolver(&llm.Context{}, func(args any) error {
+			return json.Unmarshal([]byte(`{}`), args)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "user\n", result)
+		assert.Equal(t, 1, processes.total)
+
+		client.Close()
+		assert.Zero(t, processes.total, "the process is released once stopped")
+	})
+
+	t.Run("processes beyond the limit of the user are not started", func(t *testing.T) {
+		processes := newStdioProcesses([]string{serverConfig.Command})
+		processes.maxPerUser = 1
+		require.NoError(t, processes.acquire("user"))
+
+		_, err := newStdioTransport("server", "user", serverConfig, processes, testLog())
+		assert.ErrorIs(t, err, errTooManyProcesses)
+	})
+
+	t.Run("a crashed process is restarted", func(t *testing.T) {
+		processes := newStdioProcesses([]string{serverConfig.Command})
+		stdio, err := newStdioTransport("server", "user", serverConfig, processes, testLog())
+		require.NoError(t, err)
+		defer stdio.close()
+		mcpClient, _, err := initializeClient(context.Background(), newRPCClient(stdio))
+		require.NoError(t, err)
+
+		stdio.mu.Lock()
+		require.NoError(t, stdio.process.cmd.Process.Kill())
+		exited := stdio.process.exited
+		stdio.mu.Unlock()
+		<-exited
+
+		require.Eventually(t, func() bool {
+			return mcpClient.Ping(context.Background()) == nil
+		}, 10*time.Second, 100*time.Millisecond)
+		assert.Equal(t, 1, stdio.crashes)
+	})
+}
+
+func TestConnectToAllServersSkipsCommandsNotAllowed(t *testing.T) {
+	client := newUserClient("user", testLog(), nil, nil, newStdioProcesses([]string{"/opt/mcp/server"}))
+	defer client.Close()
+
+	err := client.ConnectToAllServers(map[string]ServerConfig{"server": {Transport: TransportStdio, Command: "/bin/sh", Args: []string{"-c", "id"}}})
+	assert.Error(t, err)
+	assert.Empty(t, client.servers)
+}
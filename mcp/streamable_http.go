@@ -0,0 +1,307 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	sessionIDHeader     = "Mcp-Session-Id"
+	closeSessionTimeout = 10 * time.Second
+)
+
+// httpStatusError is returned when an MCP server answers a message with an unexpected HTTP status
+type httpStatusError struct {
+	StatusCode int
+	Body       string
+	// Challenge is the WWW-Authenticate header of the responses rejecting unauthorized requests
+	Challenge string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("MCP server returned HTTP status %d: %s", e.StatusCode, e.Body)
+}
+
+// streamableHTTPTransport implements the streamable HTTP transport of MCP. Each message is sent as a POST
+// request to the endpoint of the server, which answers requests either with a JSON body or with a stream of
+// Server-Sent Events ending with the response. The server can assign a session to the client when it is
+// initialized, a new session is started when the server expires it.
+type streamableHTTPTransport struct {
+	endpoint   string
+	headers    map[string]string
+	httpClient *http.Client
+	// auth adds the OAuth access token of the user to the requests, nil when OAuth isn't available
+	auth *serverAuthorization
+
+	requestID     atomic.Int64
+	sessionMu     sync.RWMutex
+	sessionID     string
+	handshake     sessionHandshake
+	notifications notificationHandlers
+}
+
+func newStreamableHTTPTransport(endpoint string, headers map[string]string, httpClient *http.Client, auth *serverAuthorization) *streamableHTTPTransport {
+	return &streamableHTTPTransport{
+		endpoint:   endpoint,
+		headers:    headers,
+		httpClient: httpClient,
+		auth:       auth,
+	}
+}
+
+func (t *streamableHTTPTransport) getSessionID() string {
+	t.sessionMu.RLock()
+	defer t.sessionMu.RUnlock()
+	return t.sessionID
+}
+
+func (t *streamableHTTPTransport) setSessionID(sessionID string) {
+	t.sessionMu.Lock()
+	defer t.sessionMu.Unlock()
+	t.sessionID = sessionID
+}
+
+func (t *streamableHTTPTransport) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	t.handshake.record(method, params)
+
+	result, err := t.send(ctx, method, params)
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) {
+		return result, err
+	}
+	switch {
+	case statusErr.StatusCode == http.StatusUnauthorized && t.auth != nil:
+		// The access token may have been revoked or expired early, it is refreshed once
+		if token, refreshErr := t.auth.refresh(ctx); refreshErr != nil || token == "" {
+			return nil, err
+		}
+		return t.send(ctx, method, params)
+	case statusErr.StatusCode == http.StatusNotFound && method != methodInitialize && t.getSessionID() != "":
+		// The server no longer knows the session, the spec asks clients to initialize a new one
+		if sessionErr := t.startNewSession(ctx); sessionErr != nil {
+			return nil, fmt.Errorf("failed to start a new session after it expired: %w", sessionErr)
+		}
+		return t.send(ctx, method, params)
+	}
+	return result, err
+}
+
+func (t *streamableHTTPTransport) startNewSession(ctx context.Context) error {
+	params, ok := t.handshake.initializeParams()
+	if !ok {
+		return errors.New("session was never initialized")
+	}
+	t.setSessionID("")
+	if _, err := t.send(ctx, methodInitialize, params); err != nil {
+		return err
+	}
+	return t.notify(ctx, notificationInitialized)
+}
+
+func (t *streamableHTTPTransport) send(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	id := t.requestID.Add(1)
+	body, err := newRequestMessage(id, method, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := t.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if method == methodInitialize {
+		t.setSessionID(resp.Header.Get(sessionIDHeader))
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/event-stream":
+		return t.readStreamedResponse(ctx, resp.Body, id)
+	case "application/json":
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxTransportMessageLength))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		return t.findResponse(ctx, data, id)
+	default:
+		return nil, fmt.Errorf("unexpected content type %q in response to %s", mediaType, method)
+	}
+}
+
+// findResponse returns the result of the response with the given ID in a JSON body, which can hold a single
+// message or a batch of them.
+func (t *streamableHTTPTransport) findResponse(ctx context.Context, data []byte, id int64) (json.RawMessage, error) {
+	var messages []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &messages); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+	} else {
+		messages = []json.RawMessage{data}
+	}
+
+	for _, message := range messages {
+		if result, found, err := t.handleMessage(ctx, message, id); found {
+			return result, err
+		}
+	}
+	return nil, fmt.Errorf("no response to request %d", id)
+}
+
+func (t *streamableHTTPTransport) readStreamedResponse(ctx context.Context, body io.Reader, id int64) (json.RawMessage, error) {
+	var result json.RawMessage
+	var resultErr error
+	found := false
+	err := readSSEEvents(body, func(data string) bool {
+		result, found, resultErr = t.handleMessage(ctx, []byte(data), id)
+		return found
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response stream: %w", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("response stream ended without a response to request %d", id)
+	}
+	return result, resultErr
+}
+
+// handleMessage handles a message received from the server and reports whether it is the response to the
+// request with the given ID.
+func (t *streamableHTTPTransport) handleMessage(ctx context.Context, data []byte, id int64) (json.RawMessage, bool, error) {
+	var message rpcMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, false, nil
+	}
+
+	switch {
+	case message.isResponse():
+		var responseID int64
+		if err := json.Unmarshal(message.ID, &responseID); err != nil || responseID != id {
+			return nil, false, nil
+		}
+		result, err := responseResult(&message)
+		return result, true, err
+	case message.isRequest():
+		reply, err := replyToServerRequest(&message)
+		if err != nil {
+			return nil, false, nil
+		}
+		if resp, err := t.post(ctx, reply); err == nil {
+			resp.Body.Close()
+		}
+	default:
+		t.notifications.handle(data)
+	}
+	return nil, false, nil
+}
+
+func (t *streamableHTTPTransport) notify(ctx context.Context, method string) error {
+	body, err := newNotificationMessage(method)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	resp, err := t.post(ctx, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// post sends a message to the server, the response is returned when it has a successful status.
+func (t *streamableHTTPTransport) post(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if err := t.setHeaders(ctx, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &httpStatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(responseBody),
+			Challenge:  resp.Header.Get("WWW-Authenticate"),
+		}
+	}
+	return resp, nil
+}
+
+func (t *streamableHTTPTransport) setHeaders(ctx context.Context, req *http.Request) error {
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	if sessionID := t.getSessionID(); sessionID != "" {
+		req.Header.Set(sessionIDHeader, sessionID)
+	}
+	if t.auth != nil {
+		token, err := t.auth.accessToken(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get access token: %w", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	return nil
+}
+
+func (t *streamableHTTPTransport) addNotificationHandler(handler func(mcp.JSONRPCNotification)) {
+	t.notifications.add(handler)
+}
+
+// close ends the session on the server. Servers that don't allow clients to end their sessions answer with
+// 405 Method Not Allowed, the session then expires on its own.
+func (t *streamableHTTPTransport) close() error {
+	sessionID := t.getSessionID()
+	if sessionID == "" {
+		return nil
+	}
+	t.setSessionID("")
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeSessionTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := t.setHeaders(ctx, req); err != nil {
+		return err
+	}
+	req.Header.Set(sessionIDHeader, sessionID)
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+		return &httpStatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHTTPServer is an MCP server with the streamable HTTP transport, it answers tools/list with a stream and
+// tools/call with a batch.
+type testHTTPServer struct {
+	mu          sync.Mutex
+	sessions    int
+	sessionID   string
+	initialized int
+	closed      []string
+	headers     http.Header
+}
+
+func (s *testHTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers = r.Header.Clone()
+
+	if r.Method == http.MethodDelete {
+		s.closed = append(s.closed, r.Header.Get(sessionIDHeader))
+		return
+	}
+
+	var message struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if message.Method == methodInitialize {
+		s.sessions++
+		s.sessionID = fmt.Sprintf("session-%d", s.sessions)
+		s.initialized++
+		w.Header().Set(sessionIDHeader, s.sessionID)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"protocolVersion":%q,"capabilities":{"tools":{}},"serverInfo":{"name":"test","version":"1"}}}`, message.ID, mcp.LATEST_PROTOCOL_VERSION)
+		return
+	}
+	if r.Header.Get(sessionIDHeader) != s.sessionID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(message.ID) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	switch message.Method {
+	case "tools/list":
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/message\",\"params\":{\"level\":\"info\",\"data\":\"listing\"}}\n\n")
+		fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"id\":%s,\"result\":{\"tools\":[{\"name\":\"echo\",\"inputSchema\":{\"type\":\"object\"}}]}}\n\n", message.ID)
+	case "tools/call":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `[{"jsonrpc":"2.0","method":"notifications/message","params":{}},{"jsonrpc":"2.0","id":%s,"result":{"content":[{"type":"text","text":"hello"}]}}]`, message.ID)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"error":{"code":-32601,"message":"method not found"}}`, message.ID)
+	}
+}
+
+// expireSession makes the server forget the current session
+func (s *testHTTPServer) expireSession() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionID = "expired"
+}
+
+func newTestHTTPClient(t *testing.T, server *httptest.Server) *rpcClient {
+	mcpClient, _, err := initializeClient(context.Background(), newRPCClient(newStreamableHTTPTransport(server.URL, map[string]string{MMUserIDHeader: "user"}, server.Client(), nil)))
+	require.NoError(t, err)
+	return mcpClient.(*rpcClient)
+}
+
+func TestStreamableHTTPTransport(t *testing.T) {
+	t.Run("messages are sent in the session of the client", func(t *testing.T) {
+		handler := &testHTTPServer{}
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		mcpClient := newTestHTTPClient(t, server)
+		var notifications []string
+		mcpClient.OnNotification(func(notification mcp.JSONRPCNotification) {
+			notifications = append(notifications, notification.Method)
+		})
+
+		tools, err := mcpClient.ListTools(context.Background(), mcp.ListToolsRequest{})
+		require.NoError(t, err)
+		require.Len(t, tools.Tools, 1)
+		assert.Equal(t, "echo", tools.Tools[0].Name)
+		assert.Equal(t, []string{"notifications/message"}, notifications)
+		assert.Equal(t, "session-1", handler.headers.Get(sessionIDHeader))
+		assert.Equal(t, "user", handler.headers.Get(MMUserIDHeader))
+
+		result, err := mcpClient.CallTool(context.Background(), mcp.CallToolRequest{})
+		require.NoError(t, err)
+		require.Len(t, result.Content, 1)
+		text, ok := mcp.AsTextContent(result.Content[0])
+		require.True(t, ok)
+		assert.Equal(t, "hello", text.Text)
+
+		require.NoError(t, mcpClient.Close())
+		assert.Equal(t, []string{"session-1"}, handler.closed)
+	})
+
+	t.Run("a new session is started when the server expires it", func(t *testing.T) {
+		handler := &testHTTPServer{}
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		mcpClient := newTestHTTPClient(t, server)
+		handler.expireSession()
+
+		_, err := mcpClient.ListTools(context.Background(), mcp.ListToolsRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, handler.initialized)
+		assert.Equal(t, "session-2", handler.headers.Get(sessionIDHeader))
+	})
+
+	t.Run("errors of the server are returned", func(t *testing.T) {
+		server := httptest.NewServer(&testHTTPServer{})
+		defer server.Close()
+
+		mcpClient := newTestHTTPClient(t, server)
+		err := mcpClient.Ping(context.Background())
+		var rpcErr *rpcError
+		require.ErrorAs(t, err, &rpcErr)
+		assert.Equal(t, rpcErrorMethodNotFound, rpcErr.Code)
+	})
+
+	t.Run("unauthorized users get the challenge of the server", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("WWW-Authenticate", `Bearer resource_metadata="https://example.com/meta"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		_, _, err := initializeClient(context.Background(), newRPCClient(newStreamableHTTPTransport(server.URL, nil, server.Client(), nil)))
+		statusErr, ok := unauthorized(err)
+		require.True(t, ok)
+		assert.Equal(t, `Bearer resource_metadata="https://example.com/meta"`, statusErr.Challenge)
+	})
+
+	t.Run("the given HTTP client is used", func(t *testing.T) {
+		server := httptest.NewServer(&testHTTPServer{})
+		defer server.Close()
+
+		httpClient := &http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, fmt.Errorf("blocked")
+		})}
+		_, _, err := initializeClient(context.Background(), newRPCClient(newStreamableHTTPTransport(server.URL, nil, httpClient, nil)))
+		assert.ErrorContains(t, err, "blocked")
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,409 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Transports supported for the connection to an MCP server
+const (
+	// TransportAuto tries the streamable HTTP transport and falls back to SSE for older servers
+	TransportAuto = ""
+	// TransportStreamableHTTP sends the messages as HTTP POST requests to a single endpoint
+	TransportStreamableHTTP = "http"
+	// TransportSSE receives the messages on a Server-Sent Events stream
+	TransportSSE = "sse"
+	// TransportStdio runs the server as a local process and exchanges the messages on its standard input and output
+	TransportStdio = "stdio"
+)
+
+const (
+	methodInitialize          = "initialize"
+	notificationInitialized   = "notifications/initialized"
+	rpcErrorMethodNotFound    = -32601
+	maxTransportMessageLength = 10 * 1024 * 1024
+)
+
+// transport exchanges JSON-RPC messages with an MCP server. Transports assign the IDs of the requests and
+// repeat the initialization when they have to start a new session with the server.
+type transport interface {
+	// request sends a request and returns the result of the response
+	request(ctx context.Context, method string, params any) (json.RawMessage, error)
+	// notify sends a notification
+	notify(ctx context.Context, method string) error
+	// addNotificationHandler adds a function called with the notifications sent by the server
+	addNotificationHandler(handler func(mcp.JSONRPCNotification))
+	close() error
+}
+
+// rpcMessage is any JSON-RPC message received from a server
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *rpcError       `json:"error,omitempty"`
+}
+
+func (m *rpcMessage) isResponse() bool {
+	return len(m.ID) > 0 && m.Method == ""
+}
+
+func (m *rpcMessage) isRequest() bool {
+	return len(m.ID) > 0 && m.Method != ""
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("MCP server returned error %d: %s", e.Code, e.Message)
+}
+
+type rpcResponse struct {
+	ID     any       `json:"id"`
+	Result any       `json:"result,omitempty"`
+	Error  *rpcError `json:"error,omitempty"`
+}
+
+func newRequestMessage(id int64, method string, params any) ([]byte, error) {
+	return json.Marshal(mcp.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      id,
+		Params:  params,
+		Request: mcp.Request{Method: method},
+	})
+}
+
+func newNotificationMessage(method string) ([]byte, error) {
+	return json.Marshal(mcp.JSONRPCNotification{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{
+			Method: method,
+		},
+	})
+}
+
+// replyToServerRequest builds the response to a request sent by the server. Only pings are supported, the
+// client doesn't declare the capabilities needed for the other requests.
+func replyToServerRequest(message *rpcMessage) ([]byte, error) {
+	response := struct {
+		JSONRPC string `json:"jsonrpc"`
+		rpcResponse
+	}{JSONRPC: mcp.JSONRPC_VERSION}
+	response.ID = message.ID
+	if message.Method == "ping" {
+		response.Result = struct{}{}
+	} else {
+		response.Error = &rpcError{Code: rpcErrorMethodNotFound, Message: "method not found: " + message.Method}
+	}
+	return json.Marshal(response)
+}
+
+// responseResult returns the result of a response, or the error the server returned instead.
+func responseResult(message *rpcMessage) (json.RawMessage, error) {
+	if message.Error != nil {
+		return nil, message.Error
+	}
+	if len(message.Result) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+	return message.Result, nil
+}
+
+// sessionHandshake records the initialization of a session so a transport can repeat it when it starts a new
+// session, after a local server crashed or a remote server expired the session.
+type sessionHandshake struct {
+	mu     sync.Mutex
+	params any
+}
+
+func (h *sessionHandshake) record(method string, params any) {
+	if method != methodInitialize {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.params = params
+}
+
+// initializeParams returns the parameters of the recorded initialization, false when the session was never
+// initialized.
+func (h *sessionHandshake) initializeParams() (any, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.params, h.params != nil
+}
+
+// notificationHandlers holds the handlers of the notifications received by a transport.
+type notificationHandlers struct {
+	mu       sync.RWMutex
+	handlers []func(mcp.JSONRPCNotification)
+}
+
+func (h *notificationHandlers) add(handler func(mcp.JSONRPCNotification)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers = append(h.handlers, handler)
+}
+
+func (h *notificationHandlers) handle(data []byte) {
+	var notification mcp.JSONRPCNotification
+	if err := json.Unmarshal(data, &notification); err != nil {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, handler := range h.handlers {
+		handler(notification)
+	}
+}
+
+// readSSEEvents reads a Server-Sent Events stream and calls handle with the data of each message event until
+// handle returns true or the stream ends.
+func readSSEEvents(body io.Reader, handle func(data string) bool) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxTransportMessageLength)
+
+	event := ""
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 && (event == "" || event == "message") {
+				if handle(strings.Join(data, "\n")) {
+					return nil
+				}
+			}
+			event = ""
+			data = nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(data) > 0 && (event == "" || event == "message") {
+		handle(strings.Join(data, "\n"))
+	}
+	return nil
+}
+
+// rpcClient is an MCP client exchanging its messages through a transport.
+type rpcClient struct {
+	transport transport
+}
+
+var _ client.MCPClient = (*rpcClient)(nil)
+
+func newRPCClient(t transport) *rpcClient {
+	return &rpcClient{transport: t}
+}
+
+func (c *rpcClient) call(ctx context.Context, method string, params any, result any) error {
+	response, err := c.transport.request(ctx, method, params)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response, result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
+
+func (c *rpcClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	// The capabilities are always sent, even when empty
+	params := struct {
+		ProtocolVersion string                 `json:"protocolVersion"`
+		ClientInfo      mcp.Implementation     `json:"clientInfo"`
+		Capabilities    mcp.ClientCapabilities `json:"capabilities"`
+	}{
+		ProtocolVersion: request.Params.ProtocolVersion,
+		ClientInfo:      request.Params.ClientInfo,
+		Capabilities:    request.Params.Capabilities,
+	}
+
+	var result mcp.InitializeResult
+	if err := c.call(ctx, methodInitialize, params, &result); err != nil {
+		return nil, err
+	}
+	if err := c.transport.notify(ctx, notificationInitialized); err != nil {
+		return nil, fmt.Errorf("failed to send initialized notification: %w", err)
+	}
+	return &result, nil
+}
+
+func (c *rpcClient) Ping(ctx context.Context) error {
+	return c.call(ctx, "ping", nil, nil)
+}
+
+func (c *rpcClient) ListResourcesByPage(ctx context.Context, request mcp.ListResourcesRequest) (*mcp.ListResourcesResult, error) {
+	var result mcp.ListResourcesResult
+	if err := c.call(ctx, "resources/list", request.Params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *rpcClient) ListResources(ctx context.Context, request mcp.ListResourcesRequest) (*mcp.ListResourcesResult, error) {
+	result, err := c.ListResourcesByPage(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	for result.NextCursor != "" {
+		request.Params.Cursor = result.NextCursor
+		page, err := c.ListResourcesByPage(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		result.Resources = append(result.Resources, page.Resources...)
+		result.NextCursor = page.NextCursor
+	}
+	return result, nil
+}
+
+func (c *rpcClient) ListResourceTemplatesByPage(ctx context.Context, request mcp.ListResourceTemplatesRequest) (*mcp.ListResourceTemplatesResult, error) {
+	var result mcp.ListResourceTemplatesResult
+	if err := c.call(ctx, "resources/templates/list", request.Params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *rpcClient) ListResourceTemplates(ctx context.Context, request mcp.ListResourceTemplatesRequest) (*mcp.ListResourceTemplatesResult, error) {
+	result, err := c.ListResourceTemplatesByPage(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	for result.NextCursor != "" {
+		request.Params.Cursor = result.NextCursor
+		page, err := c.ListResourceTemplatesByPage(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		result.ResourceTemplates = append(result.ResourceTemplates, page.ResourceTemplates...)
+		result.NextCursor = page.NextCursor
+	}
+	return result, nil
+}
+
+func (c *rpcClient) ReadResource(ctx context.Context, request mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+	response, err := c.transport.request(ctx, "resources/read", request.Params)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.ParseReadResourceResult(&response)
+}
+
+func (c *rpcClient) Subscribe(ctx context.Context, request mcp.SubscribeRequest) error {
+	return c.call(ctx, "resources/subscribe", request.Params, nil)
+}
+
+func (c *rpcClient) Unsubscribe(ctx context.Context, request mcp.UnsubscribeRequest) error {
+	return c.call(ctx, "resources/unsubscribe", request.Params, nil)
+}
+
+func (c *rpcClient) ListPromptsByPage(ctx context.Context, request mcp.ListPromptsRequest) (*mcp.ListPromptsResult, error) {
+	var result mcp.ListPromptsResult
+	if err := c.call(ctx, "prompts/list", request.Params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *rpcClient) ListPrompts(ctx context.Context, request mcp.ListPromptsRequest) (*mcp.ListPromptsResult, error) {
+	result, err := c.ListPromptsByPage(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	for result.NextCursor != "" {
+		request.Params.Cursor = result.NextCursor
+		page, err := c.ListPromptsByPage(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		result.Prompts = append(result.Prompts, page.Prompts...)
+		result.NextCursor = page.NextCursor
+	}
+	return result, nil
+}
+
+func (c *rpcClient) GetPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	response, err := c.transport.request(ctx, "prompts/get", request.Params)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.ParseGetPromptResult(&response)
+}
+
+func (c *rpcClient) ListToolsByPage(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	var result mcp.ListToolsResult
+	if err := c.call(ctx, "tools/list", request.Params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *rpcClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	result, err := c.ListToolsByPage(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	for result.NextCursor != "" {
+		request.Params.Cursor = result.NextCursor
+		page, err := c.ListToolsByPage(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		result.Tools = append(result.Tools, page.Tools...)
+		result.NextCursor = page.NextCursor
+	}
+	return result, nil
+}
+
+func (c *rpcClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	response, err := c.transport.request(ctx, "tools/call", request.Params)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.ParseCallToolResult(&response)
+}
+
+func (c *rpcClient) SetLevel(ctx context.Context, request mcp.SetLevelRequest) error {
+	return c.call(ctx, "logging/setLevel", request.Params, nil)
+}
+
+func (c *rpcClient) Complete(ctx context.Context, request mcp.CompleteRequest) (*mcp.CompleteResult, error) {
+	var result mcp.CompleteResult
+	if err := c.call(ctx, "completion/complete", request.Params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *rpcClient) Close() error {
+	return c.transport.close()
+}
+
+func (c *rpcClient) OnNotification(handler func(notification mcp.JSONRPCNotification)) {
+	c.transport.addNotificationHandler(handler)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcp
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSSEEvents(t *testing.T) {
+	stream := strings.Join([]string{
+		": comment",
+		"event: endpoint",
+		"data: /ignored",
+		"",
+		"data: first",
+		"data: line",
+		"",
+		"event: message",
+		"data: second",
+		"",
+		"data: last",
+	}, "\n")
+
+	var events []string
+	err := readSSEEvents(strings.NewReader(stream), func(data string) bool {
+		events = append(events, data)
+		return false
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first\nline", "second", "last"}, events)
+
+	t.Run("reading stops when the handler is done", func(t *testing.T) {
+		events = nil
+		err := readSSEEvents(strings.NewReader(stream), func(data string) bool {
+			events = append(events, data)
+			return true
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"first\nline"}, events)
+	})
+}
+
+func TestReplyToServerRequest(t *testing.T) {
+	t.Run("pings are answered", func(t *testing.T) {
+		reply, err := replyToServerRequest(&rpcMessage{ID: json.RawMessage(`7`), Method: "ping"})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"jsonrpc":"2.0","id":7,"result":{}}`, string(reply))
+	})
+
+	t.Run("other requests are rejected", func(t *testing.T) {
+		reply, err := replyToServerRequest(&rpcMessage{ID: json.RawMessage(`"a"`), Method: "sampling/createMessage"})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"jsonrpc":"2.0","id":"a","error":{"code":-32601,"message":"method not found: sampling/createMessage"}}`, string(reply))
+	})
+}
+
+func TestResponseResult(t *testing.T) {
+	result, err := responseResult(&rpcMessage{ID: json.RawMessage(`1`)})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(result))
+
+	_, err = responseResult(&rpcMessage{ID: json.RawMessage(`1`), Error: &rpcError{Code: -32602, Message: "invalid params"}})
+	var rpcErr *rpcError
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, -32602, rpcErr.Code)
+}
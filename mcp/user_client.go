@@ -6,8 +6,13 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/invopop/jsonschema"
@@ -22,15 +27,25 @@ const MMUserIDHeader = "X-Mattermost-UserID"
 
 // ServerConnection represents the connection to a single MCP server
 type ServerConnection struct {
-	client   *client.SSEMCPClient
-	serverID string
-	tools    map[string]mcp.Tool
+	client       client.MCPClient
+	serverID     string
+	tools        map[string]mcp.Tool
+	capabilities mcp.ServerCapabilities
 }
 
 // ServerConfig contains the configuration for a single MCP server
 type ServerConfig struct {
-	BaseURL string            `json:"baseURL"`
-	Headers map[string]string `json:"headers,omitempty"`
+	// Transport is one of the Transport constants, remote servers are reached with the streamable HTTP
+	// transport or SSE when it is empty
+	Transport string            `json:"transport,omitempty"`
+	BaseURL   string            `json:"baseURL"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	// Command, Args and Env configure the local server started for each user with the stdio transport
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	// OAuth is used when the server asks users to authorize access to their account
+	OAuth OAuthConfig `json:"oauth,omitempty"`
 }
 
 // ToolDefinition represents a tool provided by an MCP server
@@ -39,16 +54,47 @@ type ToolDefinition struct {
 	serverID string
 }
 
-// UserClient represents a per-user MCP client with multiple server connections
+// UserClient represents a per-user MCP client with multiple server connections. Lost connections are
+// re-established in the background and the tools are listed again when a server says they changed.
 type UserClient struct {
+	userID     string
+	log        pluginapi.LogService
+	oauth      *OAuthManager
+	httpClient *http.Client
+	processes  *stdioProcesses
+
+	// mu guards the fields below, the connections are used and repaired concurrently
+	mu           sync.RWMutex
 	clients      map[string]*ServerConnection
 	toolDefs     map[string]ToolDefinition
+	servers      map[string]*serverState
 	lastActivity time.Time
-	userID       string
-	log          pluginapi.LogService
+	closed       bool
+	done         chan struct{}
+
+	// authorizations are the servers the user has to authorize before they can be connected
+	authorizations          []ServerAuthorization
+	authorizationsCreated   time.Time
+	authorizationsDelivered bool
 }
 
-// ConnectToAllServers initializes connections to all provided servers
+func newUserClient(userID string, log pluginapi.LogService, oauth *OAuthManager, httpClient *http.Client, processes *stdioProcesses) *UserClient {
+	return &UserClient{
+		userID:       userID,
+		log:          log,
+		oauth:        oauth,
+		httpClient:   httpClient,
+		processes:    processes,
+		clients:      make(map[string]*ServerConnection),
+		toolDefs:     make(map[string]ToolDefinition),
+		servers:      make(map[string]*serverState),
+		lastActivity: time.Now(),
+		done:         make(chan struct{}),
+	}
+}
+
+// ConnectToAllServers initializes connections to all provided servers. The servers failing to connect are
+// connected again in the background, with a growing delay between the attempts.
 func (c *UserClient) ConnectToAllServers(servers map[string]ServerConfig) error {
 	if len(servers) == 0 {
 		c.log.Debug("No MCP servers provided for user", "userID", c.userID)
@@ -57,130 +103,317 @@ func (c *UserClient) ConnectToAllServers(servers map[string]ServerConfig) error
 
 	// Initialize clients for each server
 	for serverID, serverConfig := range servers {
-		if serverConfig.BaseURL == "" {
+		if serverConfig.Transport == TransportStdio && serverConfig.Command == "" {
+			c.log.Warn("Skipping MCP server with empty Command", "serverID", serverID)
+			continue
+		}
+		if serverConfig.Transport == TransportStdio && !c.processes.allowed(serverConfig.Command) {
+			c.log.Warn("Skipping MCP server with a command not allowed by "+StdioCommandsEnv, "serverID", serverID, "command", serverConfig.Command)
+			continue
+		}
+		if serverConfig.Transport != TransportStdio && serverConfig.BaseURL == "" {
 			c.log.Warn("Skipping MCP server with empty BaseURL", "serverID", serverID)
 			continue
 		}
 
-		if err := c.connectToServer(context.Background(), serverID, serverConfig); err != nil {
-			c.log.Error("Failed to connect to MCP server", "userID", c.userID, "serverID", serverID, "error", err)
+		c.mu.Lock()
+		c.servers[serverID] = &serverState{config: serverConfig}
+		c.mu.Unlock()
+
+		serverClient, err := c.connectToServer(context.Background(), serverID, serverConfig)
+		if statusErr, ok := unauthorized(err); ok && c.oauth != nil {
+			c.requireAuthorization(serverID, serverConfig, statusErr.Challenge)
 			continue
 		}
+
+		c.mu.Lock()
+		if err != nil {
+			c.log.Error("Failed to connect to MCP server", "userID", c.userID, "serverID", serverID, "error", err)
+			c.scheduleReconnect(serverID, err)
+		} else {
+			c.addConnection(serverID, serverClient)
+		}
+		c.mu.Unlock()
 	}
 
-	// If no servers were successfully connected, return error
-	if len(c.clients) == 0 {
-		c.log.Warn("No MCP servers were successfully connected for user", "userID", c.userID)
-		return fmt.Errorf("no MCP servers were successfully connected")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authorizationsCreated = time.Now()
+
+	// If no servers could be configured, return error
+	if len(c.servers) == 0 {
+		c.log.Warn("No valid MCP servers configured for user", "userID", c.userID)
+		return fmt.Errorf("no valid MCP servers configured")
 	}
 
 	// Update last activity time
 	c.lastActivity = time.Now()
 
+	go c.monitorConnections()
+
 	return nil
 }
 
-// connectToServer establishes a connection to a single server and registers its tools
-func (c *UserClient) connectToServer(ctx context.Context, serverID string, serverConfig ServerConfig) error {
-	var opts []client.ClientOption
-	headers := make(map[string]string)
-	headers[MMUserIDHeader] = c.userID
-	if serverConfig.Headers != nil {
-		maps.Copy(headers, serverConfig.Headers)
-	}
-	opts = append(opts, client.WithHeaders(serverConfig.Headers))
-
-	sseClient, err := client.NewSSEMCPClient(serverConfig.BaseURL, opts...)
+// connectToServer establishes a connection to a single server and lists its tools
+func (c *UserClient) connectToServer(ctx context.Context, serverID string, serverConfig ServerConfig) (*ServerConnection, error) {
+	mcpClient, initResult, err := c.startServerClient(ctx, serverID, serverConfig)
 	if err != nil {
-		return fmt.Errorf("failed to create MCP client: %w", err)
+		return nil, err
 	}
 
 	// Ensure client is closed on error
 	success := false
 	defer func() {
 		if !success {
-			sseClient.Close()
+			mcpClient.Close()
 		}
 	}()
 
-	if startErr := sseClient.Start(ctx); startErr != nil {
-		return fmt.Errorf("failed to start MCP client: %w", startErr)
-	}
-
-	initResult, err := sseClient.Initialize(ctx, mcp.InitializeRequest{})
-	if err != nil {
-		return fmt.Errorf("failed to initialize MCP client: %w", err)
-	}
-
 	c.log.Debug("MCP client initialized successfully",
 		"userID", c.userID,
 		"serverID", serverID,
+		"transport", serverConfig.Transport,
 		"serverInfo", initResult.ServerInfo)
 
 	serverClient := &ServerConnection{
-		client:   sseClient,
-		serverID: serverID,
-		tools:    make(map[string]mcp.Tool),
+		client:       mcpClient,
+		serverID:     serverID,
+		tools:        make(map[string]mcp.Tool),
+		capabilities: initResult.Capabilities,
 	}
-	c.clients[serverID] = serverClient
 
-	// List and register available tools
-	result, err := sseClient.ListTools(ctx, mcp.ListToolsRequest{})
+	// Servers can offer only resources and prompts
+	if initResult.Capabilities.Tools != nil {
+		tools, err := listTools(ctx, mcpClient)
+		if err != nil {
+			return nil, err
+		}
+		serverClient.tools = tools
+
+		// The tools are listed again when the server says they changed
+		mcpClient.OnNotification(func(notification mcp.JSONRPCNotification) {
+			if notification.Method == notificationToolsListChanged {
+				go c.refreshTools(serverID, serverClient)
+			}
+		})
+	}
+
+	success = true
+	return serverClient, nil
+}
+
+func listTools(ctx context.Context, mcpClient client.MCPClient) (map[string]mcp.Tool, error) {
+	result, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
 	if err != nil {
-		return fmt.Errorf("failed to list tools: %w", err)
+		return nil, fmt.Errorf("failed to list tools: %w", err)
 	}
 
-	// Store the tools for this server
+	tools := make(map[string]mcp.Tool, len(result.Tools))
 	for _, tool := range result.Tools {
-		serverClient.tools[tool.Name] = tool
-
-		// Check for tool name conflicts across servers
-		if existingTool, exists := c.toolDefs[tool.Name]; exists {
-			c.log.Warn("Tool name conflict detected",
-				"userID", c.userID,
-				"tool", tool.Name,
-				"server1", existingTool.serverID,
-				"server2", serverID)
-			// For now, last server wins for conflicts
+		tools[tool.Name] = tool
+	}
+	return tools, nil
+}
+
+// requireAuthorization starts the authorization of a server that rejected the user
+func (c *UserClient) requireAuthorization(serverID string, serverConfig ServerConfig, challenge string) {
+	c.mu.Lock()
+	if server, exists := c.servers[serverID]; exists {
+		server.state = ServerStateAuthorizationRequired
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	authURL, err := c.oauth.authorizationURL(ctx, c.userID, serverID, serverConfig, challenge)
+	if err != nil {
+		c.log.Error("Failed to start MCP server authorization", "userID", c.userID, "serverID", serverID, "error", err)
+		return
+	}
+	c.log.Debug("MCP server requires authorization", "userID", c.userID, "serverID", serverID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authorizations = append(c.authorizations, ServerAuthorization{
+		ServerID: serverID,
+		URL:      authURL,
+	})
+}
+
+// takeAuthorizations returns the servers the user has to authorize, once for each set of authorization links
+func (c *UserClient) takeAuthorizations() []ServerAuthorization {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.authorizations) == 0 || c.authorizationsDelivered {
+		return nil
+	}
+	c.authorizationsDelivered = true
+	return slices.Clone(c.authorizations)
+}
+
+// authorizationsExpired reports whether the user was given authorization links that expired since
+func (c *UserClient) authorizationsExpired() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.authorizations) > 0 && time.Since(c.authorizationsCreated) > oauthFlowExpiry
+}
+
+// unauthorized reports whether a server rejected a request because the user isn't authorized
+func unauthorized(err error) (*httpStatusError, bool) {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized {
+		return statusErr, true
+	}
+	return nil, false
+}
+
+// startServerClient creates the client for the transport of a server and initializes the session
+func (c *UserClient) startServerClient(ctx context.Context, serverID string, serverConfig ServerConfig) (client.MCPClient, *mcp.InitializeResult, error) {
+	headers := make(map[string]string)
+	maps.Copy(headers, serverConfig.Headers)
+	headers[MMUserIDHeader] = c.userID
+
+	switch serverConfig.Transport {
+	case TransportStdio:
+		stdio, err := newStdioTransport(serverID, c.userID, serverConfig, c.processes, c.log)
+		if err != nil {
+			return nil, nil, err
+		}
+		return initializeClient(ctx, newRPCClient(stdio))
+	case TransportStreamableHTTP:
+		return initializeClient(ctx, newRPCClient(newStreamableHTTPTransport(serverConfig.BaseURL, headers, c.httpClient, c.serverAuthorization(serverID))))
+	case TransportSSE:
+		return startSSEClient(ctx, serverConfig.BaseURL, headers)
+	case TransportAuto:
+		mcpClient, initResult, err := initializeClient(ctx, newRPCClient(newStreamableHTTPTransport(serverConfig.BaseURL, headers, c.httpClient, c.serverAuthorization(serverID))))
+		if err == nil {
+			return mcpClient, initResult, nil
 		}
+		if _, ok := unauthorized(err); ok {
+			// The server supports the transport but the user has to authorize it first
+			return nil, nil, err
+		}
+		c.log.Debug("MCP server does not support the streamable HTTP transport, falling back to SSE", "serverID", serverID, "error", err)
 
-		c.toolDefs[tool.Name] = ToolDefinition{
-			tool:     tool,
-			serverID: serverID,
+		mcpClient, initResult, sseErr := startSSEClient(ctx, serverConfig.BaseURL, headers)
+		if sseErr != nil {
+			return nil, nil, fmt.Errorf("failed to connect with streamable HTTP: %w, and with SSE: %w", err, sseErr)
 		}
+		return mcpClient, initResult, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown MCP transport %q", serverConfig.Transport)
+	}
+}
 
-		c.log.Debug("Registered MCP tool",
-			"userID", c.userID,
-			"name", tool.Name,
-			"description", tool.Description,
-			"server", serverID)
+// serverAuthorization returns the OAuth authorization of the user for a server, nil when OAuth isn't available
+func (c *UserClient) serverAuthorization(serverID string) *serverAuthorization {
+	if c.oauth == nil {
+		return nil
 	}
+	return &serverAuthorization{
+		oauth:    c.oauth,
+		userID:   c.userID,
+		serverID: serverID,
+	}
+}
 
-	success = true
-	return nil
+func startSSEClient(ctx context.Context, baseURL string, headers map[string]string) (client.MCPClient, *mcp.InitializeResult, error) {
+	sseClient, err := client.NewSSEMCPClient(baseURL, client.WithHeaders(headers))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create MCP client: %w", err)
+	}
+
+	if err := sseClient.Start(ctx); err != nil {
+		sseClient.Close()
+		return nil, nil, fmt.Errorf("failed to start MCP client: %w", err)
+	}
+
+	return initializeClient(ctx, sseClient)
 }
 
-// Close closes all server connections for a user client
+// initializeClient initializes the session of a started client, the client is closed when it fails
+func initializeClient(ctx context.Context, mcpClient client.MCPClient) (client.MCPClient, *mcp.InitializeResult, error) {
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+
+	initResult, err := mcpClient.Initialize(ctx, initRequest)
+	if err != nil {
+		mcpClient.Close()
+		return nil, nil, fmt.Errorf("failed to initialize MCP client: %w", err)
+	}
+	return mcpClient, initResult, nil
+}
+
+// Close closes all server connections for a user client and stops reconnecting the lost ones
 func (c *UserClient) Close() {
-	if len(c.clients) == 0 {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
 		return
 	}
+	c.closed = true
+	close(c.done)
+	for _, server := range c.servers {
+		if server.reconnectTimer != nil {
+			server.reconnectTimer.Stop()
+		}
+	}
+
+	// Clear clients and tool definitions
+	clients := c.clients
+	c.clients = make(map[string]*ServerConnection)
+	c.toolDefs = make(map[string]ToolDefinition)
+	c.mu.Unlock()
 
 	// Close all MCP server clients
-	for serverID, client := range c.clients {
+	for serverID, client := range clients {
 		if err := client.client.Close(); err != nil {
 			c.log.Error("Failed to close MCP client", "userID", c.userID, "serverID", serverID, "error", err)
 		}
 	}
+}
 
-	// Clear clients and tool definitions
-	c.clients = make(map[string]*ServerConnection)
-	c.toolDefs = make(map[string]ToolDefinition)
+// touch records the activity of the user, idle clients are closed
+func (c *UserClient) touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastActivity = time.Now()
+}
+
+// idleTime returns how long the client hasn't been used
+func (c *UserClient) idleTime() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Since(c.lastActivity)
+}
+
+// connection returns the connection to a server, false when the server isn't connected
+func (c *UserClient) connection(serverID string) (*ServerConnection, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	serverClient, exists := c.clients[serverID]
+	return serverClient, exists
+}
+
+// connections returns the connections to the servers sorted by server ID
+func (c *UserClient) connections() []*ServerConnection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	connections := slices.Collect(maps.Values(c.clients))
+	slices.SortFunc(connections, func(a, b *ServerConnection) int {
+		return strings.Compare(a.serverID, b.serverID)
+	})
+	return connections
 }
 
 // ConvertPropertiesToOrderedMap converts a map of properties to an OrderedMap using JSON marshaling
 func ConvertPropertiesToOrderedMap(source map[string]any) (*orderedmap.OrderedMap[string, *jsonschema.Schema], error) {
 	var target orderedmap.OrderedMap[string, *jsonschema.Schema]
+	// Tools without arguments can leave out the properties, which the ordered map can't unmarshal from null
+	if len(source) == 0 {
+		return orderedmap.New[string, *jsonschema.Schema](), nil
+	}
 	jsonData, err := json.Marshal(source)
 	if err != nil {
 		return nil, err
@@ -191,6 +424,8 @@ func ConvertPropertiesToOrderedMap(source map[string]any) (*orderedmap.OrderedMa
 
 // GetTools returns the tools available from the client
 func (c *UserClient) GetTools() []llm.Tool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	if len(c.clients) == 0 {
 		return nil
 	}
@@ -212,6 +447,7 @@ func (c *UserClient) GetTools() []llm.Tool {
 			Description: toolInfo.tool.Description,
 			Schema:      schema,
 			Resolver:    c.createToolResolver(name),
+			Server:      toolInfo.serverID,
 		})
 	}
 
@@ -221,7 +457,9 @@ func (c *UserClient) GetTools() []llm.Tool {
 // createToolResolver creates a resolver function for the given tool
 func (c *UserClient) createToolResolver(toolName string) func(llmContext *llm.Context, argsGetter llm.ToolArgumentGetter) (string, error) {
 	return func(llmContext *llm.Context, argsGetter llm.ToolArgumentGetter) (string, error) {
+		c.mu.Lock()
 		if len(c.clients) == 0 {
+			c.mu.Unlock()
 			return "", fmt.Errorf("MCP client has no active connections")
 		}
 
@@ -231,12 +469,14 @@ func (c *UserClient) createToolResolver(toolName string) func(llmContext *llm.Co
 		// Find which server has this tool
 		toolInfo, exists := c.toolDefs[toolName]
 		if !exists {
+			c.mu.Unlock()
 			return "", fmt.Errorf("tool %s not found", toolName)
 		}
 		serverID := toolInfo.serverID
 
 		// Get the server client
 		serverClient, exists := c.clients[serverID]
+		c.mu.Unlock()
 		if !exists {
 			return "", fmt.Errorf("server %s for tool %s not found", serverID, toolName)
 		}
@@ -265,6 +505,8 @@ func (c *UserClient) createToolResolver(toolName string) func(llmContext *llm.Co
 
 		result, err := serverClient.client.CallTool(ctx, callRequest)
 		if err != nil {
+			// The connection may have been lost, it is checked and re-established when it was
+			go c.checkConnection(serverID, serverClient)
 			return "", fmt.Errorf("failed to call tool %s on server %s: %w", toolName, serverID, err)
 		}
 
@@ -0,0 +1,217 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/mattermost/mattermost-plugin-ai/bots"
+	"github.com/mattermost/mattermost-plugin-ai/enterprise"
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/llmcontext"
+	mmmcp "github.com/mattermost/mattermost-plugin-ai/mcp"
+	"github.com/mattermost/mattermost-plugin-ai/mmapi"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+const (
+	serverName    = "mattermost"
+	serverVersion = "1.0.0"
+)
+
+// Path is the route of the MCP server endpoint relative to the plugin's URL
+const Path = "/mcp/server"
+
+// builtInToolNames are the tools of the MMToolProvider that are published, the others only make sense
+// inside a conversation with a bot.
+var builtInToolNames = []string{"SearchServer", "LookupMattermostUser"}
+
+type Config interface {
+	GetDefaultBotName() string
+	MCP() mmmcp.Config
+}
+
+type userIDKey struct{}
+
+// toolResolver resolves a tool call with the context of the MCP request
+type toolResolver func(ctx context.Context, llmContext *llm.Context, argsGetter llm.ToolArgumentGetter) (string, error)
+
+// serverTool is a tool published by the server
+type serverTool struct {
+	llm.Tool
+	resolve toolResolver
+}
+
+// Server publishes Mattermost tools to MCP clients, such as agents running in developers' IDEs. The tools
+// run on behalf of the user authenticated by the request and are subject to that user's permissions.
+type Server struct {
+	server         *server.MCPServer
+	toolProvider   llmcontext.ToolProvider
+	pluginAPI      *pluginapi.Client
+	mmClient       mmapi.Client
+	bots           *bots.MMBots
+	contextBuilder *llmcontext.Builder
+	prompts        *llm.Prompts
+	licenseChecker *enterprise.LicenseChecker
+	config         Config
+
+	// builtInToolsMu guards the names of the built-in tools published
+	builtInToolsMu sync.Mutex
+	builtInTools   []string
+}
+
+// New creates the MCP server with the built-in tools of toolProvider and the channel reading and thread
+// summarization tools.
+func New(
+	toolProvider llmcontext.ToolProvider,
+	pluginAPI *pluginapi.Client,
+	mmClient mmapi.Client,
+	bots *bots.MMBots,
+	contextBuilder *llmcontext.Builder,
+	prompts *llm.Prompts,
+	licenseChecker *enterprise.LicenseChecker,
+	config Config,
+) *Server {
+	s := &Server{
+		server: server.NewMCPServer(
+			serverName,
+			serverVersion,
+			server.WithToolCapabilities(false),
+			server.WithRecovery(),
+			server.WithInstructions("Tools to search and read the messages of the Mattermost server as the authenticated user."),
+		),
+		toolProvider:   toolProvider,
+		pluginAPI:      pluginAPI,
+		mmClient:       mmClient,
+		bots:           bots,
+		contextBuilder: contextBuilder,
+		prompts:        prompts,
+		licenseChecker: licenseChecker,
+		config:         config,
+	}
+
+	s.server.AddTools(s.serverTools(s.channelTools())...)
+	s.syncBuiltInTools()
+
+	return s
+}
+
+// syncBuiltInTools publishes the built-in tools the tool provider currently offers, which depend on the
+// configuration of the server, such as whether search is available.
+func (s *Server) syncBuiltInTools() {
+	var tools []serverTool
+	var names []string
+	for _, tool := range s.toolProvider.GetTools(true, nil) {
+		if !slices.Contains(builtInToolNames, tool.Name) {
+			continue
+		}
+		resolver := tool.Resolver
+		tools = append(tools, serverTool{
+			Tool: tool,
+			resolve: func(_ context.Context, llmContext *llm.Context, argsGetter llm.ToolArgumentGetter) (string, error) {
+				return resolver(llmContext, argsGetter)
+			},
+		})
+		names = append(names, tool.Name)
+	}
+	slices.Sort(names)
+
+	s.builtInToolsMu.Lock()
+	defer s.builtInToolsMu.Unlock()
+	if slices.Equal(names, s.builtInTools) {
+		return
+	}
+
+	// The current tools are replaced before the stale ones are removed so the others stay listed
+	if len(tools) > 0 {
+		s.server.AddTools(s.serverTools(tools)...)
+	}
+	var stale []string
+	for _, name := range s.builtInTools {
+		if !slices.Contains(names, name) {
+			stale = append(stale, name)
+		}
+	}
+	if len(stale) > 0 {
+		s.server.DeleteTools(stale...)
+	}
+	s.builtInTools = names
+}
+
+// Enabled reports whether the administrator enabled the MCP server
+func (s *Server) Enabled() bool {
+	cfg := s.config.MCP()
+	return cfg.Enabled && cfg.EnableServer
+}
+
+// HandleMessage handles a JSON-RPC message of the MCP client on behalf of userID. It returns nil for
+// notifications, which have no response.
+func (s *Server) HandleMessage(ctx context.Context, userID string, message json.RawMessage) mcp.JSONRPCMessage {
+	s.syncBuiltInTools()
+	return s.server.HandleMessage(context.WithValue(ctx, userIDKey{}, userID), message)
+}
+
+// serverTools converts tools resolved like the tools given to bots for the MCP server. The error of a failed
+// tool is logged, the client only gets the message the resolver returned for the model.
+func (s *Server) serverTools(tools []serverTool) []server.ServerTool {
+	serverTools := make([]server.ServerTool, 0, len(tools))
+	for _, tool := range tools {
+		schema, err := json.Marshal(tool.Schema)
+		if err != nil {
+			s.pluginAPI.Log.Error("Failed to marshal the schema of an MCP server tool", "tool", tool.Name, "error", err)
+			continue
+		}
+		serverTools = append(serverTools, server.ServerTool{
+			Tool:    mcp.NewToolWithRawSchema(tool.Name, tool.Description, schema),
+			Handler: s.toolHandler(tool),
+		})
+	}
+	return serverTools
+}
+
+func (s *Server) toolHandler(tool serverTool) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		user, err := s.requestingUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		llmContext := llm.NewContext()
+		llmContext.RequestingUser = user
+
+		result, err := tool.resolve(ctx, llmContext, func(args any) error {
+			arguments, marshalErr := json.Marshal(request.Params.Arguments)
+			if marshalErr != nil {
+				return marshalErr
+			}
+			return json.Unmarshal(arguments, args)
+		})
+		if err != nil {
+			s.pluginAPI.Log.Debug("MCP server tool call failed", "tool", tool.Name, "userID", user.Id, "error", err)
+			return mcp.NewToolResultError(result), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	}
+}
+
+func (s *Server) requestingUser(ctx context.Context) (*model.User, error) {
+	userID, _ := ctx.Value(userIDKey{}).(string)
+	if userID == "" {
+		return nil, fmt.Errorf("request without a user")
+	}
+
+	user, err := s.pluginAPI.User.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
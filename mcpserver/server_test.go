@@ -0,0 +1,152 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mattermost/mattermost-plugin-ai/bots"
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/mmapi/mocks"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testToolProvider struct {
+	tools []llm.Tool
+}
+
+func (p *testToolProvider) GetTools(isDM bool, bot *bots.Bot) []llm.Tool {
+	return p.tools
+}
+
+func testTool(name string) llm.Tool {
+	return llm.Tool{
+		Name:        name,
+		Description: name,
+		Schema:      llm.NewJSONSchemaFromStruct(ListChannelsArgs{}),
+		Resolver: func(*llm.Context, llm.ToolArgumentGetter) (string, error) {
+			return name, nil
+		},
+	}
+}
+
+func listTools(t *testing.T, s *Server) []string {
+	t.Helper()
+
+	response := s.HandleMessage(context.Background(), "user1", json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	result, ok := response.(mcp.JSONRPCResponse)
+	require.True(t, ok, "unexpected response %#v", response)
+	toolsResult, ok := result.Result.(mcp.ListToolsResult)
+	require.True(t, ok)
+
+	var names []string
+	for _, tool := range toolsResult.Tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+func TestServerTools(t *testing.T) {
+	provider := &testToolProvider{tools: []llm.Tool{
+		testTool("LookupMattermostUser"),
+		testTool("CreatePost"),
+	}}
+	s := New(provider, pluginapi.NewClient(&plugintest.API{}, nil), nil, nil, nil, nil, nil, nil)
+
+	t.Run("only the built-in tools are published", func(t *testing.T) {
+		assert.ElementsMatch(t, []string{"ListChannels", "ReadChannel", "ReadThread", "SummarizeThread", "LookupMattermostUser"}, listTools(t, s))
+	})
+
+	t.Run("tools the provider starts offering are published", func(t *testing.T) {
+		provider.tools = append(provider.tools, testTool("SearchServer"))
+		assert.ElementsMatch(t, []string{"ListChannels", "ReadChannel", "ReadThread", "SummarizeThread", "LookupMattermostUser", "SearchServer"}, listTools(t, s))
+	})
+
+	t.Run("tools the provider stops offering are removed", func(t *testing.T) {
+		provider.tools = []llm.Tool{testTool("SearchServer")}
+		assert.ElementsMatch(t, []string{"ListChannels", "ReadChannel", "ReadThread", "SummarizeThread", "SearchServer"}, listTools(t, s))
+
+		provider.tools = nil
+		assert.ElementsMatch(t, []string{"ListChannels", "ReadChannel", "ReadThread", "SummarizeThread"}, listTools(t, s))
+	})
+}
+
+func TestReadablePost(t *testing.T) {
+	postID := model.NewId()
+	channelID := model.NewId()
+
+	t.Run("invalid post ID", func(t *testing.T) {
+		s := &Server{mmClient: mocks.NewMockClient(t)}
+
+		_, _, err := s.readablePost("user1", "not-an-id")
+		require.Error(t, err)
+	})
+
+	t.Run("user without permission to the channel", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().GetPost(postID).Return(&model.Post{Id: postID, ChannelId: channelID}, nil)
+		mmClient.EXPECT().HasPermissionToChannel("user1", channelID, model.PermissionReadChannel).Return(false)
+		s := &Server{mmClient: mmClient}
+
+		post, channel, err := s.readablePost("user1", postID)
+		require.Error(t, err)
+		assert.Nil(t, post)
+		assert.Nil(t, channel)
+	})
+
+	t.Run("user with permission to the channel", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().GetPost(postID).Return(&model.Post{Id: postID, ChannelId: channelID}, nil)
+		mmClient.EXPECT().HasPermissionToChannel("user1", channelID, model.PermissionReadChannel).Return(true)
+		mmClient.EXPECT().GetChannel(channelID).Return(&model.Channel{Id: channelID}, nil)
+		s := &Server{mmClient: mmClient}
+
+		post, channel, err := s.readablePost("user1", postID)
+		require.NoError(t, err)
+		assert.Equal(t, postID, post.Id)
+		assert.Equal(t, channelID, channel.Id)
+	})
+}
+
+func TestToolReadChannel(t *testing.T) {
+	channelID := model.NewId()
+	llmContext := llm.NewContext()
+	llmContext.RequestingUser = &model.User{Id: "user1"}
+	args := func(args any) error {
+		return json.Unmarshal([]byte(`{"Team":"team","Channel":"private"}`), args)
+	}
+
+	t.Run("channel that doesn't exist", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		api.On("GetChannelByNameForTeamName", "team", "private", false).Return(nil, model.NewAppError("GetChannelByNameForTeamName", "app.channel.get_by_name.missing.app_error", nil, "", 404))
+		s := &Server{pluginAPI: pluginapi.NewClient(api, nil), mmClient: mocks.NewMockClient(t)}
+
+		result, err := s.toolReadChannel(context.Background(), llmContext, args)
+		require.Error(t, err)
+		assert.Equal(t, "channel not found", result)
+	})
+
+	t.Run("user without permission to the channel", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		api.On("GetChannelByNameForTeamName", "team", "private", false).Return(&model.Channel{Id: channelID}, nil)
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().HasPermissionToChannel("user1", channelID, model.PermissionReadChannel).Return(false)
+		s := &Server{pluginAPI: pluginapi.NewClient(api, nil), mmClient: mmClient}
+
+		// The posts of the channel are never read
+		result, err := s.toolReadChannel(context.Background(), llmContext, args)
+		require.Error(t, err)
+		assert.Equal(t, "channel not found", result)
+		api.AssertNotCalled(t, "GetPostsForChannel")
+	})
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-ai/format"
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/mmapi"
+	"github.com/mattermost/mattermost-plugin-ai/threads"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+const (
+	defaultReadChannelCount = 30
+	maxReadChannelCount     = 200
+)
+
+type ListChannelsArgs struct{}
+
+type ReadChannelArgs struct {
+	Team    string `jsonschema_description:"The name of the team of the channel, as in the URL of the channel. Example: 'engineering'"`
+	Channel string `jsonschema_description:"The name of the channel, as in its URL. Example: 'town-square'"`
+	Count   int    `json:",omitempty" jsonschema_description:"The number of most recent messages to read. Defaults to 30, at most 200."`
+}
+
+type ReadThreadArgs struct {
+	PostID string `jsonschema_description:"The ID of any post of the thread."`
+}
+
+type SummarizeThreadArgs struct {
+	PostID string `jsonschema_description:"The ID of any post of the thread."`
+}
+
+func (s *Server) channelTools() []serverTool {
+	return []serverTool{
+		{
+			Tool: llm.Tool{
+				Name:        "ListChannels",
+				Description: "List the channels the user is a member of, with the names of their teams. Use the names to read a channel.",
+				Schema:      llm.NewJSONSchemaFromStruct(ListChannelsArgs{}),
+				ReadOnly:    true,
+			},
+			resolve: s.toolListChannels,
+		},
+		{
+			Tool: llm.Tool{
+				Name:        "ReadChannel",
+				Description: "Read the most recent messages of a Mattermost channel. Messages are listed oldest first.",
+				Schema:      llm.NewJSONSchemaFromStruct(ReadChannelArgs{}),
+				ReadOnly:    true,
+			},
+			resolve: s.toolReadChannel,
+		},
+		{
+			Tool: llm.Tool{
+				Name:        "ReadThread",
+				Description: "Read all the messages of a Mattermost thread. Messages are listed oldest first.",
+				Schema:      llm.NewJSONSchemaFromStruct(ReadThreadArgs{}),
+				ReadOnly:    true,
+			},
+			resolve: s.toolReadThread,
+		},
+		{
+			Tool: llm.Tool{
+				Name:        "SummarizeThread",
+				Description: "Summarize a Mattermost thread with the default AI bot of the server.",
+				Schema:      llm.NewJSONSchemaFromStruct(SummarizeThreadArgs{}),
+				ReadOnly:    true,
+			},
+			resolve: s.toolSummarizeThread,
+		},
+	}
+}
+
+func (s *Server) toolListChannels(_ context.Context, llmContext *llm.Context, argsGetter llm.ToolArgumentGetter) (string, error) {
+	userID := llmContext.RequestingUser.Id
+
+	teams, err := s.pluginAPI.Team.List(pluginapi.FilterTeamsByUser(userID))
+	if err != nil {
+		return "failed to list channels", fmt.Errorf("failed to list teams: %w", err)
+	}
+
+	var builder strings.Builder
+	for _, team := range teams {
+		channels, err := s.pluginAPI.Channel.ListForTeamForUser(team.Id, userID, false)
+		if err != nil {
+			return "failed to list channels", fmt.Errorf("failed to list channels of team %s: %w", team.Id, err)
+		}
+
+		builder.WriteString(fmt.Sprintf("Team %s (%s):\n", team.Name, team.DisplayName))
+		for _, channel := range channels {
+			if channel.Type != model.ChannelTypeOpen && channel.Type != model.ChannelTypePrivate {
+				continue
+			}
+			builder.WriteString(fmt.Sprintf("- %s (%s)\n", channel.Name, channel.DisplayName))
+		}
+		builder.WriteString("\n")
+	}
+
+	if builder.Len() == 0 {
+		return "The user is not a member of any team.", nil
+	}
+	return builder.String(), nil
+}
+
+func (s *Server) toolReadChannel(_ context.Context, llmContext *llm.Context, argsGetter llm.ToolArgumentGetter) (string, error) {
+	var args ReadChannelArgs
+	err := argsGetter(&args)
+	if err != nil {
+		return "invalid parameters to function", fmt.Errorf("failed to get arguments for tool ReadChannel: %w", err)
+	}
+
+	if args.Count <= 0 {
+		args.Count = defaultReadChannelCount
+	}
+	if args.Count > maxReadChannelCount {
+		args.Count = maxReadChannelCount
+	}
+
+	channel, err := s.pluginAPI.Channel.GetByNameForTeamName(args.Team, args.Channel, false)
+	if err != nil {
+		return "channel not found", fmt.Errorf("failed to get channel %s of team %s: %w", args.Channel, args.Team, err)
+	}
+
+	if !s.mmClient.HasPermissionToChannel(llmContext.RequestingUser.Id, channel.Id, model.PermissionReadChannel) {
+		// Don't tell apart channels the user can't read from channels that don't exist
+		return "channel not found", errors.New("user doesn't have permission to read channel")
+	}
+
+	posts, err := s.pluginAPI.Post.GetPostsForChannel(channel.Id, 0, args.Count)
+	if err != nil {
+		return "failed to read channel", fmt.Errorf("failed to get posts for channel: %w", err)
+	}
+
+	threadData, err := mmapi.GetMetadataForPosts(s.mmClient, posts)
+	if err != nil {
+		return "failed to read channel", fmt.Errorf("failed to get metadata for posts: %w", err)
+	}
+
+	if len(threadData.Posts) == 0 {
+		return "The channel has no messages.", nil
+	}
+	return format.ThreadData(threadData), nil
+}
+
+func (s *Server) toolReadThread(_ context.Context, llmContext *llm.Context, argsGetter llm.ToolArgumentGetter) (string, error) {
+	var args ReadThreadArgs
+	err := argsGetter(&args)
+	if err != nil {
+		return "invalid parameters to function", fmt.Errorf("failed to get arguments for tool ReadThread: %w", err)
+	}
+
+	post, _, err := s.readablePost(llmContext.RequestingUser.Id, args.PostID)
+	if err != nil {
+		return "thread not found", err
+	}
+
+	threadData, err := mmapi.GetThreadData(s.mmClient, post.Id)
+	if err != nil {
+		return "failed to read thread", fmt.Errorf("failed to get thread data: %w", err)
+	}
+
+	return format.ThreadData(threadData), nil
+}
+
+func (s *Server) toolSummarizeThread(ctx context.Context, llmContext *llm.Context, argsGetter llm.ToolArgumentGetter) (string, error) {
+	var args SummarizeThreadArgs
+	err := argsGetter(&args)
+	if err != nil {
+		return "invalid parameters to function", fmt.Errorf("failed to get arguments for tool SummarizeThread: %w", err)
+	}
+
+	if !s.licenseChecker.IsBasicsLicensed() {
+		return "thread summarization is not available on this server", errors.New("feature not licensed")
+	}
+
+	user := llmContext.RequestingUser
+	post, channel, err := s.readablePost(user.Id, args.PostID)
+	if err != nil {
+		return "thread not found", err
+	}
+
+	bot := s.bots.GetBotByUsernameOrFirst(s.config.GetDefaultBotName())
+	if bot == nil {
+		return "no AI bot is configured", errors.New("failed to get the default bot")
+	}
+
+	if err := s.bots.CheckUsageRestrictions(user.Id, bot, channel); err != nil {
+		return "the user is not allowed to use the AI bot in this channel", err
+	}
+	if err := s.bots.CheckQuota(bot, user.Id, channel); err != nil {
+		return "the AI usage quota has been exceeded", err
+	}
+
+	summaryContext := s.contextBuilder.BuildLLMContextUserRequest(bot, user, channel)
+	summaryStream, err := threads.New(bot.LLM(), s.prompts, s.mmClient).Summarize(ctx, post.Id, summaryContext)
+	if err != nil {
+		return "failed to summarize thread", fmt.Errorf("failed to summarize thread: %w", err)
+	}
+
+	summary, err := summaryStream.ReadAll()
+	if err != nil {
+		return "failed to summarize thread", fmt.Errorf("failed to read summary: %w", err)
+	}
+
+	return summary, nil
+}
+
+// readablePost returns the post and its channel when the user can read the channel
+func (s *Server) readablePost(userID, postID string) (*model.Post, *model.Channel, error) {
+	if !model.IsValidId(postID) {
+		return nil, nil, errors.New("invalid post ID")
+	}
+
+	post, err := s.mmClient.GetPost(postID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get post: %w", err)
+	}
+
+	if !s.mmClient.HasPermissionToChannel(userID, post.ChannelId, model.PermissionReadChannel) {
+		return nil, nil, errors.New("user doesn't have permission to read channel")
+	}
+
+	channel, err := s.mmClient.GetChannel(post.ChannelId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get channel: %w", err)
+	}
+
+	return post, channel, nil
+}
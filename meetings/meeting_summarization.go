@@ -198,8 +198,10 @@ func (s *Service) newCallTranscriptionSummaryThread(bot *bots.Bot, requestingUse
 			channel,
 			s.contextBuilder.WithLLMContextDefaultTools(bot, mmapi.IsDMWith(bot.GetMMBot().UserId, channel)),
 		)
-		summaryStream, err := s.SummarizeTranscription(bot, text, requestContext)
+		ctx, cancel := streaming.NewRequestContext(context.Background())
+		summaryStream, err := s.SummarizeTranscription(ctx, bot, text, requestContext)
 		if err != nil {
+			cancel()
 			return fmt.Errorf("unable to summarize transcription: %w", err)
 		}
 
@@ -209,7 +211,7 @@ func (s *Service) newCallTranscriptionSummaryThread(bot *bots.Bot, requestingUse
 			Message:   "",
 		}
 		summaryPost.AddProp(ReferencedTranscriptPostID, transcriptionPost.Id)
-		if err := s.streamingService.StreamToNewPost(context.Background(), bot.GetMMBot().UserId, requestingUser.Id, summaryStream, summaryPost, transcriptionPost.Id); err != nil {
+		if err := s.streamingService.StreamToNewPost(ctx, cancel, bot.GetMMBot().UserId, requestingUser.Id, summaryStream, summaryPost, transcriptionPost.Id); err != nil {
 			return fmt.Errorf("unable to stream result to post: %w", err)
 		}
 
@@ -253,13 +255,19 @@ func (s *Service) summarizeCallRecording(bot *bots.Bot, rootID string, requestin
 			return fmt.Errorf("unable to upload transcript: %w", err)
 		}
 
+		ctx, err := s.streamingService.GetStreamingContext(context.Background(), transcriptPost.Id)
+		if err != nil {
+			return fmt.Errorf("unable to get post streaming context: %w", err)
+		}
+		defer s.streamingService.FinishStreaming(transcriptPost.Id)
+
 		llmContext := s.contextBuilder.BuildLLMContextUserRequest(
 			bot,
 			requestingUser,
 			channel,
-			s.contextBuilder.WithLLMContextDefaultTools(bot, channel.Type == model.ChannelTypeDirect),
+			s.contextBuilder.WithLLMContextDefaultTools(bot, mmapi.IsDMWith(bot.GetMMBot().UserId, channel)),
 		)
-		summaryStream, err := s.SummarizeTranscription(bot, transcription, llmContext)
+		summaryStream, err := s.SummarizeTranscription(ctx, bot, transcription, llmContext)
 		if err != nil {
 			return fmt.Errorf("unable to summarize transcription: %w", err)
 		}
@@ -268,12 +276,6 @@ func (s *Service) summarizeCallRecording(bot *bots.Bot, rootID string, requestin
 			return fmt.Errorf("unable to update transcript post: %w", err)
 		}
 
-		ctx, err := s.streamingService.GetStreamingContext(context.Background(), transcriptPost.Id)
-		if err != nil {
-			return fmt.Errorf("unable to get post streaming context: %w", err)
-		}
-		defer s.streamingService.FinishStreaming(transcriptPost.Id)
-
 		s.streamingService.StreamToPost(ctx, summaryStream, transcriptPost, requestingUser.Locale)
 
 		return nil
@@ -282,7 +284,7 @@ func (s *Service) summarizeCallRecording(bot *bots.Bot, rootID string, requestin
 	return nil
 }
 
-func (s *Service) SummarizeTranscription(bot *bots.Bot, transcription *subtitles.Subtitles, context *llm.Context) (*llm.TextStreamResult, error) {
+func (s *Service) SummarizeTranscription(ctx context.Context, bot *bots.Bot, transcription *subtitles.Subtitles, context *llm.Context) (*llm.TextStreamResult, error) {
 	llmFormattedTranscription := transcription.FormatForLLM()
 	tokens := bot.LLM().CountTokens(llmFormattedTranscription)
 	tokenLimitWithMargin := int(float64(bot.LLM().InputTokenLimit())*0.75) - ContextTokenMargin
@@ -292,7 +294,9 @@ func (s *Service) SummarizeTranscription(bot *bots.Bot, transcription *subtitles
 	isChunked := false
 	if tokens > tokenLimitWithMargin {
 		s.pluginAPI.Log.Debug("Transcription too long, summarizing in chunks.", "tokens", tokens, "limit", tokenLimitWithMargin)
-		chunks := chunking.SplitPlaintextOnSentences(llmFormattedTranscription, tokenLimitWithMargin*4)
+		// Chunks are split by characters, use the characters per token of this transcription to size them
+		chunkSize := int(int64(tokenLimitWithMargin) * int64(len(llmFormattedTranscription)) / int64(tokens))
+		chunks := chunking.SplitPlaintextOnSentences(llmFormattedTranscription, chunkSize)
 		summarizedChunks := make([]string, 0, len(chunks))
 		s.pluginAPI.Log.Debug("Split into chunks", "chunks", len(chunks))
 		for _, chunk := range chunks {
@@ -314,7 +318,7 @@ func (s *Service) SummarizeTranscription(bot *bots.Bot, transcription *subtitles
 				Context: context,
 			}
 
-			summarizedChunk, err := bot.LLM().ChatCompletionNoStream(request)
+			summarizedChunk, err := bot.LLM().ChatCompletionNoStream(ctx, request)
 			if err != nil {
 				return nil, fmt.Errorf("unable to get summarized chunk: %w", err)
 			}
@@ -347,7 +351,7 @@ func (s *Service) SummarizeTranscription(bot *bots.Bot, transcription *subtitles
 		Context: context,
 	}
 
-	summaryStream, err := bot.LLM().ChatCompletion(completionRequest)
+	summaryStream, err := bot.LLM().ChatCompletion(ctx, completionRequest)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get meeting summary: %w", err)
 	}
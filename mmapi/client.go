@@ -6,6 +6,7 @@ package mmapi
 import (
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
@@ -20,6 +21,7 @@ type Client interface {
 	GetPostsBefore(channelID, postID string, page, perPage int) (*model.PostList, error)
 	CreatePost(post *model.Post) error
 	UpdatePost(post *model.Post) error
+	SendEphemeralPost(userID string, post *model.Post)
 	DM(senderID, receiverID string, post *model.Post) error
 	GetChannel(channelID string) (*model.Channel, error)
 	GetDirectChannel(userID1, userID2 string) (*model.Channel, error)
@@ -29,6 +31,7 @@ type Client interface {
 	LogWarn(msg string, keyValuePairs ...interface{})
 	KVGet(key string, value interface{}) error
 	KVSet(key string, value interface{}) error
+	KVSetWithOptions(key string, value interface{}, options model.PluginKVSetOptions) (bool, error)
 	GetUserByUsername(username string) (*model.User, error)
 	GetUserStatus(userID string) (*model.Status, error)
 	HasPermissionTo(userID string, permission *model.Permission) bool
@@ -88,6 +91,19 @@ func (m *client) KVSet(key string, value interface{}) error {
 	return err
 }
 
+// KVSetWithOptions sets a value like KVSet, options.OldValue is the value as stored, use nil for a key that
+// must not exist yet
+func (m *client) KVSetWithOptions(key string, value interface{}, options model.PluginKVSetOptions) (bool, error) {
+	var setOptions []pluginapi.KVSetOption
+	if options.Atomic {
+		setOptions = append(setOptions, pluginapi.SetAtomic(options.OldValue))
+	}
+	if options.ExpireInSeconds > 0 {
+		setOptions = append(setOptions, pluginapi.SetExpiry(time.Duration(options.ExpireInSeconds)*time.Second))
+	}
+	return m.pluginAPI.KV.Set(key, value, setOptions...)
+}
+
 func (m *client) GetUserByUsername(username string) (*model.User, error) {
 	return m.pluginAPI.User.GetByUsername(username)
 }
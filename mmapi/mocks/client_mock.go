@@ -1245,6 +1245,62 @@ func (_c *MockClient_KVSet_Call) RunAndReturn(run func(key string, value interfa
 	return _c
 }
 
+// KVSetWithOptions provides a mock function for the type MockClient
+func (_mock *MockClient) KVSetWithOptions(key string, value interface{}, options model.PluginKVSetOptions) (bool, error) {
+	ret := _mock.Called(key, value, options)
+
+	if len(ret) == 0 {
+		panic("no return value specified for KVSetWithOptions")
+	}
+
+	var r0 bool
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(string, interface{}, model.PluginKVSetOptions) (bool, error)); ok {
+		return returnFunc(key, value, options)
+	}
+	if returnFunc, ok := ret.Get(0).(func(string, interface{}, model.PluginKVSetOptions) bool); ok {
+		r0 = returnFunc(key, value, options)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+	if returnFunc, ok := ret.Get(1).(func(string, interface{}, model.PluginKVSetOptions) error); ok {
+		r1 = returnFunc(key, value, options)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockClient_KVSetWithOptions_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'KVSetWithOptions'
+type MockClient_KVSetWithOptions_Call struct {
+	*mock.Call
+}
+
+// KVSetWithOptions is a helper method to define mock.On call
+//   - key
+//   - value
+//   - options
+func (_e *MockClient_Expecter) KVSetWithOptions(key interface{}, value interface{}, options interface{}) *MockClient_KVSetWithOptions_Call {
+	return &MockClient_KVSetWithOptions_Call{Call: _e.mock.On("KVSetWithOptions", key, value, options)}
+}
+
+func (_c *MockClient_KVSetWithOptions_Call) Run(run func(key string, value interface{}, options model.PluginKVSetOptions)) *MockClient_KVSetWithOptions_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(interface{}), args[2].(model.PluginKVSetOptions))
+	})
+	return _c
+}
+
+func (_c *MockClient_KVSetWithOptions_Call) Return(b bool, err error) *MockClient_KVSetWithOptions_Call {
+	_c.Call.Return(b, err)
+	return _c
+}
+
+func (_c *MockClient_KVSetWithOptions_Call) RunAndReturn(run func(key string, value interface{}, options model.PluginKVSetOptions) (bool, error)) *MockClient_KVSetWithOptions_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // LogDebug provides a mock function for the type MockClient
 func (_mock *MockClient) LogDebug(msg string, keyValuePairs ...interface{}) {
 	if len(keyValuePairs) > 0 {
@@ -1454,6 +1510,41 @@ func (_c *MockClient_PublishWebSocketEvent_Call) RunAndReturn(run func(event str
 	return _c
 }
 
+// SendEphemeralPost provides a mock function for the type MockClient
+func (_mock *MockClient) SendEphemeralPost(userID string, post *model.Post) {
+	_mock.Called(userID, post)
+	return
+}
+
+// MockClient_SendEphemeralPost_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SendEphemeralPost'
+type MockClient_SendEphemeralPost_Call struct {
+	*mock.Call
+}
+
+// SendEphemeralPost is a helper method to define mock.On call
+//   - userID
+//   - post
+func (_e *MockClient_Expecter) SendEphemeralPost(userID interface{}, post interface{}) *MockClient_SendEphemeralPost_Call {
+	return &MockClient_SendEphemeralPost_Call{Call: _e.mock.On("SendEphemeralPost", userID, post)}
+}
+
+func (_c *MockClient_SendEphemeralPost_Call) Run(run func(userID string, post *model.Post)) *MockClient_SendEphemeralPost_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(*model.Post))
+	})
+	return _c
+}
+
+func (_c *MockClient_SendEphemeralPost_Call) Return() *MockClient_SendEphemeralPost_Call {
+	_c.Call.Return()
+	return _c
+}
+
+func (_c *MockClient_SendEphemeralPost_Call) RunAndReturn(run func(userID string, post *model.Post)) *MockClient_SendEphemeralPost_Call {
+	_c.Run(run)
+	return _c
+}
+
 // UpdatePost provides a mock function for the type MockClient
 func (_mock *MockClient) UpdatePost(post *model.Post) error {
 	ret := _mock.Called(post)
@@ -0,0 +1,91 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mmapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+const (
+	// responseCacheSequenceKey counts the responses ever cached, it picks the slot of the next response
+	responseCacheSequenceKey = "llm_cache_sequence"
+	// responseCacheSlotPrefix prefixes the slots holding the keys of the cached responses
+	responseCacheSlotPrefix = "llm_cache_slot_"
+)
+
+// KVResponseCache is an llm.ResponseCache backed by the plugin KV store. Entries expire after their TTL.
+// At most maxEntries responses are kept: each response takes the next of maxEntries slots in turn, evicting
+// the response that held the slot before.
+type KVResponseCache struct {
+	kv         *pluginapi.KVService
+	maxEntries int
+}
+
+func NewKVResponseCache(kv *pluginapi.KVService, maxEntries int) *KVResponseCache {
+	return &KVResponseCache{
+		kv:         kv,
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *KVResponseCache) Get(key string) (string, bool, error) {
+	var value []byte
+	if err := c.kv.Get(key, &value); err != nil {
+		return "", false, fmt.Errorf("failed to get cached response: %w", err)
+	}
+	if len(value) == 0 {
+		return "", false, nil
+	}
+	return string(value), true, nil
+}
+
+func (c *KVResponseCache) Set(key, value string, ttl time.Duration) error {
+	slot, err := c.nextSlot()
+	if err != nil {
+		return err
+	}
+
+	slotKey := responseCacheSlotPrefix + strconv.Itoa(slot)
+	var evicted string
+	if err := c.kv.Get(slotKey, &evicted); err != nil {
+		return fmt.Errorf("failed to get cache slot: %w", err)
+	}
+	if evicted != "" && evicted != key {
+		if err := c.kv.Delete(evicted); err != nil {
+			return fmt.Errorf("failed to evict cached response: %w", err)
+		}
+	}
+	if _, err := c.kv.Set(slotKey, key); err != nil {
+		return fmt.Errorf("failed to set cache slot: %w", err)
+	}
+
+	if _, err := c.kv.Set(key, []byte(value), pluginapi.SetExpiry(ttl)); err != nil {
+		return fmt.Errorf("failed to cache response: %w", err)
+	}
+	return nil
+}
+
+// nextSlot increments the sequence atomically, so concurrent requests and servers use different slots.
+func (c *KVResponseCache) nextSlot() (int, error) {
+	var sequence int64
+	err := c.kv.SetAtomicWithRetries(responseCacheSequenceKey, func(oldValue []byte) (any, error) {
+		sequence = 0
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &sequence); err != nil {
+				return nil, fmt.Errorf("failed to parse cache sequence: %w", err)
+			}
+		}
+		sequence++
+		return sequence, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get cache slot: %w", err)
+	}
+	return int(sequence % int64(c.maxEntries)), nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mmapi
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newMemoryKV returns a KV service backed by a map
+func newMemoryKV(t *testing.T) (*pluginapi.KVService, map[string][]byte) {
+	store := map[string][]byte{}
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+
+	api.On("KVGet", mock.AnythingOfType("string")).Return(func(key string) []byte {
+		return store[key]
+	}, nil)
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(func(key string, value []byte, options model.PluginKVSetOptions) bool {
+		if options.Atomic && !bytes.Equal(store[key], options.OldValue) {
+			return false
+		}
+		if value == nil {
+			delete(store, key)
+			return true
+		}
+		store[key] = value
+		return true
+	}, nil)
+
+	client := pluginapi.NewClient(api, nil)
+	return &client.KV, store
+}
+
+func TestKVResponseCache(t *testing.T) {
+	t.Run("responses are cached", func(t *testing.T) {
+		kv, _ := newMemoryKV(t)
+		cache := NewKVResponseCache(kv, 10)
+
+		_, found, err := cache.Get("llm_cache_a")
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		require.NoError(t, cache.Set("llm_cache_a", "answer", time.Hour))
+		value, found, err := cache.Get("llm_cache_a")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "answer", value)
+	})
+
+	t.Run("oldest responses are evicted over the limit", func(t *testing.T) {
+		kv, store := newMemoryKV(t)
+		cache := NewKVResponseCache(kv, 2)
+
+		require.NoError(t, cache.Set("llm_cache_a", "1", time.Hour))
+		require.NoError(t, cache.Set("llm_cache_b", "2", time.Hour))
+		require.NoError(t, cache.Set("llm_cache_c", "3", time.Hour))
+
+		_, found, err := cache.Get("llm_cache_a")
+		require.NoError(t, err)
+		assert.False(t, found)
+		for _, key := range []string{"llm_cache_b", "llm_cache_c"} {
+			_, found, err = cache.Get(key)
+			require.NoError(t, err)
+			assert.True(t, found, key)
+		}
+
+		// The sequence and one slot per entry
+		assert.Len(t, store, 5)
+	})
+
+	t.Run("the limit is shared by every cache", func(t *testing.T) {
+		kv, _ := newMemoryKV(t)
+		require.NoError(t, NewKVResponseCache(kv, 1).Set("llm_cache_a", "1", time.Hour))
+		require.NoError(t, NewKVResponseCache(kv, 1).Set("llm_cache_b", "2", time.Hour))
+
+		_, found, err := NewKVResponseCache(kv, 1).Get("llm_cache_a")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+}
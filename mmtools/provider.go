@@ -5,6 +5,7 @@ package mmtools
 
 import (
 	"net/http"
+	"slices"
 
 	"github.com/mattermost/mattermost-plugin-ai/bots"
 	"github.com/mattermost/mattermost-plugin-ai/llm"
@@ -34,52 +35,75 @@ func NewMMToolProvider(pluginAPI mmapi.Client, search *search.Search, httpClient
 	}
 }
 
-// GetTools returns the available tools based on context
+// GetTools returns the available tools based on context. Outside of DMs only the tools enabled for channels
+// in the bot's configuration are returned, none without a bot.
 func (p *MMToolProvider) GetTools(isDM bool, bot *bots.Bot) []llm.Tool {
-	builtInTools := []llm.Tool{}
-
+	builtInTools := p.getAllTools()
 	if isDM {
-		// Add search tool if search service is available
-		if p.search != nil {
-			builtInTools = append(builtInTools, llm.Tool{
-				Name:        "SearchServer",
-				Description: "Search the Mattermost chat server the user is on for messages using semantic search. Use this tool whenever the user asks a question and you don't have the context to answer or you think your response would be more accurate with knowledge from the Mattermost server",
-				Schema:      llm.NewJSONSchemaFromStruct(SearchServerArgs{}),
-				Resolver:    p.toolSearchServer,
-			})
+		return builtInTools
+	}
+	if bot == nil {
+		return []llm.Tool{}
+	}
+
+	channelTools := []llm.Tool{}
+	for _, tool := range builtInTools {
+		if slices.Contains(bot.GetConfig().ChannelTools, tool.Name) {
+			channelTools = append(channelTools, tool)
 		}
+	}
+	return channelTools
+}
 
-		// Add user lookup tool if pluginAPI is available
-		if p.pluginAPI != nil {
-			builtInTools = append(builtInTools, llm.Tool{
-				Name:        "LookupMattermostUser",
-				Description: "Lookup a Mattermost user by their username. Available information includes: username, full name, email, nickname, position, locale, timezone, last activity, and status.",
-				Schema:      llm.NewJSONSchemaFromStruct(LookupMattermostUserArgs{}),
-				Resolver:    p.toolResolveLookupMattermostUser,
-			})
+func (p *MMToolProvider) getAllTools() []llm.Tool {
+	builtInTools := []llm.Tool{}
 
-			// Add GitHub tool if plugin is available
-			status, err := p.pluginAPI.GetPluginStatus("github")
-			if err == nil && status != nil && status.State == model.PluginStateRunning {
-				builtInTools = append(builtInTools, llm.Tool{
-					Name:        "GetGithubIssue",
-					Description: "Retrieve a single GitHub issue by owner, repo, and issue number.",
-					Schema:      llm.NewJSONSchemaFromStruct(GetGithubIssueArgs{}),
-					Resolver:    p.toolGetGithubIssue,
-				})
-			}
-		}
+	// Add search tool if search service is available
+	if p.search != nil {
+		builtInTools = append(builtInTools, llm.Tool{
+			Name:        "SearchServer",
+			Description: "Search the Mattermost chat server the user is on for messages using semantic search. Use this tool whenever the user asks a question and you don't have the context to answer or you think your response would be more accurate with knowledge from the Mattermost server",
+			Schema:      llm.NewJSONSchemaFromStruct(SearchServerArgs{}),
+			Resolver:    p.toolSearchServer,
+			ReadOnly:    true,
+			// Outside of DMs the results are limited to what every member of the channel can read
+			ChannelSafe: true,
+		})
+	}
+
+	// Add user lookup tool if pluginAPI is available
+	if p.pluginAPI != nil {
+		builtInTools = append(builtInTools, llm.Tool{
+			Name:        "LookupMattermostUser",
+			Description: "Lookup a Mattermost user by their username. Available information includes: username, full name, email, nickname, position, locale, timezone, last activity, and status.",
+			Schema:      llm.NewJSONSchemaFromStruct(LookupMattermostUserArgs{}),
+			Resolver:    p.toolResolveLookupMattermostUser,
+			ReadOnly:    true,
+		})
 
-		// Add Jira tool if httpClient is available
-		if p.httpClient != nil {
+		// Add GitHub tool if plugin is available
+		status, err := p.pluginAPI.GetPluginStatus("github")
+		if err == nil && status != nil && status.State == model.PluginStateRunning {
 			builtInTools = append(builtInTools, llm.Tool{
-				Name:        "GetJiraIssue",
-				Description: "Retrieve a single Jira issue by issue key.",
-				Schema:      llm.NewJSONSchemaFromStruct(GetJiraIssueArgs{}),
-				Resolver:    p.toolGetJiraIssue,
+				Name:        "GetGithubIssue",
+				Description: "Retrieve a single GitHub issue by owner, repo, and issue number.",
+				Schema:      llm.NewJSONSchemaFromStruct(GetGithubIssueArgs{}),
+				Resolver:    p.toolGetGithubIssue,
+				ReadOnly:    true,
 			})
 		}
 	}
 
+	// Add Jira tool if httpClient is available
+	if p.httpClient != nil {
+		builtInTools = append(builtInTools, llm.Tool{
+			Name:        "GetJiraIssue",
+			Description: "Retrieve a single Jira issue by issue key.",
+			Schema:      llm.NewJSONSchemaFromStruct(GetJiraIssueArgs{}),
+			Resolver:    p.toolGetJiraIssue,
+			ReadOnly:    true,
+		})
+	}
+
 	return builtInTools
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/mattermost/mattermost-plugin-ai/embeddings"
 	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/mmapi"
 	"github.com/mattermost/mattermost/server/public/model"
 )
 
@@ -53,12 +54,40 @@ func (p *MMToolProvider) toolSearchServer(llmContext *llm.Context, argsGetter ll
 		return "there was an error performing the search", fmt.Errorf("search failed: %w", err)
 	}
 
+	// Outside of the DM with the bot other members of the channel see the response, drop what they might not be able to read
+	visibleResults := make([]embeddings.SearchResult, 0, len(searchResults))
+	for _, result := range searchResults {
+		if p.visibleInChannel(llmContext, result.Document.ChannelID) {
+			visibleResults = append(visibleResults, result)
+		}
+	}
+
 	// Format the results
-	formatted := p.formatSearchResults(searchResults, llmContext.RequestingUser.Id)
+	formatted := p.formatSearchResults(visibleResults, llmContext.RequestingUser.Id)
 
 	return formatted, nil
 }
 
+// visibleInChannel reports whether content from channelID can be shown in the channel of the request. In the
+// DM with the bot only the requester sees it, the search already checked their permissions. Elsewhere the
+// content must come from the channel itself or, outside of DMs and group messages, from a public channel of
+// its team.
+func (p *MMToolProvider) visibleInChannel(llmContext *llm.Context, channelID string) bool {
+	requestChannel := llmContext.Channel
+	if requestChannel == nil || requestChannel.Id == channelID || mmapi.IsDMWith(llmContext.BotUserID, requestChannel) {
+		return true
+	}
+	if requestChannel.Type == model.ChannelTypeDirect || requestChannel.Type == model.ChannelTypeGroup {
+		return false
+	}
+
+	channel, err := p.pluginAPI.GetChannel(channelID)
+	if err != nil {
+		return false
+	}
+	return channel.Type == model.ChannelTypeOpen && channel.TeamId == requestChannel.TeamId
+}
+
 // formatSearchResults formats search results into a readable string
 func (p *MMToolProvider) formatSearchResults(results []embeddings.SearchResult, requestingUserID string) string {
 	if len(results) == 0 {
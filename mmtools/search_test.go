@@ -0,0 +1,82 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package mmtools
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/mmapi/mocks"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVisibleInChannel(t *testing.T) {
+	botID := model.NewId()
+	requesterID := model.NewId()
+	otherUserID := model.NewId()
+	resultChannelID := model.NewId()
+
+	tests := []struct {
+		name           string
+		requestChannel *model.Channel
+		resultChannel  *model.Channel
+		expected       bool
+	}{
+		{
+			name:           "DM with the bot",
+			requestChannel: &model.Channel{Id: model.NewId(), Type: model.ChannelTypeDirect, Name: model.GetDMNameFromIds(botID, requesterID)},
+			expected:       true,
+		},
+		{
+			name:           "DM between two users",
+			requestChannel: &model.Channel{Id: model.NewId(), Type: model.ChannelTypeDirect, Name: model.GetDMNameFromIds(otherUserID, requesterID)},
+			expected:       false,
+		},
+		{
+			name:           "DM between two users with results of the DM itself",
+			requestChannel: &model.Channel{Id: resultChannelID, Type: model.ChannelTypeDirect, Name: model.GetDMNameFromIds(otherUserID, requesterID)},
+			expected:       true,
+		},
+		{
+			name:           "group message",
+			requestChannel: &model.Channel{Id: model.NewId(), Type: model.ChannelTypeGroup},
+			expected:       false,
+		},
+		{
+			name:           "public channel of the same team",
+			requestChannel: &model.Channel{Id: model.NewId(), Type: model.ChannelTypeOpen, TeamId: "team1"},
+			resultChannel:  &model.Channel{Id: resultChannelID, Type: model.ChannelTypeOpen, TeamId: "team1"},
+			expected:       true,
+		},
+		{
+			name:           "private channel of the same team",
+			requestChannel: &model.Channel{Id: model.NewId(), Type: model.ChannelTypeOpen, TeamId: "team1"},
+			resultChannel:  &model.Channel{Id: resultChannelID, Type: model.ChannelTypePrivate, TeamId: "team1"},
+			expected:       false,
+		},
+		{
+			name:           "public channel of another team",
+			requestChannel: &model.Channel{Id: model.NewId(), Type: model.ChannelTypeOpen, TeamId: "team1"},
+			resultChannel:  &model.Channel{Id: resultChannelID, Type: model.ChannelTypeOpen, TeamId: "team2"},
+			expected:       false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := mocks.NewMockClient(t)
+			if tc.resultChannel != nil {
+				client.EXPECT().GetChannel(resultChannelID).Return(tc.resultChannel, nil)
+			}
+			provider := NewMMToolProvider(client, nil, nil)
+
+			llmContext := llm.NewContext()
+			llmContext.BotUserID = botID
+			llmContext.Channel = tc.requestChannel
+
+			assert.Equal(t, tc.expected, provider.visibleInChannel(llmContext, resultChannelID))
+		})
+	}
+}
@@ -0,0 +1,531 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+// Package ollama implements the LanguageModel interface on top of Ollama's native API.
+// Using /api/chat directly rather than the OpenAI compatible shim keeps tool calls intact
+// and lets us discover the real context window of the model through /api/show.
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/tokenizer"
+)
+
+const (
+	// DefaultInputTokenLimit is used when the context window can not be discovered.
+	// It matches the default num_ctx of recent Ollama releases.
+	DefaultInputTokenLimit = 4096
+
+	// showTimeout bounds the lookups of model details made outside of a request
+	showTimeout = 10 * time.Second
+	// showRetryDelay is how long a failed lookup of model details is reused before asking Ollama again
+	showRetryDelay = time.Minute
+)
+
+type Ollama struct {
+	httpClient       *http.Client
+	apiURL           string
+	defaultModel     string
+	inputTokenLimit  int
+	outputTokenLimit int
+	tokenizer        llm.Tokenizer
+
+	modelsMu sync.Mutex
+	models   map[string]modelDetails
+}
+
+// modelDetails is the cached result of /api/show for a model
+type modelDetails struct {
+	show      showResponse
+	err       error
+	fetchedAt time.Time
+}
+
+func New(llmService llm.ServiceConfig, httpClient *http.Client) *Ollama {
+	return &Ollama{
+		httpClient:       httpClient,
+		apiURL:           strings.TrimSuffix(llmService.APIURL, "/"),
+		defaultModel:     llmService.DefaultModel,
+		inputTokenLimit:  llmService.InputTokenLimit,
+		outputTokenLimit: llmService.OutputTokenLimit,
+		tokenizer:        tokenizer.ForModel(llmService.DefaultModel),
+		models:           make(map[string]modelDetails),
+	}
+}
+
+type message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Images    []string   `json:"images,omitempty"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+}
+
+type toolCall struct {
+	ID       string           `json:"id,omitempty"`
+	Function toolCallFunction `json:"function"`
+}
+
+type toolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type tool struct {
+	Type     string       `json:"type"`
+	Function toolFunction `json:"function"`
+}
+
+type toolFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parameters  any    `json:"parameters"`
+}
+
+type chatRequest struct {
+	Model    string         `json:"model"`
+	Messages []message      `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Tools    []tool         `json:"tools,omitempty"`
+	Format   any            `json:"format,omitempty"`
+	Options  map[string]any `json:"options,omitempty"`
+}
+
+type chatResponse struct {
+	Message    message `json:"message"`
+	Done       bool    `json:"done"`
+	DoneReason string  `json:"done_reason"`
+	Error      string  `json:"error"`
+}
+
+type showRequest struct {
+	Model string `json:"model"`
+}
+
+type showResponse struct {
+	Parameters string         `json:"parameters"`
+	ModelInfo  map[string]any `json:"model_info"`
+	// Capabilities is only reported by Ollama 0.6.4 and later
+	Capabilities []string `json:"capabilities"`
+}
+
+// isValidImageType checks if the MIME type can be decoded by Ollama's vision models
+func isValidImageType(mimeType string) bool {
+	return mimeType == "image/jpeg" || mimeType == "image/png"
+}
+
+// conversationToMessages converts the conversation posts to Ollama chat messages.
+func conversationToMessages(posts []llm.Post) []message {
+	result := make([]message, 0, len(posts))
+
+	for _, post := range posts {
+		msg := message{
+			Role:    "user",
+			Content: post.Message,
+		}
+		switch post.Role {
+		case llm.PostRoleBot:
+			msg.Role = "assistant"
+		case llm.PostRoleSystem:
+			msg.Role = "system"
+		}
+
+		for _, file := range post.Files {
+			if !isValidImageType(file.MimeType) {
+				msg.Content += fmt.Sprintf("\n[Unsupported image type: %s]", file.MimeType)
+				continue
+			}
+			data, err := io.ReadAll(file.Reader)
+			if err != nil {
+				msg.Content += "\n[Error reading image data]"
+				continue
+			}
+			msg.Images = append(msg.Images, base64.StdEncoding.EncodeToString(data))
+		}
+
+		for _, tc := range post.ToolUse {
+			arguments := tc.Arguments
+			if len(arguments) == 0 {
+				arguments = json.RawMessage("{}")
+			}
+			msg.ToolCalls = append(msg.ToolCalls, toolCall{
+				Function: toolCallFunction{
+					Name:      tc.Name,
+					Arguments: arguments,
+				},
+			})
+		}
+
+		result = append(result, msg)
+
+		// Tool results are sent back as separate messages following the assistant's request
+		for _, tc := range post.ToolUse {
+			result = append(result, message{
+				Role:     "tool",
+				Content:  tc.Result,
+				ToolName: tc.Name,
+			})
+		}
+	}
+
+	return result
+}
+
+func convertTools(tools []llm.Tool) []tool {
+	converted := make([]tool, 0, len(tools))
+	for _, t := range tools {
+		converted = append(converted, tool{
+			Type: "function",
+			Function: toolFunction{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  t.Schema,
+			},
+		})
+	}
+	return converted
+}
+
+func (o *Ollama) GetDefaultConfig() llm.LanguageModelConfig {
+	return llm.LanguageModelConfig{
+		Model:              o.defaultModel,
+		MaxGeneratedTokens: o.outputTokenLimit,
+	}
+}
+
+func (o *Ollama) createConfig(opts []llm.LanguageModelOption) llm.LanguageModelConfig {
+	cfg := o.GetDefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func (o *Ollama) chatRequestFromConfig(ctx context.Context, cfg llm.LanguageModelConfig) chatRequest {
+	request := chatRequest{
+		Model:  cfg.Model,
+		Stream: true,
+		Options: map[string]any{
+			// Ollama silently truncates prompts to num_ctx so make sure it matches what we truncate to.
+			"num_ctx": o.contextWindow(ctx, cfg.Model),
+		},
+	}
+
+	if cfg.MaxGeneratedTokens > 0 {
+		request.Options["num_predict"] = cfg.MaxGeneratedTokens
+	}
+
+	if cfg.Temperature != nil {
+		request.Options["temperature"] = *cfg.Temperature
+	}
+	if cfg.TopP != nil {
+		request.Options["top_p"] = *cfg.TopP
+	}
+	if len(cfg.StopSequences) > 0 {
+		request.Options["stop"] = cfg.StopSequences
+	}
+	if cfg.Seed != nil {
+		request.Options["seed"] = *cfg.Seed
+	}
+	if cfg.PresencePenalty != nil {
+		request.Options["presence_penalty"] = *cfg.PresencePenalty
+	}
+	if cfg.FrequencyPenalty != nil {
+		request.Options["frequency_penalty"] = *cfg.FrequencyPenalty
+	}
+
+	if cfg.JSONOutputFormat != nil {
+		request.Format = llm.NewJSONSchemaFromStruct(cfg.JSONOutputFormat)
+	}
+
+	return request
+}
+
+func (o *Ollama) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.apiURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		message := strings.TrimSpace(string(respBody))
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != "" {
+			message = errResp.Error
+		}
+		return nil, fmt.Errorf("ollama request failed: %w", &llm.UpstreamError{StatusCode: resp.StatusCode, Header: resp.Header, Body: message})
+	}
+
+	return resp, nil
+}
+
+func (o *Ollama) streamChat(ctx context.Context, request chatRequest, output chan<- llm.TextStreamEvent) {
+	resp, err := o.post(ctx, "/api/chat", request)
+	if err != nil {
+		output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeError,
+			Value: err,
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	pendingToolCalls := []llm.ToolCall{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk chatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeError,
+				Value: fmt.Errorf("failed to parse ollama response: %w", err),
+			}
+			return
+		}
+
+		if chunk.Error != "" {
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeError,
+				Value: fmt.Errorf("error from ollama stream: %s", chunk.Error),
+			}
+			return
+		}
+
+		if chunk.Message.Content != "" {
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeText,
+				Value: chunk.Message.Content,
+			}
+		}
+
+		// Ollama sends complete tool calls rather than streaming their arguments
+		for _, tc := range chunk.Message.ToolCalls {
+			id := tc.ID
+			if id == "" {
+				id = "call_" + strconv.Itoa(len(pendingToolCalls))
+			}
+			pendingToolCalls = append(pendingToolCalls, llm.ToolCall{
+				ID:        id,
+				Name:      tc.Function.Name,
+				Arguments: tc.Function.Arguments,
+			})
+		}
+
+		if chunk.Done {
+			if len(pendingToolCalls) > 0 {
+				output <- llm.TextStreamEvent{
+					Type:  llm.EventTypeToolCalls,
+					Value: pendingToolCalls,
+				}
+				return
+			}
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeEnd,
+				Value: nil,
+			}
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		output <- llm.TextStreamEvent{
+			Type:  llm.EventTypeError,
+			Value: fmt.Errorf("error reading ollama stream: %w", err),
+		}
+		return
+	}
+
+	output <- llm.TextStreamEvent{
+		Type:  llm.EventTypeError,
+		Value: errors.New("ollama stream ended unexpectedly"),
+	}
+}
+
+func (o *Ollama) ChatCompletion(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
+	chatReq := o.chatRequestFromConfig(ctx, o.createConfig(opts))
+	chatReq.Messages = conversationToMessages(request.Posts)
+	if request.Context != nil && request.Context.Tools != nil {
+		chatReq.Tools = convertTools(request.Context.Tools.GetTools())
+	}
+
+	eventStream := make(chan llm.TextStreamEvent)
+	go func() {
+		defer close(eventStream)
+		o.streamChat(ctx, chatReq, eventStream)
+	}()
+
+	return &llm.TextStreamResult{Stream: eventStream}, nil
+}
+
+func (o *Ollama) ChatCompletionNoStream(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
+	// This could perform better if we didn't use the streaming API here, but the complexity is not worth it.
+	result, err := o.ChatCompletion(ctx, request, opts...)
+	if err != nil {
+		return "", err
+	}
+	return result.ReadAll()
+}
+
+func (o *Ollama) CountTokens(text string) int {
+	return o.tokenizer.CountTokens(text)
+}
+
+// InputTokenLimit returns the configured limit if set, otherwise the context window of the default model
+// reported by /api/show.
+func (o *Ollama) InputTokenLimit() int {
+	if o.inputTokenLimit > 0 {
+		return o.inputTokenLimit
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), showTimeout)
+	defer cancel()
+	return o.contextWindow(ctx, o.defaultModel)
+}
+
+// contextWindow returns the number of tokens of context to run model with: the context length the model
+// reports, capped by the configured limit. The limit, or DefaultInputTokenLimit, is used when the model
+// doesn't report it.
+func (o *Ollama) contextWindow(ctx context.Context, model string) int {
+	contextLength, err := o.contextLength(ctx, model)
+	if err != nil || contextLength <= 0 {
+		if o.inputTokenLimit > 0 {
+			return o.inputTokenLimit
+		}
+		return DefaultInputTokenLimit
+	}
+	if o.inputTokenLimit > 0 {
+		return min(contextLength, o.inputTokenLimit)
+	}
+	return contextLength
+}
+
+// contextLength returns the context window of a model.
+// A num_ctx set in the Modelfile takes precedence over the trained context length since it is what the
+// administrator chose to run the model with.
+func (o *Ollama) contextLength(ctx context.Context, model string) (int, error) {
+	show, err := o.modelDetails(ctx, model)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(show.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			if numCtx, err := strconv.Atoi(fields[1]); err == nil && numCtx > 0 {
+				return numCtx, nil
+			}
+		}
+	}
+
+	for key, value := range show.ModelInfo {
+		if !strings.HasSuffix(key, ".context_length") {
+			continue
+		}
+		if length, ok := value.(float64); ok && length > 0 {
+			return int(length), nil
+		}
+	}
+
+	return 0, errors.New("context length not reported by model")
+}
+
+// modelDetails returns the details of a model, fetched once per model. Failures are cached for showRetryDelay
+// so an unreachable server doesn't add a lookup to every request, unless the lookup was canceled by ctx.
+func (o *Ollama) modelDetails(ctx context.Context, model string) (showResponse, error) {
+	o.modelsMu.Lock()
+	cached, ok := o.models[model]
+	o.modelsMu.Unlock()
+	if ok && (cached.err == nil || time.Since(cached.fetchedAt) < showRetryDelay) {
+		return cached.show, cached.err
+	}
+
+	show, err := o.show(ctx, model)
+	if err != nil && ctx.Err() != nil {
+		return showResponse{}, err
+	}
+
+	o.modelsMu.Lock()
+	o.models[model] = modelDetails{show: show, err: err, fetchedAt: time.Now()}
+	o.modelsMu.Unlock()
+
+	return show, err
+}
+
+// show fetches the details of a model.
+func (o *Ollama) show(ctx context.Context, model string) (showResponse, error) {
+	resp, err := o.post(ctx, "/api/show", showRequest{Model: model})
+	if err != nil {
+		return showResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var show showResponse
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return showResponse{}, fmt.Errorf("failed to decode show response: %w", err)
+	}
+	return show, nil
+}
+
+// Capabilities reports what the model supports according to /api/show. When Ollama is too old to report it, or
+// can't be reached, the model is trusted to support what the bot is configured to use.
+func (o *Ollama) Capabilities() llm.Capabilities {
+	result := llm.Capabilities{
+		Vision:        true,
+		Tools:         true,
+		JSONOutput:    true,
+		Streaming:     true,
+		ContextWindow: o.InputTokenLimit(),
+	}
+
+	if reported, ok := o.modelCapabilities(); ok {
+		result.Vision = slices.Contains(reported, "vision")
+		result.Tools = slices.Contains(reported, "tools")
+	}
+
+	return result
+}
+
+func (o *Ollama) modelCapabilities() ([]string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), showTimeout)
+	defer cancel()
+	show, err := o.modelDetails(ctx, o.defaultModel)
+	if err != nil {
+		return nil, false
+	}
+	return show.Capabilities, show.Capabilities != nil
+}
@@ -0,0 +1,356 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+)
+
+type fakeOllama struct {
+	showResponse   string
+	showStatus     int
+	modelResponses map[string]string
+	chatResponses  []string
+	lastChat       chatRequest
+	showCalls      int
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/show":
+		f.showCalls++
+		if f.showStatus != 0 {
+			w.WriteHeader(f.showStatus)
+			_, _ = w.Write([]byte(`{"error":"unavailable"}`))
+			return
+		}
+		var show showRequest
+		_ = json.NewDecoder(r.Body).Decode(&show)
+		if response, ok := f.modelResponses[show.Model]; ok {
+			_, _ = w.Write([]byte(response))
+			return
+		}
+		_, _ = w.Write([]byte(f.showResponse))
+	case "/api/chat":
+		if err := json.NewDecoder(r.Body).Decode(&f.lastChat); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, line := range f.chatResponses {
+			_, _ = w.Write([]byte(line + "\n"))
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"not found"}`))
+	}
+}
+
+type lookupArgs struct {
+	Username string
+}
+
+func newTestOllama(t *testing.T, fake *fakeOllama, cfg llm.ServiceConfig) *Ollama {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	cfg.APIURL = server.URL + "/"
+	if cfg.DefaultModel == "" {
+		cfg.DefaultModel = "llama3.1"
+	}
+	return New(cfg, server.Client())
+}
+
+func TestChatCompletionStreamsText(t *testing.T) {
+	fake := &fakeOllama{
+		showResponse: `{"model_info":{"llama.context_length":131072}}`,
+		chatResponses: []string{
+			`{"message":{"role":"assistant","content":"Hello"},"done":false}`,
+			`{"message":{"role":"assistant","content":" world"},"done":false}`,
+			`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`,
+		},
+	}
+	o := newTestOllama(t, fake, llm.ServiceConfig{OutputTokenLimit: 256})
+
+	result, err := o.ChatCompletion(context.Background(), llm.CompletionRequest{
+		Posts: []llm.Post{
+			{Role: llm.PostRoleSystem, Message: "You are helpful"},
+			{Role: llm.PostRoleUser, Message: "Hi"},
+		},
+		Context: llm.NewContext(),
+	})
+	require.NoError(t, err)
+
+	text, err := result.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello world", text)
+
+	assert.Equal(t, "llama3.1", fake.lastChat.Model)
+	assert.True(t, fake.lastChat.Stream)
+	assert.Equal(t, float64(131072), fake.lastChat.Options["num_ctx"])
+	assert.Equal(t, float64(256), fake.lastChat.Options["num_predict"])
+	require.Len(t, fake.lastChat.Messages, 2)
+	assert.Equal(t, "system", fake.lastChat.Messages[0].Role)
+	assert.Equal(t, "user", fake.lastChat.Messages[1].Role)
+}
+
+func TestChatCompletionToolCalls(t *testing.T) {
+	fake := &fakeOllama{
+		showResponse: `{"model_info":{"llama.context_length":8192}}`,
+		chatResponses: []string{
+			`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"LookupMattermostUser","arguments":{"username":"alice"}}}]},"done":false}`,
+			`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`,
+		},
+	}
+	o := newTestOllama(t, fake, llm.ServiceConfig{})
+
+	tools := llm.NewToolStore(nil, false)
+	tools.AddTools([]llm.Tool{{
+		Name:        "LookupMattermostUser",
+		Description: "Lookup a user",
+		Schema:      llm.NewJSONSchemaFromStruct(lookupArgs{}),
+	}})
+
+	result, err := o.ChatCompletion(context.Background(), llm.CompletionRequest{
+		Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Who is alice?"}},
+		Context: llm.NewContext(func(c *llm.Context) { c.Tools = tools }),
+	})
+	require.NoError(t, err)
+
+	var toolCalls []llm.ToolCall
+	for event := range result.Stream {
+		if event.Type == llm.EventTypeToolCalls {
+			toolCalls = event.Value.([]llm.ToolCall)
+		}
+		require.NotEqual(t, llm.EventTypeError, event.Type, "unexpected error: %v", event.Value)
+	}
+
+	require.Len(t, toolCalls, 1)
+	assert.Equal(t, "LookupMattermostUser", toolCalls[0].Name)
+	assert.NotEmpty(t, toolCalls[0].ID)
+	assert.JSONEq(t, `{"username":"alice"}`, string(toolCalls[0].Arguments))
+
+	require.Len(t, fake.lastChat.Tools, 1)
+	assert.Equal(t, "function", fake.lastChat.Tools[0].Type)
+	assert.Equal(t, "LookupMattermostUser", fake.lastChat.Tools[0].Function.Name)
+}
+
+func TestChatCompletionStreamError(t *testing.T) {
+	fake := &fakeOllama{
+		showResponse: `{"model_info":{}}`,
+		chatResponses: []string{
+			`{"message":{"role":"assistant","content":"partial"},"done":false}`,
+			`{"error":"model ran out of memory"}`,
+		},
+	}
+	o := newTestOllama(t, fake, llm.ServiceConfig{InputTokenLimit: 1000})
+
+	result, err := o.ChatCompletion(context.Background(), llm.CompletionRequest{
+		Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+		Context: llm.NewContext(),
+	})
+	require.NoError(t, err)
+
+	_, err = result.ReadAll()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "model ran out of memory")
+}
+
+func TestChatCompletionUnavailable(t *testing.T) {
+	fake := &fakeOllama{showStatus: http.StatusServiceUnavailable}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/chat" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":"server busy"}`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	o := New(llm.ServiceConfig{APIURL: server.URL, DefaultModel: "llama3.1"}, server.Client())
+
+	result, err := o.ChatCompletion(context.Background(), llm.CompletionRequest{
+		Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+		Context: llm.NewContext(),
+	})
+	require.NoError(t, err)
+
+	_, err = result.ReadAll()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server busy")
+	assert.True(t, llm.IsRetryableError(err))
+}
+
+func TestInputTokenLimit(t *testing.T) {
+	t.Run("configured limit wins", func(t *testing.T) {
+		fake := &fakeOllama{showResponse: `{"model_info":{"llama.context_length":131072}}`}
+		o := newTestOllama(t, fake, llm.ServiceConfig{InputTokenLimit: 2000})
+		assert.Equal(t, 2000, o.InputTokenLimit())
+		assert.Equal(t, 0, fake.showCalls)
+	})
+
+	t.Run("discovered from model info and cached", func(t *testing.T) {
+		fake := &fakeOllama{showResponse: `{"model_info":{"general.architecture":"qwen2","qwen2.context_length":32768}}`}
+		o := newTestOllama(t, fake, llm.ServiceConfig{})
+		assert.Equal(t, 32768, o.InputTokenLimit())
+		assert.Equal(t, 32768, o.InputTokenLimit())
+		assert.Equal(t, 1, fake.showCalls)
+	})
+
+	t.Run("num_ctx from Modelfile parameters takes precedence", func(t *testing.T) {
+		fake := &fakeOllama{showResponse: `{"parameters":"stop \"<|eot_id|>\"\nnum_ctx 16384","model_info":{"llama.context_length":131072}}`}
+		o := newTestOllama(t, fake, llm.ServiceConfig{})
+		assert.Equal(t, 16384, o.InputTokenLimit())
+	})
+
+	t.Run("falls back to default when not reported", func(t *testing.T) {
+		fake := &fakeOllama{showResponse: `{"model_info":{}}`}
+		o := newTestOllama(t, fake, llm.ServiceConfig{})
+		assert.Equal(t, DefaultInputTokenLimit, o.InputTokenLimit())
+	})
+
+	t.Run("failures are cached", func(t *testing.T) {
+		fake := &fakeOllama{showStatus: http.StatusServiceUnavailable}
+		o := newTestOllama(t, fake, llm.ServiceConfig{})
+		assert.Equal(t, DefaultInputTokenLimit, o.InputTokenLimit())
+		assert.Equal(t, DefaultInputTokenLimit, o.InputTokenLimit())
+		assert.Equal(t, 1, fake.showCalls)
+	})
+
+	t.Run("canceled lookups are not cached", func(t *testing.T) {
+		fake := &fakeOllama{showResponse: `{"model_info":{"llama.context_length":8192}}`}
+		o := newTestOllama(t, fake, llm.ServiceConfig{})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, DefaultInputTokenLimit, o.contextWindow(ctx, "llama3.1"))
+		assert.Equal(t, 8192, o.InputTokenLimit())
+	})
+}
+
+func TestChatCompletionContextWindow(t *testing.T) {
+	chatResponses := []string{`{"message":{"role":"assistant","content":"Hi"},"done":true}`}
+
+	t.Run("discovered for the model of the request", func(t *testing.T) {
+		fake := &fakeOllama{
+			showResponse:   `{"model_info":{"llama.context_length":8192}}`,
+			modelResponses: map[string]string{"qwen2.5": `{"model_info":{"qwen2.context_length":32768}}`},
+			chatResponses:  chatResponses,
+		}
+		o := newTestOllama(t, fake, llm.ServiceConfig{})
+
+		result, err := o.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		}, llm.WithModel("qwen2.5"))
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.NoError(t, err)
+
+		assert.Equal(t, "qwen2.5", fake.lastChat.Model)
+		assert.Equal(t, float64(32768), fake.lastChat.Options["num_ctx"])
+	})
+
+	t.Run("capped by the configured limit", func(t *testing.T) {
+		fake := &fakeOllama{
+			showResponse:  `{"model_info":{"llama.context_length":131072}}`,
+			chatResponses: chatResponses,
+		}
+		o := newTestOllama(t, fake, llm.ServiceConfig{InputTokenLimit: 16000})
+
+		result, err := o.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.NoError(t, err)
+
+		assert.Equal(t, float64(16000), fake.lastChat.Options["num_ctx"])
+	})
+
+	t.Run("limited to what the model supports", func(t *testing.T) {
+		fake := &fakeOllama{
+			showResponse:  `{"model_info":{"llama.context_length":8192}}`,
+			chatResponses: chatResponses,
+		}
+		o := newTestOllama(t, fake, llm.ServiceConfig{InputTokenLimit: 16000})
+
+		result, err := o.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		_, err = result.ReadAll()
+		require.NoError(t, err)
+
+		assert.Equal(t, float64(8192), fake.lastChat.Options["num_ctx"])
+	})
+}
+
+func TestCapabilities(t *testing.T) {
+	t.Run("reported by the model", func(t *testing.T) {
+		fake := &fakeOllama{showResponse: `{"capabilities":["completion","vision"]}`}
+		o := newTestOllama(t, fake, llm.ServiceConfig{InputTokenLimit: 2000})
+		capabilities := o.Capabilities()
+		assert.True(t, capabilities.Vision)
+		assert.False(t, capabilities.Tools)
+		assert.Equal(t, 2000, capabilities.ContextWindow)
+
+		o.Capabilities()
+		assert.Equal(t, 1, fake.showCalls)
+	})
+
+	t.Run("everything is assumed supported when not reported", func(t *testing.T) {
+		fake := &fakeOllama{showResponse: `{"model_info":{}}`}
+		o := newTestOllama(t, fake, llm.ServiceConfig{InputTokenLimit: 2000})
+		capabilities := o.Capabilities()
+		assert.True(t, capabilities.Vision)
+		assert.True(t, capabilities.Tools)
+	})
+}
+
+func TestConversationToMessages(t *testing.T) {
+	posts := []llm.Post{
+		{Role: llm.PostRoleSystem, Message: "system"},
+		{
+			Role:    llm.PostRoleUser,
+			Message: "Look at this",
+			Files: []llm.File{
+				{MimeType: "image/png", Reader: bytes.NewReader([]byte("image-1"))},
+				{MimeType: "image/tiff", Reader: bytes.NewReader([]byte("image-2"))},
+			},
+		},
+		{
+			Role: llm.PostRoleBot,
+			ToolUse: []llm.ToolCall{
+				{ID: "1", Name: "SearchServer", Arguments: json.RawMessage(`{"term":"roadmap"}`), Result: "found it", Status: llm.ToolCallStatusSuccess},
+			},
+		},
+	}
+
+	messages := conversationToMessages(posts)
+	require.Len(t, messages, 4)
+
+	assert.Equal(t, message{Role: "system", Content: "system"}, messages[0])
+
+	assert.Equal(t, "user", messages[1].Role)
+	assert.Equal(t, "Look at this\n[Unsupported image type: image/tiff]", messages[1].Content)
+	assert.Equal(t, []string{"aW1hZ2UtMQ=="}, messages[1].Images)
+
+	assert.Equal(t, "assistant", messages[2].Role)
+	require.Len(t, messages[2].ToolCalls, 1)
+	assert.Equal(t, "SearchServer", messages[2].ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"term":"roadmap"}`, string(messages[2].ToolCalls[0].Function.Arguments))
+
+	assert.Equal(t, message{Role: "tool", Content: "found it", ToolName: "SearchServer"}, messages[3])
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	openaiClient "github.com/sashabaranov/go-openai"
+)
+
+// modelsResponse is the model list returned by OpenAI and compatible servers. OpenAI doesn't report context
+// lengths, vLLM reports them as max_model_len and OpenRouter and others as context_length.
+type modelsResponse struct {
+	Data []struct {
+		ID            string `json:"id"`
+		MaxModelLen   int    `json:"max_model_len"`
+		ContextLength int    `json:"context_length"`
+	} `json:"data"`
+}
+
+// azureDeploymentsResponse is the deployment list of an Azure OpenAI resource
+type azureDeploymentsResponse struct {
+	Data []struct {
+		ID     string `json:"id"`
+		Model  string `json:"model"`
+		Status string `json:"status"`
+	} `json:"data"`
+}
+
+// azureDeploymentsAPIVersion is the data plane API version listing the deployments of a resource, later
+// versions only list them through the management API
+const azureDeploymentsAPIVersion = "2022-12-01"
+
+// ListModels returns the models available to the API key. Azure requests go to a deployment rather than a model,
+// so the deployments of the resource are listed instead.
+func (s *OpenAI) ListModels(ctx context.Context) ([]llm.ModelInfo, error) {
+	if s.azure {
+		return s.listAzureDeployments(ctx)
+	}
+
+	headers := http.Header{}
+	if s.config.APIKey != "" {
+		headers.Set("Authorization", "Bearer "+s.config.APIKey)
+	}
+	if s.config.OrgID != "" {
+		headers.Set("OpenAI-Organization", s.config.OrgID)
+	}
+
+	var models modelsResponse
+	if err := s.getModels(ctx, strings.TrimSuffix(s.baseURL, "/")+"/models", headers, &models); err != nil {
+		return nil, err
+	}
+
+	// The context windows of OpenAI models are known, the models behind a compatible server could be anything
+	knownModels := s.baseURL == openaiClient.DefaultConfig("").BaseURL
+
+	result := make([]llm.ModelInfo, 0, len(models.Data))
+	for _, model := range models.Data {
+		info := llm.ModelInfo{
+			ID:            model.ID,
+			ContextWindow: max(model.MaxModelLen, model.ContextLength),
+		}
+		if info.ContextWindow == 0 && knownModels {
+			info.ContextWindow = modelInputTokenLimit(model.ID)
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	return result, nil
+}
+
+// listAzureDeployments returns the deployments of the Azure resource that are ready to take requests, the
+// context window being the one of the model deployed
+func (s *OpenAI) listAzureDeployments(ctx context.Context) ([]llm.ModelInfo, error) {
+	headers := http.Header{}
+	headers.Set("api-key", s.config.APIKey)
+
+	var deployments azureDeploymentsResponse
+	url := strings.TrimSuffix(s.baseURL, "/") + "/openai/deployments?api-version=" + azureDeploymentsAPIVersion
+	if err := s.getModels(ctx, url, headers, &deployments); err != nil {
+		return nil, err
+	}
+
+	result := make([]llm.ModelInfo, 0, len(deployments.Data))
+	for _, deployment := range deployments.Data {
+		if deployment.Status != "" && deployment.Status != "succeeded" {
+			continue
+		}
+		result = append(result, llm.ModelInfo{
+			ID:            deployment.ID,
+			ContextWindow: modelInputTokenLimit(azureModelName(deployment.Model)),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	return result, nil
+}
+
+// azureModelName returns the OpenAI name of a model as Azure names it, Azure drops the dot of gpt-3.5
+func azureModelName(model string) string {
+	return strings.Replace(model, "gpt-35", "gpt-3.5", 1)
+}
+
+// getModels sends a request listing models to the provider and decodes its response into value
+func (s *OpenAI) getModels(ctx context.Context, url string, headers http.Header, value any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = headers
+
+	httpClient := s.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to list models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4*1024))
+		return fmt.Errorf("failed to list models: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		return fmt.Errorf("failed to decode models response: %w", err)
+	}
+	return nil
+}
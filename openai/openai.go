@@ -11,6 +11,7 @@ import (
 	"image"
 	"image/png"
 	"io"
+	"math"
 	"net/http"
 	"strings"
 	"time"
@@ -19,25 +20,34 @@ import (
 
 	"github.com/mattermost/mattermost-plugin-ai/llm"
 	"github.com/mattermost/mattermost-plugin-ai/subtitles"
+	"github.com/mattermost/mattermost-plugin-ai/tokenizer"
 	openaiClient "github.com/sashabaranov/go-openai"
 )
 
 type Config struct {
-	APIKey              string        `json:"apiKey"`
-	APIURL              string        `json:"apiURL"`
-	OrgID               string        `json:"orgID"`
-	DefaultModel        string        `json:"defaultModel"`
-	InputTokenLimit     int           `json:"inputTokenLimit"`
-	OutputTokenLimit    int           `json:"outputTokenLimit"`
-	StreamingTimeout    time.Duration `json:"streamingTimeout"`
-	SendUserID          bool          `json:"sendUserID"`
-	EmbeddingModel      string        `json:"embeddingModel"`
-	EmbeddingDimentions int           `json:"embeddingDimensions"`
+	APIKey           string        `json:"apiKey"`
+	APIURL           string        `json:"apiURL"`
+	OrgID            string        `json:"orgID"`
+	DefaultModel     string        `json:"defaultModel"`
+	InputTokenLimit  int           `json:"inputTokenLimit"`
+	OutputTokenLimit int           `json:"outputTokenLimit"`
+	StreamingTimeout time.Duration `json:"streamingTimeout"`
+	SendUserID       bool          `json:"sendUserID"`
+	// ModelSupportsVision declares that the model behind an OpenAI compatible endpoint accepts images
+	ModelSupportsVision bool   `json:"modelSupportsVision"`
+	EmbeddingModel      string `json:"embeddingModel"`
+	EmbeddingDimentions int    `json:"embeddingDimensions"`
 }
 
 type OpenAI struct {
-	client *openaiClient.Client
-	config Config
+	client     *openaiClient.Client
+	config     Config
+	tokenizer  llm.Tokenizer
+	vision     bool
+	jsonOutput bool
+	httpClient *http.Client
+	baseURL    string
+	azure      bool
 }
 
 const (
@@ -48,33 +58,44 @@ const (
 var ErrStreamingTimeout = errors.New("timeout streaming")
 
 func NewAzure(config Config, httpClient *http.Client) *OpenAI {
-	return newOpenAI(config, httpClient,
+	result := newOpenAI(config, httpClient,
 		func(apiKey string) openaiClient.ClientConfig {
 			clientConfig := openaiClient.DefaultAzureConfig(apiKey, strings.TrimSuffix(config.APIURL, "/"))
-			clientConfig.APIVersion = "2024-06-01"
+			// 2024-10-21 is the first GA version supporting stream_options.include_usage
+			clientConfig.APIVersion = "2024-10-21"
 			return clientConfig
 		},
 	)
+	result.vision = !isTextOnlyModel(config.DefaultModel)
+	result.jsonOutput = true
+	return result
 }
 
 func NewCompatible(config Config, httpClient *http.Client) *OpenAI {
-	return newOpenAI(config, httpClient,
+	result := newOpenAI(config, httpClient,
 		func(apiKey string) openaiClient.ClientConfig {
 			clientConfig := openaiClient.DefaultConfig(apiKey)
 			clientConfig.BaseURL = strings.TrimSuffix(config.APIURL, "/")
 			return clientConfig
 		},
 	)
+	// What the model behind a compatible endpoint supports can't be discovered, the administrator declares it.
+	// Many compatible servers reject the json_schema response format, so the schema is only given in the prompt.
+	result.vision = config.ModelSupportsVision
+	return result
 }
 
 func New(config Config, httpClient *http.Client) *OpenAI {
-	return newOpenAI(config, httpClient,
+	result := newOpenAI(config, httpClient,
 		func(apiKey string) openaiClient.ClientConfig {
 			clientConfig := openaiClient.DefaultConfig(apiKey)
 			clientConfig.OrgID = config.OrgID
 			return clientConfig
 		},
 	)
+	result.vision = !isTextOnlyModel(config.DefaultModel)
+	result.jsonOutput = true
+	return result
 }
 
 // NewEmbeddings creates a new OpenAI client configured only for embeddings functionality
@@ -116,8 +137,12 @@ func newOpenAI(
 	clientConfig.HTTPClient = httpClient
 
 	return &OpenAI{
-		client: openaiClient.NewClientWithConfig(clientConfig),
-		config: config,
+		client:     openaiClient.NewClientWithConfig(clientConfig),
+		config:     config,
+		tokenizer:  tokenizer.ForModel(config.DefaultModel),
+		httpClient: httpClient,
+		baseURL:    clientConfig.BaseURL,
+		azure:      clientConfig.APIType == openaiClient.APITypeAzure,
 	}
 }
 
@@ -242,10 +267,10 @@ type ToolBufferElement struct {
 	args strings.Builder
 }
 
-func (s *OpenAI) streamResultToChannels(request openaiClient.ChatCompletionRequest, llmContext *llm.Context, output chan<- llm.TextStreamEvent) {
+func (s *OpenAI) streamResultToChannels(ctx context.Context, request openaiClient.ChatCompletionRequest, llmContext *llm.Context, output chan<- llm.TextStreamEvent) {
 	request.Stream = true
 
-	ctx, cancel := context.WithCancelCause(context.Background())
+	ctx, cancel := context.WithCancelCause(ctx)
 	defer cancel(nil)
 
 	// watchdog to cancel if the streaming stalls
@@ -289,13 +314,25 @@ func (s *OpenAI) streamResultToChannels(request openaiClient.ChatCompletionReque
 
 	// Buffering in the case of tool use
 	var toolsBuffer map[int]*ToolBufferElement
+
+	// With stream_options.include_usage the usage comes in a last chunk after the one with the finish reason,
+	// so the final event is held until the stream is done.
+	var usage *openaiClient.Usage
+	var finishReason openaiClient.FinishReason
+	finalEvent := llm.TextStreamEvent{
+		Type:  llm.EventTypeEnd,
+		Value: nil,
+	}
 	for {
 		response, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
-			output <- llm.TextStreamEvent{
-				Type:  llm.EventTypeEnd,
-				Value: nil,
+			if usage != nil {
+				output <- llm.TextStreamEvent{
+					Type:  llm.EventTypeUsage,
+					Value: usageFromOpenAI(*usage, finishReason),
+				}
 			}
+			output <- finalEvent
 			return
 		}
 		if err != nil {
@@ -316,6 +353,14 @@ func (s *OpenAI) streamResultToChannels(request openaiClient.ChatCompletionReque
 		// Ping the watchdog when we receive a response
 		watchdog <- struct{}{}
 
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+
+		if len(response.Choices) == 0 {
+			continue
+		}
+
 		delta := response.Choices[0].Delta
 		numTools := len(delta.ToolCalls)
 
@@ -338,21 +383,29 @@ func (s *OpenAI) streamResultToChannels(request openaiClient.ChatCompletionReque
 			}
 		}
 
-		if len(response.Choices) == 0 {
-			continue
+		// Compatible servers such as vLLM and DeepSeek stream the reasoning separately from the answer
+		if response.Choices[0].Delta.ReasoningContent != "" {
+			output <- llm.TextStreamEvent{
+				Type:  llm.EventTypeReasoning,
+				Value: response.Choices[0].Delta.ReasoningContent,
+			}
 		}
 
-		// Check finishing conditions
-		switch response.Choices[0].FinishReason {
-		case "":
-			// Not done yet, keep going
-		case openaiClient.FinishReasonStop:
+		if response.Choices[0].Delta.Content != "" {
 			output <- llm.TextStreamEvent{
-				Type:  llm.EventTypeEnd,
-				Value: nil,
+				Type:  llm.EventTypeText,
+				Value: response.Choices[0].Delta.Content,
 			}
-			return
-		case openaiClient.FinishReasonToolCalls:
+		}
+
+		// Check finishing conditions
+		if response.Choices[0].FinishReason == "" {
+			// Not done yet, keep going
+			continue
+		}
+		finishReason = response.Choices[0].FinishReason
+
+		if finishReason == openaiClient.FinishReasonToolCalls {
 			// Verify OpenAI functions are not recursing too deep.
 			numFunctionCalls := 0
 			for i := len(request.Messages) - 1; i >= 0; i-- {
@@ -371,58 +424,43 @@ func (s *OpenAI) streamResultToChannels(request openaiClient.ChatCompletionReque
 			}
 
 			// Transfer the buffered tools into tool calls
-			tools := []openaiClient.ToolCall{}
-			for i, tool := range toolsBuffer {
-				name := tool.name.String()
-				arguments := tool.args.String()
-				toolID := tool.id.String()
-				num := i
-				tools = append(tools, openaiClient.ToolCall{
-					Function: openaiClient.FunctionCall{
-						Name:      name,
-						Arguments: arguments,
-					},
-					ID:    toolID,
-					Index: &num,
-					Type:  openaiClient.ToolTypeFunction,
-				})
-			}
-
-			// Send tool calls event and end the stream
-			pendingToolCalls := make([]llm.ToolCall, 0, len(tools))
-			for _, tool := range tools {
+			pendingToolCalls := make([]llm.ToolCall, 0, len(toolsBuffer))
+			for _, tool := range toolsBuffer {
 				pendingToolCalls = append(pendingToolCalls, llm.ToolCall{
-					ID:          tool.ID,
-					Name:        tool.Function.Name,
+					ID:          tool.id.String(),
+					Name:        tool.name.String(),
 					Description: "", // OpenAI doesn't provide description in the response
-					Arguments:   []byte(tool.Function.Arguments),
+					Arguments:   []byte(tool.args.String()),
 				})
 			}
 
-			output <- llm.TextStreamEvent{
+			// Send the tool calls instead of ending the stream
+			finalEvent = llm.TextStreamEvent{
 				Type:  llm.EventTypeToolCalls,
 				Value: pendingToolCalls,
 			}
-			return
-		default:
-			fmt.Printf("Unknown finish reason: %s", response.Choices[0].FinishReason)
-			return
 		}
+	}
+}
 
-		if response.Choices[0].Delta.Content != "" {
-			output <- llm.TextStreamEvent{
-				Type:  llm.EventTypeText,
-				Value: response.Choices[0].Delta.Content,
-			}
-		}
+// usageFromOpenAI converts the usage reported by OpenAI, cached tokens are included in the prompt tokens.
+func usageFromOpenAI(usage openaiClient.Usage, finishReason openaiClient.FinishReason) llm.Usage {
+	result := llm.Usage{
+		InputTokens:  int64(usage.PromptTokens),
+		OutputTokens: int64(usage.CompletionTokens),
+		FinishReason: string(finishReason),
+	}
+	if usage.PromptTokensDetails != nil {
+		result.CachedTokens = int64(usage.PromptTokensDetails.CachedTokens)
 	}
+	return result
 }
 
-func (s *OpenAI) streamResult(request openaiClient.ChatCompletionRequest, llmContext *llm.Context) (*llm.TextStreamResult, error) {
+func (s *OpenAI) streamResult(ctx context.Context, request openaiClient.ChatCompletionRequest, llmContext *llm.Context) (*llm.TextStreamResult, error) {
 	eventStream := make(chan llm.TextStreamEvent)
 	go func() {
 		defer close(eventStream)
-		s.streamResultToChannels(request, llmContext, eventStream)
+		s.streamResultToChannels(ctx, request, llmContext, eventStream)
 	}()
 
 	return &llm.TextStreamResult{Stream: eventStream}, nil
@@ -448,7 +486,33 @@ func (s *OpenAI) completionRequestFromConfig(cfg llm.LanguageModelConfig) openai
 	request := openaiClient.ChatCompletionRequest{
 		Model: cfg.Model,
 	}
-	request.MaxTokens = cfg.MaxGeneratedTokens
+
+	// Reasoning models reject max_tokens, their limit includes the reasoning tokens. They also only support
+	// the default sampling and no stop sequences.
+	if cfg.ReasoningEffort != "" || isReasoningModel(cfg.Model) {
+		request.MaxCompletionTokens = cfg.MaxGeneratedTokens
+		request.ReasoningEffort = cfg.ReasoningEffort
+	} else {
+		request.MaxTokens = cfg.MaxGeneratedTokens
+		if cfg.Temperature != nil {
+			// A zero temperature is omitted from the request, send the closest value instead
+			request.Temperature = max(float32(*cfg.Temperature), math.SmallestNonzeroFloat32)
+		}
+		if cfg.TopP != nil {
+			request.TopP = max(float32(*cfg.TopP), math.SmallestNonzeroFloat32)
+		}
+		if cfg.PresencePenalty != nil {
+			request.PresencePenalty = float32(*cfg.PresencePenalty)
+		}
+		if cfg.FrequencyPenalty != nil {
+			request.FrequencyPenalty = float32(*cfg.FrequencyPenalty)
+		}
+		request.Stop = cfg.StopSequences
+	}
+	if cfg.Seed != nil {
+		seed := int(*cfg.Seed)
+		request.Seed = &seed
+	}
 
 	if cfg.JSONOutputFormat != nil {
 		request.ResponseFormat = &openaiClient.ChatCompletionResponseFormat{
@@ -464,21 +528,47 @@ func (s *OpenAI) completionRequestFromConfig(cfg llm.LanguageModelConfig) openai
 	return request
 }
 
-func (s *OpenAI) ChatCompletion(request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
+// textOnlyModelPrefixes are the OpenAI models that don't accept images
+var textOnlyModelPrefixes = []string{"gpt-3.5", "o1-mini", "o3-mini"}
+
+func isTextOnlyModel(model string) bool {
+	for _, prefix := range textOnlyModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var reasoningModelPrefixes = []string{"o1", "o3", "o4", "gpt-5"}
+
+func isReasoningModel(model string) bool {
+	for _, prefix := range reasoningModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *OpenAI) ChatCompletion(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
 	openAIRequest := s.completionRequestFromConfig(s.createConfig(opts))
 	openAIRequest = modifyCompletionRequestWithRequest(openAIRequest, request)
 	openAIRequest.Stream = true
+	openAIRequest.StreamOptions = &openaiClient.StreamOptions{
+		IncludeUsage: true,
+	}
 	if s.config.SendUserID {
 		if request.Context.RequestingUser != nil {
 			openAIRequest.User = request.Context.RequestingUser.Id
 		}
 	}
-	return s.streamResult(openAIRequest, request.Context)
+	return s.streamResult(ctx, openAIRequest, request.Context)
 }
 
-func (s *OpenAI) ChatCompletionNoStream(request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
+func (s *OpenAI) ChatCompletionNoStream(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
 	// This could perform better if we didn't use the streaming API here, but the complexity is not worth it.
-	result, err := s.ChatCompletion(request, opts...)
+	result, err := s.ChatCompletion(ctx, request, opts...)
 	if err != nil {
 		return "", err
 	}
@@ -532,12 +622,7 @@ func (s *OpenAI) GenerateImage(prompt string) (image.Image, error) {
 }
 
 func (s *OpenAI) CountTokens(text string) int {
-	// Counting tokens is really annoying, so we approximate for now.
-	charCount := float64(len(text)) / 4.0
-	wordCount := float64(len(strings.Fields(text))) / 0.75
-
-	// Average the two
-	return int((charCount + wordCount) / 2.0)
+	return s.tokenizer.CountTokens(text)
 }
 
 func (s *OpenAI) InputTokenLimit() int {
@@ -545,27 +630,43 @@ func (s *OpenAI) InputTokenLimit() int {
 		return s.config.InputTokenLimit
 	}
 
+	return modelInputTokenLimit(s.config.DefaultModel)
+}
+
+// modelInputTokenLimit returns the context window of an OpenAI model.
+func modelInputTokenLimit(model string) int {
 	switch {
-	case strings.HasPrefix(s.config.DefaultModel, "gpt-4o"),
-		strings.HasPrefix(s.config.DefaultModel, "o1-preview"),
-		strings.HasPrefix(s.config.DefaultModel, "o1-mini"),
-		strings.HasPrefix(s.config.DefaultModel, "gpt-4-turbo"),
-		strings.HasPrefix(s.config.DefaultModel, "gpt-4-0125-preview"),
-		strings.HasPrefix(s.config.DefaultModel, "gpt-4-1106-preview"):
+	case strings.HasPrefix(model, "gpt-4o"),
+		strings.HasPrefix(model, "o1-preview"),
+		strings.HasPrefix(model, "o1-mini"),
+		strings.HasPrefix(model, "gpt-4-turbo"),
+		strings.HasPrefix(model, "gpt-4-0125-preview"),
+		strings.HasPrefix(model, "gpt-4-1106-preview"):
 		return 128000
-	case strings.HasPrefix(s.config.DefaultModel, "gpt-4"):
+	case strings.HasPrefix(model, "gpt-4"):
 		return 8192
-	case strings.HasPrefix(s.config.DefaultModel, "gpt-3.5-turbo"),
-		s.config.DefaultModel == "gpt-3.5-turbo-0125",
-		s.config.DefaultModel == "gpt-3.5-turbo-1106":
+	case strings.HasPrefix(model, "gpt-3.5-turbo"),
+		model == "gpt-3.5-turbo-0125",
+		model == "gpt-3.5-turbo-1106":
 		return 16385
-	case s.config.DefaultModel == "gpt-3.5-turbo-instruct":
+	case model == "gpt-3.5-turbo-instruct":
 		return 4096
 	}
 
 	return 128000 // Default fallback
 }
 
+func (s *OpenAI) Capabilities() llm.Capabilities {
+	return llm.Capabilities{
+		Vision:        s.vision,
+		Tools:         true,
+		JSONOutput:    s.jsonOutput,
+		Streaming:     true,
+		MaxImageSize:  OpenAIMaxImageSize,
+		ContextWindow: s.InputTokenLimit(),
+	}
+}
+
 func (s *OpenAI) CreateEmbedding(ctx context.Context, text string) ([]float32, error) {
 	resp, err := s.client.CreateEmbeddings(ctx, openaiClient.EmbeddingRequest{
 		Input:      []string{text},
@@ -0,0 +1,280 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeStreamServer(t *testing.T, chunks []string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, map[string]any{"include_usage": true}, body["stream_options"])
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+func collectEvents(t *testing.T, result *llm.TextStreamResult) []llm.TextStreamEvent {
+	t.Helper()
+	var events []llm.TextStreamEvent
+	for event := range result.Stream {
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestStreamingUsage(t *testing.T) {
+	t.Run("usage is reported before the end of the stream", func(t *testing.T) {
+		server := newFakeStreamServer(t, []string{
+			`{"choices":[{"index":0,"delta":{"content":"Hello"}}]}`,
+			`{"choices":[{"index":0,"delta":{"content":" world"},"finish_reason":"stop"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":2,"total_tokens":14,"prompt_tokens_details":{"cached_tokens":8}}}`,
+		})
+		defer server.Close()
+
+		client := NewCompatible(Config{APIURL: server.URL, DefaultModel: "test", StreamingTimeout: time.Second}, server.Client())
+		result, err := client.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []llm.TextStreamEvent{
+			{Type: llm.EventTypeText, Value: "Hello"},
+			{Type: llm.EventTypeText, Value: " world"},
+			{Type: llm.EventTypeUsage, Value: llm.Usage{InputTokens: 12, OutputTokens: 2, CachedTokens: 8, FinishReason: "stop"}},
+			{Type: llm.EventTypeEnd},
+		}, collectEvents(t, result))
+	})
+
+	t.Run("tool calls are sent after the usage", func(t *testing.T) {
+		server := newFakeStreamServer(t, []string{
+			`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{\"q\":"}}]}}]}`,
+			`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"x\"}"}}]},"finish_reason":"tool_calls"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":30,"completion_tokens":5,"total_tokens":35}}`,
+		})
+		defer server.Close()
+
+		client := NewCompatible(Config{APIURL: server.URL, DefaultModel: "test", StreamingTimeout: time.Second}, server.Client())
+		result, err := client.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+
+		events := collectEvents(t, result)
+		require.Len(t, events, 2)
+		assert.Equal(t, llm.TextStreamEvent{Type: llm.EventTypeUsage, Value: llm.Usage{InputTokens: 30, OutputTokens: 5, FinishReason: "tool_calls"}}, events[0])
+		assert.Equal(t, llm.EventTypeToolCalls, events[1].Type)
+		assert.Equal(t, []llm.ToolCall{{ID: "call_1", Name: "lookup", Arguments: []byte(`{"q":"x"}`)}}, events[1].Value)
+	})
+
+	t.Run("servers that don't report usage still end the stream", func(t *testing.T) {
+		server := newFakeStreamServer(t, []string{
+			`{"choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":"length"}]}`,
+		})
+		defer server.Close()
+
+		client := NewCompatible(Config{APIURL: server.URL, DefaultModel: "test", StreamingTimeout: time.Second}, server.Client())
+		text, err := client.ChatCompletionNoStream(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Hi", text)
+	})
+}
+
+func TestReasoning(t *testing.T) {
+	t.Run("reasoning models get max_completion_tokens and the reasoning effort", func(t *testing.T) {
+		client := New(Config{DefaultModel: "o3-mini", OutputTokenLimit: 1000}, nil)
+		request := client.completionRequestFromConfig(client.createConfig([]llm.LanguageModelOption{llm.WithReasoningParams(llm.ReasoningParams{ReasoningEffort: "high"})}))
+		assert.Equal(t, 0, request.MaxTokens)
+		assert.Equal(t, 1000, request.MaxCompletionTokens)
+		assert.Equal(t, "high", request.ReasoningEffort)
+
+		client = New(Config{DefaultModel: "gpt-5", OutputTokenLimit: 1000}, nil)
+		request = client.completionRequestFromConfig(client.createConfig(nil))
+		assert.Equal(t, 0, request.MaxTokens)
+		assert.Equal(t, 1000, request.MaxCompletionTokens)
+		assert.Empty(t, request.ReasoningEffort)
+	})
+
+	t.Run("other models get max_tokens", func(t *testing.T) {
+		client := New(Config{DefaultModel: "gpt-4o", OutputTokenLimit: 1000}, nil)
+		request := client.completionRequestFromConfig(client.createConfig(nil))
+		assert.Equal(t, 1000, request.MaxTokens)
+		assert.Equal(t, 0, request.MaxCompletionTokens)
+	})
+
+	t.Run("reasoning content is streamed apart from the answer", func(t *testing.T) {
+		server := newFakeStreamServer(t, []string{
+			`{"choices":[{"index":0,"delta":{"reasoning_content":"Thinking"}}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"Answer"},"finish_reason":"stop"}]}`,
+		})
+		defer server.Close()
+
+		client := NewCompatible(Config{APIURL: server.URL, DefaultModel: "deepseek-reasoner", StreamingTimeout: time.Second}, server.Client())
+		result, err := client.ChatCompletion(context.Background(), llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []llm.TextStreamEvent{
+			{Type: llm.EventTypeReasoning, Value: "Thinking"},
+			{Type: llm.EventTypeText, Value: "Answer"},
+			{Type: llm.EventTypeEnd},
+		}, collectEvents(t, result))
+	})
+}
+
+func TestSamplingParams(t *testing.T) {
+	opts := []llm.LanguageModelOption{
+		llm.WithTemperature(0),
+		llm.WithTopP(0.9),
+		llm.WithStopSequences("END"),
+		llm.WithSeed(42),
+		llm.WithPresencePenalty(0.5),
+		llm.WithFrequencyPenalty(0.25),
+	}
+
+	t.Run("parameters are sent", func(t *testing.T) {
+		client := New(Config{DefaultModel: "gpt-4o"}, nil)
+		request := client.completionRequestFromConfig(client.createConfig(opts))
+
+		// A zero temperature would be omitted from the request
+		assert.Positive(t, request.Temperature)
+		assert.Less(t, request.Temperature, float32(0.001))
+		assert.Equal(t, float32(0.9), request.TopP)
+		assert.Equal(t, []string{"END"}, request.Stop)
+		require.NotNil(t, request.Seed)
+		assert.Equal(t, 42, *request.Seed)
+		assert.Equal(t, float32(0.5), request.PresencePenalty)
+		assert.Equal(t, float32(0.25), request.FrequencyPenalty)
+	})
+
+	t.Run("reasoning models only get the seed", func(t *testing.T) {
+		client := New(Config{DefaultModel: "o3"}, nil)
+		request := client.completionRequestFromConfig(client.createConfig(opts))
+
+		assert.Zero(t, request.Temperature)
+		assert.Zero(t, request.TopP)
+		assert.Zero(t, request.PresencePenalty)
+		assert.Zero(t, request.FrequencyPenalty)
+		assert.Empty(t, request.Stop)
+		assert.NotNil(t, request.Seed)
+	})
+}
+
+func TestCancellation(t *testing.T) {
+	t.Run("cancelling the context aborts the upstream request", func(t *testing.T) {
+		requestDone := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello\"}}]}\n\n")
+			w.(http.Flusher).Flush()
+
+			<-r.Context().Done()
+			close(requestDone)
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		client := NewCompatible(Config{APIURL: server.URL, DefaultModel: "test", StreamingTimeout: time.Minute}, server.Client())
+		result, err := client.ChatCompletion(ctx, llm.CompletionRequest{
+			Posts:   []llm.Post{{Role: llm.PostRoleUser, Message: "Hi"}},
+			Context: llm.NewContext(),
+		})
+		require.NoError(t, err)
+
+		first := <-result.Stream
+		assert.Equal(t, llm.TextStreamEvent{Type: llm.EventTypeText, Value: "Hello"}, first)
+
+		cancel()
+
+		events := collectEvents(t, result)
+		require.NotEmpty(t, events)
+		last := events[len(events)-1]
+		assert.Equal(t, llm.EventTypeError, last.Type)
+		assert.ErrorIs(t, last.Value.(error), context.Canceled)
+
+		select {
+		case <-requestDone:
+		case <-time.After(5 * time.Second):
+			t.Fatal("upstream request was not cancelled")
+		}
+	})
+}
+
+func TestListModels(t *testing.T) {
+	t.Run("compatible server reporting context lengths", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v1/models", r.URL.Path)
+			assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{"data":[{"id":"qwen2.5","max_model_len":32768},{"id":"llama3.1"}]}`)
+		}))
+		defer server.Close()
+
+		s := NewCompatible(Config{APIKey: "key", APIURL: server.URL + "/v1/"}, server.Client())
+		models, err := s.ListModels(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []llm.ModelInfo{
+			{ID: "llama3.1"},
+			{ID: "qwen2.5", ContextWindow: 32768},
+		}, models)
+	})
+
+	t.Run("azure deployments", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/openai/deployments", r.URL.Path)
+			assert.Equal(t, azureDeploymentsAPIVersion, r.URL.Query().Get("api-version"))
+			assert.Equal(t, "key", r.Header.Get("api-key"))
+			assert.Empty(t, r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{"data":[
+				{"id":"chat","model":"gpt-4o","status":"succeeded"},
+				{"id":"legacy","model":"gpt-35-turbo","status":"succeeded"},
+				{"id":"creating","model":"gpt-4o","status":"running"}
+			],"object":"list"}`)
+		}))
+		defer server.Close()
+
+		s := NewAzure(Config{APIKey: "key", APIURL: server.URL + "/"}, server.Client())
+		models, err := s.ListModels(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []llm.ModelInfo{
+			{ID: "chat", ContextWindow: 128000},
+			{ID: "legacy", ContextWindow: 16385},
+		}, models)
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"message":"Incorrect API key provided"}}`)
+		}))
+		defer server.Close()
+
+		s := NewCompatible(Config{APIKey: "key", APIURL: server.URL}, server.Client())
+		_, err := s.ListModels(context.Background())
+		assert.ErrorContains(t, err, "Incorrect API key provided")
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package quota
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-ai/i18n"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ExceededMessage returns the localized explanation posted to a user who went over a quota,
+// with the reset time shown in their timezone and in the date format of their language.
+func ExceededMessage(bundle *i18n.Bundle, user *model.User, exceeded *ExceededError) string {
+	T := i18n.LocalizerFunc(bundle, user.Locale)
+
+	location, err := time.LoadLocation(user.GetPreferredTimezone())
+	if err != nil {
+		location = time.UTC
+	}
+	// The layout is translated so the date reads the way it is usually written in the language of the user
+	resetAt := exceeded.ResetAt.In(location).Format(T("agents.quota_exceeded.reset_time_layout", "Mon Jan 2 15:04 MST"))
+
+	switch exceeded.Scope {
+	case ScopeTeam:
+		if exceeded.Period == PeriodMonthly {
+			return T("agents.quota_exceeded.team_monthly", "Your team has reached its monthly AI usage limit. It resets on %s.", resetAt)
+		}
+		return T("agents.quota_exceeded.team_daily", "Your team has reached its daily AI usage limit. It resets on %s.", resetAt)
+	case ScopeBot:
+		if exceeded.Period == PeriodMonthly {
+			return T("agents.quota_exceeded.bot_monthly", "This agent has reached its monthly usage limit. It resets on %s.", resetAt)
+		}
+		return T("agents.quota_exceeded.bot_daily", "This agent has reached its daily usage limit. It resets on %s.", resetAt)
+	default:
+		if exceeded.Period == PeriodMonthly {
+			return T("agents.quota_exceeded.user_monthly", "You have reached your monthly AI usage limit. It resets on %s.", resetAt)
+		}
+		return T("agents.quota_exceeded.user_daily", "You have reached your daily AI usage limit. It resets on %s.", resetAt)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package quota
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+)
+
+// Limits are the budgets for a single user, team or bot. Zero means unlimited.
+type Limits struct {
+	DailyTokens     int64 `json:"dailyTokens"`
+	MonthlyTokens   int64 `json:"monthlyTokens"`
+	DailyRequests   int64 `json:"dailyRequests"`
+	MonthlyRequests int64 `json:"monthlyRequests"`
+}
+
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Config holds the quota settings. Each set of limits applies to every user, team and bot individually.
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	User    Limits `json:"user"`
+	Team    Limits `json:"team"`
+	Bot     Limits `json:"bot"`
+}
+
+type Configuration interface {
+	Quotas() Config
+}
+
+// Scope identifies who a budget belongs to.
+type Scope string
+
+const (
+	ScopeUser Scope = "user"
+	ScopeTeam Scope = "team"
+	ScopeBot  Scope = "bot"
+)
+
+// Period is the window a budget applies to.
+type Period string
+
+const (
+	PeriodDaily   Period = "daily"
+	PeriodMonthly Period = "monthly"
+)
+
+// Subject is the user, team and bot a request is accounted to. TeamID is empty for requests
+// made outside of a team, such as in DMs.
+type Subject struct {
+	UserID string
+	TeamID string
+	BotID  string
+}
+
+// Usage is the amount consumed within a period.
+type Usage struct {
+	Requests     int64
+	InputTokens  int64
+	OutputTokens int64
+	CachedTokens int64
+}
+
+func (u Usage) Tokens() int64 {
+	return u.InputTokens + u.OutputTokens
+}
+
+// ExceededError is returned when a request would go over one of the configured budgets.
+type ExceededError struct {
+	Scope   Scope
+	Period  Period
+	ResetAt time.Time
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s %s quota exceeded, resets at %s", e.Scope, e.Period, e.ResetAt.Format(time.RFC3339))
+}
+
+// Budget is the limits applying to one of the user, team or bot of a subject.
+type Budget struct {
+	Scope  Scope
+	ID     string
+	Limits Limits
+}
+
+// Store persists usage aggregated per day.
+type Store interface {
+	AddUsage(day time.Time, subject Subject, usage Usage) error
+	// AddRequest counts a request of the subject on dayStart unless one of the budgets has already been used up
+	// since dayStart or monthStart, checking and counting atomically. It returns false when the request wasn't
+	// counted.
+	AddRequest(dayStart, monthStart time.Time, subject Subject, budgets []Budget) (bool, error)
+	// GetUsage returns the usage of the given user, team or bot since dayStart and since monthStart.
+	GetUsage(scope Scope, id string, dayStart, monthStart time.Time) (daily Usage, monthly Usage, err error)
+}
+
+type Service struct {
+	store  Store
+	config Configuration
+	now    func() time.Time
+}
+
+func NewService(store Store, config Configuration) *Service {
+	return &Service{
+		store:  store,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// periodStarts returns the start of the current day and month, quotas reset at midnight UTC.
+func periodStarts(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return dayStart, monthStart
+}
+
+// Check verifies that the subject has budget left and, if so, counts a new request against it.
+// It returns an *ExceededError when any of the user, team or bot budgets has been used up.
+// Nothing is checked or counted when quotas are disabled.
+func (s *Service) Check(subject Subject) error {
+	cfg := s.config.Quotas()
+	if !cfg.Enabled {
+		return nil
+	}
+
+	dayStart, monthStart := periodStarts(s.now())
+	budgets := subjectBudgets(cfg, subject)
+
+	added, err := s.store.AddRequest(dayStart, monthStart, subject, budgets)
+	if err != nil {
+		return fmt.Errorf("failed to record request: %w", err)
+	}
+	if added {
+		return nil
+	}
+
+	return s.exceededBudget(budgets, dayStart, monthStart)
+}
+
+// subjectBudgets returns the budgets configured for the subject. Team budgets don't apply outside of teams.
+func subjectBudgets(cfg Config, subject Subject) []Budget {
+	budgets := []Budget{}
+	for _, budget := range []Budget{
+		{Scope: ScopeUser, ID: subject.UserID, Limits: cfg.User},
+		{Scope: ScopeTeam, ID: subject.TeamID, Limits: cfg.Team},
+		{Scope: ScopeBot, ID: subject.BotID, Limits: cfg.Bot},
+	} {
+		if budget.ID != "" && !budget.Limits.IsZero() {
+			budgets = append(budgets, budget)
+		}
+	}
+	return budgets
+}
+
+// exceededBudget finds the budget that was used up to report when it resets.
+func (s *Service) exceededBudget(budgets []Budget, dayStart, monthStart time.Time) error {
+	for _, budget := range budgets {
+		daily, monthly, err := s.store.GetUsage(budget.Scope, budget.ID, dayStart, monthStart)
+		if err != nil {
+			return fmt.Errorf("failed to get %s usage: %w", budget.Scope, err)
+		}
+
+		// Monthly budgets are checked first since they take longer to reset.
+		if monthly.Exceeds(budget.Limits.MonthlyRequests, budget.Limits.MonthlyTokens) {
+			return &ExceededError{Scope: budget.Scope, Period: PeriodMonthly, ResetAt: monthStart.AddDate(0, 1, 0)}
+		}
+		if daily.Exceeds(budget.Limits.DailyRequests, budget.Limits.DailyTokens) {
+			return &ExceededError{Scope: budget.Scope, Period: PeriodDaily, ResetAt: dayStart.AddDate(0, 0, 1)}
+		}
+	}
+
+	// Only reached if the store refuses a request that is within budget
+	return &ExceededError{Scope: ScopeUser, Period: PeriodDaily, ResetAt: dayStart.AddDate(0, 0, 1)}
+}
+
+// Exceeds reports whether the usage reached either limit, zero limits are unlimited.
+func (u Usage) Exceeds(requestLimit, tokenLimit int64) bool {
+	return exceeds(u.Requests, requestLimit) || exceeds(u.Tokens(), tokenLimit)
+}
+
+func exceeds(used, limit int64) bool {
+	return limit > 0 && used >= limit
+}
+
+// RecordUsage accounts tokens consumed on behalf of the subject.
+func (s *Service) RecordUsage(subject Subject, usage llm.Usage) error {
+	dayStart, _ := periodStarts(s.now())
+	return s.store.AddUsage(dayStart, subject, Usage{
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		CachedTokens: usage.CachedTokens,
+	})
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-ai/i18n"
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type usageKey struct {
+	day     time.Time
+	subject Subject
+}
+
+// memoryStore is an in memory Store for tests.
+type memoryStore struct {
+	usage map[usageKey]Usage
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{usage: map[usageKey]Usage{}}
+}
+
+func (s *memoryStore) AddUsage(day time.Time, subject Subject, usage Usage) error {
+	key := usageKey{day: day, subject: subject}
+	existing := s.usage[key]
+	existing.Requests += usage.Requests
+	existing.InputTokens += usage.InputTokens
+	existing.OutputTokens += usage.OutputTokens
+	existing.CachedTokens += usage.CachedTokens
+	s.usage[key] = existing
+	return nil
+}
+
+func (s *memoryStore) AddRequest(dayStart, monthStart time.Time, subject Subject, budgets []Budget) (bool, error) {
+	for _, budget := range budgets {
+		daily, monthly, err := s.GetUsage(budget.Scope, budget.ID, dayStart, monthStart)
+		if err != nil {
+			return false, err
+		}
+		if monthly.Exceeds(budget.Limits.MonthlyRequests, budget.Limits.MonthlyTokens) ||
+			daily.Exceeds(budget.Limits.DailyRequests, budget.Limits.DailyTokens) {
+			return false, nil
+		}
+	}
+	return true, s.AddUsage(dayStart, subject, Usage{Requests: 1})
+}
+
+func (s *memoryStore) GetUsage(scope Scope, id string, dayStart, monthStart time.Time) (Usage, Usage, error) {
+	var daily, monthly Usage
+	for key, usage := range s.usage {
+		var keyID string
+		switch scope {
+		case ScopeUser:
+			keyID = key.subject.UserID
+		case ScopeTeam:
+			keyID = key.subject.TeamID
+		case ScopeBot:
+			keyID = key.subject.BotID
+		}
+		if keyID != id || key.day.Before(monthStart) {
+			continue
+		}
+		monthly.Requests += usage.Requests
+		monthly.InputTokens += usage.InputTokens
+		monthly.OutputTokens += usage.OutputTokens
+		if !key.day.Before(dayStart) {
+			daily.Requests += usage.Requests
+			daily.InputTokens += usage.InputTokens
+			daily.OutputTokens += usage.OutputTokens
+		}
+	}
+	return daily, monthly, nil
+}
+
+type staticConfig Config
+
+func (c staticConfig) Quotas() Config {
+	return Config(c)
+}
+
+func newTestService(cfg Config, now time.Time) (*Service, *memoryStore) {
+	store := newMemoryStore()
+	service := NewService(store, staticConfig(cfg))
+	service.now = func() time.Time { return now }
+	return service, store
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2024, 5, 17, 15, 30, 0, 0, time.UTC)
+	subject := Subject{UserID: "user", TeamID: "team", BotID: "bot"}
+
+	t.Run("disabled quotas never block and don't count requests", func(t *testing.T) {
+		service, store := newTestService(Config{Enabled: false, User: Limits{DailyRequests: 1}}, now)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, service.Check(subject))
+		}
+		assert.Empty(t, store.usage)
+	})
+
+	t.Run("refused requests are not counted", func(t *testing.T) {
+		service, store := newTestService(Config{Enabled: true, User: Limits{DailyRequests: 1}}, now)
+		require.NoError(t, service.Check(subject))
+		require.Error(t, service.Check(subject))
+		require.Error(t, service.Check(subject))
+
+		daily, _, err := store.GetUsage(ScopeUser, "user", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), daily.Requests)
+	})
+
+	t.Run("daily request limit", func(t *testing.T) {
+		service, _ := newTestService(Config{Enabled: true, User: Limits{DailyRequests: 2}}, now)
+		require.NoError(t, service.Check(subject))
+		require.NoError(t, service.Check(subject))
+
+		err := service.Check(subject)
+		var exceeded *ExceededError
+		require.ErrorAs(t, err, &exceeded)
+		assert.Equal(t, ScopeUser, exceeded.Scope)
+		assert.Equal(t, PeriodDaily, exceeded.Period)
+		assert.Equal(t, time.Date(2024, 5, 18, 0, 0, 0, 0, time.UTC), exceeded.ResetAt)
+
+		// Another user is not affected
+		require.NoError(t, service.Check(Subject{UserID: "other", TeamID: "team", BotID: "bot"}))
+	})
+
+	t.Run("monthly token limit for the team", func(t *testing.T) {
+		service, store := newTestService(Config{Enabled: true, Team: Limits{MonthlyTokens: 1000}}, now)
+		require.NoError(t, store.AddUsage(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), Subject{UserID: "other", TeamID: "team", BotID: "bot"}, Usage{InputTokens: 800, OutputTokens: 200}))
+
+		err := service.Check(subject)
+		var exceeded *ExceededError
+		require.ErrorAs(t, err, &exceeded)
+		assert.Equal(t, ScopeTeam, exceeded.Scope)
+		assert.Equal(t, PeriodMonthly, exceeded.Period)
+		assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), exceeded.ResetAt)
+
+		// Usage from the previous month doesn't count
+		service.now = func() time.Time { return time.Date(2024, 6, 1, 0, 0, 1, 0, time.UTC) }
+		require.NoError(t, service.Check(subject))
+	})
+
+	t.Run("team limits are skipped outside of teams", func(t *testing.T) {
+		service, _ := newTestService(Config{Enabled: true, Team: Limits{DailyRequests: 1}}, now)
+		dm := Subject{UserID: "user", BotID: "bot"}
+		require.NoError(t, service.Check(dm))
+		require.NoError(t, service.Check(dm))
+	})
+
+	t.Run("bot limit is shared by all users", func(t *testing.T) {
+		service, _ := newTestService(Config{Enabled: true, Bot: Limits{DailyTokens: 100}}, now)
+		require.NoError(t, service.Check(subject))
+		require.NoError(t, service.RecordUsage(subject, llm.Usage{InputTokens: 60, OutputTokens: 40}))
+
+		err := service.Check(Subject{UserID: "other", BotID: "bot"})
+		var exceeded *ExceededError
+		require.ErrorAs(t, err, &exceeded)
+		assert.Equal(t, ScopeBot, exceeded.Scope)
+	})
+}
+
+type fixedModel struct {
+	answer string
+	usage  *llm.Usage
+}
+
+func (m *fixedModel) ChatCompletion(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
+	if m.usage == nil {
+		return llm.NewStreamFromString(m.answer), nil
+	}
+
+	stream := make(chan llm.TextStreamEvent)
+	go func() {
+		defer close(stream)
+		stream <- llm.TextStreamEvent{Type: llm.EventTypeText, Value: m.answer}
+		stream <- llm.TextStreamEvent{Type: llm.EventTypeUsage, Value: *m.usage}
+		stream <- llm.TextStreamEvent{Type: llm.EventTypeEnd}
+	}()
+	return &llm.TextStreamResult{Stream: stream}, nil
+}
+
+func (m *fixedModel) ChatCompletionNoStream(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
+	return m.answer, nil
+}
+
+func (m *fixedModel) CountTokens(text string) int    { return len(text) }
+func (m *fixedModel) InputTokenLimit() int           { return 1000 }
+func (m *fixedModel) Capabilities() llm.Capabilities { return llm.Capabilities{Streaming: true} }
+
+func TestUsageWrapper(t *testing.T) {
+	now := time.Date(2024, 5, 17, 15, 30, 0, 0, time.UTC)
+	day := time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)
+	subject := Subject{UserID: "user", TeamID: "team", BotID: "bot"}
+
+	request := llm.CompletionRequest{
+		Posts: []llm.Post{{Role: llm.PostRoleUser, Message: "123"}},
+		Context: llm.NewContext(func(c *llm.Context) {
+			c.RequestingUser = &model.User{Id: "user"}
+			c.Channel = &model.Channel{Id: "channel", TeamId: "team"}
+		}),
+	}
+
+	t.Run("estimates usage when the provider doesn't report it", func(t *testing.T) {
+		service, store := newTestService(Config{Enabled: true}, now)
+		wrapped := NewUsageWrapper(service, "bot", nil)(&fixedModel{answer: "12345"})
+
+		result, err := wrapped.ChatCompletion(context.Background(), request)
+		require.NoError(t, err)
+		text, err := result.ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, "12345", text)
+
+		_, err = wrapped.ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+
+		// Requests without a requesting user aren't accounted
+		_, err = wrapped.ChatCompletionNoStream(context.Background(), llm.CompletionRequest{Posts: request.Posts})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[usageKey]Usage{
+			{day: day, subject: subject}: {InputTokens: 6, OutputTokens: 10},
+		}, store.usage)
+	})
+
+	t.Run("uses the usage reported by the provider", func(t *testing.T) {
+		service, store := newTestService(Config{Enabled: false}, now)
+		reported := &llm.Usage{InputTokens: 100, OutputTokens: 20, CachedTokens: 80, FinishReason: "stop"}
+		wrapped := NewUsageWrapper(service, "bot", nil)(&fixedModel{answer: "12345", usage: reported})
+
+		text, err := wrapped.ChatCompletionNoStream(context.Background(), request)
+		require.NoError(t, err)
+		assert.Equal(t, "12345", text)
+
+		assert.Equal(t, map[usageKey]Usage{
+			{day: day, subject: subject}: {InputTokens: 100, OutputTokens: 20, CachedTokens: 80},
+		}, store.usage)
+	})
+}
+
+func TestExceededMessage(t *testing.T) {
+	bundle := i18n.Init()
+	exceeded := &ExceededError{Scope: ScopeUser, Period: PeriodDaily, ResetAt: time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)}
+
+	t.Run("reset time in the timezone of the user", func(t *testing.T) {
+		user := &model.User{Locale: "en", Timezone: map[string]string{"useAutomaticTimezone": "false", "manualTimezone": "America/New_York"}}
+		assert.Equal(t, "You have reached your daily AI usage limit. It resets on Fri Mar 14 20:00 EDT.", ExceededMessage(bundle, user, exceeded))
+	})
+
+	t.Run("reset time in the date format of the language of the user", func(t *testing.T) {
+		user := &model.User{Locale: "es", Timezone: map[string]string{"useAutomaticTimezone": "true", "automaticTimezone": "Europe/Madrid"}}
+		assert.Equal(t, "Ha alcanzado su límite diario de uso de IA. Se restablece el 15/03/2025 01:00 CET.", ExceededMessage(bundle, user, exceeded))
+	})
+
+	t.Run("UTC for users without a timezone", func(t *testing.T) {
+		user := &model.User{Locale: "en"}
+		assert.Equal(t, "You have reached your daily AI usage limit. It resets on Sat Mar 15 00:00 UTC.", ExceededMessage(bundle, user, exceeded))
+	})
+}
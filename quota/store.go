@@ -0,0 +1,203 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package quota
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/mattermost/mattermost-plugin-ai/mmapi"
+)
+
+var scopeColumns = map[Scope]string{
+	ScopeUser: "UserID",
+	ScopeTeam: "TeamID",
+	ScopeBot:  "BotID",
+}
+
+// DBStore keeps usage in the LLM_Usage table.
+type DBStore struct {
+	db *mmapi.DBClient
+}
+
+func NewDBStore(db *mmapi.DBClient) *DBStore {
+	return &DBStore{
+		db: db,
+	}
+}
+
+func (s *DBStore) AddUsage(day time.Time, subject Subject, usage Usage) error {
+	_, err := s.db.ExecBuilder(s.db.Builder().Insert("LLM_Usage").
+		Columns("Day", "UserID", "TeamID", "BotID", "Requests", "InputTokens", "OutputTokens", "CachedTokens").
+		Values(day.Format(time.DateOnly), subject.UserID, subject.TeamID, subject.BotID, usage.Requests, usage.InputTokens, usage.OutputTokens, usage.CachedTokens).
+		Suffix(`ON CONFLICT (Day, UserID, TeamID, BotID) DO UPDATE SET
+			Requests = LLM_Usage.Requests + EXCLUDED.Requests,
+			InputTokens = LLM_Usage.InputTokens + EXCLUDED.InputTokens,
+			OutputTokens = LLM_Usage.OutputTokens + EXCLUDED.OutputTokens,
+			CachedTokens = LLM_Usage.CachedTokens + EXCLUDED.CachedTokens`))
+	if err != nil {
+		return fmt.Errorf("failed to add usage: %w", err)
+	}
+	return nil
+}
+
+// AddRequest inserts the request only when the usage of none of the budgets reached its limits, in a single
+// statement so the check and the count can't be interleaved with those of other requests.
+func (s *DBStore) AddRequest(dayStart, monthStart time.Time, subject Subject, budgets []Budget) (bool, error) {
+	day := dayStart.Format(time.DateOnly)
+	month := monthStart.Format(time.DateOnly)
+
+	request := sq.Select().
+		Column(sq.Expr("CAST(? AS DATE)", day)).
+		Column(sq.Expr("?", subject.UserID)).
+		Column(sq.Expr("?", subject.TeamID)).
+		Column(sq.Expr("?", subject.BotID)).
+		Columns("1", "0", "0", "0")
+	for _, budget := range budgets {
+		column, ok := scopeColumns[budget.Scope]
+		if !ok {
+			return false, fmt.Errorf("unknown quota scope: %s", budget.Scope)
+		}
+
+		exceeded := sq.Or{}
+		if budget.Limits.MonthlyRequests > 0 {
+			exceeded = append(exceeded, sq.Expr("SUM(Requests) >= ?", budget.Limits.MonthlyRequests))
+		}
+		if budget.Limits.MonthlyTokens > 0 {
+			exceeded = append(exceeded, sq.Expr("SUM(InputTokens + OutputTokens) >= ?", budget.Limits.MonthlyTokens))
+		}
+		if budget.Limits.DailyRequests > 0 {
+			exceeded = append(exceeded, sq.Expr("COALESCE(SUM(Requests) FILTER (WHERE Day >= ?), 0) >= ?", day, budget.Limits.DailyRequests))
+		}
+		if budget.Limits.DailyTokens > 0 {
+			exceeded = append(exceeded, sq.Expr("COALESCE(SUM(InputTokens + OutputTokens) FILTER (WHERE Day >= ?), 0) >= ?", day, budget.Limits.DailyTokens))
+		}
+
+		usedUp, args, err := sq.Select("1").
+			From("LLM_Usage").
+			Where(sq.Eq{column: budget.ID}).
+			Where(sq.GtOrEq{"Day": month}).
+			Having(exceeded).
+			ToSql()
+		if err != nil {
+			return false, fmt.Errorf("failed to build %s quota check: %w", budget.Scope, err)
+		}
+		request = request.Where(sq.Expr("NOT EXISTS ("+usedUp+")", args...))
+	}
+
+	var added []int64
+	if err := s.db.DoQuery(&added, s.db.Builder().Insert("LLM_Usage").
+		Columns("Day", "UserID", "TeamID", "BotID", "Requests", "InputTokens", "OutputTokens", "CachedTokens").
+		Select(request).
+		Suffix(`ON CONFLICT (Day, UserID, TeamID, BotID) DO UPDATE SET
+			Requests = LLM_Usage.Requests + EXCLUDED.Requests
+			RETURNING Requests`),
+	); err != nil {
+		return false, fmt.Errorf("failed to add request: %w", err)
+	}
+
+	return len(added) > 0, nil
+}
+
+func (s *DBStore) GetUsage(scope Scope, id string, dayStart, monthStart time.Time) (Usage, Usage, error) {
+	column, ok := scopeColumns[scope]
+	if !ok {
+		return Usage{}, Usage{}, fmt.Errorf("unknown quota scope: %s", scope)
+	}
+
+	day := dayStart.Format(time.DateOnly)
+	var rows []struct {
+		DailyRequests       int64
+		DailyInputTokens    int64
+		DailyOutputTokens   int64
+		MonthlyRequests     int64
+		MonthlyInputTokens  int64
+		MonthlyOutputTokens int64
+	}
+	if err := s.db.DoQuery(&rows, s.db.Builder().
+		Select().
+		Column(sq.Expr("COALESCE(SUM(Requests) FILTER (WHERE Day >= ?), 0) AS DailyRequests", day)).
+		Column(sq.Expr("COALESCE(SUM(InputTokens) FILTER (WHERE Day >= ?), 0) AS DailyInputTokens", day)).
+		Column(sq.Expr("COALESCE(SUM(OutputTokens) FILTER (WHERE Day >= ?), 0) AS DailyOutputTokens", day)).
+		Column("COALESCE(SUM(Requests), 0) AS MonthlyRequests").
+		Column("COALESCE(SUM(InputTokens), 0) AS MonthlyInputTokens").
+		Column("COALESCE(SUM(OutputTokens), 0) AS MonthlyOutputTokens").
+		From("LLM_Usage").
+		Where(sq.Eq{column: id}).
+		Where(sq.GtOrEq{"Day": monthStart.Format(time.DateOnly)}),
+	); err != nil {
+		return Usage{}, Usage{}, fmt.Errorf("failed to get usage: %w", err)
+	}
+	if len(rows) == 0 {
+		return Usage{}, Usage{}, nil
+	}
+
+	row := rows[0]
+	daily := Usage{Requests: row.DailyRequests, InputTokens: row.DailyInputTokens, OutputTokens: row.DailyOutputTokens}
+	monthly := Usage{Requests: row.MonthlyRequests, InputTokens: row.MonthlyInputTokens, OutputTokens: row.MonthlyOutputTokens}
+	return daily, monthly, nil
+}
+
+// ReportGroup is a dimension usage can be grouped by in reports.
+type ReportGroup string
+
+const (
+	GroupByBot  ReportGroup = "bot"
+	GroupByTeam ReportGroup = "team"
+	GroupByUser ReportGroup = "user"
+	GroupByDay  ReportGroup = "day"
+)
+
+var reportGroupColumns = map[ReportGroup]string{
+	GroupByBot:  "BotID",
+	GroupByTeam: "TeamID",
+	GroupByUser: "UserID",
+	GroupByDay:  "to_char(Day, 'YYYY-MM-DD') AS Day",
+}
+
+// ReportRow is the usage of one group. Only the fields of the requested groups are set.
+type ReportRow struct {
+	BotID        string `json:"bot_id,omitempty"`
+	TeamID       string `json:"team_id,omitempty"`
+	UserID       string `json:"user_id,omitempty"`
+	Day          string `json:"day,omitempty"`
+	Requests     int64  `json:"requests"`
+	InputTokens  int64  `json:"input_tokens"`
+	OutputTokens int64  `json:"output_tokens"`
+	CachedTokens int64  `json:"cached_tokens"`
+}
+
+// Report returns the usage between since and until, both inclusive days, grouped by the given dimensions.
+func (s *DBStore) Report(groupBy []ReportGroup, since, until time.Time) ([]ReportRow, error) {
+	query := s.db.Builder().
+		Select().
+		From("LLM_Usage").
+		Where(sq.GtOrEq{"Day": since.Format(time.DateOnly)}).
+		Where(sq.LtOrEq{"Day": until.Format(time.DateOnly)})
+
+	for i, group := range groupBy {
+		column, ok := reportGroupColumns[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown usage group: %s", group)
+		}
+		query = query.Column(column).
+			GroupBy(strconv.Itoa(i + 1)).
+			OrderBy(strconv.Itoa(i + 1))
+	}
+
+	query = query.
+		Column("COALESCE(SUM(Requests), 0) AS Requests").
+		Column("COALESCE(SUM(InputTokens), 0) AS InputTokens").
+		Column("COALESCE(SUM(OutputTokens), 0) AS OutputTokens").
+		Column("COALESCE(SUM(CachedTokens), 0) AS CachedTokens")
+
+	rows := []ReportRow{}
+	if err := s.db.DoQuery(&rows, query); err != nil {
+		return nil, fmt.Errorf("failed to get usage report: %w", err)
+	}
+
+	return rows, nil
+}
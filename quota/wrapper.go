@@ -0,0 +1,149 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package quota
+
+import (
+	"context"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+)
+
+type usageLogger interface {
+	Error(message string, keyValuePairs ...any)
+}
+
+// UsageWrapper accounts the tokens of every request made on behalf of a user, using the usage reported
+// by the provider when available and an estimate otherwise.
+type UsageWrapper struct {
+	wrapped llm.LanguageModel
+	service *Service
+	botID   string
+	log     usageLogger
+}
+
+func NewUsageWrapper(service *Service, botID string, log usageLogger) llm.LanguageModelWrapper {
+	return func(wrapped llm.LanguageModel) llm.LanguageModel {
+		return &UsageWrapper{
+			wrapped: wrapped,
+			service: service,
+			botID:   botID,
+			log:     log,
+		}
+	}
+}
+
+// subject returns who the request is made for, requests without a requesting user are not accounted.
+func (w *UsageWrapper) subject(request llm.CompletionRequest) (Subject, bool) {
+	if request.Context == nil || request.Context.RequestingUser == nil {
+		return Subject{}, false
+	}
+
+	subject := Subject{
+		UserID: request.Context.RequestingUser.Id,
+		BotID:  w.botID,
+	}
+	switch {
+	case request.Context.Channel != nil && request.Context.Channel.TeamId != "":
+		subject.TeamID = request.Context.Channel.TeamId
+	case request.Context.Team != nil:
+		subject.TeamID = request.Context.Team.Id
+	}
+
+	return subject, true
+}
+
+// estimateUsage is used for providers that don't report usage.
+func (w *UsageWrapper) estimateUsage(request llm.CompletionRequest, output string) llm.Usage {
+	var inputTokens int64
+	for _, post := range request.Posts {
+		inputTokens += int64(w.wrapped.CountTokens(post.Message))
+	}
+	return llm.Usage{
+		InputTokens:  inputTokens,
+		OutputTokens: int64(w.wrapped.CountTokens(output)),
+	}
+}
+
+func (w *UsageWrapper) record(subject Subject, usage llm.Usage) {
+	if err := w.service.RecordUsage(subject, usage); err != nil && w.log != nil {
+		w.log.Error("failed to record token usage", "error", err)
+	}
+}
+
+func (w *UsageWrapper) ChatCompletion(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (*llm.TextStreamResult, error) {
+	subject, ok := w.subject(request)
+	if !ok {
+		return w.wrapped.ChatCompletion(ctx, request, opts...)
+	}
+
+	result, err := w.wrapped.ChatCompletion(ctx, request, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(chan llm.TextStreamEvent)
+	go func() {
+		defer close(output)
+
+		text := ""
+		answered := false
+		var reported *llm.Usage
+		for event := range result.Stream {
+			switch event.Type {
+			case llm.EventTypeText, llm.EventTypeReasoning:
+				if chunk, isString := event.Value.(string); isString {
+					text += chunk
+				}
+				answered = true
+			case llm.EventTypeUsage:
+				if usage, isUsage := event.Value.(llm.Usage); isUsage {
+					reported = &usage
+				}
+			case llm.EventTypeEnd, llm.EventTypeToolCalls:
+				answered = true
+			}
+			output <- event
+		}
+
+		switch {
+		case reported != nil:
+			w.record(subject, *reported)
+		case answered:
+			w.record(subject, w.estimateUsage(request, text))
+		}
+	}()
+
+	return &llm.TextStreamResult{Stream: output}, nil
+}
+
+// ChatCompletionNoStream goes through the streaming API so the usage reported by the provider can be read.
+func (w *UsageWrapper) ChatCompletionNoStream(ctx context.Context, request llm.CompletionRequest, opts ...llm.LanguageModelOption) (string, error) {
+	if _, ok := w.subject(request); !ok {
+		return w.wrapped.ChatCompletionNoStream(ctx, request, opts...)
+	}
+
+	result, err := w.ChatCompletion(ctx, request, opts...)
+	if err != nil {
+		return "", err
+	}
+	text, err := result.ReadAll()
+
+	// ReadAll can stop early, let the stream finish so the usage gets recorded.
+	for range result.Stream {
+	}
+
+	return text, err
+}
+
+func (w *UsageWrapper) CountTokens(text string) int {
+	return w.wrapped.CountTokens(text)
+}
+
+func (w *UsageWrapper) InputTokenLimit() int {
+	return w.wrapped.InputTokenLimit()
+}
+
+func (w *UsageWrapper) Capabilities() llm.Capabilities {
+	return w.wrapped.Capabilities()
+}
@@ -4,6 +4,7 @@
 package react
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -29,7 +30,11 @@ func New(
 	}
 }
 
-func (r *React) Resolve(message string, context *llm.Context) (string, error) {
+type emojiSelection struct {
+	Emoji string `json:"emoji"`
+}
+
+func (r *React) Resolve(ctx context.Context, message string, context *llm.Context) (string, error) {
 	context.Parameters = map[string]any{"Message": message}
 
 	// Format prompt for emoji selection
@@ -54,13 +59,13 @@ func (r *React) Resolve(message string, context *llm.Context) (string, error) {
 	}
 
 	// Get emoji from LLM
-	emojiName, err := r.llm.ChatCompletionNoStream(completionRequest, llm.WithMaxGeneratedTokens(25))
+	selection, err := llm.CompleteJSON[emojiSelection](ctx, r.llm, completionRequest, llm.WithMaxGeneratedTokens(50), llm.WithCache())
 	if err != nil {
 		return "", fmt.Errorf("failed to get emoji from LLM: %w", err)
 	}
 
 	// Process the emoji name
-	emojiName = strings.Trim(strings.TrimSpace(emojiName), ":")
+	emojiName := strings.Trim(strings.TrimSpace(selection.Emoji), ":")
 
 	// Validate the emoji
 	if _, found := model.GetSystemEmojiId(emojiName); !found {
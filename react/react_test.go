@@ -4,6 +4,7 @@
 package react_test
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -30,7 +31,7 @@ func TestReactResolve(t *testing.T) {
 		{
 			name:          "success",
 			message:       "Great job on the presentation!",
-			llmResponse:   "thumbsup",
+			llmResponse:   `{"emoji": "thumbsup"}`,
 			llmError:      nil,
 			expectedEmoji: "thumbsup",
 			expectedError: false,
@@ -38,12 +39,21 @@ func TestReactResolve(t *testing.T) {
 		{
 			name:          "invalid emoji",
 			message:       "Great job on the presentation!",
-			llmResponse:   "not_an_emoji",
+			llmResponse:   `{"emoji": "not_an_emoji"}`,
 			llmError:      nil,
 			expectedEmoji: "",
 			expectedError: true,
 			errorContains: "LLM returned something other than emoji",
 		},
+		{
+			name:          "response is not JSON",
+			message:       "Great job on the presentation!",
+			llmResponse:   "thumbsup",
+			llmError:      nil,
+			expectedEmoji: "",
+			expectedError: true,
+			errorContains: "no JSON object found",
+		},
 		{
 			name:          "llm error",
 			message:       "Great job on the presentation!",
@@ -62,13 +72,14 @@ func TestReactResolve(t *testing.T) {
 			prompts, err := llm.NewPrompts(prompts.PromptsFolder)
 			assert.NoError(t, err)
 
-			mockLLM.EXPECT().ChatCompletionNoStream(mock.Anything, mock.Anything).Return(tc.llmResponse, tc.llmError)
+			mockLLM.EXPECT().Capabilities().Return(llm.Capabilities{JSONOutput: true})
+			mockLLM.EXPECT().ChatCompletionNoStream(context.Background(), mock.Anything, mock.Anything).Return(tc.llmResponse, tc.llmError)
 
 			r := react.New(mockLLM, prompts)
 			ctx := llm.NewContext()
 
 			// Execute
-			emoji, err := r.Resolve(tc.message, ctx)
+			emoji, err := r.Resolve(context.Background(), tc.message, ctx)
 
 			// Assert
 			if tc.expectedError {
@@ -112,7 +123,7 @@ func TestReactEval(t *testing.T) {
 			r := react.New(t.LLM, t.Prompts)
 			llmContext := llm.NewContext()
 
-			result, err := r.Resolve(tc.message, llmContext)
+			result, err := r.Resolve(context.Background(), tc.message, llmContext)
 
 			require.NoError(t, err)
 			assert.NotEmpty(t, result, "Expected a non-empty emoji reaction")
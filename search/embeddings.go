@@ -12,6 +12,7 @@ import (
 	"github.com/mattermost/mattermost-plugin-ai/chunking"
 	"github.com/mattermost/mattermost-plugin-ai/embeddings"
 	"github.com/mattermost/mattermost-plugin-ai/enterprise"
+	"github.com/mattermost/mattermost-plugin-ai/llm"
 	"github.com/mattermost/mattermost-plugin-ai/openai"
 	"github.com/mattermost/mattermost-plugin-ai/postgres"
 )
@@ -72,6 +73,7 @@ func InitEmbeddingsSearch(db *sqlx.DB, httpClient *http.Client, cfg embeddings.E
 		if err != nil {
 			return nil, err
 		}
+		embeddor = embeddings.NewRetryingProvider(embeddor, llm.DefaultRetryConfig())
 
 		// Check if we have specific chunking options configured
 		chunkingOpts := cfg.ChunkingOptions
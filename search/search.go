@@ -228,7 +228,15 @@ func (s *Search) RunSearch(ctx context.Context, userID string, bot *bots.Bot, qu
 			Context: promptCtx,
 		}
 
-		resultStream, err := bot.LLM().ChatCompletion(prompt)
+		streamContext, err := s.streamingService.GetStreamingContext(context.Background(), responsePost.Id)
+		if err != nil {
+			s.mmclient.LogError("Error getting post streaming context", "error", err)
+			processingError = err
+			return
+		}
+		defer s.streamingService.FinishStreaming(responsePost.Id)
+
+		resultStream, err := bot.LLM().ChatCompletion(streamContext, prompt)
 		if err != nil {
 			s.mmclient.LogError("Error generating answer", "error", err)
 			processingError = err
@@ -250,13 +258,6 @@ func (s *Search) RunSearch(ctx context.Context, userID string, bot *bots.Bot, qu
 			return
 		}
 
-		streamContext, err := s.streamingService.GetStreamingContext(context.Background(), responsePost.Id)
-		if err != nil {
-			s.mmclient.LogError("Error getting post streaming context", "error", err)
-			processingError = err
-			return
-		}
-		defer s.streamingService.FinishStreaming(responsePost.Id)
 		s.streamingService.StreamToPost(streamContext, resultStream, responsePost, "")
 	}(query, teamID, channelID, maxResults)
 
@@ -320,7 +321,7 @@ func (s *Search) SearchQuery(ctx context.Context, userID string, bot *bots.Bot,
 		Context: promptCtx,
 	}
 
-	answer, err := bot.LLM().ChatCompletionNoStream(prompt)
+	answer, err := bot.LLM().ChatCompletionNoStream(ctx, prompt)
 	if err != nil {
 		return Response{}, fmt.Errorf("failed to generate answer: %w", err)
 	}
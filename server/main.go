@@ -7,6 +7,7 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/mattermost/mattermost-plugin-ai/api"
@@ -20,11 +21,13 @@ import (
 	"github.com/mattermost/mattermost-plugin-ai/llm"
 	"github.com/mattermost/mattermost-plugin-ai/llmcontext"
 	"github.com/mattermost/mattermost-plugin-ai/mcp"
+	"github.com/mattermost/mattermost-plugin-ai/mcpserver"
 	"github.com/mattermost/mattermost-plugin-ai/meetings"
 	"github.com/mattermost/mattermost-plugin-ai/metrics"
 	"github.com/mattermost/mattermost-plugin-ai/mmapi"
 	"github.com/mattermost/mattermost-plugin-ai/mmtools"
 	"github.com/mattermost/mattermost-plugin-ai/prompts"
+	"github.com/mattermost/mattermost-plugin-ai/quota"
 	"github.com/mattermost/mattermost-plugin-ai/search"
 	"github.com/mattermost/mattermost-plugin-ai/streaming"
 	"github.com/mattermost/mattermost/server/public/model"
@@ -76,7 +79,18 @@ func (p *Plugin) OnActivate() error {
 		p.configuration.Update(&newCfg)
 	}
 
-	bots := bots.New(p.API, pluginAPI, licenseChecker, &p.configuration, llmUpstreamHTTPClient)
+	updated, newCfg, err = migrateModelSupportsVision(p.API, pluginAPI, newCfg)
+	if err != nil {
+		pluginAPI.Log.Error("failed to migrate the vision settings of OpenAI compatible services", "error", err)
+		// Don't fail on migration errors
+	}
+	if updated && err == nil {
+		p.configuration.Update(&newCfg)
+	}
+
+	quotaService := quota.NewService(quota.NewDBStore(dbClient), &p.configuration)
+
+	bots := bots.New(p.API, pluginAPI, licenseChecker, &p.configuration, llmUpstreamHTTPClient, quotaService)
 	p.configuration.RegisterUpdateListener(func() {
 		if ensureErr := bots.EnsureBots(p.configuration.GetBots()); ensureErr != nil {
 			pluginAPI.Log.Error("failed to ensure bots on configuration update", "error", ensureErr)
@@ -130,7 +144,20 @@ func (p *Plugin) OnActivate() error {
 		untrustedHTTPClient,
 	)
 
-	mcpClientManager := mcp.NewClientManager(p.configuration.MCP(), pluginAPI.Log)
+	// The authorization servers are discovered from the responses of the MCP servers, they are reached like
+	// any untrusted URL
+	mcpOAuthHTTPClient := httpservice.MakeHTTPServicePlugin(p.API).MakeClient(false)
+	mcpOAuthHTTPClient.Timeout = time.Second * 30
+	mcpOAuth := mcp.NewOAuthManager(mmClient, pluginAPI.Log, mcpOAuthHTTPClient, func() string {
+		siteURL := pluginAPI.Configuration.GetConfig().ServiceSettings.SiteURL
+		if siteURL == nil {
+			return ""
+		}
+		return strings.TrimSuffix(*siteURL, "/") + "/plugins/" + manifest.Id + mcp.OAuthCallbackPath
+	})
+	mcpHTTPClient := httpservice.MakeHTTPServicePlugin(p.API).MakeClient(true)
+	mcpHTTPClient.Timeout = time.Minute * 5 // MCP tool calls can be slow
+	mcpClientManager := mcp.NewClientManager(p.configuration.MCP(), pluginAPI.Log, mcpOAuth, mcpHTTPClient, mcp.StdioCommandsFromEnv())
 	p.configuration.RegisterUpdateListener(func() {
 		mcpClientManager.ReInit(p.configuration.MCP())
 	})
@@ -140,6 +167,7 @@ func (p *Plugin) OnActivate() error {
 		toolProvider,
 		mcpClientManager,
 		&p.configuration,
+		i18nBundle,
 	)
 
 	conversationsService := conversations.New(
@@ -170,6 +198,17 @@ func (p *Plugin) OnActivate() error {
 	// TODO: Refactor to avoid circular dependency
 	conversationsService.SetMeetingsService(meetingsService)
 
+	mcpServer := mcpserver.New(
+		toolProvider,
+		pluginAPI,
+		mmClient,
+		bots,
+		contextBuilder,
+		prompts,
+		licenseChecker,
+		&p.configuration,
+	)
+
 	apiService := api.New(
 		bots,
 		conversationsService,
@@ -186,6 +225,8 @@ func (p *Plugin) OnActivate() error {
 		licenseChecker,
 		streamingService,
 		i18nBundle,
+		mcpClientManager,
+		mcpServer,
 	)
 
 	// Keep only what we need
@@ -94,3 +94,62 @@ func migrateServicesToBots(mutexAPI cluster.MutexPluginAPI, pluginAPI *pluginapi
 
 	return true, cfg, nil
 }
+
+// migrateModelSupportsVision keeps vision working for the bots using an OpenAI compatible service that had it
+// enabled before the service had to declare that its model accepts images.
+func migrateModelSupportsVision(mutexAPI cluster.MutexPluginAPI, pluginAPI *pluginapi.Client, cfg config.Config) (bool, config.Config, error) {
+	mtx, err := cluster.NewMutex(mutexAPI, "migrate_model_supports_vision")
+	if err != nil {
+		return false, cfg, fmt.Errorf("failed to create mutex: %w", err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	migrationDone := false
+	_ = pluginAPI.KV.Get("migrate_model_supports_vision_done", &migrationDone)
+	if migrationDone {
+		return false, cfg, nil
+	}
+
+	existingConfig := cfg.Clone()
+	updated := false
+	for i := range existingConfig.Bots {
+		bot := &existingConfig.Bots[i]
+		if !bot.EnableVision {
+			continue
+		}
+		migrate := func(service *llm.ServiceConfig) {
+			if service.Type == llm.ServiceTypeOpenAICompatible && !service.ModelSupportsVision {
+				service.ModelSupportsVision = true
+				updated = true
+			}
+		}
+		migrate(&bot.Service)
+		for j := range bot.FallbackServices {
+			migrate(&bot.FallbackServices[j])
+		}
+	}
+
+	if !updated {
+		_, _ = pluginAPI.KV.Set("migrate_model_supports_vision_done", true)
+		return false, cfg, nil
+	}
+
+	pluginAPI.Log.Debug("Migrating vision settings of OpenAI compatible services")
+
+	out := map[string]any{}
+	marshalBytes, err := json.Marshal(existingConfig)
+	if err != nil {
+		return false, cfg, fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+	if err := json.Unmarshal(marshalBytes, &out); err != nil {
+		return false, cfg, fmt.Errorf("failed to unmarshal configuration to output: %w", err)
+	}
+
+	if err := pluginAPI.Configuration.SavePluginConfig(map[string]any{"config": out}); err != nil {
+		return false, cfg, fmt.Errorf("failed to save plugin configuration: %w", err)
+	}
+	_, _ = pluginAPI.KV.Set("migrate_model_supports_vision_done", true)
+
+	return true, *existingConfig, nil
+}
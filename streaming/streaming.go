@@ -6,6 +6,7 @@ package streaming
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -22,13 +23,53 @@ const PostStreamingControlStart = "start"
 
 const ToolCallProp = "pending_tool_call"
 
+// ProviderProp records which service answered when the bot has fallback services configured
+const ProviderProp = "llm_provider"
+
+// UsageProp holds the llm.Usage of the response as JSON, summed over every LLM call made for the post
+const UsageProp = "llm_usage"
+
+// ReasoningProp holds the reasoning of the model, displayed apart from the message
+const ReasoningProp = "llm_reasoning"
+
+// ReasoningBlocksProp holds the []llm.ReasoningBlock of a response with tool calls as JSON, sent back with the tool results
+const ReasoningBlocksProp = "llm_reasoning_blocks"
+
+// PrivateToolCallProp marks a post outside of a DM whose tool calls wait for the requester's approval. The calls
+// are kept in the KV store instead of ToolCallProp so the other members of the channel don't see them.
+const PrivateToolCallProp = "llm_private_tool_call"
+
+// ToolApprovalPostType is the type of the ephemeral post asking the requester to approve tool calls
+const ToolApprovalPostType = "custom_llm_tool_approval"
+
+// ToolApprovalPostIDProp holds the ID of the post whose tool calls the ephemeral approval post is about
+const ToolApprovalPostIDProp = "llm_tool_approval_post_id"
+
+// PrivateToolCallKey is the KV store key of the tool calls of a post marked with PrivateToolCallProp
+func PrivateToolCallKey(postID string) string {
+	return "private_tool_call_" + postID
+}
+
+// ToolStepsProp holds the []llm.ToolStep of a response whose tool calls ran before it continued, as JSON
+const ToolStepsProp = "llm_tool_steps"
+
+// PrivateToolStepsProp marks a post outside of the DM with the bot whose ToolStepsProp only holds the names and
+// statuses of the tool calls. The whole steps are kept in the KV store for the requester.
+const PrivateToolStepsProp = "llm_private_tool_steps"
+
+// PrivateToolStepsKey is the KV store key of the steps of a post marked with PrivateToolStepsProp
+func PrivateToolStepsKey(postID, requesterID string) string {
+	return "private_tool_steps_" + postID + "_" + requesterID
+}
+
 type Service interface {
-	StreamToNewPost(ctx context.Context, botID string, requesterUserID string, stream *llm.TextStreamResult, post *model.Post, respondingToPostID string) error
-	StreamToNewDM(ctx context.Context, botID string, stream *llm.TextStreamResult, userID string, post *model.Post, respondingToPostID string) error
+	StreamToNewPost(ctx context.Context, cancelRequest context.CancelFunc, botID string, requesterUserID string, stream *llm.TextStreamResult, post *model.Post, respondingToPostID string) error
+	StreamToNewDM(ctx context.Context, cancelRequest context.CancelFunc, botID string, stream *llm.TextStreamResult, userID string, post *model.Post, respondingToPostID string) error
 	StreamToPost(ctx context.Context, stream *llm.TextStreamResult, post *model.Post, userLocale string)
 	StopStreaming(postID string)
 	GetStreamingContext(inCtx context.Context, postID string) (context.Context, error)
 	FinishStreaming(postID string)
+	ResendToolApproval(post *model.Post, userLocale string) error
 }
 
 type postStreamContext struct {
@@ -37,6 +78,13 @@ type postStreamContext struct {
 
 var ErrAlreadyStreamingToPost = fmt.Errorf("already streaming to post")
 
+// NewRequestContext returns the context to make an LLM request with when its stream goes to a post that doesn't
+// exist yet. Passing cancel on to StreamToNewPost or StreamToNewDM lets StopStreaming cancel the request, they
+// call it once the stream is done or failed to start. Callers must call it themselves when they don't get that far.
+func NewRequestContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(parent)
+}
+
 type MMPostStreamService struct {
 	contexts      map[string]postStreamContext
 	contextsMutex sync.Mutex
@@ -52,18 +100,18 @@ func NewMMPostStreamService(mmClient mmapi.Client, i18n *i18n.Bundle) *MMPostStr
 	}
 }
 
-func (p *MMPostStreamService) StreamToNewPost(ctx context.Context, botID string, requesterUserID string, stream *llm.TextStreamResult, post *model.Post, respondingToPostID string) error {
+func (p *MMPostStreamService) StreamToNewPost(ctx context.Context, cancelRequest context.CancelFunc, botID string, requesterUserID string, stream *llm.TextStreamResult, post *model.Post, respondingToPostID string) error {
 	// We use ModifyPostForBot directly here to add the responding to post ID
 	ModifyPostForBot(botID, requesterUserID, post, respondingToPostID)
 
 	if err := p.mmClient.CreatePost(post); err != nil {
+		cancelRequest()
 		return fmt.Errorf("unable to create post: %w", err)
 	}
 
-	// The callback is already set when creating the context
-
-	ctx, err := p.GetStreamingContext(context.Background(), post.Id)
+	ctx, err := p.streamingContext(ctx, post.Id, cancelRequest)
 	if err != nil {
+		cancelRequest()
 		return err
 	}
 
@@ -94,18 +142,18 @@ func (p *MMPostStreamService) StreamToNewPost(ctx context.Context, botID string,
 	return nil
 }
 
-func (p *MMPostStreamService) StreamToNewDM(ctx context.Context, botID string, stream *llm.TextStreamResult, userID string, post *model.Post, respondingToPostID string) error {
+func (p *MMPostStreamService) StreamToNewDM(ctx context.Context, cancelRequest context.CancelFunc, botID string, stream *llm.TextStreamResult, userID string, post *model.Post, respondingToPostID string) error {
 	// We use ModifyPostForBot directly here to add the responding to post ID
 	ModifyPostForBot(botID, userID, post, respondingToPostID)
 
 	if err := p.mmClient.DM(botID, userID, post); err != nil {
+		cancelRequest()
 		return fmt.Errorf("failed to post DM: %w", err)
 	}
 
-	// The callback is already set when creating the context
-
-	ctx, err := p.GetStreamingContext(context.Background(), post.Id)
+	ctx, err := p.streamingContext(ctx, post.Id, cancelRequest)
 	if err != nil {
+		cancelRequest()
 		return err
 	}
 
@@ -145,6 +193,15 @@ func (p *MMPostStreamService) sendPostStreamingUpdateEvent(post *model.Post, mes
 	})
 }
 
+func (p *MMPostStreamService) sendPostStreamingReasoningEvent(post *model.Post, reasoning string) {
+	p.mmClient.PublishWebSocketEvent("postupdate", map[string]interface{}{
+		"post_id":   post.Id,
+		"reasoning": reasoning,
+	}, &model.WebsocketBroadcast{
+		ChannelId: post.ChannelId,
+	})
+}
+
 func (p *MMPostStreamService) sendPostStreamingControlEvent(post *model.Post, control string) {
 	p.mmClient.PublishWebSocketEvent("postupdate", map[string]interface{}{
 		"post_id": post.Id,
@@ -154,6 +211,8 @@ func (p *MMPostStreamService) sendPostStreamingControlEvent(post *model.Post, co
 	})
 }
 
+// StopStreaming cancels the streaming context of the post. The LLM request is cancelled along with it when it was
+// made with the streaming context or its cancel function was given to StreamToNewPost or StreamToNewDM.
 func (p *MMPostStreamService) StopStreaming(postID string) {
 	p.contextsMutex.Lock()
 	defer p.contextsMutex.Unlock()
@@ -164,6 +223,12 @@ func (p *MMPostStreamService) StopStreaming(postID string) {
 }
 
 func (p *MMPostStreamService) GetStreamingContext(inCtx context.Context, postID string) (context.Context, error) {
+	return p.streamingContext(inCtx, postID, nil)
+}
+
+// streamingContext registers the streaming context of the post, cancelRequest is called along with its cancel
+// function when not nil.
+func (p *MMPostStreamService) streamingContext(inCtx context.Context, postID string, cancelRequest context.CancelFunc) (context.Context, error) {
 	p.contextsMutex.Lock()
 	defer p.contextsMutex.Unlock()
 
@@ -172,6 +237,13 @@ func (p *MMPostStreamService) GetStreamingContext(inCtx context.Context, postID
 	}
 
 	ctx, cancel := context.WithCancel(inCtx)
+	if cancelRequest != nil {
+		streamCancel := cancel
+		cancel = func() {
+			streamCancel()
+			cancelRequest()
+		}
+	}
 
 	streamingContext := postStreamContext{
 		cancel: cancel,
@@ -187,6 +259,9 @@ func (p *MMPostStreamService) GetStreamingContext(inCtx context.Context, postID
 func (p *MMPostStreamService) FinishStreaming(postID string) {
 	p.contextsMutex.Lock()
 	defer p.contextsMutex.Unlock()
+	if streamContext, ok := p.contexts[postID]; ok {
+		streamContext.cancel()
+	}
 	delete(p.contexts, postID)
 }
 
@@ -199,6 +274,7 @@ func (p *MMPostStreamService) StreamToPost(ctx context.Context, stream *llm.Text
 		p.sendPostStreamingControlEvent(post, PostStreamingControlEnd)
 	}()
 
+	reasoning := ""
 	for {
 		select {
 		case event := <-stream.Stream:
@@ -209,6 +285,33 @@ func (p *MMPostStreamService) StreamToPost(ctx context.Context, stream *llm.Text
 					post.Message += textChunk
 					p.sendPostStreamingUpdateEvent(post, post.Message)
 				}
+			case llm.EventTypeReasoning:
+				if reasoningChunk, ok := event.Value.(string); ok {
+					reasoning += reasoningChunk
+					post.AddProp(ReasoningProp, reasoning)
+					p.sendPostStreamingReasoningEvent(post, reasoning)
+				}
+			case llm.EventTypeReasoningBlocks:
+				if blocks, ok := event.Value.([]llm.ReasoningBlock); ok {
+					blocksJSON, err := json.Marshal(blocks)
+					if err != nil {
+						p.mmClient.LogError("Failed to marshal reasoning blocks", "error", err)
+					} else {
+						post.AddProp(ReasoningBlocksProp, string(blocksJSON))
+					}
+				}
+			case llm.EventTypeToolStep:
+				if step, ok := event.Value.(llm.ToolStep); ok {
+					p.addToolStep(post, step)
+				}
+			case llm.EventTypeProvider:
+				if provider, ok := event.Value.(string); ok {
+					post.AddProp(ProviderProp, provider)
+				}
+			case llm.EventTypeUsage:
+				if usage, ok := event.Value.(llm.Usage); ok {
+					addUsageProp(post, usage)
+				}
 			case llm.EventTypeEnd:
 				// Stream has closed cleanly
 				if strings.TrimSpace(post.Message) == "" {
@@ -222,6 +325,12 @@ func (p *MMPostStreamService) StreamToPost(ctx context.Context, stream *llm.Text
 				}
 				return
 			case llm.EventTypeError:
+				if ctx.Err() != nil {
+					// The request failed because it was cancelled along with the stream
+					p.stopStreamToPost(stream, post)
+					return
+				}
+
 				// Handle error event
 				var err error
 				if errValue, ok := event.Value.(error); ok {
@@ -248,9 +357,16 @@ func (p *MMPostStreamService) StreamToPost(ctx context.Context, stream *llm.Text
 			case llm.EventTypeToolCalls:
 				// Handle tool call event
 				if toolCalls, ok := event.Value.([]llm.ToolCall); ok {
-					// Ensure all tool calls have Pending status
+					// Tool calls the policies already accepted or rejected keep their status, the others wait for the user
 					for i := range toolCalls {
-						toolCalls[i].Status = llm.ToolCallStatusPending
+						if toolCalls[i].Status != llm.ToolCallStatusAccepted && toolCalls[i].Status != llm.ToolCallStatusRejected {
+							toolCalls[i].Status = llm.ToolCallStatusPending
+						}
+					}
+
+					if channel, err := p.mmClient.GetChannel(post.ChannelId); err != nil || !mmapi.IsDMWith(post.UserId, channel) {
+						p.requestPrivateToolApproval(post, toolCalls, T)
+						return
 					}
 
 					// Add the tool call as a prop to the post
@@ -278,12 +394,199 @@ func (p *MMPostStreamService) StreamToPost(ctx context.Context, stream *llm.Text
 				return
 			}
 		case <-ctx.Done():
-			if err := p.mmClient.UpdatePost(post); err != nil {
-				p.mmClient.LogError("Error updating post on stop signaled", "error", err)
-				return
-			}
-			p.sendPostStreamingControlEvent(post, PostStreamingControlCancel)
+			p.stopStreamToPost(stream, post)
 			return
 		}
 	}
 }
+
+// stopStreamToPost saves what was streamed so far when the stream is stopped. The events the LLM request sends
+// while it winds down are discarded.
+func (p *MMPostStreamService) stopStreamToPost(stream *llm.TextStreamResult, post *model.Post) {
+	go func() {
+		for range stream.Stream {
+		}
+	}()
+
+	if err := p.mmClient.UpdatePost(post); err != nil {
+		p.mmClient.LogError("Error updating post on stop signaled", "error", err)
+		return
+	}
+	p.sendPostStreamingControlEvent(post, PostStreamingControlCancel)
+}
+
+// requestPrivateToolApproval keeps the tool calls of a post outside of a DM away from the other members of
+// the channel and asks the requester to approve them with an ephemeral post.
+func (p *MMPostStreamService) requestPrivateToolApproval(post *model.Post, toolCalls []llm.ToolCall, T i18n.TranslationFunc) {
+	if err := p.mmClient.KVSet(PrivateToolCallKey(post.Id), toolCalls); err != nil {
+		p.mmClient.LogError("Failed to save private tool calls", "error", err)
+		return
+	}
+
+	post.AddProp(PrivateToolCallProp, true)
+	if err := p.mmClient.UpdatePost(post); err != nil {
+		p.mmClient.LogError("Failed to update post with private tool call", "error", err)
+	}
+
+	if err := p.sendToolApproval(post, toolCalls, T); err != nil {
+		p.mmClient.LogError("Failed to send tool approval request", "error", err)
+	}
+}
+
+// ResendToolApproval sends the requester the ephemeral post asking to approve the private tool calls of post
+// again, the one sent when the calls were made is gone once the requester reloads.
+func (p *MMPostStreamService) ResendToolApproval(post *model.Post, userLocale string) error {
+	var toolCalls []llm.ToolCall
+	if err := p.mmClient.KVGet(PrivateToolCallKey(post.Id), &toolCalls); err != nil {
+		return fmt.Errorf("failed to get private tool calls: %w", err)
+	}
+	if len(toolCalls) == 0 {
+		return errors.New("post missing pending tool calls")
+	}
+
+	return p.sendToolApproval(post, toolCalls, i18n.LocalizerFunc(p.i18n, userLocale))
+}
+
+// sendToolApproval sends the ephemeral post asking the requester of post to approve toolCalls
+func (p *MMPostStreamService) sendToolApproval(post *model.Post, toolCalls []llm.ToolCall, T i18n.TranslationFunc) error {
+	toolCallJSON, err := json.Marshal(toolCalls)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tool calls: %w", err)
+	}
+
+	rootID := post.RootId
+	if rootID == "" {
+		rootID = post.Id
+	}
+	requesterID, _ := post.GetProp(LLMRequesterUserID).(string)
+	approvalPost := &model.Post{
+		UserId:    post.UserId,
+		ChannelId: post.ChannelId,
+		RootId:    rootID,
+		Type:      ToolApprovalPostType,
+		Message:   T("agents.tool_call_private_approval", "The agent wants to use tools to answer. Only you can see this request."),
+	}
+	approvalPost.AddProp(ToolApprovalPostIDProp, post.Id)
+	approvalPost.AddProp(ToolCallProp, string(toolCallJSON))
+	p.mmClient.SendEphemeralPost(requesterID, approvalPost)
+	return nil
+}
+
+// addToolStep records a step of the response on the post and sends it to the clients so they show its tool
+// calls while the response continues.
+func (p *MMPostStreamService) addToolStep(post *model.Post, step llm.ToolStep) {
+	requesterID, _ := post.GetProp(LLMRequesterUserID).(string)
+	steps := GetToolSteps(p.mmClient, post, requesterID)
+	steps = append(steps, step)
+
+	// Outside of the DM with the bot the steps are kept away from the other members of the channel
+	private := post.GetProp(PrivateToolStepsProp) != nil
+	if !private {
+		channel, err := p.mmClient.GetChannel(post.ChannelId)
+		private = err != nil || !mmapi.IsDMWith(post.UserId, channel)
+	}
+
+	stepsJSON, err := SetToolSteps(p.mmClient, post, steps, private)
+	if err != nil {
+		p.mmClient.LogError("Failed to record tool steps", "error", err)
+		return
+	}
+
+	p.mmClient.PublishWebSocketEvent("postupdate", map[string]interface{}{
+		"post_id":    post.Id,
+		"control":    "tool_steps",
+		"tool_steps": stepsJSON,
+	}, &model.WebsocketBroadcast{
+		ChannelId: post.ChannelId,
+	})
+}
+
+// GetToolSteps returns the steps recorded on the post as userID made them, nil when there are none. The steps
+// of a post marked with PrivateToolStepsProp are only returned to its requester.
+func GetToolSteps(mmClient mmapi.Client, post *model.Post, userID string) []llm.ToolStep {
+	if post.GetProp(PrivateToolStepsProp) != nil {
+		requesterID, _ := post.GetProp(LLMRequesterUserID).(string)
+		if requesterID == "" || requesterID != userID {
+			return nil
+		}
+
+		var steps []llm.ToolStep
+		if err := mmClient.KVGet(PrivateToolStepsKey(post.Id, requesterID), &steps); err != nil {
+			mmClient.LogError("Failed to get private tool steps", "error", err)
+			return nil
+		}
+		return steps
+	}
+
+	stepsJSON, ok := post.GetProp(ToolStepsProp).(string)
+	if !ok {
+		return nil
+	}
+	var steps []llm.ToolStep
+	if err := json.Unmarshal([]byte(stepsJSON), &steps); err != nil {
+		return nil
+	}
+	return steps
+}
+
+// SetToolSteps records the steps on the post and returns the JSON added to it. When private, the post only
+// gets the names and statuses of the tool calls and the steps are kept in the KV store for the requester.
+func SetToolSteps(mmClient mmapi.Client, post *model.Post, steps []llm.ToolStep, private bool) (string, error) {
+	visibleSteps := steps
+	if private {
+		requesterID, _ := post.GetProp(LLMRequesterUserID).(string)
+		if err := mmClient.KVSet(PrivateToolStepsKey(post.Id, requesterID), steps); err != nil {
+			return "", fmt.Errorf("failed to save private tool steps: %w", err)
+		}
+
+		visibleSteps = make([]llm.ToolStep, 0, len(steps))
+		for _, step := range steps {
+			toolCalls := make([]llm.ToolCall, 0, len(step.ToolCalls))
+			for _, toolCall := range step.ToolCalls {
+				toolCalls = append(toolCalls, llm.ToolCall{ID: toolCall.ID, Name: toolCall.Name, Status: toolCall.Status})
+			}
+			visibleSteps = append(visibleSteps, llm.ToolStep{Message: step.Message, ToolCalls: toolCalls})
+		}
+	}
+
+	stepsJSON, err := json.Marshal(visibleSteps)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal tool steps: %w", err)
+	}
+	post.AddProp(ToolStepsProp, string(stepsJSON))
+	if private {
+		post.AddProp(PrivateToolStepsProp, true)
+	}
+	return string(stepsJSON), nil
+}
+
+// DeleteToolSteps removes the steps recorded on the post
+func DeleteToolSteps(mmClient mmapi.Client, post *model.Post) {
+	if post.GetProp(PrivateToolStepsProp) != nil {
+		requesterID, _ := post.GetProp(LLMRequesterUserID).(string)
+		if err := mmClient.KVSet(PrivateToolStepsKey(post.Id, requesterID), nil); err != nil {
+			mmClient.LogError("Failed to delete private tool steps", "error", err)
+		}
+	}
+	post.DelProp(ToolStepsProp)
+	post.DelProp(PrivateToolStepsProp)
+}
+
+// addUsageProp adds usage to the usage already recorded on the post, tool calls and regenerations
+// stream into the same post more than once.
+func addUsageProp(post *model.Post, usage llm.Usage) {
+	if existingJSON, ok := post.GetProp(UsageProp).(string); ok {
+		var existing llm.Usage
+		if err := json.Unmarshal([]byte(existingJSON), &existing); err == nil {
+			usage.InputTokens += existing.InputTokens
+			usage.OutputTokens += existing.OutputTokens
+			usage.CachedTokens += existing.CachedTokens
+		}
+	}
+
+	usageJSON, err := json.Marshal(usage)
+	if err != nil {
+		return
+	}
+	post.AddProp(UsageProp, string(usageJSON))
+}
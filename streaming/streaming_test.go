@@ -0,0 +1,227 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-ai/i18n"
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/mattermost/mattermost-plugin-ai/mmapi/mocks"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestCancellation(t *testing.T) {
+	t.Run("stopping the stream cancels the request", func(t *testing.T) {
+		service := NewMMPostStreamService(mocks.NewMockClient(t), i18n.Init())
+		requestCtx, cancel := NewRequestContext(context.Background())
+
+		streamCtx, err := service.streamingContext(requestCtx, "post", cancel)
+		require.NoError(t, err)
+
+		service.StopStreaming("post")
+		assert.Error(t, streamCtx.Err())
+		assert.Error(t, requestCtx.Err())
+	})
+
+	t.Run("finishing the stream cancels the request", func(t *testing.T) {
+		service := NewMMPostStreamService(mocks.NewMockClient(t), i18n.Init())
+		requestCtx, cancel := NewRequestContext(context.Background())
+
+		_, err := service.streamingContext(requestCtx, "post", cancel)
+		require.NoError(t, err)
+
+		service.FinishStreaming("post")
+		assert.Error(t, requestCtx.Err())
+	})
+
+	t.Run("the request is cancelled when the post can't be created", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().CreatePost(mock.Anything).Return(errors.New("database down"))
+		service := NewMMPostStreamService(mmClient, i18n.Init())
+		requestCtx, cancel := NewRequestContext(context.Background())
+
+		err := service.StreamToNewPost(requestCtx, cancel, "bot", "user", &llm.TextStreamResult{}, &model.Post{ChannelId: "channel"}, "")
+		require.Error(t, err)
+		assert.Error(t, requestCtx.Err())
+	})
+
+	t.Run("a post streams only once", func(t *testing.T) {
+		service := NewMMPostStreamService(mocks.NewMockClient(t), i18n.Init())
+
+		_, err := service.GetStreamingContext(context.Background(), "post")
+		require.NoError(t, err)
+		_, err = service.GetStreamingContext(context.Background(), "post")
+		assert.ErrorIs(t, err, ErrAlreadyStreamingToPost)
+	})
+}
+
+func TestRequestPrivateToolApproval(t *testing.T) {
+	toolCalls := []llm.ToolCall{{ID: "call", Name: "GetJiraIssue", Arguments: []byte(`{"issue":"MM-1"}`)}}
+	T := i18n.LocalizerFunc(i18n.Init(), "en")
+
+	newPost := func() *model.Post {
+		post := &model.Post{Id: "post", UserId: "bot", ChannelId: "channel", RootId: "root"}
+		post.AddProp(LLMRequesterUserID, "requester")
+		return post
+	}
+
+	t.Run("the tool calls are kept private and only the requester is asked", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().KVSet(PrivateToolCallKey("post"), toolCalls).Return(nil)
+		mmClient.EXPECT().UpdatePost(mock.MatchedBy(func(post *model.Post) bool {
+			return post.GetProp(PrivateToolCallProp) == true && post.GetProp(ToolCallProp) == nil
+		})).Return(nil)
+		var approvalPost *model.Post
+		mmClient.EXPECT().SendEphemeralPost("requester", mock.Anything).Run(func(_ string, post *model.Post) {
+			approvalPost = post
+		})
+		service := NewMMPostStreamService(mmClient, i18n.Init())
+
+		service.requestPrivateToolApproval(newPost(), toolCalls, T)
+
+		require.NotNil(t, approvalPost)
+		assert.Equal(t, ToolApprovalPostType, approvalPost.Type)
+		assert.Equal(t, "channel", approvalPost.ChannelId)
+		assert.Equal(t, "root", approvalPost.RootId)
+		assert.Equal(t, "post", approvalPost.GetProp(ToolApprovalPostIDProp))
+		var sent []llm.ToolCall
+		require.NoError(t, json.Unmarshal([]byte(approvalPost.GetProp(ToolCallProp).(string)), &sent))
+		assert.Equal(t, toolCalls, sent)
+	})
+
+	t.Run("a root post is the root of the approval thread", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().KVSet(mock.Anything, mock.Anything).Return(nil)
+		mmClient.EXPECT().UpdatePost(mock.Anything).Return(nil)
+		mmClient.EXPECT().SendEphemeralPost("requester", mock.MatchedBy(func(post *model.Post) bool {
+			return post.RootId == "post"
+		}))
+		service := NewMMPostStreamService(mmClient, i18n.Init())
+
+		post := newPost()
+		post.RootId = ""
+		service.requestPrivateToolApproval(post, toolCalls, T)
+	})
+
+	t.Run("nothing is shown when the tool calls can't be saved", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().KVSet(mock.Anything, mock.Anything).Return(errors.New("kv down"))
+		mmClient.EXPECT().LogError(mock.Anything, mock.Anything, mock.Anything)
+		service := NewMMPostStreamService(mmClient, i18n.Init())
+
+		post := newPost()
+		service.requestPrivateToolApproval(post, toolCalls, T)
+		assert.Nil(t, post.GetProp(PrivateToolCallProp))
+	})
+}
+
+func TestResendToolApproval(t *testing.T) {
+	toolCalls := []llm.ToolCall{{ID: "call", Name: "GetJiraIssue", Arguments: []byte(`{"issue":"MM-1"}`)}}
+	post := &model.Post{Id: "post", UserId: "bot", ChannelId: "channel"}
+	post.AddProp(LLMRequesterUserID, "requester")
+	post.AddProp(PrivateToolCallProp, true)
+
+	t.Run("the requester is asked again", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().KVGet(PrivateToolCallKey("post"), mock.Anything).Run(func(_ string, value interface{}) {
+			*value.(*[]llm.ToolCall) = toolCalls
+		}).Return(nil)
+		mmClient.EXPECT().SendEphemeralPost("requester", mock.MatchedBy(func(approvalPost *model.Post) bool {
+			return approvalPost.Type == ToolApprovalPostType && approvalPost.GetProp(ToolApprovalPostIDProp) == "post"
+		}))
+		service := NewMMPostStreamService(mmClient, i18n.Init())
+
+		require.NoError(t, service.ResendToolApproval(post, "en"))
+	})
+
+	t.Run("tool calls that were already decided", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().KVGet(PrivateToolCallKey("post"), mock.Anything).Return(nil)
+		service := NewMMPostStreamService(mmClient, i18n.Init())
+
+		require.Error(t, service.ResendToolApproval(post, "en"))
+	})
+}
+
+func TestToolSteps(t *testing.T) {
+	steps := []llm.ToolStep{{
+		Message: "Let me check.",
+		ToolCalls: []llm.ToolCall{{
+			ID:        "call",
+			Name:      "GetJiraIssue",
+			Arguments: []byte(`{"issue":"MM-1"}`),
+			Result:    "private issue",
+			Status:    llm.ToolCallStatusSuccess,
+		}},
+		Reasoning: []llm.ReasoningBlock{{Text: "thinking"}},
+	}}
+	newPost := func() *model.Post {
+		post := &model.Post{Id: "post", UserId: "bot", ChannelId: "channel"}
+		post.AddProp(LLMRequesterUserID, "requester")
+		return post
+	}
+
+	t.Run("steps in the DM with the bot are on the post", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		post := newPost()
+
+		_, err := SetToolSteps(mmClient, post, steps, false)
+		require.NoError(t, err)
+		assert.Nil(t, post.GetProp(PrivateToolStepsProp))
+		assert.Equal(t, steps, GetToolSteps(mmClient, post, "other"))
+	})
+
+	t.Run("private steps only show the names and statuses of the tool calls", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().KVSet(PrivateToolStepsKey("post", "requester"), steps).Return(nil)
+		post := newPost()
+
+		stepsJSON, err := SetToolSteps(mmClient, post, steps, true)
+		require.NoError(t, err)
+		assert.Equal(t, stepsJSON, post.GetProp(ToolStepsProp))
+		assert.Equal(t, true, post.GetProp(PrivateToolStepsProp))
+		assert.NotContains(t, stepsJSON, "private issue")
+		assert.NotContains(t, stepsJSON, "MM-1")
+		assert.NotContains(t, stepsJSON, "thinking")
+
+		var visible []llm.ToolStep
+		require.NoError(t, json.Unmarshal([]byte(stepsJSON), &visible))
+		require.Len(t, visible, 1)
+		require.Len(t, visible[0].ToolCalls, 1)
+		assert.Equal(t, "GetJiraIssue", visible[0].ToolCalls[0].Name)
+		assert.Equal(t, llm.ToolCallStatusSuccess, visible[0].ToolCalls[0].Status)
+	})
+
+	t.Run("private steps are only returned to the requester", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().KVGet(PrivateToolStepsKey("post", "requester"), mock.Anything).Run(func(_ string, value interface{}) {
+			*value.(*[]llm.ToolStep) = steps
+		}).Return(nil)
+		post := newPost()
+		post.AddProp(ToolStepsProp, "[]")
+		post.AddProp(PrivateToolStepsProp, true)
+
+		assert.Nil(t, GetToolSteps(mmClient, post, "other"))
+		assert.Equal(t, steps, GetToolSteps(mmClient, post, "requester"))
+	})
+
+	t.Run("private steps are deleted with the post's", func(t *testing.T) {
+		mmClient := mocks.NewMockClient(t)
+		mmClient.EXPECT().KVSet(PrivateToolStepsKey("post", "requester"), nil).Return(nil)
+		post := newPost()
+		post.AddProp(ToolStepsProp, "[]")
+		post.AddProp(PrivateToolStepsProp, true)
+
+		DeleteToolSteps(mmClient, post)
+		assert.Nil(t, post.GetProp(ToolStepsProp))
+		assert.Nil(t, post.GetProp(PrivateToolStepsProp))
+	})
+}
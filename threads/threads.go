@@ -4,6 +4,7 @@
 package threads
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/mattermost/mattermost-plugin-ai/format"
@@ -30,19 +31,19 @@ func New(
 	}
 }
 
-func (t *Threads) Summarize(threadRootID string, context *llm.Context) (*llm.TextStreamResult, error) {
-	return t.Analyze(threadRootID, context, prompts.PromptSummarizeThreadSystem)
+func (t *Threads) Summarize(ctx context.Context, threadRootID string, context *llm.Context) (*llm.TextStreamResult, error) {
+	return t.Analyze(ctx, threadRootID, context, prompts.PromptSummarizeThreadSystem)
 }
 
-func (t *Threads) FindActionItems(threadRootID string, context *llm.Context) (*llm.TextStreamResult, error) {
-	return t.Analyze(threadRootID, context, prompts.PromptFindActionItemsSystem)
+func (t *Threads) FindActionItems(ctx context.Context, threadRootID string, context *llm.Context) (*llm.TextStreamResult, error) {
+	return t.Analyze(ctx, threadRootID, context, prompts.PromptFindActionItemsSystem)
 }
 
-func (t *Threads) FindOpenQuestions(threadRootID string, context *llm.Context) (*llm.TextStreamResult, error) {
-	return t.Analyze(threadRootID, context, prompts.PromptFindOpenQuestionsSystem)
+func (t *Threads) FindOpenQuestions(ctx context.Context, threadRootID string, context *llm.Context) (*llm.TextStreamResult, error) {
+	return t.Analyze(ctx, threadRootID, context, prompts.PromptFindOpenQuestionsSystem)
 }
 
-func (t *Threads) Analyze(postIDToAnalyze string, context *llm.Context, promptName string) (*llm.TextStreamResult, error) {
+func (t *Threads) Analyze(ctx context.Context, postIDToAnalyze string, context *llm.Context, promptName string) (*llm.TextStreamResult, error) {
 	posts, err := t.createInitalPosts(postIDToAnalyze, context, promptName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create initial posts: %w", err)
@@ -52,7 +53,7 @@ func (t *Threads) Analyze(postIDToAnalyze string, context *llm.Context, promptNa
 		Posts:   posts,
 		Context: context,
 	}
-	analysisStream, err := t.llm.ChatCompletion(completionReqest)
+	analysisStream, err := t.llm.ChatCompletion(ctx, completionReqest)
 	if err != nil {
 		return nil, err
 	}
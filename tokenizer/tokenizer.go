@@ -0,0 +1,127 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+// Package tokenizer counts tokens the way the models of each provider do. OpenAI models are counted
+// exactly with their byte pair encodings, other models with estimators calibrated for their tokenizers.
+package tokenizer
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+func init() {
+	// Use the encodings embedded in the binary instead of downloading them.
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+}
+
+const (
+	EncodingO200K  = "o200k_base"
+	EncodingCL100K = "cl100k_base"
+)
+
+// BPE counts tokens with one of the OpenAI byte pair encodings. The encoding is loaded on first use,
+// if it can't be loaded tokens are estimated instead.
+type BPE struct {
+	encodingName string
+	once         sync.Once
+	encoding     *tiktoken.Tiktoken
+}
+
+// The encodings are large so they are loaded once and shared by all models.
+var (
+	O200K  = &BPE{encodingName: EncodingO200K}
+	CL100K = &BPE{encodingName: EncodingCL100K}
+)
+
+func (t *BPE) CountTokens(text string) int {
+	t.once.Do(func() {
+		t.encoding, _ = tiktoken.GetEncoding(t.encodingName)
+	})
+	if t.encoding == nil {
+		return Default.CountTokens(text)
+	}
+
+	// Special tokens are counted as plain text, the way they are sent to the model.
+	return len(t.encoding.EncodeOrdinary(text))
+}
+
+// Estimator approximates token counts for models whose tokenizer is not available offline. Text is
+// estimated from both its characters and its words, which keeps the estimate close for prose as well as
+// for code and markup. CJK characters, which are not separated by spaces, are counted on their own.
+type Estimator struct {
+	CharsPerToken    float64
+	TokensPerWord    float64
+	TokensPerCJKRune float64
+}
+
+var (
+	// Default is calibrated against the o200k and cl100k encodings, it is used for Gemini, Llama and other
+	// models with similarly sized vocabularies.
+	Default = Estimator{CharsPerToken: 4.5, TokensPerWord: 1.3, TokensPerCJKRune: 0.8}
+	// Anthropic accounts for the Claude tokenizer splitting text in more tokens than the OpenAI encodings.
+	Anthropic = Estimator{CharsPerToken: 4.0, TokensPerWord: 1.5, TokensPerCJKRune: 1.1}
+)
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func (e Estimator) CountTokens(text string) int {
+	chars, words, cjk := 0, 0, 0
+	inWord := false
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			cjk++
+			inWord = false
+		case unicode.IsSpace(r):
+			chars++
+			inWord = false
+		default:
+			chars++
+			if !inWord {
+				words++
+			}
+			inWord = true
+		}
+	}
+
+	estimate := (float64(chars)/e.CharsPerToken+float64(words)*e.TokensPerWord)/2 + float64(cjk)*e.TokensPerCJKRune
+	return int(math.Ceil(estimate))
+}
+
+var o200kPrefixes = []string{"gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "gpt-oss", "o1", "o3", "o4"}
+var cl100kPrefixes = []string{"gpt-4", "gpt-3.5", "text-embedding-"}
+
+// ForModel returns the tokenizer for the given model name. Names can include a vendor prefix, such as
+// "openai/gpt-4o" or "us.anthropic.claude-sonnet-4". Unknown models use the Default estimator.
+// Providers pick the tokenizer of their default model, it is also used for requests made for other models.
+func ForModel(model string) llm.Tokenizer {
+	model = strings.ToLower(model)
+	if strings.Contains(model, "claude") {
+		return Anthropic
+	}
+
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	for _, prefix := range o200kPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return O200K
+		}
+	}
+	for _, prefix := range cl100kPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return CL100K
+		}
+	}
+
+	return Default
+}
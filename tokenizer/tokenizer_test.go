@@ -0,0 +1,72 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package tokenizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-ai/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleEnglish = `Mattermost is an open source platform for secure collaboration across the entire software development lifecycle. ` +
+	`Teams use it to plan sprints, review pull requests, respond to incidents and keep everyone informed without leaving their workspace. ` +
+	`The AI plugin lets users mention an agent in any channel to summarize threads, answer questions about past discussions, ` +
+	`or draft replies, while administrators control which models are used and who can access them.`
+
+const sampleJapanese = `Mattermostは、ソフトウェア開発ライフサイクル全体にわたる安全なコラボレーションのためのオープンソースプラットフォームです。` +
+	`チームはスプリントの計画、プルリクエストのレビュー、インシデントへの対応に利用しています。`
+
+func TestForModel(t *testing.T) {
+	tests := []struct {
+		model    string
+		expected llm.Tokenizer
+	}{
+		{model: "gpt-4o", expected: O200K},
+		{model: "gpt-4o-mini", expected: O200K},
+		{model: "gpt-4.1", expected: O200K},
+		{model: "o3-mini", expected: O200K},
+		{model: "openai/gpt-4o", expected: O200K},
+		{model: "gpt-oss:20b", expected: O200K},
+		{model: "gpt-4-turbo", expected: CL100K},
+		{model: "gpt-3.5-turbo", expected: CL100K},
+		{model: "GPT-4", expected: CL100K},
+		{model: "claude-sonnet-4-20250514", expected: Anthropic},
+		{model: "us.anthropic.claude-3-5-haiku-20241022-v1:0", expected: Anthropic},
+		{model: "gemini-2.0-flash", expected: Default},
+		{model: "llama3.1", expected: Default},
+		{model: "", expected: Default},
+	}
+	for _, tc := range tests {
+		t.Run(tc.model, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ForModel(tc.model))
+		})
+	}
+}
+
+func TestBPE(t *testing.T) {
+	assert.Equal(t, 2, O200K.CountTokens("hello world"))
+	assert.Equal(t, 2, CL100K.CountTokens("hello world"))
+	assert.Equal(t, 0, O200K.CountTokens(""))
+
+	// Special tokens in user content are counted as text
+	assert.Greater(t, CL100K.CountTokens("<|endoftext|>"), 1)
+}
+
+func TestEstimator(t *testing.T) {
+	assert.Equal(t, 0, Default.CountTokens(""))
+	assert.Equal(t, 3, Default.CountTokens("hello world"))
+	assert.Greater(t, Anthropic.CountTokens(sampleEnglish), Default.CountTokens(sampleEnglish))
+	assert.Equal(t, 5, Default.CountTokens(strings.Repeat("日本", 3)))
+
+	// The default estimator stays close to the OpenAI encodings
+	for _, sample := range []string{sampleEnglish, sampleJapanese} {
+		exact := O200K.CountTokens(sample)
+		require.Positive(t, exact)
+		estimate := Default.CountTokens(sample)
+		assert.InDelta(t, exact, estimate, float64(exact)*0.25, "estimate %d, exact %d", estimate, exact)
+	}
+}
@@ -127,6 +127,23 @@ export async function doRegenerate(postid: string) {
     });
 }
 
+export async function doResendToolApproval(postid: string) {
+    const url = `${postRoute(postid)}/tool_approval`;
+    const response = await fetch(url, Client4.getOptions({
+        method: 'POST',
+    }));
+
+    if (response.ok) {
+        return;
+    }
+
+    throw new ClientError(Client4.url, {
+        message: '',
+        status_code: response.status,
+        url,
+    });
+}
+
 export async function doToolCall(postid: string, toolIDs: string[]) {
     const url = `${postRoute(postid)}/tool_call`;
     const response = await fetch(url, Client4.getOptions({
@@ -372,6 +389,62 @@ export async function cancelReindex() {
         url,
     });
 }
+
+export async function listServiceModels(service: any) {
+    const url = `${baseRoute()}/admin/services/models`;
+    const response = await fetch(url, Client4.getOptions({
+        method: 'POST',
+        body: JSON.stringify(service),
+    }));
+
+    if (response.ok) {
+        return response.json();
+    }
+
+    const data = await response.json().catch(() => ({}));
+    throw new ClientError(Client4.url, {
+        message: data.error || '',
+        status_code: response.status,
+        url,
+    });
+}
+
+export async function testServiceConnection(service: any) {
+    const url = `${baseRoute()}/admin/services/test`;
+    const response = await fetch(url, Client4.getOptions({
+        method: 'POST',
+        body: JSON.stringify(service),
+    }));
+
+    if (response.ok) {
+        return response.json();
+    }
+
+    throw new ClientError(Client4.url, {
+        message: '',
+        status_code: response.status,
+        url,
+    });
+}
+
+export async function checkBotCapabilities(bot: any): Promise<{problems: string[]}> {
+    const url = `${baseRoute()}/admin/bots/capabilities`;
+    const response = await fetch(url, Client4.getOptions({
+        method: 'POST',
+        body: JSON.stringify(bot),
+    }));
+
+    if (response.ok) {
+        return response.json();
+    }
+
+    throw new ClientError(Client4.url, {
+        message: '',
+        status_code: response.status,
+        url,
+    });
+}
+
 export async function getChannelInterval(
     channelID: string,
     startTime: number,
@@ -401,3 +474,82 @@ export async function getChannelInterval(
         url,
     });
 }
+
+export type MCPResource = {
+    serverID: string;
+    uri: string;
+    name: string;
+    description?: string;
+    mimeType?: string;
+};
+
+export type MCPPromptArgument = {
+    name: string;
+    description?: string;
+    required?: boolean;
+};
+
+export type MCPPrompt = {
+    serverID: string;
+    name: string;
+    description?: string;
+    arguments?: MCPPromptArgument[];
+};
+
+export type MCPConversationRequest = {
+    message?: string;
+    resources?: Array<{serverID: string, uri: string, name?: string}>;
+    prompt?: {serverID: string, name: string, arguments: {[key: string]: string}};
+};
+
+export async function getMCPResources(): Promise<MCPResource[]> {
+    const url = `${baseRoute()}/mcp/resources`;
+    const response = await fetch(url, Client4.getOptions({
+        method: 'GET',
+    }));
+
+    if (response.ok) {
+        return response.json();
+    }
+
+    throw new ClientError(Client4.url, {
+        message: '',
+        status_code: response.status,
+        url,
+    });
+}
+
+export async function getMCPPrompts(): Promise<MCPPrompt[]> {
+    const url = `${baseRoute()}/mcp/prompts`;
+    const response = await fetch(url, Client4.getOptions({
+        method: 'GET',
+    }));
+
+    if (response.ok) {
+        return response.json();
+    }
+
+    throw new ClientError(Client4.url, {
+        message: '',
+        status_code: response.status,
+        url,
+    });
+}
+
+export async function doStartMCPConversation(request: MCPConversationRequest, botUsername?: string): Promise<{postID: string, channelID: string}> {
+    const url = `${baseRoute()}/mcp/conversation${botUsername ? `?botUsername=${botUsername}` : ''}`;
+    const response = await fetch(url, Client4.getOptions({
+        method: 'POST',
+        body: JSON.stringify(request),
+    }));
+
+    if (response.ok) {
+        return response.json();
+    }
+
+    throw new ClientError(Client4.url, {
+        message: '',
+        status_code: response.status,
+        url,
+    });
+}
@@ -11,20 +11,25 @@ import {GlobalState} from '@mattermost/types/store';
 
 import {SendIcon} from '@mattermost/compass-icons/components';
 
-import {doPostbackSummary, doRegenerate, doStopGenerating} from '@/client';
+import {doPostbackSummary, doRegenerate, doResendToolApproval, doStopGenerating} from '@/client';
 
 import {useSelectNotAIPost} from '@/hooks';
 
 import {PostMessagePreview} from '@/mm_webapp';
 
 import {SearchSources} from './search_sources';
+import {ReasoningDisplay} from './reasoning_display';
 
 import PostText from './post_text';
 import IconRegenerate from './assets/icon_regenerate';
 import IconCancel from './assets/icon_cancel';
 import ToolApprovalSet from './tool_approval_set';
+import ToolSteps, {ToolStep} from './tool_steps';
 
 const SearchResultsPropKey = 'search_results';
+const ReasoningPropKey = 'llm_reasoning';
+const ToolStepsPropKey = 'llm_tool_steps';
+const PrivateToolCallPropKey = 'llm_private_tool_call';
 
 const PostBody = styled.div`
 `;
@@ -93,11 +98,34 @@ const PostSummaryHelpMessage = styled.div`
 	margin-top: 16px;
 `;
 
+const PrivateToolCallNotice = styled.div`
+	margin-top: 8px;
+	font-size: 12px;
+	font-style: italic;
+	color: rgba(var(--center-channel-color-rgb), 0.64);
+`;
+
+const ResendToolApprovalButton = styled.button`
+	padding: 0;
+	margin-left: 4px;
+	border: none;
+	background: none;
+	font-size: 12px;
+	font-weight: 600;
+	color: var(--link-color);
+
+	:hover {
+		text-decoration: underline;
+	}
+`;
+
 export interface PostUpdateWebsocketMessage {
     post_id: string
     next?: string
+    reasoning?: string
     control?: string
     tool_call?: string
+    tool_steps?: string
 }
 
 export enum ToolCallStatus {
@@ -126,6 +154,7 @@ interface Props {
 export const LLMBotPost = (props: Props) => {
     const selectPost = useSelectNotAIPost();
     const [message, setMessage] = useState(props.post.message);
+    const [reasoning, setReasoning] = useState(props.post.props?.[ReasoningPropKey] ?? '');
 
     // Generating is true while we are reciving new content from the websocket
     const [generating, setGenerating] = useState(false);
@@ -138,6 +167,7 @@ export const LLMBotPost = (props: Props) => {
 
     // State for tool calls
     const [toolCalls, setToolCalls] = useState<ToolCall[]>([]);
+    const [toolSteps, setToolSteps] = useState<ToolStep[]>([]);
     const [error, setError] = useState('');
 
     const currentUserId = useSelector<GlobalState, string>((state) => state.entities.users.currentUserId);
@@ -156,9 +186,34 @@ export const LLMBotPost = (props: Props) => {
                 // Log error for debugging
                 setError('Error parsing tool calls');
             }
+        } else {
+            // The tool calls became a step of the response once they were decided
+            setToolCalls([]);
         }
     }, [toolCallsJson]);
 
+    // Get the steps the response already made from post props
+    const toolStepsJson = props.post.props?.[ToolStepsPropKey];
+
+    useEffect(() => {
+        if (toolStepsJson) {
+            try {
+                setToolSteps(JSON.parse(toolStepsJson));
+            } catch (error) {
+                setError('Error parsing tool steps');
+            }
+        } else {
+            setToolSteps([]);
+        }
+    }, [toolStepsJson]);
+
+    useEffect(() => {
+        const postReasoning = props.post.props?.[ReasoningPropKey];
+        if (postReasoning && postReasoning !== reasoning) {
+            setReasoning(postReasoning);
+        }
+    }, [props.post.props?.[ReasoningPropKey]]);
+
     useEffect(() => {
         if (props.post.message !== '' && props.post.message !== message) {
             setMessage(props.post.message);
@@ -189,6 +244,24 @@ export const LLMBotPost = (props: Props) => {
                     return;
                 }
 
+                // Steps are sent as the tools of the response run
+                if (data.control === 'tool_steps' && data.tool_steps) {
+                    try {
+                        setToolSteps(JSON.parse(data.tool_steps));
+                        setToolCalls([]);
+                    } catch (error) {
+                        setError('Error parsing tool steps');
+                    }
+                    return;
+                }
+
+                // Reasoning is streamed separately from the message
+                if (data.reasoning && !stoppedRef.current) {
+                    setGenerating(true);
+                    setReasoning(data.reasoning);
+                    return;
+                }
+
                 // Handle regular post updates
                 if (data.next && !stoppedRef.current) {
                     setGenerating(true);
@@ -220,6 +293,7 @@ export const LLMBotPost = (props: Props) => {
         setGenerating(true);
         setStopped(false);
         setMessage('');
+        setReasoning('');
         doRegenerate(props.post.id);
     };
 
@@ -229,6 +303,15 @@ export const LLMBotPost = (props: Props) => {
         doStopGenerating(props.post.id);
     };
 
+    // The ephemeral approval request is gone once the requester reloads, they can ask for it again
+    const resendToolApproval = async () => {
+        try {
+            await doResendToolApproval(props.post.id);
+        } catch (err) {
+            setError('Error sending the approval request');
+        }
+    };
+
     const postSummary = async () => {
         const result = await doPostbackSummary(props.post.id);
         selectPost(result.rootid, result.channelid);
@@ -267,6 +350,10 @@ export const LLMBotPost = (props: Props) => {
                 {permalinkView}
             </>
             }
+            <ReasoningDisplay
+                reasoning={reasoning}
+                generating={generating && message === ''}
+            />
             <PostText
                 message={message}
                 channelID={props.post.channel_id}
@@ -278,6 +365,17 @@ export const LLMBotPost = (props: Props) => {
                     sources={JSON.parse(props.post.props[SearchResultsPropKey])}
                 />
             )}
+            <ToolSteps steps={toolSteps}/>
+            {props.post.props?.[PrivateToolCallPropKey] && toolCalls.length === 0 && (
+                <PrivateToolCallNotice>
+                    <FormattedMessage defaultMessage='Waiting for the requester to approve the tools this response uses.'/>
+                    {requesterIsCurrentUser && (
+                        <ResendToolApprovalButton onClick={resendToolApproval}>
+                            <FormattedMessage defaultMessage='Show the approval request'/>
+                        </ResendToolApprovalButton>
+                    )}
+                </PrivateToolCallNotice>
+            )}
             {toolCalls && toolCalls.length > 0 && (
                 <ToolApprovalSet
                     postID={props.post.id}
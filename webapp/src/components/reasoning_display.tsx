@@ -0,0 +1,64 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+import React, {useState} from 'react';
+import styled from 'styled-components';
+import {FormattedMessage} from 'react-intl';
+
+const ReasoningContainer = styled.div`
+    margin-bottom: 8px;
+    border-left: 2px solid rgba(var(--center-channel-color-rgb), 0.16);
+    padding-left: 12px;
+`;
+
+const ReasoningHeader = styled.div`
+    display: flex;
+    align-items: center;
+    gap: 4px;
+    cursor: pointer;
+    color: rgba(var(--center-channel-color-rgb), 0.64);
+    font-size: 12px;
+    font-weight: 600;
+    line-height: 16px;
+`;
+
+const CollapseIcon = styled.i<{isOpen: boolean}>`
+    font-size: 16px;
+    transform: ${(props) => (props.isOpen ? 'rotate(180deg)' : 'rotate(0deg)')};
+    transition: transform 0.15s ease-in-out;
+`;
+
+const ReasoningText = styled.div`
+    margin-top: 4px;
+    color: rgba(var(--center-channel-color-rgb), 0.64);
+    font-size: 13px;
+    line-height: 18px;
+    white-space: pre-wrap;
+`;
+
+interface Props {
+    reasoning: string;
+    generating: boolean;
+}
+
+// ReasoningDisplay shows the reasoning of the model above its answer, collapsed unless the user opens it.
+export const ReasoningDisplay = ({reasoning, generating}: Props) => {
+    const [isOpen, setIsOpen] = useState(false);
+
+    if (!reasoning) {
+        return null;
+    }
+
+    return (
+        <ReasoningContainer data-testid='llm-bot-post-reasoning'>
+            <ReasoningHeader onClick={() => setIsOpen(!isOpen)}>
+                {generating ? <FormattedMessage defaultMessage='Thinking...'/> : <FormattedMessage defaultMessage='Reasoning'/>}
+                <CollapseIcon
+                    className='icon-chevron-down'
+                    isOpen={isOpen}
+                />
+            </ReasoningHeader>
+            {isOpen && <ReasoningText>{reasoning}</ReasoningText>}
+        </ReasoningContainer>
+    );
+};
@@ -0,0 +1,297 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+import React, {useEffect, useState} from 'react';
+import styled from 'styled-components';
+import {FormattedMessage, useIntl} from 'react-intl';
+
+import {LightbulbOutlineIcon, PaperclipIcon} from '@mattermost/compass-icons/components';
+
+import {doStartMCPConversation, getMCPPrompts, getMCPResources, MCPPrompt, MCPResource} from '@/client';
+import {LLMBot} from '@/bots';
+import Checkbox from '../checkbox';
+
+import {Button} from './common';
+
+type Props = {
+    activeBot: LLMBot | null
+    onStarted: (postID: string) => void
+}
+
+const resourceKey = (resource: {serverID: string, uri: string}) => `${resource.serverID}\n${resource.uri}`;
+
+// MCPOptions offers the prompts of the MCP servers as templates starting a conversation, and lets the user
+// attach MCP resources to the first message of a conversation.
+const MCPOptions = ({activeBot, onStarted}: Props) => {
+    const intl = useIntl();
+    const [prompts, setPrompts] = useState<MCPPrompt[]>([]);
+    const [resources, setResources] = useState<MCPResource[]>([]);
+    const [selectedPrompt, setSelectedPrompt] = useState<MCPPrompt | null>(null);
+    const [promptArguments, setPromptArguments] = useState<{[key: string]: string}>({});
+    const [showResources, setShowResources] = useState(false);
+    const [selectedResources, setSelectedResources] = useState<string[]>([]);
+    const [message, setMessage] = useState('');
+    const [starting, setStarting] = useState(false);
+    const [error, setError] = useState(false);
+
+    useEffect(() => {
+        getMCPPrompts().then(setPrompts).catch(() => setPrompts([]));
+        getMCPResources().then(setResources).catch(() => setResources([]));
+    }, []);
+
+    if (prompts.length === 0 && resources.length === 0) {
+        return null;
+    }
+
+    const start = async (request: Parameters<typeof doStartMCPConversation>[0]) => {
+        setStarting(true);
+        setError(false);
+        try {
+            const result = await doStartMCPConversation(request, activeBot?.username);
+            setSelectedPrompt(null);
+            setSelectedResources([]);
+            setMessage('');
+            onStarted(result.postID);
+        } catch {
+            setError(true);
+        } finally {
+            setStarting(false);
+        }
+    };
+
+    const selectPrompt = (prompt: MCPPrompt) => {
+        setShowResources(false);
+        setSelectedPrompt(prompt);
+        setPromptArguments({});
+    };
+
+    const startPrompt = () => {
+        if (!selectedPrompt) {
+            return;
+        }
+        start({
+            prompt: {
+                serverID: selectedPrompt.serverID,
+                name: selectedPrompt.name,
+                arguments: promptArguments,
+            },
+        });
+    };
+
+    const startWithResources = () => {
+        start({
+            message,
+            resources: resources.
+                filter((resource) => selectedResources.includes(resourceKey(resource))).
+                map((resource) => ({serverID: resource.serverID, uri: resource.uri, name: resource.name})),
+        });
+    };
+
+    const missingArguments = (selectedPrompt?.arguments || []).some((argument) => argument.required && !promptArguments[argument.name]?.trim());
+
+    return (
+        <Container>
+            <Options>
+                {prompts.map((prompt) => (
+                    <OptionButton
+                        key={`${prompt.serverID}\n${prompt.name}`}
+                        title={prompt.description || prompt.name}
+                        onClick={() => selectPrompt(prompt)}
+                    >
+                        <LightbulbOutlineIcon/>
+                        {prompt.name}
+                    </OptionButton>
+                ))}
+                {resources.length > 0 && (
+                    <OptionButton
+                        onClick={() => {
+                            setSelectedPrompt(null);
+                            setShowResources(!showResources);
+                        }}
+                    >
+                        <PaperclipIcon/>
+                        <FormattedMessage defaultMessage='Attach resources'/>
+                    </OptionButton>
+                )}
+            </Options>
+
+            {selectedPrompt && (
+                <Form>
+                    <FormTitle>{selectedPrompt.name}</FormTitle>
+                    {selectedPrompt.description && <FormHelp>{selectedPrompt.description}</FormHelp>}
+                    {(selectedPrompt.arguments || []).map((argument) => (
+                        <Field key={argument.name}>
+                            <FieldLabel>
+                                {argument.name}
+                                {argument.required && ' *'}
+                            </FieldLabel>
+                            <Input
+                                value={promptArguments[argument.name] || ''}
+                                placeholder={argument.description}
+                                onChange={(e) => setPromptArguments({...promptArguments, [argument.name]: e.target.value})}
+                            />
+                        </Field>
+                    ))}
+                    <Actions>
+                        <Button onClick={() => setSelectedPrompt(null)}>
+                            <FormattedMessage defaultMessage='Cancel'/>
+                        </Button>
+                        <PrimaryButton
+                            disabled={starting || missingArguments}
+                            onClick={startPrompt}
+                        >
+                            <FormattedMessage defaultMessage='Start'/>
+                        </PrimaryButton>
+                    </Actions>
+                </Form>
+            )}
+
+            {showResources && (
+                <Form>
+                    <FormTitle><FormattedMessage defaultMessage='Attach resources'/></FormTitle>
+                    <ResourceList>
+                        {resources.map((resource) => {
+                            const key = resourceKey(resource);
+                            return (
+                                <Checkbox
+                                    key={key}
+                                    testId={`mcp-resource-${key}`}
+                                    text={`${resource.name} (${resource.serverID})`}
+                                    checked={selectedResources.includes(key)}
+                                    onChange={(checked) => setSelectedResources(checked ? [...selectedResources, key] : selectedResources.filter((selected) => selected !== key))}
+                                />
+                            );
+                        })}
+                    </ResourceList>
+                    <TextArea
+                        value={message}
+                        placeholder={intl.formatMessage({defaultMessage: 'Ask about the attached resources...'})}
+                        onChange={(e) => setMessage(e.target.value)}
+                    />
+                    <Actions>
+                        <Button onClick={() => setShowResources(false)}>
+                            <FormattedMessage defaultMessage='Cancel'/>
+                        </Button>
+                        <PrimaryButton
+                            disabled={starting || selectedResources.length === 0 || !message.trim()}
+                            onClick={startWithResources}
+                        >
+                            <FormattedMessage defaultMessage='Start'/>
+                        </PrimaryButton>
+                    </Actions>
+                </Form>
+            )}
+
+            {error && (
+                <ErrorText>
+                    <FormattedMessage defaultMessage='The conversation could not be started. Please try again later.'/>
+                </ErrorText>
+            )}
+        </Container>
+    );
+};
+
+const Container = styled.div`
+    display: flex;
+    flex-direction: column;
+    gap: 8px;
+    margin-bottom: 16px;
+`;
+
+const Options = styled.div`
+    display: flex;
+    gap: 8px;
+    flex-wrap: wrap;
+`;
+
+const OptionButton = styled(Button)`
+    color: rgb(var(--link-color-rgb));
+    background-color: rgba(var(--button-bg-rgb), 0.08);
+    svg {
+        fill: rgb(var(--link-color-rgb));
+    }
+    &:hover {
+        background-color: rgba(var(--button-bg-rgb), 0.12);
+    }
+    line-height: 16px;
+`;
+
+const PrimaryButton = styled(Button)`
+    color: var(--button-color);
+    background-color: var(--button-bg);
+    &:hover {
+        color: var(--button-color);
+        background-color: rgba(var(--button-bg-rgb), 0.88);
+    }
+    &:disabled {
+        opacity: 0.48;
+        cursor: not-allowed;
+    }
+`;
+
+const Form = styled.div`
+    display: flex;
+    flex-direction: column;
+    gap: 8px;
+    padding: 12px;
+    border: 1px solid rgba(var(--center-channel-color-rgb), 0.16);
+    border-radius: 4px;
+`;
+
+const FormTitle = styled.div`
+    font-weight: 600;
+    font-size: 14px;
+`;
+
+const FormHelp = styled.div`
+    font-size: 12px;
+    color: rgba(var(--center-channel-color-rgb), 0.72);
+`;
+
+const Field = styled.label`
+    display: flex;
+    flex-direction: column;
+    gap: 4px;
+    margin: 0;
+`;
+
+const FieldLabel = styled.span`
+    font-size: 12px;
+    font-weight: 600;
+`;
+
+const Input = styled.input`
+    padding: 6px 8px;
+    border: 1px solid rgba(var(--center-channel-color-rgb), 0.16);
+    border-radius: 4px;
+    background: var(--center-channel-bg);
+`;
+
+const TextArea = styled.textarea`
+    padding: 6px 8px;
+    min-height: 64px;
+    border: 1px solid rgba(var(--center-channel-color-rgb), 0.16);
+    border-radius: 4px;
+    background: var(--center-channel-bg);
+    resize: vertical;
+`;
+
+const ResourceList = styled.div`
+    display: flex;
+    flex-direction: column;
+    max-height: 200px;
+    overflow-y: auto;
+`;
+
+const Actions = styled.div`
+    display: flex;
+    justify-content: flex-end;
+    gap: 8px;
+`;
+
+const ErrorText = styled.div`
+    color: var(--error-text);
+    font-size: 12px;
+`;
+
+export default MCPOptions;
@@ -24,6 +24,7 @@ import {BotsHandler} from '@/redux';
 import manifest from '@/manifest';
 
 import {Button, RHSPaddingContainer, RHSText, RHSTitle} from './common';
+import MCPOptions from './mcp_options';
 
 const CreatePostContainer = styled.div`
 	.custom-textarea {
@@ -248,6 +249,13 @@ const RHSNewTab = ({selectPost, setCurrentTab, activeBot}: Props) => {
                         <FormattedMessage defaultMessage='To-do list'/>
                     </OptionButton>
                 </QuestionOptions>
+                <MCPOptions
+                    activeBot={activeBot}
+                    onStarted={(postID) => {
+                        selectPost(postID);
+                        setCurrentTab('thread');
+                    }}
+                />
                 <CreatePostContainer
                     data-testid='rhs-new-tab-create-post'
                 >
@@ -1,20 +1,22 @@
 // Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
 // See LICENSE.txt for license information.
 
-import React, {useState} from 'react';
+import React, {useEffect, useState} from 'react';
 import styled from 'styled-components';
 import {FormattedMessage, useIntl} from 'react-intl';
 
-import {TrashCanOutlineIcon, ChevronDownIcon, AlertOutlineIcon, ChevronUpIcon} from '@mattermost/compass-icons/components';
+import {TrashCanOutlineIcon, ChevronDownIcon, AlertOutlineIcon, ChevronUpIcon, PlusIcon} from '@mattermost/compass-icons/components';
 
+import {checkBotCapabilities} from '../../client';
 import IconAI from '../assets/icon_ai';
 import {DangerPill, Pill} from '../pill';
 
-import {ButtonIcon} from '../assets/buttons';
+import {ButtonIcon, TertiaryButton} from '../assets/buttons';
 
-import {BooleanItem, ItemList, SelectionItem, SelectionItemOption, TextItem} from './item';
+import {BooleanItem, HelpText, ItemList, SelectionItem, SelectionItemOption, TextItem} from './item';
 import AvatarItem from './avatar';
 import {ChannelAccessLevelItem, UserAccessLevelItem} from './llm_access';
+import ServiceCheckItem from './service_check';
 
 export type LLMService = {
     type: string
@@ -26,6 +28,30 @@ export type LLMService = {
     streamingTimeoutSeconds: number
     sendUserId: boolean
     outputTokenLimit: number
+    awsAccessKeyID?: string
+    awsSecretAccessKey?: string
+    awsRegion?: string
+    modelSupportsVision?: boolean
+}
+
+export type ToolPolicyRule = {
+    tool: string
+    server: string
+    policy: string
+}
+
+export type SamplingParams = {
+    temperature?: number
+    topP?: number
+    stopSequences?: string[]
+    seed?: number
+    presencePenalty?: number
+    frequencyPenalty?: number
+}
+
+export type ReasoningParams = {
+    reasoningEffort?: string
+    thinkingBudget?: number
 }
 
 export enum ChannelAccessLevel {
@@ -55,6 +81,13 @@ export type LLMBotConfig = {
     userAccessLevel: UserAccessLevel
     userIDs: string[]
     teamIDs: string[]
+    fallbackServices?: LLMService[]
+    sampling?: SamplingParams
+    reasoning?: ReasoningParams
+    toolPolicies?: ToolPolicyRule[]
+    maxToolSteps?: number
+    channelTools?: string[]
+    toolTokenBudget?: number
 }
 
 type Props = {
@@ -69,23 +102,80 @@ const mapServiceTypeToDisplayName = new Map<string, string>([
     ['openaicompatible', 'OpenAI Compatible'],
     ['azure', 'Azure'],
     ['anthropic', 'Anthropic'],
+    ['ollama', 'Ollama'],
+    ['gemini', 'Gemini'],
+    ['bedrock', 'AWS Bedrock'],
 ]);
 
 function serviceTypeToDisplayName(serviceType: string): string {
     return mapServiceTypeToDisplayName.get(serviceType) || serviceType;
 }
 
+const serviceTypeOptions = Array.from(mapServiceTypeToDisplayName.entries()).map(([value, displayName]) => (
+    <SelectionItemOption
+        key={value}
+        value={value}
+    >
+        {displayName}
+    </SelectionItemOption>
+));
+
+const emptyService: LLMService = {
+    type: 'openai',
+    apiKey: '',
+    apiURL: '',
+    orgId: '',
+    defaultModel: '',
+    tokenLimit: 0,
+    streamingTimeoutSeconds: 0,
+    sendUserId: false,
+    outputTokenLimit: 0,
+};
+
+function isServiceMissingInfo(service: LLMService): boolean {
+    return service.type === '' ||
+        (service.type !== 'openaicompatible' && service.type !== 'azure' && service.type !== 'ollama' && service.type !== 'bedrock' && service.apiKey === '') ||
+        ((service.type === 'openaicompatible' || service.type === 'azure' || service.type === 'ollama') && service.apiURL === '') ||
+        (service.type === 'bedrock' && (!service.awsAccessKeyID || !service.awsSecretAccessKey || !service.awsRegion));
+}
+
 const Bot = (props: Props) => {
     const [open, setOpen] = useState(false);
     const intl = useIntl();
     const missingInfo = props.bot.name === '' ||
 		props.bot.displayName === '' ||
-		props.bot.service.type === '' ||
-		(props.bot.service.type !== 'openaicompatible' && props.bot.service.type !== 'azure' && props.bot.service.apiKey === '') ||
-		((props.bot.service.type === 'openaicompatible' || props.bot.service.type === 'azure') && props.bot.service.apiURL === '');
+		isServiceMissingInfo(props.bot.service) ||
+		(props.bot.fallbackServices ?? []).some(isServiceMissingInfo);
 
     const invalidUsername = props.bot.name !== '' && (!(/^[a-z0-9.\-_]+$/).test(props.bot.name) || !(/[a-z]/).test(props.bot.name.charAt(0)));
-    const invalidMaxTokens = props.bot.service.type === 'anthropic' && props.bot.service?.outputTokenLimit === 0;
+    const invalidMaxTokens = (props.bot.service.type === 'anthropic' || props.bot.service.type === 'bedrock') && props.bot.service?.outputTokenLimit === 0;
+
+    // Features enabled for the bot that its services don't support are not used, tell the administrator why
+    const [capabilityProblems, setCapabilityProblems] = useState<string[]>([]);
+    useEffect(() => {
+        if (missingInfo) {
+            setCapabilityProblems([]);
+            return undefined;
+        }
+        let cancelled = false;
+        const timeout = setTimeout(async () => {
+            try {
+                const result = await checkBotCapabilities(props.bot);
+                if (!cancelled) {
+                    setCapabilityProblems(result.problems);
+                }
+            } catch (err) {
+                if (!cancelled) {
+                    setCapabilityProblems([]);
+                }
+            }
+        }, 500);
+        return () => {
+            cancelled = true;
+            clearTimeout(timeout);
+        };
+    }, [props.bot, missingInfo]);
+
     return (
         <BotContainer>
             <HeaderContainer onClick={() => setOpen((o) => !o)}>
@@ -118,6 +208,12 @@ const Bot = (props: Props) => {
                         <FormattedMessage defaultMessage='Output token limit must be greater than 0'/>
                     </DangerPill>
                 )}
+                {capabilityProblems.length > 0 && (
+                    <DangerPill>
+                        <AlertOutlineIcon/>
+                        <FormattedMessage defaultMessage='Unsupported features'/>
+                    </DangerPill>
+                )}
 
                 <ButtonIcon
                     onClick={props.onDelete}
@@ -128,6 +224,9 @@ const Bot = (props: Props) => {
             </HeaderContainer>
             {open && (
                 <ItemListContainer>
+                    {capabilityProblems.map((problem) => (
+                        <CapabilityProblem key={problem}>{problem}</CapabilityProblem>
+                    ))}
                     <ItemList>
                         <TextItem
                             label={intl.formatMessage({defaultMessage: 'Display name'})}
@@ -150,15 +249,16 @@ const Bot = (props: Props) => {
                             value={props.bot.service.type}
                             onChange={(e) => props.onChange({...props.bot, service: {...props.bot.service, type: e.target.value}})}
                         >
-                            <SelectionItemOption value='openai'>{'OpenAI'}</SelectionItemOption>
-                            <SelectionItemOption value='openaicompatible'>{'OpenAI Compatible'}</SelectionItemOption>
-                            <SelectionItemOption value='azure'>{'Azure'}</SelectionItemOption>
-                            <SelectionItemOption value='anthropic'>{'Anthropic'}</SelectionItemOption>
+                            {serviceTypeOptions}
                         </SelectionItem>
                         <ServiceItem
                             service={props.bot.service}
                             onChange={(service) => props.onChange({...props.bot, service})}
                         />
+                        <FallbackServicesItem
+                            services={props.bot.fallbackServices ?? []}
+                            onChange={(fallbackServices) => props.onChange({...props.bot, fallbackServices})}
+                        />
                         <TextItem
                             label={intl.formatMessage({defaultMessage: 'Custom instructions'})}
                             placeholder={intl.formatMessage({defaultMessage: 'How would you like the AI to respond?'})}
@@ -166,7 +266,17 @@ const Bot = (props: Props) => {
                             value={props.bot.customInstructions}
                             onChange={(e) => props.onChange({...props.bot, customInstructions: e.target.value})}
                         />
-                        {(props.bot.service.type === 'openai' || props.bot.service.type === 'openaicompatible' || props.bot.service.type === 'azure' || props.bot.service.type === 'anthropic') && (
+                        <SamplingItem
+                            serviceType={props.bot.service.type}
+                            sampling={props.bot.sampling ?? {}}
+                            onChange={(sampling) => props.onChange({...props.bot, sampling})}
+                        />
+                        <ReasoningItem
+                            serviceType={props.bot.service.type}
+                            reasoning={props.bot.reasoning ?? {}}
+                            onChange={(reasoning) => props.onChange({...props.bot, reasoning})}
+                        />
+                        {(props.bot.service.type === 'openai' || props.bot.service.type === 'openaicompatible' || props.bot.service.type === 'azure' || props.bot.service.type === 'anthropic' || props.bot.service.type === 'ollama' || props.bot.service.type === 'gemini' || props.bot.service.type === 'bedrock') && (
                             <>
                                 <BooleanItem
                                     label={
@@ -187,6 +297,43 @@ const Bot = (props: Props) => {
                                     onChange={(to: boolean) => props.onChange({...props.bot, disableTools: !to})}
                                     helpText={intl.formatMessage({defaultMessage: 'By default some tool use is enabled to allow for features such as integrations with JIRA. Disabling this allows use of models that do not support or are not very good at tool use. Some features will not work without tools.'})}
                                 />
+                                {!props.bot.disableTools && (
+                                    <>
+                                        <ToolPoliciesItem
+                                            rules={props.bot.toolPolicies ?? []}
+                                            onChange={(toolPolicies) => props.onChange({...props.bot, toolPolicies})}
+                                        />
+                                        <TextItem
+                                            label={intl.formatMessage({defaultMessage: 'Tools in channels'})}
+                                            value={(props.bot.channelTools ?? []).join(', ')}
+                                            placeholder={intl.formatMessage({defaultMessage: 'None'})}
+                                            onChange={(e) => props.onChange({...props.bot, channelTools: e.target.value === '' ? [] : e.target.value.split(',').map((name) => name.trim())})}
+                                            helptext={intl.formatMessage({defaultMessage: 'Comma separated names of the tools the agent can use when mentioned in a channel, such as SearchServer. Only the user who mentioned the agent can approve tool calls, and search results are limited to content every channel member can read.'})}
+                                        />
+                                        <TextItem
+                                            label={intl.formatMessage({defaultMessage: 'Maximum tool steps'})}
+                                            type='number'
+                                            value={props.bot.maxToolSteps ? props.bot.maxToolSteps.toString() : ''}
+                                            placeholder='10'
+                                            onChange={(e) => {
+                                                const value = parseInt(e.target.value, 10);
+                                                props.onChange({...props.bot, maxToolSteps: isNaN(value) ? 0 : value});
+                                            }}
+                                            helptext={intl.formatMessage({defaultMessage: 'Number of times a response can call the model while running tools on its own. Further tool calls need the user to approve them.'})}
+                                        />
+                                        <TextItem
+                                            label={intl.formatMessage({defaultMessage: 'Tool token budget'})}
+                                            type='number'
+                                            value={props.bot.toolTokenBudget ? props.bot.toolTokenBudget.toString() : ''}
+                                            placeholder={intl.formatMessage({defaultMessage: 'No limit'})}
+                                            onChange={(e) => {
+                                                const value = parseInt(e.target.value, 10);
+                                                props.onChange({...props.bot, toolTokenBudget: isNaN(value) ? 0 : value});
+                                            }}
+                                            helptext={intl.formatMessage({defaultMessage: 'Number of input and output tokens a response can use while running tools on its own. Further tool calls need the user to approve them.'})}
+                                        />
+                                    </>
+                                )}
                             </>
                         )}
                         <ChannelAccessLevelItem
@@ -212,6 +359,10 @@ const Bot = (props: Props) => {
     );
 };
 
+const CapabilityProblem = styled(HelpText)`
+	color: var(--error-text);
+`;
+
 const Horizontal = styled.div`
 	display: flex;
 	flex-direction: row;
@@ -219,6 +370,255 @@ const Horizontal = styled.div`
 	gap: 8px;
 `;
 
+type FallbackServicesItemProps = {
+    services: LLMService[]
+    onChange: (services: LLMService[]) => void
+}
+
+const FallbackServicesItem = (props: FallbackServicesItemProps) => {
+    const intl = useIntl();
+
+    const updateService = (index: number, service: LLMService) => {
+        props.onChange(props.services.map((s, i) => (i === index ? service : s)));
+    };
+
+    return (
+        <>
+            {props.services.map((service, index) => (
+                <React.Fragment key={index}>
+                    <Horizontal>
+                        <FallbackTitle>
+                            <FormattedMessage
+                                defaultMessage='Fallback service {number}'
+                                values={{number: index + 1}}
+                            />
+                        </FallbackTitle>
+                        <ButtonIcon
+                            onClick={() => props.onChange(props.services.filter((_, i) => i !== index))}
+                        >
+                            <TrashIcon/>
+                        </ButtonIcon>
+                    </Horizontal>
+                    <SelectionItem
+                        label={intl.formatMessage({defaultMessage: 'Service'})}
+                        value={service.type}
+                        onChange={(e) => updateService(index, {...service, type: e.target.value})}
+                    >
+                        {serviceTypeOptions}
+                    </SelectionItem>
+                    <ServiceItem
+                        service={service}
+                        onChange={(updated) => updateService(index, updated)}
+                    />
+                </React.Fragment>
+            ))}
+            <div>
+                <TertiaryButton
+                    onClick={() => props.onChange([...props.services, {...emptyService}])}
+                >
+                    <PlusFallbackIcon/>
+                    <FormattedMessage defaultMessage='Add fallback service'/>
+                </TertiaryButton>
+            </div>
+        </>
+    );
+};
+
+type ToolPoliciesItemProps = {
+    rules: ToolPolicyRule[]
+    onChange: (rules: ToolPolicyRule[]) => void
+}
+
+const ToolPoliciesItem = (props: ToolPoliciesItemProps) => {
+    const intl = useIntl();
+
+    const updateRule = (index: number, rule: ToolPolicyRule) => {
+        props.onChange(props.rules.map((r, i) => (i === index ? rule : r)));
+    };
+
+    return (
+        <>
+            {props.rules.map((rule, index) => (
+                <React.Fragment key={index}>
+                    <Horizontal>
+                        <FallbackTitle>
+                            <FormattedMessage
+                                defaultMessage='Tool policy {number}'
+                                values={{number: index + 1}}
+                            />
+                        </FallbackTitle>
+                        <ButtonIcon
+                            onClick={() => props.onChange(props.rules.filter((_, i) => i !== index))}
+                        >
+                            <TrashIcon/>
+                        </ButtonIcon>
+                    </Horizontal>
+                    <div/>
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'Tool name'})}
+                        placeholder={intl.formatMessage({defaultMessage: 'Any tool'})}
+                        value={rule.tool}
+                        onChange={(e) => updateRule(index, {...rule, tool: e.target.value})}
+                    />
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'MCP server'})}
+                        placeholder={intl.formatMessage({defaultMessage: 'Any server'})}
+                        value={rule.server}
+                        onChange={(e) => updateRule(index, {...rule, server: e.target.value})}
+                        helptext={intl.formatMessage({defaultMessage: 'Name of the MCP server providing the tool. Leave empty to match built-in tools as well.'})}
+                    />
+                    <SelectionItem
+                        label={intl.formatMessage({defaultMessage: 'Policy'})}
+                        value={rule.policy}
+                        onChange={(e) => updateRule(index, {...rule, policy: e.target.value})}
+                    >
+                        <SelectionItemOption value='auto'>{intl.formatMessage({defaultMessage: 'Run automatically'})}</SelectionItemOption>
+                        <SelectionItemOption value='ask'>{intl.formatMessage({defaultMessage: 'Require approval'})}</SelectionItemOption>
+                        <SelectionItemOption value='deny'>{intl.formatMessage({defaultMessage: 'Deny'})}</SelectionItemOption>
+                    </SelectionItem>
+                </React.Fragment>
+            ))}
+            <div>
+                <TertiaryButton
+                    onClick={() => props.onChange([...props.rules, {tool: '', server: '', policy: 'ask'}])}
+                >
+                    <PlusFallbackIcon/>
+                    <FormattedMessage defaultMessage='Add tool policy'/>
+                </TertiaryButton>
+            </div>
+            <HelpText>
+                <FormattedMessage defaultMessage='The first policy matching a tool applies. Without a matching policy, tools that only read data run automatically and the others require approval.'/>
+            </HelpText>
+        </>
+    );
+};
+
+type SamplingItemProps = {
+    serviceType: string
+    sampling: SamplingParams
+    onChange: (sampling: SamplingParams) => void
+}
+
+// parseOptionalNumber returns undefined for an empty field so the provider default is used.
+function parseOptionalNumber(value: string, parse: (value: string) => number): number | undefined {
+    const parsed = parse(value);
+    return isNaN(parsed) ? undefined : parsed;
+}
+
+const SamplingItem = (props: SamplingItemProps) => {
+    const intl = useIntl();
+    const leaveEmpty = intl.formatMessage({defaultMessage: 'Leave empty to use the provider default.'});
+
+    return (
+        <>
+            <TextItem
+                label={intl.formatMessage({defaultMessage: 'Temperature'})}
+                type='number'
+                step='0.1'
+                min='0'
+                placeholder={intl.formatMessage({defaultMessage: 'Default'})}
+                value={props.sampling.temperature?.toString() ?? ''}
+                onChange={(e) => props.onChange({...props.sampling, temperature: parseOptionalNumber(e.target.value, parseFloat)})}
+                helptext={intl.formatMessage({defaultMessage: 'Lower values give more focused and consistent answers, higher values more creative ones. Ignored by reasoning models.'}) + ' ' + leaveEmpty}
+            />
+            {props.serviceType !== 'asage' && (
+                <>
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'Top P'})}
+                        type='number'
+                        step='0.05'
+                        min='0'
+                        max='1'
+                        placeholder={intl.formatMessage({defaultMessage: 'Default'})}
+                        value={props.sampling.topP?.toString() ?? ''}
+                        onChange={(e) => props.onChange({...props.sampling, topP: parseOptionalNumber(e.target.value, parseFloat)})}
+                        helptext={leaveEmpty}
+                    />
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'Stop sequences'})}
+                        placeholder={intl.formatMessage({defaultMessage: 'None'})}
+                        value={(props.sampling.stopSequences ?? []).join(',')}
+                        onChange={(e) => {
+                            const stopSequences = e.target.value.split(',').filter((sequence) => sequence !== '');
+                            props.onChange({...props.sampling, stopSequences});
+                        }}
+                        helptext={intl.formatMessage({defaultMessage: 'Comma separated sequences that end the response when generated.'})}
+                    />
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'Seed'})}
+                        type='number'
+                        placeholder={intl.formatMessage({defaultMessage: 'None'})}
+                        value={props.sampling.seed?.toString() ?? ''}
+                        onChange={(e) => props.onChange({...props.sampling, seed: parseOptionalNumber(e.target.value, (value) => parseInt(value, 10))})}
+                        helptext={intl.formatMessage({defaultMessage: 'Makes responses reproducible on the providers that support it.'})}
+                    />
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'Presence penalty'})}
+                        type='number'
+                        step='0.1'
+                        placeholder={intl.formatMessage({defaultMessage: 'Default'})}
+                        value={props.sampling.presencePenalty?.toString() ?? ''}
+                        onChange={(e) => props.onChange({...props.sampling, presencePenalty: parseOptionalNumber(e.target.value, parseFloat)})}
+                        helptext={leaveEmpty}
+                    />
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'Frequency penalty'})}
+                        type='number'
+                        step='0.1'
+                        placeholder={intl.formatMessage({defaultMessage: 'Default'})}
+                        value={props.sampling.frequencyPenalty?.toString() ?? ''}
+                        onChange={(e) => props.onChange({...props.sampling, frequencyPenalty: parseOptionalNumber(e.target.value, parseFloat)})}
+                        helptext={leaveEmpty}
+                    />
+                </>
+            )}
+        </>
+    );
+};
+
+type ReasoningItemProps = {
+    serviceType: string
+    reasoning: ReasoningParams
+    onChange: (reasoning: ReasoningParams) => void
+}
+
+const ReasoningItem = (props: ReasoningItemProps) => {
+    const intl = useIntl();
+    const isOpenAIType = props.serviceType === 'openai' || props.serviceType === 'openaicompatible' || props.serviceType === 'azure';
+
+    return (
+        <>
+            {isOpenAIType && (
+                <SelectionItem
+                    label={intl.formatMessage({defaultMessage: 'Reasoning effort'})}
+                    value={props.reasoning.reasoningEffort ?? ''}
+                    onChange={(e) => props.onChange({...props.reasoning, reasoningEffort: e.target.value})}
+                    helptext={intl.formatMessage({defaultMessage: 'How much reasoning models think before answering. Leave on default for models that do not support reasoning.'})}
+                >
+                    <SelectionItemOption value=''>{intl.formatMessage({defaultMessage: 'Default'})}</SelectionItemOption>
+                    <SelectionItemOption value='minimal'>{intl.formatMessage({defaultMessage: 'Minimal'})}</SelectionItemOption>
+                    <SelectionItemOption value='low'>{intl.formatMessage({defaultMessage: 'Low'})}</SelectionItemOption>
+                    <SelectionItemOption value='medium'>{intl.formatMessage({defaultMessage: 'Medium'})}</SelectionItemOption>
+                    <SelectionItemOption value='high'>{intl.formatMessage({defaultMessage: 'High'})}</SelectionItemOption>
+                </SelectionItem>
+            )}
+            {props.serviceType === 'anthropic' && (
+                <TextItem
+                    label={intl.formatMessage({defaultMessage: 'Thinking budget'})}
+                    type='number'
+                    value={props.reasoning.thinkingBudget?.toString() || '0'}
+                    onChange={(e) => {
+                        const value = parseInt(e.target.value, 10);
+                        const thinkingBudget = isNaN(value) ? 0 : value;
+                        props.onChange({...props.reasoning, thinkingBudget});
+                    }}
+                    helptext={intl.formatMessage({defaultMessage: 'Number of tokens the model can use for extended thinking, at least 1024 and less than the output token limit. Set to 0 to disable thinking.'})}
+                />
+            )}
+        </>
+    );
+};
+
 type ServiceItemProps = {
     service: LLMService
     onChange: (service: LLMService) => void
@@ -232,6 +632,7 @@ const ServiceItem = (props: ServiceItemProps) => {
     const getDefaultOutputTokenLimit = () => {
         switch (type) {
         case 'anthropic':
+        case 'bedrock':
             return '8192';
         default:
             return '0';
@@ -240,19 +641,42 @@ const ServiceItem = (props: ServiceItemProps) => {
 
     return (
         <>
-            {(type === 'openaicompatible' || type === 'azure') && (
+            {(type === 'openaicompatible' || type === 'azure' || type === 'ollama' || type === 'bedrock') && (
                 <TextItem
                     label={intl.formatMessage({defaultMessage: 'API URL'})}
                     value={props.service.apiURL}
                     onChange={(e) => props.onChange({...props.service, apiURL: e.target.value})}
                 />
             )}
-            <TextItem
-                label={intl.formatMessage({defaultMessage: 'API Key'})}
-                type='password'
-                value={props.service.apiKey}
-                onChange={(e) => props.onChange({...props.service, apiKey: e.target.value})}
-            />
+            {type !== 'ollama' && type !== 'bedrock' && (
+                <TextItem
+                    label={intl.formatMessage({defaultMessage: 'API Key'})}
+                    type='password'
+                    value={props.service.apiKey}
+                    onChange={(e) => props.onChange({...props.service, apiKey: e.target.value})}
+                />
+            )}
+            {type === 'bedrock' && (
+                <>
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'AWS Region'})}
+                        placeholder='us-east-1'
+                        value={props.service.awsRegion ?? ''}
+                        onChange={(e) => props.onChange({...props.service, awsRegion: e.target.value})}
+                    />
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'AWS Access Key ID'})}
+                        value={props.service.awsAccessKeyID ?? ''}
+                        onChange={(e) => props.onChange({...props.service, awsAccessKeyID: e.target.value})}
+                    />
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'AWS Secret Access Key'})}
+                        type='password'
+                        value={props.service.awsSecretAccessKey ?? ''}
+                        onChange={(e) => props.onChange({...props.service, awsSecretAccessKey: e.target.value})}
+                    />
+                </>
+            )}
             {isOpenAIType && (
                 <>
                     <TextItem
@@ -268,6 +692,14 @@ const ServiceItem = (props: ServiceItemProps) => {
                     />
                 </>
             )}
+            {type === 'openaicompatible' && (
+                <BooleanItem
+                    label={intl.formatMessage({defaultMessage: 'Model supports images'})}
+                    value={props.service.modelSupportsVision ?? false}
+                    onChange={(to: boolean) => props.onChange({...props.service, modelSupportsVision: to})}
+                    helpText={intl.formatMessage({defaultMessage: 'Enable if the model accepts images. Images are only sent to the model when this and vision are enabled.'})}
+                />
+            )}
             <TextItem
                 label={intl.formatMessage({defaultMessage: 'Default model'})}
                 value={props.service.defaultModel}
@@ -305,6 +737,10 @@ const ServiceItem = (props: ServiceItemProps) => {
                     }}
                 />
             )}
+            <ServiceCheckItem
+                service={props.service}
+                onChange={props.onChange}
+            />
         </>
     );
 };
@@ -336,6 +772,16 @@ const Spacer = styled.div`
 	flex-grow: 1;
 `;
 
+const FallbackTitle = styled.div`
+	font-weight: 600;
+`;
+
+const PlusFallbackIcon = styled(PlusIcon)`
+	width: 18px;
+	height: 18px;
+	margin-right: 8px;
+`;
+
 const TrashIcon = styled(TrashCanOutlineIcon)`
 	width: 16px;
 	height: 16px;
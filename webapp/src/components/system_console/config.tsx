@@ -17,6 +17,7 @@ import NoBotsPage from './no_bots_page';
 import EmbeddingSearchPanel from './embedding_search/embedding_search_panel';
 import {EmbeddingSearchConfig} from './embedding_search/types';
 import MCPServers, {MCPConfig} from './mcp_servers';
+import QuotasPanel, {QuotasConfig, defaultQuotasConfig} from './quotas';
 
 type Config = {
     services: ServiceData[],
@@ -28,6 +29,7 @@ type Config = {
     allowedUpstreamHostnames: string,
     embeddingSearchConfig: EmbeddingSearchConfig,
     mcp: MCPConfig
+    quotas: QuotasConfig
 }
 
 type Props = {
@@ -98,6 +100,7 @@ const defaultConfig = {
         servers: {},
         idleTimeout: 30,
     },
+    quotas: defaultQuotasConfig,
 };
 
 const BetaMessage = () => (
@@ -238,6 +241,13 @@ const Config = (props: Props) => {
                     props.setSaveNeeded();
                 }}
             />
+            <QuotasPanel
+                value={value.quotas || defaultConfig.quotas}
+                onChange={(quotas) => {
+                    props.onChange(props.id, {...value, quotas});
+                    props.setSaveNeeded();
+                }}
+            />
             {mcpConfig.enabled &&
                 <Panel
                     title={
@@ -8,17 +8,29 @@ import {FormattedMessage, useIntl} from 'react-intl';
 
 import {TertiaryButton} from '../assets/buttons';
 
-import {BooleanItem, ItemList, TextItem} from './item';
+import {BooleanItem, ItemList, SelectionItem, SelectionItemOption, TextItem} from './item';
 
 export type MCPServerConfig = {
+    transport?: string;
     baseURL: string;
     headers: {[key: string]: string};
+    command?: string;
+    args?: string[];
+    env?: {[key: string]: string};
+    oauth?: MCPOAuthConfig;
+};
+
+export type MCPOAuthConfig = {
+    clientID?: string;
+    clientSecret?: string;
+    scopes?: string;
 };
 
 export type MCPConfig = {
     enabled: boolean;
     servers: {[key: string]: MCPServerConfig};
     idleTimeout?: number;
+    enableServer?: boolean;
 };
 
 type Props = {
@@ -28,10 +40,94 @@ type Props = {
 
 // Default configuration for a new MCP server
 const defaultServerConfig: MCPServerConfig = {
+    transport: '',
     baseURL: '',
     headers: {},
 };
 
+// Editor for a set of key/value pairs, such as headers or environment variables
+const KeyValueList = ({
+    title,
+    keyPlaceholder,
+    addLabel,
+    values,
+    onChange,
+}: {
+    title: string;
+    keyPlaceholder: string;
+    addLabel: string;
+    values: {[key: string]: string};
+    onChange: (values: {[key: string]: string}) => void;
+}) => {
+    const intl = useIntl();
+
+    // Add a new entry
+    const addEntry = () => {
+        onChange({
+            ...values,
+            '': '',
+        });
+    };
+
+    // Update an entry's key or value
+    const updateEntry = (oldKey: string, newKey: string, value: string) => {
+        const updated = {...values};
+
+        // If the key has changed, remove the old one
+        if (oldKey !== newKey && oldKey !== '') {
+            delete updated[oldKey];
+        }
+
+        // Set the new key-value pair
+        updated[newKey] = value;
+        onChange(updated);
+    };
+
+    // Remove an entry
+    const removeEntry = (key: string) => {
+        const updated = {...values};
+        delete updated[key];
+        onChange(updated);
+    };
+
+    return (
+        <HeadersSection>
+            <HeadersSectionTitle>
+                {title}
+            </HeadersSectionTitle>
+
+            <HeadersList>
+                {Object.entries(values).map(([key, value]) => (
+                    <HeaderRow key={key}>
+                        <HeaderInput
+                            placeholder={keyPlaceholder}
+                            value={key}
+                            onChange={(e) => updateEntry(key, e.target.value, value)}
+                        />
+                        <HeaderInput
+                            placeholder={intl.formatMessage({defaultMessage: 'Value'})}
+                            value={value}
+                            onChange={(e) => updateEntry(key, key, e.target.value)}
+                        />
+                        <RemoveHeaderButton
+                            onClick={() => removeEntry(key)}
+                        >
+                            <TrashCanOutlineIcon size={14}/>
+                        </RemoveHeaderButton>
+                    </HeaderRow>
+                ))}
+            </HeadersList>
+
+            <AddHeaderButton
+                onClick={addEntry}
+            >
+                <PlusIcon size={14}/>
+                {addLabel}
+            </AddHeaderButton>
+        </HeadersSection>
+    );
+};
+
 // Component for a single MCP server configuration
 const MCPServer = ({
     serverID,
@@ -56,52 +152,11 @@ const MCPServer = ({
         ...serverConfig,
     };
 
-    // Update server URL
-    const updateServerURL = (baseURL: string) => {
-        onChange(serverID, {
-            ...config,
-            baseURL,
-        });
-    };
-
-    // Add a new header
-    const addHeader = () => {
-        const headers = config.headers || {};
+    // Update a field of the server configuration
+    const updateConfig = (changes: Partial<MCPServerConfig>) => {
         onChange(serverID, {
             ...config,
-            headers: {
-                ...headers,
-                '': '',
-            },
-        });
-    };
-
-    // Update a header's key or value
-    const updateHeader = (oldKey: string, newKey: string, value: string) => {
-        const headers = {...(config.headers || {})};
-
-        // If the key has changed, remove the old one
-        if (oldKey !== newKey && oldKey !== '') {
-            delete headers[oldKey];
-        }
-
-        // Set the new key-value pair
-        headers[newKey] = value;
-
-        onChange(serverID, {
-            ...config,
-            headers,
-        });
-    };
-
-    // Remove a header
-    const removeHeader = (key: string) => {
-        const headers = {...(config.headers || {})};
-        delete headers[key];
-
-        onChange(serverID, {
-            ...config,
-            headers,
+            ...changes,
         });
     };
 
@@ -151,48 +206,83 @@ const MCPServer = ({
                 </DeleteButton>
             </ServerHeader>
 
-            <TextItem
-                label={intl.formatMessage({defaultMessage: 'Server URL'})}
-                placeholder='https://mcp.example.com'
-                value={config.baseURL}
-                onChange={(e) => updateServerURL(e.target.value)}
-                helptext={intl.formatMessage({defaultMessage: 'The base URL of the MCP server.'})}
-            />
-
-            <HeadersSection>
-                <HeadersSectionTitle>
-                    {intl.formatMessage({defaultMessage: 'Headers'})}
-                </HeadersSectionTitle>
-
-                <HeadersList>
-                    {Object.entries(config.headers || {}).map(([key, value]) => (
-                        <HeaderRow key={key}>
-                            <HeaderInput
-                                placeholder={intl.formatMessage({defaultMessage: 'Header name'})}
-                                value={key}
-                                onChange={(e) => updateHeader(key, e.target.value, value)}
+            <SelectionItem
+                label={intl.formatMessage({defaultMessage: 'Transport'})}
+                value={config.transport || ''}
+                onChange={(e) => updateConfig({transport: e.target.value})}
+                helptext={intl.formatMessage({defaultMessage: 'How to connect to the MCP server. Automatic uses streamable HTTP and falls back to SSE for older servers. Local process starts the server on the Mattermost server for each user.'})}
+            >
+                <SelectionItemOption value=''>{intl.formatMessage({defaultMessage: 'Automatic'})}</SelectionItemOption>
+                <SelectionItemOption value='http'>{intl.formatMessage({defaultMessage: 'Streamable HTTP'})}</SelectionItemOption>
+                <SelectionItemOption value='sse'>{intl.formatMessage({defaultMessage: 'SSE'})}</SelectionItemOption>
+                <SelectionItemOption value='stdio'>{intl.formatMessage({defaultMessage: 'Local process (stdio)'})}</SelectionItemOption>
+            </SelectionItem>
+
+            {config.transport === 'stdio' ? (
+                <>
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'Command'})}
+                        placeholder='/opt/mcp/bin/server'
+                        value={config.command || ''}
+                        onChange={(e) => updateConfig({command: e.target.value})}
+                        helptext={intl.formatMessage({defaultMessage: 'The executable of the MCP server. A process is started for each user and restarted if it crashes. It must be listed in the MM_AI_MCP_STDIO_COMMANDS environment variable of the Mattermost server.'})}
+                    />
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'Arguments'})}
+                        multiline={true}
+                        value={(config.args || []).join('\n')}
+                        onChange={(e) => updateConfig({args: e.target.value.split('\n')})}
+                        helptext={intl.formatMessage({defaultMessage: 'The arguments of the command, one per line.'})}
+                    />
+                    <KeyValueList
+                        title={intl.formatMessage({defaultMessage: 'Environment Variables'})}
+                        keyPlaceholder={intl.formatMessage({defaultMessage: 'Variable name'})}
+                        addLabel={intl.formatMessage({defaultMessage: 'Add Variable'})}
+                        values={config.env || {}}
+                        onChange={(env) => updateConfig({env})}
+                    />
+                </>
+            ) : (
+                <>
+                    <TextItem
+                        label={intl.formatMessage({defaultMessage: 'Server URL'})}
+                        placeholder='https://mcp.example.com'
+                        value={config.baseURL}
+                        onChange={(e) => updateConfig({baseURL: e.target.value})}
+                        helptext={intl.formatMessage({defaultMessage: 'The base URL of the MCP server.'})}
+                    />
+                    <KeyValueList
+                        title={intl.formatMessage({defaultMessage: 'Headers'})}
+                        keyPlaceholder={intl.formatMessage({defaultMessage: 'Header name'})}
+                        addLabel={intl.formatMessage({defaultMessage: 'Add Header'})}
+                        values={config.headers || {}}
+                        onChange={(headers) => updateConfig({headers})}
+                    />
+                    {config.transport !== 'sse' && (
+                        <>
+                            <TextItem
+                                label={intl.formatMessage({defaultMessage: 'OAuth Client ID'})}
+                                value={config.oauth?.clientID || ''}
+                                onChange={(e) => updateConfig({oauth: {...config.oauth, clientID: e.target.value}})}
+                                helptext={intl.formatMessage({defaultMessage: 'Used when the server asks users to connect their account. Leave empty to register the plugin with the authorization server automatically.'})}
                             />
-                            <HeaderInput
-                                placeholder={intl.formatMessage({defaultMessage: 'Value'})}
-                                value={value}
-                                onChange={(e) => updateHeader(key, key, e.target.value)}
+                            <TextItem
+                                label={intl.formatMessage({defaultMessage: 'OAuth Client Secret'})}
+                                type='password'
+                                value={config.oauth?.clientSecret || ''}
+                                onChange={(e) => updateConfig({oauth: {...config.oauth, clientSecret: e.target.value}})}
                             />
-                            <RemoveHeaderButton
-                                onClick={() => removeHeader(key)}
-                            >
-                                <TrashCanOutlineIcon size={14}/>
-                            </RemoveHeaderButton>
-                        </HeaderRow>
-                    ))}
-                </HeadersList>
-
-                <AddHeaderButton
-                    onClick={addHeader}
-                >
-                    <PlusIcon size={14}/>
-                    <FormattedMessage defaultMessage='Add Header'/>
-                </AddHeaderButton>
-            </HeadersSection>
+                            <TextItem
+                                label={intl.formatMessage({defaultMessage: 'OAuth Scopes'})}
+                                placeholder='read write'
+                                value={config.oauth?.scopes || ''}
+                                onChange={(e) => updateConfig({oauth: {...config.oauth, scopes: e.target.value}})}
+                                helptext={intl.formatMessage({defaultMessage: 'Space separated scopes to request. Leave empty to request the scopes the server advertises.'})}
+                            />
+                        </>
+                    )}
+                </>
+            )}
         </ServerContainer>
     );
 };
@@ -319,6 +409,14 @@ const MCPServers = ({mcpConfig, onChange}: Props) => {
                         helptext={intl.formatMessage({defaultMessage: 'How long to keep an inactive user connection open before closing it automatically. Lower values save resources, higher values improve response times.'})}
                     />
                 )}
+                {mcpConfig.enabled && (
+                    <BooleanItem
+                        label={intl.formatMessage({defaultMessage: 'Enable Mattermost MCP Server'})}
+                        value={Boolean(mcpConfig.enableServer)}
+                        onChange={(enableServer) => onChange({...mcpConfig, enableServer})}
+                        helpText={intl.formatMessage({defaultMessage: 'Let MCP clients, such as agents in IDEs, search and read Mattermost as their user. Clients authenticate with a personal access token.'})}
+                    />
+                )}
             </ItemList>
 
             {mcpConfig.enabled && (
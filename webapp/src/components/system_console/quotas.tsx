@@ -0,0 +1,123 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+import React from 'react';
+import {useIntl} from 'react-intl';
+
+import Panel from './panel';
+import {BooleanItem, ItemList} from './item';
+import {IntItem} from './number_items';
+
+export type QuotaLimits = {
+    dailyTokens: number
+    monthlyTokens: number
+    dailyRequests: number
+    monthlyRequests: number
+}
+
+export type QuotasConfig = {
+    enabled: boolean
+    user: QuotaLimits
+    team: QuotaLimits
+    bot: QuotaLimits
+}
+
+const emptyLimits: QuotaLimits = {
+    dailyTokens: 0,
+    monthlyTokens: 0,
+    dailyRequests: 0,
+    monthlyRequests: 0,
+};
+
+export const defaultQuotasConfig: QuotasConfig = {
+    enabled: false,
+    user: emptyLimits,
+    team: emptyLimits,
+    bot: emptyLimits,
+};
+
+type LimitsItemsProps = {
+    label: string
+    limits: QuotaLimits
+    onChange: (limits: QuotaLimits) => void
+}
+
+const LimitsItems = (props: LimitsItemsProps) => {
+    const intl = useIntl();
+    const limits = props.limits || emptyLimits;
+
+    return (
+        <>
+            <IntItem
+                label={intl.formatMessage({defaultMessage: '{scope}: Daily requests'}, {scope: props.label})}
+                value={limits.dailyRequests}
+                min={0}
+                onChange={(value) => props.onChange({...limits, dailyRequests: value})}
+            />
+            <IntItem
+                label={intl.formatMessage({defaultMessage: '{scope}: Monthly requests'}, {scope: props.label})}
+                value={limits.monthlyRequests}
+                min={0}
+                onChange={(value) => props.onChange({...limits, monthlyRequests: value})}
+            />
+            <IntItem
+                label={intl.formatMessage({defaultMessage: '{scope}: Daily tokens'}, {scope: props.label})}
+                value={limits.dailyTokens}
+                min={0}
+                onChange={(value) => props.onChange({...limits, dailyTokens: value})}
+            />
+            <IntItem
+                label={intl.formatMessage({defaultMessage: '{scope}: Monthly tokens'}, {scope: props.label})}
+                value={limits.monthlyTokens}
+                min={0}
+                onChange={(value) => props.onChange({...limits, monthlyTokens: value})}
+            />
+        </>
+    );
+};
+
+type Props = {
+    value: QuotasConfig
+    onChange: (config: QuotasConfig) => void
+}
+
+const QuotasPanel = (props: Props) => {
+    const intl = useIntl();
+    const value = props.value || defaultQuotasConfig;
+
+    return (
+        <Panel
+            title={intl.formatMessage({defaultMessage: 'Usage Quotas'})}
+            subtitle={intl.formatMessage({defaultMessage: 'Limit how much each user, team and bot can use the AI services. Limits of 0 are unlimited. Quotas reset at midnight UTC and on the first day of each month.'})}
+        >
+            <ItemList>
+                <BooleanItem
+                    label={intl.formatMessage({defaultMessage: 'Enable quotas'})}
+                    value={value.enabled}
+                    onChange={(to) => props.onChange({...value, enabled: to})}
+                />
+                {value.enabled && (
+                    <>
+                        <LimitsItems
+                            label={intl.formatMessage({defaultMessage: 'Per user'})}
+                            limits={value.user}
+                            onChange={(limits) => props.onChange({...value, user: limits})}
+                        />
+                        <LimitsItems
+                            label={intl.formatMessage({defaultMessage: 'Per team'})}
+                            limits={value.team}
+                            onChange={(limits) => props.onChange({...value, team: limits})}
+                        />
+                        <LimitsItems
+                            label={intl.formatMessage({defaultMessage: 'Per bot'})}
+                            limits={value.bot}
+                            onChange={(limits) => props.onChange({...value, bot: limits})}
+                        />
+                    </>
+                )}
+            </ItemList>
+        </Panel>
+    );
+};
+
+export default QuotasPanel;
@@ -0,0 +1,163 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+import React, {useState} from 'react';
+import styled from 'styled-components';
+import {FormattedMessage, useIntl} from 'react-intl';
+
+import {listServiceModels, testServiceConnection} from '../../client';
+import {TertiaryButton} from '../assets/buttons';
+
+import {HelpText, ItemLabel} from './item';
+import {LLMService} from './bot';
+
+type ModelInfo = {
+    id: string
+    displayName?: string
+    contextWindow?: number
+}
+
+type ConnectionTestResult = {
+    success: boolean
+    latencyMs: number
+    response?: string
+    error?: string
+}
+
+type Props = {
+    service: LLMService
+    onChange: (service: LLMService) => void
+}
+
+const ServiceCheckItem = (props: Props) => {
+    const intl = useIntl();
+    const [testing, setTesting] = useState(false);
+    const [testResult, setTestResult] = useState<ConnectionTestResult | null>(null);
+    const [loadingModels, setLoadingModels] = useState(false);
+    const [models, setModels] = useState<ModelInfo[] | null>(null);
+    const [modelsError, setModelsError] = useState('');
+
+    const testConnection = async () => {
+        setTesting(true);
+        try {
+            setTestResult(await testServiceConnection(props.service));
+        } catch (err) {
+            setTestResult({success: false, latencyMs: 0, error: intl.formatMessage({defaultMessage: 'The connection test could not be run. Check that the required settings are filled in.'})});
+        }
+        setTesting(false);
+    };
+
+    const loadModels = async () => {
+        setLoadingModels(true);
+        setModelsError('');
+        try {
+            setModels(await listServiceModels(props.service));
+        } catch (err: any) {
+            setModels(null);
+            setModelsError(err?.message || intl.formatMessage({defaultMessage: 'Failed to list the models.'}));
+        }
+        setLoadingModels(false);
+    };
+
+    const defaultModelMissing = models !== null && props.service.defaultModel !== '' && !models.some((model) => model.id === props.service.defaultModel);
+
+    return (
+        <>
+            <ItemLabel>{intl.formatMessage({defaultMessage: 'Check configuration'})}</ItemLabel>
+            <div>
+                <Buttons>
+                    <TertiaryButton
+                        disabled={testing}
+                        onClick={testConnection}
+                    >
+                        <FormattedMessage defaultMessage='Test connection'/>
+                    </TertiaryButton>
+                    <TertiaryButton
+                        disabled={loadingModels}
+                        onClick={loadModels}
+                    >
+                        <FormattedMessage defaultMessage='List models'/>
+                    </TertiaryButton>
+                </Buttons>
+                {testResult?.success && (
+                    <HelpText>
+                        <FormattedMessage
+                            defaultMessage='Connected, the model answered in {latency} ms.'
+                            values={{latency: testResult.latencyMs}}
+                        />
+                    </HelpText>
+                )}
+                {testResult && !testResult.success && (
+                    <ErrorText>{testResult.error}</ErrorText>
+                )}
+                {modelsError && (
+                    <ErrorText>{modelsError}</ErrorText>
+                )}
+                {defaultModelMissing && (
+                    <ErrorText>
+                        <FormattedMessage
+                            defaultMessage='The default model {model} is not available to this service.'
+                            values={{model: props.service.defaultModel}}
+                        />
+                    </ErrorText>
+                )}
+                {models && (
+                    <ModelList>
+                        {models.map((model) => (
+                            <ModelRow
+                                key={model.id}
+                                selected={model.id === props.service.defaultModel}
+                                onClick={() => props.onChange({...props.service, defaultModel: model.id})}
+                            >
+                                {model.displayName || model.id}
+                                {Boolean(model.contextWindow) && (
+                                    <ContextWindow>
+                                        <FormattedMessage
+                                            defaultMessage='{tokens} tokens'
+                                            values={{tokens: model.contextWindow}}
+                                        />
+                                    </ContextWindow>
+                                )}
+                            </ModelRow>
+                        ))}
+                    </ModelList>
+                )}
+            </div>
+        </>
+    );
+};
+
+const Buttons = styled.div`
+	display: flex;
+	gap: 8px;
+`;
+
+const ErrorText = styled(HelpText)`
+	color: var(--error-text);
+`;
+
+const ModelList = styled.div`
+	margin-top: 8px;
+	max-height: 200px;
+	overflow-y: auto;
+	border: 1px solid rgba(var(--center-channel-color-rgb), 0.16);
+	border-radius: 4px;
+`;
+
+const ModelRow = styled.div<{selected: boolean}>`
+	display: flex;
+	justify-content: space-between;
+	padding: 6px 12px;
+	cursor: pointer;
+	font-weight: ${(props) => (props.selected ? 600 : 400)};
+
+	&:hover {
+		background: rgba(var(--center-channel-color-rgb), 0.08);
+	}
+`;
+
+const ContextWindow = styled.span`
+	color: rgba(var(--center-channel-color-rgb), 0.72);
+`;
+
+export default ServiceCheckItem;
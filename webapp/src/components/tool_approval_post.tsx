@@ -0,0 +1,40 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+import React from 'react';
+
+import {ToolCall} from './llmbot_post';
+import PostText from './post_text';
+import ToolApprovalSet from './tool_approval_set';
+
+interface Props {
+    post: any;
+}
+
+// ToolApprovalPost is the ephemeral post asking the requester to approve the tool calls of a response outside of a DM
+export const ToolApprovalPost = (props: Props) => {
+    const postID = props.post.props?.llm_tool_approval_post_id;
+
+    let toolCalls: ToolCall[] = [];
+    try {
+        toolCalls = JSON.parse(props.post.props?.pending_tool_call ?? '[]');
+    } catch (error) {
+        return <div className='error'>{'Error parsing tool calls'}</div>;
+    }
+
+    return (
+        <>
+            <PostText
+                message={props.post.message}
+                channelID={props.post.channel_id}
+                postID={props.post.id}
+            />
+            {postID && (
+                <ToolApprovalSet
+                    postID={postID}
+                    toolCalls={toolCalls}
+                />
+            )}
+        </>
+    );
+};
@@ -58,8 +58,9 @@ const ToolApprovalSet: React.FC<ToolApprovalSetProps> = (props) => {
         };
         setToolDecisions(updatedDecisions);
 
+        // Tool calls accepted or rejected by the bot's policies don't need a decision
         const hasUndecided = props.toolCalls.some((tool) => {
-            return !Object.hasOwn(updatedDecisions, tool.id) || updatedDecisions[tool.id] === null;
+            return tool.status === ToolCallStatus.Pending && (!Object.hasOwn(updatedDecisions, tool.id) || updatedDecisions[tool.id] === null);
         });
 
         if (hasUndecided) {
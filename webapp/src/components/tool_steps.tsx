@@ -0,0 +1,82 @@
+// Copyright (c) 2023-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+import React, {useState} from 'react';
+import styled from 'styled-components';
+import {FormattedMessage} from 'react-intl';
+
+import {ToolCall, ToolCallStatus} from './llmbot_post';
+import ToolCard from './tool_card';
+
+export interface ToolStep {
+    message: string;
+    tool_calls: ToolCall[];
+}
+
+interface ToolStepsProps {
+    steps: ToolStep[];
+}
+
+// ToolSteps shows the tool calls of the steps a response already made, collapsed by default
+const ToolSteps: React.FC<ToolStepsProps> = (props) => {
+    const [expandedTools, setExpandedTools] = useState<string[]>([]);
+
+    const toggleCollapse = (toolID: string) => {
+        setExpandedTools((prev) =>
+            (prev.includes(toolID) ? prev.filter((id) => id !== toolID) : [...prev, toolID]),
+        );
+    };
+
+    if (props.steps.length === 0) {
+        return null;
+    }
+
+    return (
+        <StepsContainer>
+            {props.steps.map((step, index) => {
+                const failed = step.tool_calls.filter((call) => call.status !== ToolCallStatus.Success).length;
+                return (
+                    <Step key={index}>
+                        <StepTitle>
+                            <FormattedMessage
+                                defaultMessage='Step {number}: {count, plural, one {# tool call} other {# tool calls}}{failed, plural, =0 {} other {, # not completed}}'
+                                values={{number: index + 1, count: step.tool_calls.length, failed}}
+                            />
+                        </StepTitle>
+                        {step.tool_calls.map((tool) => (
+                            <ToolCard
+                                key={tool.id}
+                                tool={tool}
+                                isCollapsed={!expandedTools.includes(tool.id)}
+                                isProcessing={false}
+                                onToggleCollapse={() => toggleCollapse(tool.id)}
+                            />
+                        ))}
+                    </Step>
+                );
+            })}
+        </StepsContainer>
+    );
+};
+
+const StepsContainer = styled.div`
+    display: flex;
+    flex-direction: column;
+    gap: 8px;
+    margin: 16px 0;
+`;
+
+const Step = styled.div`
+    display: flex;
+    flex-direction: column;
+`;
+
+const StepTitle = styled.div`
+    margin-bottom: 8px;
+    font-size: 11px;
+    font-weight: 600;
+    line-height: 16px;
+    color: rgba(var(--center-channel-color-rgb), 0.72);
+`;
+
+export default ToolSteps;
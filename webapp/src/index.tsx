@@ -25,6 +25,7 @@ import PostEventListener from './websocket';
 import {BotsHandler, setupRedux} from './redux';
 import UnreadsSummarize from './components/unreads_summarize';
 import {PostbackPost} from './components/postback_post';
+import {ToolApprovalPost} from './components/tool_approval_post';
 import {isRHSCompatable} from './mm_webapp';
 import SearchButton from './components/search_button';
 import {doSelectPost} from './hooks';
@@ -121,6 +122,7 @@ export default class Plugin {
 
         registry.registerPostTypeComponent('custom_llmbot', LLMBotPostWithWebsockets);
         registry.registerPostTypeComponent('custom_llm_postback', PostbackPost);
+        registry.registerPostTypeComponent('custom_llm_tool_approval', ToolApprovalPost);
         if (registry.registerPostActionComponent) {
             registry.registerPostActionComponent(PostMenu);
         } else {